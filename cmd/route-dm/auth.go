@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	matrixio "routes/pkg/io"
+)
+
+// apiClient is a caller of the server, known by its API key, with its usage
+// of the current UTC day. The usage is guarded by server.mu and is lost on
+// restart.
+type apiClient struct {
+	name  string
+	quota int64 // elements per day; 0 for no cap
+
+	day      string
+	requests int64
+	elements int64
+}
+
+// newAPIClients returns the configured clients keyed by the SHA-256 digest
+// of their API key, or nil when there are none and the server is open.
+func newAPIClients(cfgs []matrixio.ClientConfig) (map[string]*apiClient, error) {
+	if len(cfgs) == 0 {
+		return nil, nil
+	}
+	clients := make(map[string]*apiClient, len(cfgs))
+	names := make(map[string]bool, len(cfgs))
+	for i, c := range cfgs {
+		digest := strings.ToLower(c.KeySHA256)
+		if b, err := hex.DecodeString(digest); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("client %d: key_sha256 must be a hex SHA-256 digest", i+1)
+		}
+		switch {
+		case c.Name == "":
+			return nil, fmt.Errorf("client %d has no name", i+1)
+		case names[c.Name]:
+			return nil, fmt.Errorf("client %q is listed twice", c.Name)
+		case clients[digest] != nil:
+			return nil, fmt.Errorf("clients %q and %q share a key", clients[digest].name, c.Name)
+		case c.DailyElements < 0:
+			return nil, fmt.Errorf("client %q has a negative daily_elements", c.Name)
+		}
+		names[c.Name] = true
+		clients[digest] = &apiClient{name: c.Name, quota: c.DailyElements}
+	}
+	return clients, nil
+}
+
+// newAPIKey returns a random API key and the digest the config lists it by.
+func newAPIKey() (key, digest string, err error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("generating API key: %w", err)
+	}
+	key = "rdm_" + hex.EncodeToString(b)
+	sum := sha256.Sum256([]byte(key))
+	return key, hex.EncodeToString(sum[:]), nil
+}
+
+type clientContextKey struct{}
+
+// authenticate lets a request through to next only with the API key of a
+// configured client, given as "Authorization: Bearer KEY" or "X-API-Key:
+// KEY". Servers without clients let every request through.
+func (s *server) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.clients == nil {
+			next(w, r)
+			return
+		}
+		client := s.clientForKey(r.Header.Get("Authorization"), r.Header.Get("X-API-Key"))
+		if client == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="route-dm"`)
+			writeError(w, http.StatusUnauthorized, errUnauthenticated)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), clientContextKey{}, client)))
+	}
+}
+
+var errUnauthenticated = errors.New("missing or unknown API key")
+
+// clientForKey returns the client whose key is given, as "Bearer KEY" in
+// authorization or as is in apiKey, or nil if there is none.
+func (s *server) clientForKey(authorization, apiKey string) *apiClient {
+	key := apiKey
+	if bearer, ok := strings.CutPrefix(authorization, "Bearer "); ok {
+		key = strings.TrimSpace(bearer)
+	}
+	if key == "" {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(key))
+	return s.clients[hex.EncodeToString(sum[:])]
+}
+
+// requestClient returns the client that made r, or nil on an open server.
+func requestClient(r *http.Request) *apiClient {
+	return contextClient(r.Context())
+}
+
+// contextClient returns the client authenticate or the gRPC interceptors
+// stored in ctx, or nil on an open server.
+func contextClient(ctx context.Context) *apiClient {
+	c, _ := ctx.Value(clientContextKey{}).(*apiClient)
+	return c
+}
+
+// clientName is the name a job records as its owner; empty on an open
+// server.
+func clientName(r *http.Request) string {
+	if c := requestClient(r); c != nil {
+		return c.name
+	}
+	return ""
+}
+
+var errQuotaExceeded = errors.New("daily quota exceeded")
+
+// charge counts a request for elements origin-destination pairs against
+// the client's daily quota, refusing it if it would go over. Nil clients,
+// on an open server, are never refused.
+func (s *server) charge(c *apiClient, elements int64) error {
+	if c == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.rollover()
+	if c.quota > 0 && c.elements+elements > c.quota {
+		slog.Warn("client over its daily quota", "client", c.name, "used", c.elements, "quota", c.quota, "requested", elements)
+		return fmt.Errorf("%w: %d of %d elements used today, %d requested", errQuotaExceeded, c.elements, c.quota, elements)
+	}
+	c.requests++
+	c.elements += elements
+	return nil
+}
+
+// refund gives back a charge of elements for a request that then failed,
+// so the client is not billed for work the server never did.
+func (s *server) refund(c *apiClient, elements int64) {
+	if c == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A charge made before the day rolled over was cleared with it.
+	c.requests = max(0, c.requests-1)
+	c.elements = max(0, c.elements-elements)
+}
+
+// rollover starts a new day's usage once the UTC date changes. server.mu
+// must be held.
+func (c *apiClient) rollover() {
+	if today := time.Now().UTC().Format(time.DateOnly); c.day != today {
+		c.day, c.requests, c.elements = today, 0, 0
+	}
+}
+
+// clientUsage is the body of GET /usage.
+type clientUsage struct {
+	Client   string `json:"client"`
+	Day      string `json:"day"`
+	Requests int64  `json:"requests"`
+	Elements int64  `json:"elements"`
+	// Quota is the daily element cap; 0 means none.
+	Quota int64 `json:"quota"`
+}
+
+func (s *server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	c := requestClient(r)
+	if c == nil {
+		writeError(w, http.StatusNotFound, errors.New("the server has no clients to count usage for"))
+		return
+	}
+	s.mu.Lock()
+	c.rollover()
+	usage := clientUsage{Client: c.name, Day: c.day, Requests: c.requests, Elements: c.elements, Quota: c.quota}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, usage)
+}
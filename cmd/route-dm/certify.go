@@ -0,0 +1,488 @@
+package main
+
+import (
+	"cmp"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+// certificate is the statement of method for one output file. It is signed
+// as canonical JSON, so field order and names are part of the format.
+type certificate struct {
+	Output      string            `json:"output"`
+	OutputSHA   string            `json:"output_sha256"`
+	OutputTime  string            `json:"output_modified"`
+	Input       string            `json:"input"`
+	InputSHA    string            `json:"input_sha256,omitempty"`
+	Provider    string            `json:"provider"`
+	APIVersion  string            `json:"api_version"`
+	Parameters  map[string]string `json:"parameters"`
+	CertifiedAt string            `json:"certified_at"`
+}
+
+var certificateTemplate = template.Must(template.New("certificate").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Route distance certificate</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; }
+th { text-align: left; padding-right: 2em; vertical-align: top; }
+code { word-break: break-all; }
+</style>
+</head>
+<body>
+<h1>Route distance certificate</h1>
+<p>The distances and durations in <code>{{.Cert.Output}}</code> were computed by
+querying {{.Cert.Provider}} for each origin and destination pair listed in
+<code>{{.Cert.Input}}</code>, using the parameters below. The checksums identify
+the exact files this statement covers.</p>
+<table>
+<tr><th>Output file</th><td><code>{{.Cert.Output}}</code></td></tr>
+<tr><th>Output SHA-256</th><td><code>{{.Cert.OutputSHA}}</code></td></tr>
+<tr><th>Output written</th><td>{{.Cert.OutputTime}}</td></tr>
+<tr><th>Input</th><td><code>{{.Cert.Input}}</code></td></tr>
+{{if .Cert.InputSHA}}<tr><th>Input SHA-256</th><td><code>{{.Cert.InputSHA}}</code></td></tr>
+{{end}}<tr><th>Provider</th><td>{{.Cert.Provider}}</td></tr>
+<tr><th>API version</th><td>{{.Cert.APIVersion}}</td></tr>
+{{range $name, $value := .Cert.Parameters}}<tr><th>{{$name}}</th><td>{{$value}}</td></tr>
+{{end}}<tr><th>Certified at</th><td>{{.Cert.CertifiedAt}}</td></tr>
+</table>
+{{if .Signature}}<h2>Signature</h2>
+<p>Ed25519 signature over the statement below, verifiable with the public key.</p>
+<table>
+<tr><th>Public key</th><td><code>{{.PublicKey}}</code></td></tr>
+<tr><th>Signature</th><td><code>{{.Signature}}</code></td></tr>
+</table>
+<pre>{{.Statement}}</pre>
+{{end}}</body>
+</html>
+`))
+
+// runCertify implements `route-dm certify`: it writes an HTML certificate
+// describing how an output file was produced. Print it to PDF from a browser
+// when a PDF is required. The query settings come from the parameters file
+// the run saved next to the output; the flags only describe outputs without
+// one, such as those of pipelines.
+func runCertify(args []string) error {
+	fs := flag.NewFlagSet("certify", flag.ExitOnError)
+	configPath := fs.String("config", matrixio.DefaultConfigFile, "config file the output was produced with")
+	input := fs.String("input", "", "input the output was computed from (default from the config)")
+	out := fs.String("o", "certificate.html", "certificate file to write")
+	keyPath := fs.String("signing-key", "", "PEM-encoded Ed25519 private key (PKCS #8) used to sign the certificate")
+	provider := fs.String("provider", "", "routing API the output was produced with (default from the config, else google)")
+	mode := fs.String("mode", "driving", "travel mode the output was produced with")
+	avoid := fs.String("avoid", "", "route features the output avoided, comma-separated")
+	departureTime := fs.String("departure-time", "", "departure time the output was produced with (RFC3339 or now)")
+	arrivalTime := fs.String("arrival-time", "", "arrival time the output was produced with (RFC3339)")
+	trafficModel := fs.String("traffic-model", "", "traffic model the output was produced with")
+	units := fs.String("units", "", "API unit system the output was produced with (default from the config, else metric)")
+	language := fs.String("language", "", "language the output was produced with")
+	region := fs.String("region", "", "region code the output was produced with")
+	transitMode := fs.String("transit-mode", "", "transit modes the output was produced with, comma-separated")
+	transitPreference := fs.String("transit-routing-preference", "", "transit routing preference the output was produced with")
+	alternatives := fs.Bool("alternatives", false, "the output has alternative route columns")
+	tolls := fs.Bool("tolls", false, "the output has a toll cost column")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s certify [flags] output.csv\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "The query settings are read from output.csv%s, which runs save next to file outputs.\nThe flags describing them are only accepted for outputs without one.\n", runParametersSuffix)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one output file")
+	}
+	output := fs.Arg(0)
+
+	explicit := false
+	var queryFlags []string
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "config":
+			explicit = true
+		case "input", "o", "signing-key":
+		default:
+			queryFlags = append(queryFlags, "-"+f.Name)
+		}
+	})
+	cfg, err := matrixio.LoadConfig(*configPath, explicit)
+	if err != nil {
+		return err
+	}
+	if *input != "" {
+		cfg.Input = *input
+	}
+
+	params, err := loadRunParameters(output)
+	switch {
+	case err == nil && len(queryFlags) > 0:
+		return fmt.Errorf("%s states the settings of the run; %s cannot override them", output+runParametersSuffix, strings.Join(queryFlags, ", "))
+	case errors.Is(err, os.ErrNotExist):
+		slog.Warn("the output has no parameters file; certifying the settings given by the flags", "parameters", output+runParametersSuffix)
+		params, err = flagRunParameters(cmp.Or(*provider, cfg.Provider), cmp.Or(*units, cfg.Units), *trafficModel, *alternatives, *tolls,
+			*avoid, *language, *region, *departureTime, matrix.TravelOptions{
+				Mode:              *mode,
+				TransitModes:      *transitMode,
+				RoutingPreference: *transitPreference,
+				ArrivalTime:       *arrivalTime,
+			})
+	}
+	if err != nil {
+		return err
+	}
+	if err := params.validate(); err != nil {
+		return fmt.Errorf("%s: %w", output+runParametersSuffix, err)
+	}
+
+	info, err := os.Stat(output)
+	if err != nil {
+		return err
+	}
+	outputSHA, err := fileSHA256(output)
+	if err != nil {
+		return err
+	}
+	// Remote inputs (sheets://, stdin) have no file to checksum.
+	inputSHA, _ := fileSHA256(cfg.Input)
+
+	cert := certificate{
+		Output:      output,
+		OutputSHA:   outputSHA,
+		OutputTime:  info.ModTime().UTC().Format(time.RFC3339),
+		Input:       cfg.Input,
+		InputSHA:    inputSHA,
+		Parameters:  certificateParameters(cfg, params),
+		CertifiedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	cert.Provider, cert.APIVersion = describeProvider(params.Provider)
+
+	page := struct {
+		Cert                            certificate
+		Statement, PublicKey, Signature string
+	}{Cert: cert}
+
+	if *keyPath != "" {
+		key, err := readSigningKey(*keyPath)
+		if err != nil {
+			return err
+		}
+		statement, err := json.MarshalIndent(cert, "", "  ")
+		if err != nil {
+			return err
+		}
+		page.Statement = string(statement)
+		page.PublicKey = base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+		page.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, statement))
+	}
+
+	err = matrixio.WriteOutput(*out, func(w io.Writer) error {
+		return certificateTemplate.Execute(w, page)
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("certificate written", "output", output, "certificate", *out)
+	return nil
+}
+
+// runParametersSuffix is added to the name of a file output for the file
+// holding the parameters of the run that wrote it.
+const runParametersSuffix = ".params.json"
+
+// runParameters are the settings of a run that shape its results. A run
+// saves them next to a local file output for certify to state, since the
+// output itself does not record how it was queried.
+type runParameters struct {
+	Provider                 string   `json:"provider"`
+	Mode                     string   `json:"mode"`
+	Units                    string   `json:"units"`
+	Avoid                    []string `json:"avoid,omitempty"`
+	Language                 string   `json:"language,omitempty"`
+	Region                   string   `json:"region,omitempty"`
+	DepartureTime            string   `json:"departure_time,omitempty"` // RFC3339 or now
+	ArrivalTime              string   `json:"arrival_time,omitempty"`
+	TrafficModel             string   `json:"traffic_model,omitempty"` // as given, so possibly all
+	TransitModes             []string `json:"transit_modes,omitempty"`
+	TransitRoutingPreference string   `json:"transit_routing_preference,omitempty"`
+	Alternatives             bool     `json:"alternatives,omitempty"`
+	Tolls                    bool     `json:"tolls,omitempty"`
+	// Started is when the run started, which a departure time of now
+	// refers to.
+	Started string `json:"started"`
+}
+
+// newRunParameters returns the parameters of a run of provider with opts,
+// which the run has validated.
+func newRunParameters(provider string, opts matrix.QueryOptions, trafficModel string, alternatives, tolls bool) runParameters {
+	p := runParameters{
+		Provider:                 cmp.Or(provider, "google"),
+		Mode:                     cmp.Or(opts.Mode, "driving"),
+		Units:                    cmp.Or(opts.Units, "metric"),
+		Avoid:                    opts.Avoid,
+		Language:                 opts.Language,
+		Region:                   opts.Region,
+		TrafficModel:             trafficModel,
+		TransitModes:             opts.TransitModes,
+		TransitRoutingPreference: opts.TransitRoutingPreference,
+		Alternatives:             alternatives,
+		Tolls:                    tolls,
+		Started:                  time.Now().UTC().Format(time.RFC3339),
+	}
+	switch {
+	case opts.DepartureNow:
+		p.DepartureTime = "now"
+	case !opts.DepartureTime.IsZero():
+		p.DepartureTime = opts.DepartureTime.Format(time.RFC3339)
+	}
+	if !opts.ArrivalTime.IsZero() {
+		p.ArrivalTime = opts.ArrivalTime.Format(time.RFC3339)
+	}
+	return p
+}
+
+// flagRunParameters returns the parameters stated by certify's flags, for
+// outputs saved without them. Times in the past are accepted: the run has
+// happened.
+func flagRunParameters(provider, units, trafficModel string, alternatives, tolls bool, avoid, language, region, departureTime string, travel matrix.TravelOptions) (runParameters, error) {
+	var opts matrix.QueryOptions
+	if err := matrix.ParseAvoid(avoid, &opts); err != nil {
+		return runParameters{}, err
+	}
+	if err := matrix.ParseUnitSystem(units, &opts); err != nil {
+		return runParameters{}, err
+	}
+	if err := matrix.ParseLocale(language, region, &opts); err != nil {
+		return runParameters{}, err
+	}
+	if err := parsePastDeparture(departureTime, &opts); err != nil {
+		return runParameters{}, err
+	}
+	if err := matrix.ParseTravelMode(travel, &opts); err != nil {
+		return runParameters{}, err
+	}
+	p := newRunParameters(provider, opts, trafficModel, alternatives, tolls)
+	p.Started = ""
+	return p, nil
+}
+
+// parsePastDeparture reads a departure time like matrix.ParseDepartureTime,
+// but accepts one in the past.
+func parsePastDeparture(s string, opts *matrix.QueryOptions) error {
+	switch s {
+	case "":
+		return nil
+	case "now":
+		opts.DepartureNow = true
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return fmt.Errorf("invalid departure time %q: use RFC3339 or now", s)
+	}
+	opts.DepartureTime = t
+	return nil
+}
+
+// validate checks p the way a run parses its options, so the certificate
+// cannot state settings no run could have used. Only the format of times is
+// checked, since they are in the past by the time the output is certified.
+func (p runParameters) validate() error {
+	var opts matrix.QueryOptions
+	if err := matrix.ParseAvoid(strings.Join(p.Avoid, ","), &opts); err != nil {
+		return err
+	}
+	if err := matrix.ParseUnitSystem(p.Units, &opts); err != nil {
+		return err
+	}
+	if err := matrix.ParseLocale(p.Language, p.Region, &opts); err != nil {
+		return err
+	}
+	if err := parsePastDeparture(p.DepartureTime, &opts); err != nil {
+		return err
+	}
+	travel := matrix.TravelOptions{
+		Mode:              p.Mode,
+		TransitModes:      strings.Join(p.TransitModes, ","),
+		RoutingPreference: p.TransitRoutingPreference,
+		ArrivalTime:       p.ArrivalTime,
+	}
+	if err := matrix.ParseTravelMode(travel, &opts); err != nil {
+		return err
+	}
+	if p.TrafficModel != "" {
+		if _, err := matrix.ParseTrafficModel(p.TrafficModel, &opts); err != nil {
+			return err
+		}
+	}
+	_, err := matrix.NewProvider(p.Provider, "", opts)
+	return err
+}
+
+// recordRunParameters saves p next to output, if it is a local file;
+// databases, remote objects and stdout have no file to certify. fresh is
+// false when the run added rows to an existing output, with -append or
+// -retry-failed. The parameters file then stays only if the earlier rows
+// were queried the same way, so certify never states settings that only
+// some of the rows were queried with.
+func recordRunParameters(output string, fresh bool, p runParameters) {
+	if output == "-" || strings.Contains(output, "://") || strings.HasPrefix(output, "sheets:") {
+		return
+	}
+	path := output + runParametersSuffix
+	if !fresh {
+		before, err := loadRunParameters(output)
+		if err != nil {
+			// The earlier rows' parameters are unknown.
+			return
+		}
+		// The first run's start is the one a departure of now refers to.
+		p.Started = before.Started
+		if !reflect.DeepEqual(before, p) {
+			slog.Warn("the output now holds rows queried with other parameters than before; removing its parameters file", "parameters", path)
+			os.Remove(path)
+		}
+		return
+	}
+	err := matrixio.WriteOutput(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p)
+	})
+	if err != nil {
+		slog.Error("saving run parameters", "parameters", path, "err", err)
+	}
+}
+
+// loadRunParameters reads the parameters saved next to output.
+func loadRunParameters(output string) (runParameters, error) {
+	data, err := os.ReadFile(output + runParametersSuffix)
+	if err != nil {
+		return runParameters{}, err
+	}
+	var p runParameters
+	if err := json.Unmarshal(data, &p); err != nil {
+		return runParameters{}, fmt.Errorf("%s: %w", output+runParametersSuffix, err)
+	}
+	return p, nil
+}
+
+// describeProvider names the routing API behind a provider as accepted by
+// matrix.NewProvider, and the API version it queries.
+func describeProvider(name string) (provider, apiVersion string) {
+	switch {
+	case name == "routes":
+		return "Google Routes API", "distanceMatrix/v2:computeRouteMatrix"
+	case name == "osrm":
+		return "OSRM public demo server (https://router.project-osrm.org)", "table/v1"
+	case strings.HasPrefix(name, "osrm="):
+		return "OSRM server at " + strings.TrimPrefix(name, "osrm="), "table/v1"
+	case name == "mock":
+		return "straight-line mock provider (no routing API)", "none"
+	default:
+		return "Google Distance Matrix API", "distancematrix/json (legacy Maps Web Service)"
+	}
+}
+
+// certificateParameters lists the request settings that affect the results.
+func certificateParameters(cfg matrixio.Config, p runParameters) map[string]string {
+	crs := cfg.CRS
+	if crs == "" {
+		crs = "EPSG:4326"
+	}
+	cfg.DefaultUnitsFor(matrix.QueryOptions{Units: p.Units})
+	m := cfg.Columns
+	params := map[string]string{
+		"mode":                p.Mode,
+		"coordinate system":   crs,
+		"origin columns":      m.OriginLat + ", " + m.OriginLng,
+		"destination columns": m.DestinationLat + ", " + m.DestinationLng,
+		"distance units":      matrixio.DescribeDistanceUnits(cfg.DistanceUnits),
+		"unit system":         p.Units,
+	}
+	optional := map[string]string{
+		"avoid":                      strings.Join(p.Avoid, ", "),
+		"language":                   p.Language,
+		"region":                     p.Region,
+		"departure time":             p.DepartureTime,
+		"arrival time":               p.ArrivalTime,
+		"traffic model":              p.TrafficModel,
+		"transit modes":              strings.Join(p.TransitModes, ", "),
+		"transit routing preference": p.TransitRoutingPreference,
+	}
+	if p.DepartureTime == "now" && p.Started != "" {
+		optional["departure time"] = "now, at " + p.Started
+	}
+	if p.TrafficModel == "all" {
+		optional["traffic model"] = "best_guess, with pessimistic and optimistic columns"
+	}
+	if p.Alternatives {
+		optional["alternatives"] = "default, shortest and fastest routes"
+	}
+	if p.Tolls {
+		optional["tolls"] = "toll cost estimated by the Google Routes API"
+	}
+	for name, value := range optional {
+		if value != "" {
+			params[name] = value
+		}
+	}
+	if m.HasAddresses() {
+		params["geocoding provider"] = cfg.Geocode.Provider
+	}
+	return params
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func readSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not PEM encoded", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	ed, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 key", path)
+	}
+	return ed, nil
+}
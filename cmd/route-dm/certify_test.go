@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	matrixio "routes/pkg/io"
+)
+
+func TestRunParametersValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  runParameters
+		wantErr bool
+	}{
+		{"defaults", runParameters{Provider: "google", Mode: "driving", Units: "metric"}, false},
+		{"past departure in traffic", runParameters{Provider: "google", Mode: "driving", DepartureTime: "2020-01-01T08:00:00Z", TrafficModel: "pessimistic"}, false},
+		{"departure now", runParameters{Provider: "google", DepartureTime: "now", TrafficModel: "all"}, false},
+		{"transit", runParameters{Provider: "google", Mode: "transit", TransitModes: []string{"bus", "rail"}, TransitRoutingPreference: "fewer_transfers", ArrivalTime: "2020-01-01T09:00:00Z"}, false},
+		{"malformed departure", runParameters{Provider: "google", DepartureTime: "tomorrow"}, true},
+		{"traffic model without departure", runParameters{Provider: "google", TrafficModel: "optimistic"}, true},
+		{"transit modes when driving", runParameters{Provider: "google", Mode: "driving", TransitModes: []string{"bus"}}, true},
+		{"unknown avoid", runParameters{Provider: "google", Avoid: []string{"hills"}}, true},
+		{"unknown provider", runParameters{Provider: "carrier-pigeon"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.params.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validate() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRecordRunParameters(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "out.csv")
+	first := runParameters{Provider: "google", Mode: "driving", Units: "metric", DepartureTime: "now", TrafficModel: "all", Tolls: true, Started: "2026-10-16T08:00:00Z"}
+	recordRunParameters(output, true, first)
+	got, err := loadRunParameters(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := certificateParameters(matrixio.DefaultConfig(), got)
+	for name, want := range map[string]string{
+		"departure time": "now, at 2026-10-16T08:00:00Z",
+		"traffic model":  "best_guess, with pessimistic and optimistic columns",
+		"tolls":          "toll cost estimated by the Google Routes API",
+	} {
+		if params[name] != want {
+			t.Errorf("certificate %s = %q, want %q", name, params[name], want)
+		}
+	}
+
+	// Appending rows queried the same way keeps the first run's file.
+	same := first
+	same.Started = "2026-10-16T09:00:00Z"
+	recordRunParameters(output, false, same)
+	if got, err := loadRunParameters(output); err != nil || got.Started != first.Started {
+		t.Errorf("after appending alike rows: %+v, %v; want the first run's parameters", got, err)
+	}
+
+	// Appending rows queried otherwise leaves nothing to certify.
+	other := first
+	other.Mode = "walking"
+	recordRunParameters(output, false, other)
+	if _, err := loadRunParameters(output); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("after appending other rows: %v, want the parameters file removed", err)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	matrixio "routes/pkg/io"
+)
+
+// defaultAlertFailureRate is the share of failed rows from which a chat
+// summary is posted as an alert.
+const defaultAlertFailureRate = 0.05
+
+// chatNotifier posts the outcome of one batch to a Slack or Microsoft Teams
+// incoming webhook.
+type chatNotifier struct {
+	url   string
+	teams bool
+	// alertRate is the failure rate from which the summary is an alert.
+	alertRate float64
+	jobID     string
+}
+
+// newChatNotifier returns a notifier for the batch jobID, or nil if no chat
+// webhook is configured.
+func newChatNotifier(cfg matrixio.ChatConfig, jobID string) (*chatNotifier, error) {
+	if cfg.Webhook == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(cfg.Webhook)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, fmt.Errorf("chat webhook %q is not an http(s) URL", cfg.Webhook)
+	}
+	c := &chatNotifier{url: cfg.Webhook, alertRate: cfg.AlertFailureRate, jobID: jobID}
+	switch cfg.Kind {
+	case "":
+		host := u.Hostname()
+		c.teams = strings.HasSuffix(host, ".office.com") || strings.HasSuffix(host, ".azure.com")
+	case "slack":
+	case "teams":
+		c.teams = true
+	default:
+		return nil, fmt.Errorf("unknown chat kind %q (want slack or teams)", cfg.Kind)
+	}
+	if c.alertRate == 0 {
+		c.alertRate = defaultAlertFailureRate
+	}
+	if c.alertRate < 0 || c.alertRate > 1 {
+		return nil, errors.New("chat alert failure rate must be between 0 and 1")
+	}
+	return c, nil
+}
+
+// chatFact is one labelled value of a chat summary.
+type chatFact struct {
+	name, value string
+}
+
+// notify posts e. Errors are logged rather than returned so a broken chat
+// webhook never fails the run it reports on.
+func (c *chatNotifier) notify(e webhookEvent) {
+	title, alert := c.title(e)
+	facts := []chatFact{{"Job", c.jobID}}
+	if e.Rows > 0 {
+		facts = append(facts,
+			chatFact{"Rows", fmt.Sprint(e.Rows)},
+			chatFact{"Failed", fmt.Sprintf("%d (%.1f%%)", e.FailedRows, 100*failureRate(e))})
+	}
+	if e.Elements > 0 {
+		facts = append(facts, chatFact{"API elements", fmt.Sprint(e.Elements)})
+	}
+	if e.CostUSD > 0 {
+		facts = append(facts, chatFact{"Cost", fmt.Sprintf("$%.2f at list prices", e.CostUSD)})
+	}
+	if e.ElapsedSec > 0 {
+		facts = append(facts, chatFact{"Duration", time.Duration(e.ElapsedSec * float64(time.Second)).Round(time.Millisecond).String()})
+	}
+	if e.Output != "" {
+		facts = append(facts, chatFact{"Output", e.Output})
+	}
+	if e.ErrorsOutput != "" {
+		facts = append(facts, chatFact{"Failed rows", e.ErrorsOutput})
+	}
+	if e.Error != "" {
+		facts = append(facts, chatFact{"Error", e.Error})
+	}
+
+	var message any
+	if c.teams {
+		message = teamsMessage(title, alert, facts)
+	} else {
+		message = slackMessage(title, alert, facts)
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		slog.Error("encoding chat message", "err", err)
+		return
+	}
+	if err := postJSON(c.url, body); err != nil {
+		slog.Warn("posting chat message", "job", c.jobID, "attempts", webhookAttempts, "err", err)
+		return
+	}
+	slog.Debug("chat message posted", "job", c.jobID, "alert", alert)
+}
+
+// postText posts a plain text report under title, laid out as a code
+// block so its columns stay aligned.
+func (c *chatNotifier) postText(title, text string) error {
+	var message any
+	if c.teams {
+		message = map[string]any{
+			"type": "message",
+			"attachments": []map[string]any{{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]any{
+					"type":    "AdaptiveCard",
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"version": "1.4",
+					"body": []map[string]any{
+						{"type": "TextBlock", "text": title, "weight": "Bolder", "size": "Medium", "wrap": true},
+						{"type": "TextBlock", "text": text, "fontType": "Monospace", "wrap": true},
+					},
+				},
+			}},
+		}
+	} else {
+		message = map[string]any{"text": "*" + title + "*\n```\n" + text + "```"}
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return postJSON(c.url, body)
+}
+
+// title sums up e in a line, and reports whether it calls for an alert: the
+// run failed or too many of its rows did.
+func (c *chatNotifier) title(e webhookEvent) (string, bool) {
+	switch {
+	case e.Status != "succeeded":
+		return "route-dm run failed: " + e.Error, true
+	case e.Rows > 0 && failureRate(e) >= c.alertRate:
+		return fmt.Sprintf("route-dm run needs attention: %d of %d rows failed", e.FailedRows, e.Rows), true
+	default:
+		return fmt.Sprintf("route-dm run finished: %d rows, %d failed", e.Rows, e.FailedRows), false
+	}
+}
+
+func failureRate(e webhookEvent) float64 {
+	if e.Rows == 0 {
+		return 0
+	}
+	return float64(e.FailedRows) / float64(e.Rows)
+}
+
+// slackMessage lays the summary out as a Slack message with a coloured
+// attachment, red for alerts.
+func slackMessage(title string, alert bool, facts []chatFact) any {
+	type field struct {
+		Title string `json:"title"`
+		Value string `json:"value"`
+		Short bool   `json:"short"`
+	}
+	fields := make([]field, len(facts))
+	for i, f := range facts {
+		fields[i] = field{Title: f.name, Value: f.value, Short: len(f.value) < 40}
+	}
+	color, text := "good", title
+	if alert {
+		color, text = "danger", ":rotating_light: *"+title+"*"
+	}
+	return map[string]any{
+		"text": text,
+		"attachments": []map[string]any{{
+			"color":  color,
+			"fields": fields,
+		}},
+	}
+}
+
+// teamsMessage lays the summary out as an Adaptive Card, which Teams
+// workflows and connectors both accept, with the title in red for alerts.
+func teamsMessage(title string, alert bool, facts []chatFact) any {
+	type fact struct {
+		Title string `json:"title"`
+		Value string `json:"value"`
+	}
+	factSet := make([]fact, len(facts))
+	for i, f := range facts {
+		factSet[i] = fact{Title: f.name, Value: f.value}
+	}
+	color := "Good"
+	if alert {
+		color, title = "Attention", "⚠ "+title
+	}
+	return map[string]any{
+		"type": "message",
+		"attachments": []map[string]any{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]any{
+				"type":    "AdaptiveCard",
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"version": "1.4",
+				"body": []map[string]any{
+					{"type": "TextBlock", "text": title, "weight": "Bolder", "size": "Medium", "color": color, "wrap": true},
+					{"type": "FactSet", "facts": factSet},
+				},
+			},
+		}},
+	}
+}
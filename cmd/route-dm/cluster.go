@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+// maxClusterIterations bounds the k-medoids refinement, which normally
+// settles in a handful of rounds.
+const maxClusterIterations = 100
+
+// runCluster implements `route-dm cluster`: it computes the matrix between
+// all sites and groups them into k clusters with k-medoids on the road
+// distance, each cluster's medoid serving as its hub.
+func runCluster(args []string) error {
+	fs := flag.NewFlagSet("cluster", flag.ExitOnError)
+	sitesPath := fs.String("sites", "sites.csv", "CSV file listing the sites")
+	k := fs.Int("k", 5, "number of clusters")
+	output := fs.String("output", "clusters.csv", "output CSV file, or - for stdout")
+	api := addMatrixFlags(fs)
+	avoid := fs.String("avoid", "", "comma-separated route features to avoid: tolls, highways, ferries, indoor")
+	unitName := fs.String("distance-unit", "km", "distance unit: km, mi, m or nmi")
+	idColumn := fs.String("id-column", "1", "column holding the site ID (header name or 1-based position)")
+	latColumn := fs.String("lat-column", "2", "column holding the latitude")
+	lngColumn := fs.String("lng-column", "3", "column holding the longitude")
+	crs := fs.String("crs", "", "EPSG code of the input coordinates (default WGS84)")
+	applyCSVFlags := matrixio.CSVFlags(fs)
+	fs.Parse(args)
+
+	var dialect matrixio.CSVConfig
+	applyCSVFlags(&dialect)
+
+	units, err := matrixio.ParseDistanceUnits([]string{*unitName})
+	if err != nil {
+		return err
+	}
+	var opts matrix.QueryOptions
+	if err := matrix.ParseAvoid(*avoid, &opts); err != nil {
+		return err
+	}
+	if *output == "-" {
+		messages = os.Stderr
+	}
+	epsg, err := matrixio.ParseEPSG(*crs)
+	if err != nil {
+		return err
+	}
+
+	sites, err := readMatrixPoints(*sitesPath, dialect, *idColumn, *latColumn, *lngColumn, epsg)
+	if err != nil {
+		return fmt.Errorf("reading sites: %w", err)
+	}
+	if *k < 1 || *k > len(sites) {
+		return fmt.Errorf("-k must be from 1 to the number of sites (%d)", len(sites))
+	}
+
+	cells, err := api.query(opts, sites, sites)
+	if err != nil {
+		return err
+	}
+
+	medoids, assignment := kMedoids(stopDistances(cells), *k)
+	if medoids == nil {
+		return errors.New("some site cannot be reached from any hub using only the pairs the API answered; see the errors above")
+	}
+	records := clusterRecords(sites, cells, medoids, assignment, units[0])
+
+	err = matrixio.WriteOutput(*output, func(w io.Writer) error {
+		return dialect.WriteAll(w, records)
+	})
+	if err != nil {
+		return err
+	}
+
+	if *output != "-" {
+		slog.Info("clusters written", "sites", len(sites), "clusters", *k, "output", *output)
+	}
+	return nil
+}
+
+// kMedoids groups the points of the distance matrix d, where d[i][j] is the
+// distance from i to j, into k clusters. It returns the medoid of each
+// cluster and the cluster of each point, minimising the total distance from
+// the medoids to their members. Medoids are chosen greedily (the BUILD step
+// of PAM) and then refined by alternating assignment and medoid updates. It
+// returns nils if some point is unreachable from every medoid.
+func kMedoids(d [][]float64, k int) (medoids, assignment []int) {
+	n := len(d)
+	// nearest[j] is the distance from the closest medoid chosen so far.
+	nearest := make([]float64, n)
+	for j := range nearest {
+		nearest[j] = math.Inf(1)
+	}
+	isMedoid := make([]bool, n)
+	for len(medoids) < k {
+		best, bestCost := -1, math.Inf(1)
+		for m := 0; m < n; m++ {
+			if isMedoid[m] {
+				continue
+			}
+			var cost float64
+			for j := 0; j < n; j++ {
+				cost += min(nearest[j], d[m][j])
+			}
+			if best < 0 || cost < bestCost {
+				best, bestCost = m, cost
+			}
+		}
+		medoids = append(medoids, best)
+		isMedoid[best] = true
+		for j := range nearest {
+			nearest[j] = min(nearest[j], d[best][j])
+		}
+	}
+
+	assignment = make([]int, n)
+	for range maxClusterIterations {
+		for j := range assignment {
+			assignment[j] = 0
+			for c, m := range medoids {
+				if d[m][j] < d[medoids[assignment[j]]][j] {
+					assignment[j] = c
+				}
+			}
+		}
+
+		changed := false
+		for c := range medoids {
+			best, bestCost := medoids[c], clusterCost(d, medoids[c], c, assignment)
+			for m, a := range assignment {
+				if a != c {
+					continue
+				}
+				if cost := clusterCost(d, m, c, assignment); cost < bestCost {
+					best, bestCost = m, cost
+				}
+			}
+			if best != medoids[c] {
+				medoids[c], changed = best, true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	for j, c := range assignment {
+		if math.IsInf(d[medoids[c]][j], 1) {
+			return nil, nil
+		}
+	}
+	return medoids, assignment
+}
+
+// clusterCost is the total distance from m to the members of cluster c.
+func clusterCost(d [][]float64, m, c int, assignment []int) float64 {
+	var cost float64
+	for j, a := range assignment {
+		if a == c {
+			cost += d[m][j]
+		}
+	}
+	return cost
+}
+
+// clusterRecords writes one row per site with its 1-based cluster, the
+// cluster's hub and the road distance and duration from the hub.
+func clusterRecords(sites []matrixPoint, cells [][]matrixCell, medoids, assignment []int, unit matrixio.DistanceUnit) [][]string {
+	records := [][]string{{"SITE_ID", "CLUSTER", "HUB_ID", unit.Column() + "_FROM_HUB", "DURATION_FROM_HUB"}}
+	for j, site := range sites {
+		hub := medoids[assignment[j]]
+		cell := cells[hub][j]
+		if hub == j {
+			cell = matrixCell{distanceKm: 0, duration: "0 mins"}
+		}
+		records = append(records, []string{site.id, strconv.Itoa(assignment[j] + 1), sites[hub].id, unit.Format(cell.distanceKm), cell.duration})
+	}
+	return records
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestKMedoids(t *testing.T) {
+	inf := math.Inf(1)
+	twoTowns := planeDistances(
+		[2]float64{0, 0}, [2]float64{1, 0}, [2]float64{2, 0},
+		[2]float64{100, 0}, [2]float64{101, 0}, [2]float64{102, 0},
+	)
+	tests := []struct {
+		name           string
+		d              [][]float64
+		k              int
+		wantMedoids    []int
+		wantAssignment []int
+	}{
+		{
+			name:           "two towns",
+			d:              twoTowns,
+			k:              2,
+			wantMedoids:    []int{1, 4},
+			wantAssignment: []int{0, 0, 0, 1, 1, 1},
+		},
+		{
+			name:           "one cluster takes the median",
+			d:              planeDistances([2]float64{0, 0}, [2]float64{1, 0}, [2]float64{2, 0}, [2]float64{10, 0}, [2]float64{11, 0}),
+			k:              1,
+			wantMedoids:    []int{2},
+			wantAssignment: []int{0, 0, 0, 0, 0},
+		},
+		{
+			name:           "a cluster per site",
+			d:              planeDistances([2]float64{0, 0}, [2]float64{5, 0}, [2]float64{9, 0}),
+			k:              3,
+			wantMedoids:    []int{1, 0, 2}, // the most central first
+			wantAssignment: []int{1, 0, 2},
+		},
+		{
+			// Site 2 is cheap to reach from 0 but not from 1, so hub 1's
+			// cluster would cost more: distances run from hub to site.
+			name: "distances from the hub",
+			d: [][]float64{
+				{0, 1, 1},
+				{1, 0, 50},
+				{50, 1, 0},
+			},
+			k:              1,
+			wantMedoids:    []int{0},
+			wantAssignment: []int{0, 0, 0},
+		},
+		{
+			name: "unreachable site",
+			d: [][]float64{
+				{0, 1, inf},
+				{1, 0, inf},
+				{inf, inf, 0},
+			},
+			k:              1,
+			wantMedoids:    nil,
+			wantAssignment: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			medoids, assignment := kMedoids(tt.d, tt.k)
+			if !slices.Equal(medoids, tt.wantMedoids) || !slices.Equal(assignment, tt.wantAssignment) {
+				t.Errorf("kMedoids(k=%d) = %v, %v; want %v, %v", tt.k, medoids, assignment, tt.wantMedoids, tt.wantAssignment)
+			}
+		})
+	}
+}
+
+func TestKMedoidsAssignsNearestMedoid(t *testing.T) {
+	d := planeDistances(
+		[2]float64{0, 0}, [2]float64{0, 1}, [2]float64{1, 0},
+		[2]float64{10, 10}, [2]float64{10, 11}, [2]float64{11, 10},
+		[2]float64{0, 20}, [2]float64{1, 20}, [2]float64{0, 21},
+	)
+	medoids, assignment := kMedoids(d, 3)
+	if len(medoids) != 3 || len(assignment) != len(d) {
+		t.Fatalf("kMedoids = %v, %v", medoids, assignment)
+	}
+	for j, c := range assignment {
+		for _, m := range medoids {
+			if d[m][j] < d[medoids[c]][j] {
+				t.Errorf("site %d is assigned to medoid %d but medoid %d is closer", j, medoids[c], m)
+			}
+		}
+	}
+	for group := 0; group < 9; group += 3 {
+		if assignment[group] != assignment[group+1] || assignment[group] != assignment[group+2] {
+			t.Errorf("sites %d-%d are split: %v", group, group+2, assignment)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	matrixio "routes/pkg/io"
+)
+
+// command is a route-dm subcommand.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+// subcommands lists the subcommands in the order help shows them. Without
+// one, route-dm runs a batch, as `route-dm run` does.
+func subcommands() []command {
+	return []command{
+		{"run", "compute the distance of every route in the input (the default)", batchCommand(batchRun)},
+		{"validate", "read and check the input and options without calling any API", batchCommand(batchValidate)},
+		{"estimate", "report the API requests, cost and time a run would take", batchCommand(batchEstimate)},
+		{"serve", "serve the matrix and batch API over HTTP and gRPC", runServe},
+		{"worker", "compute route jobs from an SQS, RabbitMQ or Kafka queue", runWorker},
+		{"cache", "manage the geocoding caches (cache purge)", runCache},
+		{"init", "write a config file from a sample input", runInit},
+		{"matrix", "compute every origin against every destination", runMatrix},
+		{"nearest", "find each site's closest terminals", runNearest},
+		{"cluster", "group sites around hubs by road distance", runCluster},
+		{"optimize", "order one vehicle's stops", runOptimize},
+		{"vrp", "split sites into capacity-bound vehicle trips", runVRP},
+		{"pipeline", "run the stages of a pipeline file", runPipeline},
+		{"report", "write or send the weekly KPI report from the run history", runReport},
+		{"certify", "write a certificate describing how an output was produced", runCertify},
+		{"completion", "print a bash, zsh or fish completion script", runCompletion},
+		{"help", "show this help, or a command's with help COMMAND", runHelp},
+	}
+}
+
+// lookupCommand returns the subcommand called name.
+func lookupCommand(name string) (command, bool) {
+	for _, c := range subcommands() {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// batchCommand returns the run function of a subcommand that runs the
+// batch up to stage with the top-level flags.
+func batchCommand(stage batchStage) func([]string) error {
+	return func(args []string) error {
+		runBatch(args, stage)
+		return nil
+	}
+}
+
+// usage prints the subcommands followed by the flags of a batch run.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range subcommands() {
+		fmt.Fprintf(out, "  %-9s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(out, "\nRun %s COMMAND -h for the flags of a command. The flags of run, validate and estimate, also accepted without a command:\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// runHelp implements `route-dm help [COMMAND]`.
+func runHelp(args []string) error {
+	if len(args) > 0 && args[0] != "help" {
+		c, ok := lookupCommand(args[0])
+		if !ok {
+			return fmt.Errorf("unknown command %q", args[0])
+		}
+		return c.run([]string{"-h"})
+	}
+	runBatch([]string{"-h"}, batchRun)
+	return nil
+}
+
+// runCache implements `route-dm cache purge`: it deletes the geocoding
+// caches named by the config or flags, so addresses are looked up afresh.
+func runCache(args []string) error {
+	fs := flag.NewFlagSet("cache", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s cache purge [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", matrixio.DefaultConfigFile, "config file naming the caches")
+	geocodeCache := fs.String("geocode-cache", "", "geocoding cache file (default from the config)")
+	purge := len(args) > 0 && args[0] == "purge"
+	if purge {
+		args = args[1:]
+	}
+	// Parsing before checking the action lets `cache -h` show the usage.
+	fs.Parse(args)
+	if !purge {
+		fs.Usage()
+		return errors.New("cache needs the purge action")
+	}
+
+	explicit := false
+	fs.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "config" })
+	cfg, err := matrixio.LoadConfig(*configPath, explicit)
+	if err != nil {
+		return err
+	}
+	if *geocodeCache != "" {
+		cfg.Geocode.Cache = *geocodeCache
+	}
+
+	var purged []string
+	for _, path := range []string{cfg.Geocode.Cache, cfg.Geocode.ReverseCache} {
+		if path == "" {
+			continue
+		}
+		err := os.Remove(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		purged = append(purged, path)
+	}
+	if len(purged) == 0 {
+		fmt.Fprintln(messages, "No caches to purge.")
+		return nil
+	}
+	fmt.Fprintf(messages, "Purged %s.\n", strings.Join(purged, ", "))
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"routes/pkg/matrix"
+)
+
+// completionScripts are the shell completion scripts `route-dm completion`
+// prints. They complete command names and the values of -provider, -compare
+// and -mode themselves, and ask the binary for the flags of a command with
+// `help COMMAND`, so they never go stale as flags are added.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for {{.Prog}}; load with: source <({{.Prog}} completion bash)
+_route_dm() {
+    local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]} cmd=run
+    case $prev in
+    -provider|-compare)
+        COMPREPLY=($(compgen -W "{{.Providers}}" -- "$cur"))
+        return ;;
+    -mode)
+        COMPREPLY=($(compgen -W "{{.Modes}}" -- "$cur"))
+        return ;;
+    esac
+    if [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then
+        COMPREPLY=($(compgen -W "{{.Commands}}" -- "$cur"))
+        return
+    fi
+    if [[ $cur == -* ]]; then
+        [[ ${COMP_WORDS[1]} != -* ]] && cmd=${COMP_WORDS[1]}
+        COMPREPLY=($(compgen -W "$({{.Prog}} help "$cmd" 2>&1 | sed -n 's/^  \(-[^ ]*\).*/\1/p')" -- "$cur"))
+    fi
+}
+complete -o default -F _route_dm {{.Prog}}
+`,
+	"zsh": `#compdef {{.Prog}}
+# zsh completion for {{.Prog}}; load with: source <({{.Prog}} completion zsh)
+_route_dm() {
+    local cmd=run
+    (( CURRENT > 2 )) && [[ $words[2] != -* ]] && cmd=$words[2]
+    case $words[CURRENT-1] in
+    -provider|-compare) compadd {{.Providers}}; return ;;
+    -mode) compadd {{.Modes}}; return ;;
+    esac
+    if (( CURRENT == 2 )) && [[ $PREFIX != -* ]]; then
+        compadd {{.Commands}}
+        return
+    fi
+    if [[ $PREFIX == -* ]]; then
+        compadd -- ${(f)"$({{.Prog}} help $cmd 2>&1 | sed -n 's/^  \(-[^ ]*\).*/\1/p')"}
+        return
+    fi
+    _files
+}
+compdef _route_dm {{.Prog}}
+`,
+	"fish": `# fish completion for {{.Prog}}; load with: {{.Prog}} completion fish | source
+function __route_dm_flags
+    set -l words (commandline -opc)
+    set -l cmd run
+    if test (count $words) -gt 1; and not string match -q -- '-*' $words[2]
+        set cmd $words[2]
+    end
+    {{.Prog}} help $cmd 2>&1 | string replace -rf '^  (-\S+).*' '$1'
+end
+function __route_dm_after
+    contains -- (commandline -opc)[-1] $argv
+end
+{{range .Subcommands}}complete -c {{$.Prog}} -f -n __fish_use_subcommand -a {{.Name}} -d '{{.Summary}}'
+{{end}}complete -c {{.Prog}} -n 'string match -q -- "-*" (commandline -ct)' -a '(__route_dm_flags)'
+complete -c {{.Prog}} -x -n '__route_dm_after -provider -compare' -a '{{.Providers}}'
+complete -c {{.Prog}} -x -n '__route_dm_after -mode' -a '{{.Modes}}'
+`,
+}
+
+// runCompletion implements `route-dm completion SHELL`.
+func runCompletion(args []string) error {
+	usage := fmt.Sprintf("Usage: %s completion bash|zsh|fish", os.Args[0])
+	if len(args) == 1 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+		fmt.Fprintln(os.Stderr, usage)
+		return nil
+	}
+	if len(args) != 1 || completionScripts[args[0]] == "" {
+		return errors.New(usage)
+	}
+	return writeCompletion(os.Stdout, args[0])
+}
+
+// writeCompletion writes the completion script for shell to w.
+func writeCompletion(w io.Writer, shell string) error {
+	type subcommand struct{ Name, Summary string }
+	data := struct {
+		Prog, Commands, Providers, Modes string
+		Subcommands                      []subcommand
+	}{
+		Prog:      filepath.Base(os.Args[0]),
+		Providers: strings.Join(matrix.ProviderNames, " "),
+		Modes:     "driving walking bicycling transit two_wheeler",
+	}
+	var names []string
+	for _, c := range subcommands() {
+		names = append(names, c.name)
+		data.Subcommands = append(data.Subcommands, subcommand{c.name, strings.ReplaceAll(c.summary, "'", `\'`)})
+	}
+	data.Commands = strings.Join(names, " ")
+	return template.Must(template.New(shell).Parse(completionScripts[shell])).Execute(w, data)
+}
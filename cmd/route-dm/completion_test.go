@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"routes/pkg/matrix"
+)
+
+func TestCompletionScripts(t *testing.T) {
+	// The scripts ask the binary for the flags of a command, and complete
+	// the values of these flags themselves.
+	want := []string{"help", "-provider", "-compare", "-mode", "driving", "transit"}
+	for _, c := range subcommands() {
+		want = append(want, c.name)
+	}
+	want = append(want, matrix.ProviderNames...)
+
+	for shell := range completionScripts {
+		var b strings.Builder
+		if err := writeCompletion(&b, shell); err != nil {
+			t.Errorf("completion %s: %v", shell, err)
+			continue
+		}
+		script := b.String()
+		for _, w := range want {
+			if !strings.Contains(script, w) {
+				t.Errorf("completion %s: script lacks %q", shell, w)
+			}
+		}
+		if strings.Contains(script, "<no value>") {
+			t.Errorf("completion %s: script has an unset template field", shell)
+		}
+	}
+	if err := runCompletion([]string{"tcsh"}); err == nil {
+		t.Error("completion tcsh succeeded, want an error")
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+// sku is a billed Google Maps Platform product. Prices are USD list prices
+// per 1000 units for the first 100,000 units a month; volume pricing takes
+// 20% off from there on.
+type sku struct {
+	name        string
+	perThousand float64
+}
+
+var (
+	skuMatrixBasic      = sku{"Distance Matrix", 5}
+	skuMatrixAdvanced   = sku{"Distance Matrix Advanced", 10}
+	skuDirections       = sku{"Directions", 5}
+	skuDirectionsAdv    = sku{"Directions Advanced", 10}
+	skuRoutesEssentials = sku{"Routes Essentials", 5}
+	skuRoutesPro        = sku{"Routes Pro", 10}
+	skuRoutesEnterprise = sku{"Routes Enterprise", 15}
+	skuGeocoding        = sku{"Geocoding", 5}
+)
+
+const volumeTier = 100000
+
+// cost prices units of the SKU across the volume tiers.
+func (s sku) cost(units int) float64 {
+	list := min(units, volumeTier)
+	return float64(list)*s.perThousand/1000 + float64(units-list)*s.perThousand*0.8/1000
+}
+
+// matrixCost is the list price of elements billed matrix elements.
+func matrixCost(provider string, opts matrix.QueryOptions, elements int64) float64 {
+	s, _ := matrixSKUs(provider, opts)
+	return s.cost(int(elements))
+}
+
+// dryRunEstimate counts the requests and billed units a run would make.
+type dryRunEstimate struct {
+	requests int
+	// bounded is set when some counts are upper bounds, as for the
+	// departure search, which stops early when it can.
+	bounded bool
+	units   map[sku]int
+}
+
+func (e *dryRunEstimate) add(s sku, requests, units int) {
+	e.requests += requests
+	e.units[s] += units
+}
+
+// estimateRun predicts the API usage of querying routes with opts and
+// annotators, without making any calls.
+func estimateRun(cfg matrixio.Config, opts matrix.QueryOptions, routes []matrix.Route, annotators []matrix.Annotator, g *matrix.Geocoder) dryRunEstimate {
+	e := dryRunEstimate{units: make(map[sku]int)}
+
+	pairs := make(map[[2]string]bool)
+	ends := make(map[string]bool)
+	addresses := make(map[string]bool)
+	queried := make(map[string]bool)
+	legs := 0
+	for _, r := range routes {
+		pairs[[2]string{r.Origin + r.OriginAddress, r.Destination + r.DestinationAddress}] = true
+		ends[r.Origin+r.OriginAddress] = true
+		ends[r.Destination+r.DestinationAddress] = true
+		// matrix.QueryRoutes queries each distinct route once.
+		key := strings.Join(append([]string{r.Origin + r.OriginAddress, r.Destination + r.DestinationAddress}, r.Waypoints...), "|")
+		if !queried[key] {
+			queried[key] = true
+			legs += len(r.Waypoints) + 1
+		}
+		for _, address := range []string{r.OriginAddress, r.DestinationAddress} {
+			if address != "" && (g == nil || !g.Cached(address)) {
+				addresses[address] = true
+			}
+		}
+	}
+
+	matrixSKU, routesMatrix := matrixSKUs(cfg.Provider, opts)
+	e.add(matrixSKU, legs, legs)
+	e.add(skuGeocoding, len(addresses), len(addresses))
+
+	directions := skuDirections
+	if cfg.Provider == "routes" {
+		directions = routesMatrix
+	}
+	for _, a := range annotators {
+		switch a := a.(type) {
+		case *matrix.TrafficModels:
+			e.add(matrixSKU, len(routes)*a.Queries(), len(routes)*a.Queries())
+		case *matrix.PeakTimes:
+			e.add(matrixSKU, len(pairs)*a.Queries(), len(pairs)*a.Queries())
+		case *matrix.DepartureSearch:
+			e.add(matrixSKU, len(routes)*matrix.MaxDepartureQueries, len(routes)*matrix.MaxDepartureQueries)
+			e.bounded = true
+		case matrix.ReverseGeocoding:
+			e.add(skuGeocoding, len(ends), len(ends))
+		case *matrix.RouteGeometry:
+			e.add(directions, len(pairs), len(pairs))
+		case *matrix.RouteAlternatives:
+			s := skuDirectionsAdv
+			if cfg.Provider == "routes" {
+				s = skuRoutesPro
+			}
+			e.add(s, len(pairs), len(pairs))
+		case *matrix.TollCost:
+			e.add(skuRoutesEnterprise, len(pairs), len(pairs))
+		case *matrix.ProviderComparison:
+			e.add(matrixSKU, len(routes)*a.Queries(), len(routes)*a.Queries())
+		}
+	}
+	return e
+}
+
+// estimateMatrix predicts the API usage of computing every one of origins
+// against every one of destinations, as computeMatrix does.
+func estimateMatrix(provider string, opts matrix.QueryOptions, origins, destinations int) dryRunEstimate {
+	e := dryRunEstimate{units: make(map[sku]int)}
+	originBlock, destinationBlock := matrixBlocks(origins)
+	requests := ((origins + originBlock - 1) / originBlock) * ((destinations + destinationBlock - 1) / destinationBlock)
+	s, _ := matrixSKUs(provider, opts)
+	e.add(s, requests, origins*destinations)
+	return e
+}
+
+// matrixSKUs returns the SKU matrix requests to provider with opts bill,
+// and the Routes API SKU of the same tier, which Routes API route requests
+// bill whatever the provider.
+func matrixSKUs(provider string, opts matrix.QueryOptions) (matrixSKU, routesMatrix sku) {
+	matrixSKU, routesMatrix = skuMatrixBasic, skuRoutesEssentials
+	if opts.InTraffic() {
+		matrixSKU, routesMatrix = skuMatrixAdvanced, skuRoutesPro
+		if opts.TrafficModel != "" || opts.Mode == "two_wheeler" {
+			routesMatrix = skuRoutesEnterprise
+		}
+	}
+	if provider == "routes" {
+		matrixSKU = routesMatrix
+	}
+	return matrixSKU, routesMatrix
+}
+
+// cost totals the estimated cost over all SKUs.
+func (e dryRunEstimate) cost() float64 {
+	var total float64
+	for s, n := range e.units {
+		total += s.cost(n)
+	}
+	return total
+}
+
+// billed totals the estimated billed units over all SKUs.
+func (e dryRunEstimate) billed() int {
+	var total int
+	for _, n := range e.units {
+		total += n
+	}
+	return total
+}
+
+// checkBudget returns an error if the estimate goes over the run's caps on
+// billed units or cost; zero caps are unset.
+func checkBudget(e dryRunEstimate, maxElements int, maxCost float64) error {
+	bound := ""
+	if e.bounded {
+		bound = "up to "
+	}
+	if maxElements > 0 && e.billed() > maxElements {
+		return fmt.Errorf("run would bill %s%d elements, over the -max-elements limit of %d", bound, e.billed(), maxElements)
+	}
+	if maxCost > 0 && e.cost() > maxCost {
+		return fmt.Errorf("run would cost %s$%.2f, over the -max-cost limit of $%g", bound, e.cost(), maxCost)
+	}
+	return nil
+}
+
+// printDryRun reports the estimate for a run of routes at the given
+// concurrency, assuming each request takes the median latency.
+func printDryRun(cfg matrixio.Config, routes []matrix.Route, e dryRunEstimate, latency time.Duration) {
+	concurrency := max(cfg.Concurrency, 1)
+	wall := time.Duration((e.requests+concurrency-1)/concurrency) * latency
+	qps := float64(concurrency) / latency.Seconds()
+
+	bound := ""
+	if e.bounded {
+		bound = "up to "
+	}
+
+	fmt.Fprintf(messages, "Dry run of %s (no API calls made)\n", cfg.Input)
+	fmt.Fprintf(messages, "  rows:               %d\n", len(routes))
+	fmt.Fprintf(messages, "  API requests:       %s%d\n", bound, e.requests)
+
+	skus := make([]sku, 0, len(e.units))
+	for s, n := range e.units {
+		if n > 0 {
+			skus = append(skus, s)
+		}
+	}
+	sort.Slice(skus, func(i, j int) bool { return skus[i].name < skus[j].name })
+
+	var total float64
+	for _, s := range skus {
+		cost := s.cost(e.units[s])
+		total += cost
+		fmt.Fprintf(messages, "  %-25s %d billed, $%.2f\n", s.name+":", e.units[s], cost)
+	}
+	fmt.Fprintf(messages, "  estimated cost:     %s$%.2f (list prices, 20%% volume discount past %d per SKU)\n", bound, total, volumeTier)
+	fmt.Fprintf(messages, "  projected wall time at concurrency %d (%.1f requests/second at %s median latency): %s%s\n",
+		concurrency, qps, latency, bound, wall.Round(time.Millisecond))
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	matrixio "routes/pkg/io"
+)
+
+// defaultAttachLimit is the largest output attached to the email unless the
+// config sets another limit; most mail servers refuse much more.
+const defaultAttachLimit = 10 << 20
+
+// mailer emails the outcome of one batch to a distribution list.
+type mailer struct {
+	cfg   matrixio.EmailConfig
+	jobID string
+}
+
+// newMailer returns a mailer for the batch jobID, or nil if no recipients
+// are configured.
+func newMailer(cfg matrixio.EmailConfig, jobID string) (*mailer, error) {
+	if len(cfg.To) == 0 {
+		return nil, nil
+	}
+	if cfg.SMTP == "" || cfg.From == "" {
+		return nil, errors.New("emailing the run needs email.smtp and email.from in the config")
+	}
+	if _, _, err := net.SplitHostPort(cfg.SMTP); err != nil {
+		return nil, fmt.Errorf("email.smtp: %w", err)
+	}
+	if cfg.AttachLimit == 0 {
+		cfg.AttachLimit = defaultAttachLimit
+	}
+	return &mailer{cfg: cfg, jobID: jobID}, nil
+}
+
+// notify emails e. Errors are logged rather than returned so a mail outage
+// never fails the run it reports on.
+func (m *mailer) notify(e webhookEvent) {
+	msg, err := m.message(e)
+	if err == nil {
+		err = m.send(msg)
+	}
+	if err != nil {
+		slog.Warn("emailing run outcome", "job", m.jobID, "to", strings.Join(m.cfg.To, ","), "err", err)
+		return
+	}
+	slog.Debug("run outcome emailed", "job", m.jobID, "to", strings.Join(m.cfg.To, ","))
+}
+
+// message builds the email for e: a plain text summary, with the output
+// attached when configured and small enough.
+func (m *mailer) message(e webhookEvent) ([]byte, error) {
+	subject := fmt.Sprintf("route-dm run %s: %d rows, %d failed", e.Status, e.Rows, e.FailedRows)
+	if e.Status != "succeeded" {
+		subject = "route-dm run failed: " + e.Error
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Job:          %s\n", m.jobID)
+	fmt.Fprintf(&text, "Status:       %s\n", e.Status)
+	if e.Error != "" {
+		fmt.Fprintf(&text, "Error:        %s\n", e.Error)
+	}
+	if e.Status == "succeeded" || e.Rows > 0 {
+		fmt.Fprintf(&text, "Rows:         %d (%d failed)\n", e.Rows, e.FailedRows)
+	}
+
+	attachment := ""
+	if e.Output != "" {
+		location := e.Output
+		if m.cfg.Link != "" {
+			location = m.cfg.Link
+		}
+		if m.cfg.Attach && m.attachable(e.Output) {
+			attachment = e.Output
+			location += " (attached)"
+		}
+		fmt.Fprintf(&text, "Output:       %s\n", location)
+	}
+	if e.ErrorsOutput != "" {
+		fmt.Fprintf(&text, "Failed rows:  %s\n", e.ErrorsOutput)
+	}
+	return m.compose(subject, text.String(), attachment)
+}
+
+// compose builds an email with text as its plain text body and the file
+// attachment, if not empty, attached.
+func (m *mailer) compose(subject, text, attachment string) ([]byte, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	part.Write([]byte(strings.ReplaceAll(text, "\n", "\r\n")))
+	if attachment != "" {
+		if err := attachFile(w, attachment); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(m.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", w.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// attachable reports whether output is a local file within the attachment
+// limit.
+func (m *mailer) attachable(output string) bool {
+	if output == "-" || strings.Contains(output, "://") {
+		return false
+	}
+	info, err := os.Stat(output)
+	return err == nil && info.Mode().IsRegular() && info.Size() <= m.cfg.AttachLimit
+}
+
+// attachFile adds the file at path to w as a base64 attachment.
+func attachFile(w *multipart.Writer, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Transfer-Encoding", "base64")
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(path)}))
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	// Base64 bodies are wrapped at 76 characters, as MIME requires.
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		part.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	_, err = part.Write([]byte(encoded + "\r\n"))
+	return err
+}
+
+// send delivers msg through the configured server, logging in with
+// SMTP_USERNAME and SMTP_PASSWORD when set.
+func (m *mailer) send(msg []byte) error {
+	host, port, _ := net.SplitHostPort(m.cfg.SMTP)
+	var auth smtp.Auth
+	if user := os.Getenv("SMTP_USERNAME"); user != "" {
+		auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	if port != "465" {
+		// SendMail upgrades with STARTTLS when the server offers it.
+		return smtp.SendMail(m.cfg.SMTP, auth, m.cfg.From, m.cfg.To, msg)
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", m.cfg.SMTP, &tls.Config{ServerName: host})
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.cfg.From); err != nil {
+		return err
+	}
+	for _, to := range m.cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"routes/pkg/matrix"
+	"routes/pkg/routedmpb"
+)
+
+// grpcServer implements the RouteDistanceMatrix service of routedm.proto
+// with the provider, clients and limits of the HTTP server.
+type grpcServer struct {
+	routedmpb.UnimplementedRouteDistanceMatrixServer
+	s *server
+}
+
+// newGRPCServer returns a gRPC server for the API of s, authenticating
+// calls as the HTTP endpoints do.
+func (s *server) newGRPCServer() *grpc.Server {
+	gs := grpc.NewServer(grpc.UnaryInterceptor(s.authenticateUnary), grpc.StreamInterceptor(s.authenticateStream))
+	routedmpb.RegisterRouteDistanceMatrixServer(gs, grpcServer{s: s})
+	return gs
+}
+
+// authenticateUnary lets a call through only with the API key of a
+// configured client in its "authorization" ("Bearer KEY") or "x-api-key"
+// metadata, like authenticate.
+func (s *server) authenticateUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := s.authenticateContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authenticateStream is authenticateUnary for streaming calls.
+func (s *server) authenticateStream(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticateContext(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, authenticatedStream{stream, ctx})
+}
+
+// authenticatedStream is a stream whose context carries the calling client.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (a authenticatedStream) Context() context.Context {
+	return a.ctx
+}
+
+// authenticateContext returns ctx with the calling client, found from the
+// call's metadata. Servers without clients let every call through.
+func (s *server) authenticateContext(ctx context.Context) (context.Context, error) {
+	if s.clients == nil {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	client := s.clientForKey(first("authorization"), first("x-api-key"))
+	if client == nil {
+		return nil, status.Error(codes.Unauthenticated, errUnauthenticated.Error())
+	}
+	return context.WithValue(ctx, clientContextKey{}, client), nil
+}
+
+// chargeStatus charges the calling client for elements, as a gRPC status
+// error when its quota refuses them.
+func (s *server) chargeStatus(ctx context.Context, elements int64) error {
+	if err := s.charge(contextClient(ctx), elements); err != nil {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return nil
+}
+
+// stoppable returns p stopped once ctx is done, so a cancelled call makes
+// no further requests.
+func stoppable(ctx context.Context, p matrix.Provider) matrix.Provider {
+	stop := matrix.NewStoppableProvider(p)
+	context.AfterFunc(ctx, stop.Stop)
+	return stop
+}
+
+func invalidArgument(err error) error {
+	return status.Error(codes.InvalidArgument, err.Error())
+}
+
+// grpcMatrixQuery is a checked matrix request, ready to compute.
+type grpcMatrixQuery struct {
+	opts                  matrix.QueryOptions
+	p                     matrix.Provider
+	origins, destinations []matrixPoint
+}
+
+// matrixQuery checks a matrix request and charges the calling client for
+// it.
+func (s *server) matrixQuery(ctx context.Context, req *routedmpb.MatrixRequest) (grpcMatrixQuery, error) {
+	var q grpcMatrixQuery
+	if len(req.Origins) == 0 || len(req.Destinations) == 0 {
+		return q, invalidArgument(errors.New("origins and destinations must not be empty"))
+	}
+	n := len(req.Origins) * len(req.Destinations)
+	if n > s.maxElements {
+		return q, invalidArgument(fmt.Errorf("%d elements requested, over the limit of %d", n, s.maxElements))
+	}
+	var err error
+	if q.opts, q.p, err = s.newQuery(req.GetOptions().GetAvoid(), req.GetOptions().GetMode()); err != nil {
+		return q, invalidArgument(err)
+	}
+	if q.origins, err = s.matrixPoints(req.Origins); err != nil {
+		return q, invalidArgument(fmt.Errorf("origins: %w", err))
+	}
+	if q.destinations, err = s.matrixPoints(req.Destinations); err != nil {
+		return q, invalidArgument(fmt.Errorf("destinations: %w", err))
+	}
+	return q, s.chargeStatus(ctx, int64(n))
+}
+
+func (g grpcServer) ComputeMatrix(ctx context.Context, req *routedmpb.MatrixRequest) (*routedmpb.MatrixResponse, error) {
+	s := g.s
+	q, err := s.matrixQuery(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	cells := s.cachedMatrix(stoppable(ctx, q.p), q.opts, q.origins, q.destinations)
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	resp := &routedmpb.MatrixResponse{Origins: req.Origins, Destinations: req.Destinations}
+	for i, row := range cells {
+		out := &routedmpb.MatrixRow{}
+		for j, cell := range row {
+			out.Elements = append(out.Elements, matrixElement(i, j, cell))
+		}
+		resp.Rows = append(resp.Rows, out)
+	}
+	return resp, nil
+}
+
+// matrixElement is cell, the route from origin i to destination j, as
+// returned by the gRPC service.
+func matrixElement(i, j int, cell matrixCell) *routedmpb.MatrixElement {
+	element := &routedmpb.MatrixElement{Status: "N/A", OriginIndex: int32(i), DestinationIndex: int32(j)}
+	if cell.duration != "N/A" {
+		element.Status, element.DistanceKm, element.Duration = "OK", cell.distanceKm, cell.duration
+	}
+	return element
+}
+
+func (g grpcServer) ComputeBatch(ctx context.Context, req *routedmpb.BatchRequest) (*routedmpb.BatchResponse, error) {
+	s := g.s
+	opts, p, routes, err := s.batchQuery(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	client := &matrix.Client{Provider: stoppable(ctx, p), Concurrency: s.cfg.Concurrency}
+	results := client.Compute(matrix.Request{Routes: routes, Options: opts})
+	if err := ctx.Err(); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	resp := &routedmpb.BatchResponse{}
+	for i, r := range results {
+		resp.Results = append(resp.Results, routeResult(i, r))
+	}
+	return resp, nil
+}
+
+func (g grpcServer) StreamMatrix(req *routedmpb.MatrixRequest, stream routedmpb.RouteDistanceMatrix_StreamMatrixServer) error {
+	s := g.s
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	q, err := s.matrixQuery(ctx, req)
+	if err != nil {
+		return err
+	}
+	// A failed send means the caller has gone; stop querying for it.
+	var sendErr error
+	computeMatrixBlocks(stoppable(ctx, q.p), q.opts, q.origins, q.destinations, s.cfg.Concurrency, func(b matrixBlock, cells [][]matrixCell) {
+		for i := b.o; i < b.oEnd && sendErr == nil; i++ {
+			for j := b.d; j < b.dEnd && sendErr == nil; j++ {
+				sendErr = stream.Send(matrixElement(i, j, cells[i][j]))
+			}
+		}
+		if sendErr != nil {
+			cancel()
+		}
+	})
+	if sendErr != nil {
+		return sendErr
+	}
+	if err := stream.Context().Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	return nil
+}
+
+func (g grpcServer) StreamBatch(req *routedmpb.BatchRequest, stream routedmpb.RouteDistanceMatrix_StreamBatchServer) error {
+	s := g.s
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	opts, p, routes, err := s.batchQuery(ctx, req)
+	if err != nil {
+		return err
+	}
+	var sendErr error
+	client := &matrix.Client{Provider: stoppable(ctx, p), Concurrency: s.cfg.Concurrency}
+	client.Stream(matrix.Request{Routes: routes, Options: opts}, func(i int, r matrix.Result) {
+		if sendErr == nil {
+			if sendErr = stream.Send(routeResult(i, r)); sendErr != nil {
+				cancel()
+			}
+		}
+	})
+	if sendErr != nil {
+		return sendErr
+	}
+	if err := stream.Context().Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	return nil
+}
+
+// batchQuery checks a batch request and charges the calling client for it,
+// returning its routes with the options and provider to compute them with.
+func (s *server) batchQuery(ctx context.Context, req *routedmpb.BatchRequest) (matrix.QueryOptions, matrix.Provider, []matrix.Route, error) {
+	if len(req.Routes) == 0 {
+		return matrix.QueryOptions{}, nil, nil, invalidArgument(errors.New("routes must not be empty"))
+	}
+	opts, p, err := s.newQuery(req.GetOptions().GetAvoid(), req.GetOptions().GetMode())
+	if err != nil {
+		return opts, nil, nil, invalidArgument(err)
+	}
+	routes := make([]matrix.Route, len(req.Routes))
+	for i, r := range req.Routes {
+		if routes[i], err = s.batchRoute(r); err != nil {
+			return opts, nil, nil, invalidArgument(fmt.Errorf("route %d: %w", i+1, err))
+		}
+	}
+	elements := estimateRun(s.cfg, opts, routes, nil, nil).billed()
+	if elements > s.maxElements {
+		return opts, nil, nil, invalidArgument(fmt.Errorf("%d elements requested, over the limit of %d", elements, s.maxElements))
+	}
+	if err := s.chargeStatus(ctx, int64(elements)); err != nil {
+		return opts, nil, nil, err
+	}
+	return opts, p, routes, nil
+}
+
+// batchRoute converts a route of a batch request to WGS84.
+func (s *server) batchRoute(r *routedmpb.Route) (matrix.Route, error) {
+	route := matrix.Route{SiteCode: r.SiteCode, SiteName: r.SiteName, TerminalCode: r.TerminalCode}
+	var err error
+	if route.Origin, err = s.coordinate(r.Origin); err != nil {
+		return route, fmt.Errorf("origin: %w", err)
+	}
+	if route.Destination, err = s.coordinate(r.Destination); err != nil {
+		return route, fmt.Errorf("destination: %w", err)
+	}
+	for i, waypoint := range r.Waypoints {
+		coordinate, err := s.coordinate(waypoint)
+		if err != nil {
+			return route, fmt.Errorf("waypoint %d: %w", i+1, err)
+		}
+		route.Waypoints = append(route.Waypoints, coordinate)
+	}
+	return route, nil
+}
+
+// routeResult is r, the result of route i of a batch, as returned by the
+// gRPC service. Failed routes have no distance or duration.
+func routeResult(i int, r matrix.Result) *routedmpb.RouteResult {
+	result := &routedmpb.RouteResult{
+		Index:        int32(i),
+		SiteCode:     r.SiteCode,
+		SiteName:     r.SiteName,
+		TerminalCode: r.TerminalCode,
+		Status:       r.Status,
+		Error:        r.Error,
+	}
+	if r.Status == "OK" {
+		result.DistanceKm, result.Duration, result.DurationSeconds = r.DistanceKm, r.Duration, int64(r.Seconds)
+	}
+	return result
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+	"routes/pkg/routedmpb"
+)
+
+// dialGRPC serves the gRPC API of s in memory and returns a client of it.
+func dialGRPC(t *testing.T, s *server) routedmpb.RouteDistanceMatrixClient {
+	t.Helper()
+	ln := bufconn.Listen(1 << 20)
+	gs := s.newGRPCServer()
+	go gs.Serve(ln)
+	t.Cleanup(gs.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return routedmpb.NewRouteDistanceMatrixClient(conn)
+}
+
+func newMockServer() *server {
+	return &server{cfg: matrixio.Config{Provider: "mock", Concurrency: 2}, epsg: 4326, maxElements: 100, jobs: map[string]*batchJob{}}
+}
+
+func TestGRPCComputeMatrix(t *testing.T) {
+	client := dialGRPC(t, newMockServer())
+	origins := []string{"-6.2,106.8", "-6.3, 106.9"}
+	destinations := []string{"-6.9,107.6", "-7.0,107.7", "-6.25,106.85"}
+	resp, err := client.ComputeMatrix(context.Background(), &routedmpb.MatrixRequest{Origins: origins, Destinations: destinations})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same cells as the CLI's matrix command computes.
+	p, err := matrix.NewProvider("mock", "", matrix.QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newMockServer()
+	o, _ := s.matrixPoints(origins)
+	d, _ := s.matrixPoints(destinations)
+	want := computeMatrix(p, matrix.QueryOptions{}, o, d, 1)
+
+	if len(resp.Rows) != len(origins) {
+		t.Fatalf("got %d rows, want %d", len(resp.Rows), len(origins))
+	}
+	for i, row := range resp.Rows {
+		if len(row.Elements) != len(destinations) {
+			t.Fatalf("row %d has %d elements, want %d", i, len(row.Elements), len(destinations))
+		}
+		for j, e := range row.Elements {
+			if e.OriginIndex != int32(i) || e.DestinationIndex != int32(j) || e.Status != "OK" ||
+				e.DistanceKm != want[i][j].distanceKm || e.Duration != want[i][j].duration {
+				t.Errorf("element %d,%d = %v, want OK %v km %s", i, j, e, want[i][j].distanceKm, want[i][j].duration)
+			}
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		req  *routedmpb.MatrixRequest
+		want string
+	}{
+		{"no destinations", &routedmpb.MatrixRequest{Origins: origins}, "must not be empty"},
+		{"bad location", &routedmpb.MatrixRequest{Origins: origins, Destinations: []string{"-6.9 107.6"}}, `destinations: location 1: "-6.9 107.6" is not lat,lng`},
+		{"bad mode", &routedmpb.MatrixRequest{Origins: origins, Destinations: destinations, Options: &routedmpb.QueryOptions{Mode: "flying"}}, "flying"},
+		{"too large", &routedmpb.MatrixRequest{Origins: make([]string, 11), Destinations: make([]string, 10)}, "110 elements requested, over the limit of 100"},
+	} {
+		_, err := client.ComputeMatrix(context.Background(), tt.req)
+		if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want InvalidArgument with %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestGRPCComputeBatch(t *testing.T) {
+	client := dialGRPC(t, newMockServer())
+	routes := []*routedmpb.Route{
+		{SiteCode: "S1", SiteName: "Alpha", TerminalCode: "T1", Origin: "-6.3,106.9", Destination: "-6.2,106.8"},
+		{SiteCode: "S2", TerminalCode: "T1", Origin: "-6.3,106.9", Destination: "-6.5,107.2", Waypoints: []string{"-6.4,107.0"}},
+	}
+	resp, err := client.ComputeBatch(context.Background(), &routedmpb.BatchRequest{Routes: routes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 2 {
+		t.Fatalf("got %d results, want 2", len(resp.Results))
+	}
+	p, err := matrix.NewProvider("mock", "", matrix.QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range resp.Results {
+		want := matrix.QueryRoute(p, matrix.Route{Origin: routes[i].Origin, Destination: routes[i].Destination, Waypoints: routes[i].Waypoints}, matrix.QueryOptions{})
+		if r.Index != int32(i) || r.SiteCode != routes[i].SiteCode || r.SiteName != routes[i].SiteName || r.Status != "OK" ||
+			r.DistanceKm != want.DistanceKm || r.DurationSeconds != int64(want.Seconds) {
+			t.Errorf("result %d = %v, want %s OK %v km %d s", i, r, routes[i].SiteCode, want.DistanceKm, want.Seconds)
+		}
+	}
+
+	bad := append(routes, &routedmpb.Route{SiteCode: "S3", Origin: "-6.3,106.9", Destination: "-6.5,107.2", Waypoints: []string{"north"}})
+	_, err = client.ComputeBatch(context.Background(), &routedmpb.BatchRequest{Routes: bad})
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "route 3: waypoint 1") {
+		t.Errorf("bad waypoint: got %v", err)
+	}
+	if _, err := client.ComputeBatch(context.Background(), &routedmpb.BatchRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("empty batch: got %v", err)
+	}
+}
+
+func TestGRPCClients(t *testing.T) {
+	aliceKey, aliceDigest, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := newAPIClients([]matrixio.ClientConfig{{Name: "alice", KeySHA256: aliceDigest, DailyElements: 6}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newMockServer()
+	s.clients = clients
+	client := dialGRPC(t, s)
+	req := &routedmpb.MatrixRequest{Origins: []string{"-6.2,106.8", "-6.3,106.9"}, Destinations: []string{"-6.9,107.6", "-7.0,107.7"}}
+
+	for _, tt := range []struct {
+		name string
+		md   metadata.MD
+		want codes.Code
+	}{
+		{"no key", nil, codes.Unauthenticated},
+		{"unknown key", metadata.Pairs("authorization", "Bearer rdm_nope"), codes.Unauthenticated},
+		{"bearer key", metadata.Pairs("authorization", "Bearer "+aliceKey), codes.OK},
+		{"x-api-key over quota", metadata.Pairs("x-api-key", aliceKey), codes.ResourceExhausted},
+	} {
+		ctx := metadata.NewOutgoingContext(context.Background(), tt.md)
+		if _, err := client.ComputeMatrix(ctx, req); status.Code(err) != tt.want {
+			t.Errorf("%s: got %v, want %s", tt.name, err, tt.want)
+		}
+	}
+	if got := clients[aliceDigest]; got.requests != 1 || got.elements != 4 {
+		t.Errorf("alice used %d requests and %d elements, want 1 and 4", got.requests, got.elements)
+	}
+}
+
+func TestGRPCStreamMatrix(t *testing.T) {
+	s := newMockServer()
+	// 30 origins need two blocks of requests, which finish in any order.
+	s.maxElements = 1000
+	client := dialGRPC(t, s)
+	req := &routedmpb.MatrixRequest{Destinations: []string{"-6.9,107.6", "-7.0,107.7"}}
+	for i := range 30 {
+		req.Origins = append(req.Origins, fmt.Sprintf("-6.%02d,106.8", i))
+	}
+	whole, err := client.ComputeMatrix(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := client.StreamMatrix(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[[2]int32]bool{}
+	for {
+		e, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := [2]int32{e.OriginIndex, e.DestinationIndex}
+		if seen[key] {
+			t.Errorf("element %v sent twice", key)
+		}
+		seen[key] = true
+		if want := whole.Rows[e.OriginIndex].Elements[e.DestinationIndex]; !proto.Equal(e, want) {
+			t.Errorf("streamed %v, want %v", e, want)
+		}
+	}
+	if len(seen) != 60 {
+		t.Errorf("streamed %d elements, want 60", len(seen))
+	}
+
+	// Checks happen before the first element is sent.
+	req.Origins = append(req.Origins, "north")
+	stream, err = client.StreamMatrix(context.Background(), req)
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("bad origin: got %v, want InvalidArgument", err)
+	}
+}
+
+func TestGRPCStreamBatch(t *testing.T) {
+	aliceKey, aliceDigest, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newMockServer()
+	if s.clients, err = newAPIClients([]matrixio.ClientConfig{{Name: "alice", KeySHA256: aliceDigest}}); err != nil {
+		t.Fatal(err)
+	}
+	client := dialGRPC(t, s)
+	// S1 and S3 share their pair, which is queried once and sent for both.
+	req := &routedmpb.BatchRequest{Routes: []*routedmpb.Route{
+		{SiteCode: "S1", TerminalCode: "T1", Origin: "-6.3,106.9", Destination: "-6.2,106.8"},
+		{SiteCode: "S2", TerminalCode: "T1", Origin: "-6.3,106.9", Destination: "-6.5,107.2"},
+		{SiteCode: "S3", TerminalCode: "T2", Origin: "-6.3,106.9", Destination: "-6.2,106.8"},
+	}}
+
+	stream, err := client.StreamBatch(context.Background(), req)
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("streaming without a key: got %v, want Unauthenticated", err)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", aliceKey)
+	whole, err := client.ComputeBatch(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream, err = client.StreamBatch(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]*routedmpb.RouteResult, len(req.Routes))
+	for {
+		r, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[r.Index] != nil {
+			t.Errorf("result %d sent twice", r.Index)
+		}
+		got[r.Index] = r
+	}
+	for i, want := range whole.Results {
+		if !proto.Equal(got[i], want) {
+			t.Errorf("streamed result %d = %v, want %v", i, got[i], want)
+		}
+	}
+	// Both calls were charged the two distinct pairs.
+	if c := s.clients[aliceDigest]; c.requests != 2 || c.elements != 4 {
+		t.Errorf("alice used %d requests and %d elements, want 2 and 4", c.requests, c.elements)
+	}
+}
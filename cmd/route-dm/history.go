@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"routes/pkg/matrix"
+)
+
+// historyRecord is one batch run as the run history keeps it, a JSON line
+// each.
+type historyRecord struct {
+	JobID      string         `json:"job_id"`
+	Finished   time.Time      `json:"finished"`
+	Status     string         `json:"status"` // succeeded or failed
+	Error      string         `json:"error,omitempty"`
+	Rows       int            `json:"rows"`
+	FailedRows int            `json:"failed_rows"`
+	Failures   map[string]int `json:"failures_by_type,omitempty"`
+	Elements   int64          `json:"api_elements,omitempty"`
+	CostUSD    float64        `json:"cost_usd,omitempty"`
+	// Terminals break the routes the run computed down by terminal.
+	Terminals []terminalTotals `json:"terminals,omitempty"`
+}
+
+// terminalTotals are the successful routes of one terminal in a run.
+type terminalTotals struct {
+	Terminal   string  `json:"terminal"`
+	Routes     int     `json:"routes"`
+	DistanceKm float64 `json:"distance_km"`
+	Seconds    int64   `json:"duration_seconds"`
+}
+
+// runHistory appends the outcome of one batch to the run history file,
+// which route-dm report reads.
+type runHistory struct {
+	path  string
+	jobID string
+
+	failures  map[string]int
+	terminals []terminalTotals
+}
+
+// newRunHistory returns the history of the batch jobID, or nil if no
+// history file is configured.
+func newRunHistory(path, jobID string) *runHistory {
+	if path == "" {
+		return nil
+	}
+	return &runHistory{path: path, jobID: jobID}
+}
+
+// add tallies the results the run computed, for the record notify writes.
+func (h *runHistory) add(results []matrix.Result) {
+	h.failures = make(map[string]int)
+	byTerminal := make(map[string]*terminalTotals)
+	for _, r := range results {
+		if r.Status != "OK" {
+			h.failures[r.Status]++
+			continue
+		}
+		t := byTerminal[r.TerminalCode]
+		if t == nil {
+			t = &terminalTotals{Terminal: r.TerminalCode}
+			byTerminal[r.TerminalCode] = t
+		}
+		t.Routes++
+		t.DistanceKm += r.DistanceKm
+		t.Seconds += int64(r.Seconds)
+	}
+	h.terminals = h.terminals[:0]
+	for _, t := range byTerminal {
+		h.terminals = append(h.terminals, *t)
+	}
+	slices.SortFunc(h.terminals, func(a, b terminalTotals) int { return cmp.Compare(a.Terminal, b.Terminal) })
+}
+
+// notify appends the run to the history. Errors are logged rather than
+// returned so a history that cannot be written never fails the run.
+func (h *runHistory) notify(e webhookEvent) {
+	record := historyRecord{
+		JobID:      h.jobID,
+		Finished:   time.Now().UTC(),
+		Status:     e.Status,
+		Error:      e.Error,
+		Rows:       e.Rows,
+		FailedRows: e.FailedRows,
+		Elements:   e.Elements,
+		CostUSD:    e.CostUSD,
+		Terminals:  h.terminals,
+	}
+	if len(h.failures) > 0 {
+		record.Failures = h.failures
+	}
+	if err := appendHistory(h.path, record); err != nil {
+		slog.Warn("recording run history", "history", h.path, "err", err)
+	}
+}
+
+func appendHistory(path string, record historyRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readHistory returns the runs of the history file that finished in
+// [from, to), in the order they were appended.
+func readHistory(path string, from, to time.Time) ([]historyRecord, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil // no run recorded yet
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []historyRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r historyRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		if !r.Finished.Before(from) && r.Finished.Before(to) {
+			records = append(records, r)
+		}
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	matrixio "routes/pkg/io"
+)
+
+// sampleRows is how many data rows init inspects when guessing columns.
+const sampleRows = 50
+
+// runInit implements `route-dm init`: it inspects a sample CSV, guesses which
+// column holds each field, asks the user to confirm and writes a config file.
+func runInit(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	configPath := fs.String("config", matrixio.DefaultConfigFile, "path of the config file to write")
+	applyCSVFlags := matrixio.CSVFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s init [flags] sample.csv\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one sample CSV file")
+	}
+	sample := fs.Arg(0)
+
+	var dialect matrixio.CSVConfig
+	applyCSVFlags(&dialect)
+
+	header, rows, err := readSample(sample, dialect, sampleRows)
+	if err != nil {
+		return err
+	}
+
+	guess := guessColumns(header, rows)
+
+	fmt.Printf("Found %d columns in %s:\n", len(header), sample)
+	for i, name := range header {
+		example := ""
+		if len(rows) > 0 && i < len(rows[0]) {
+			example = rows[0][i]
+		}
+		fmt.Printf("  %d. %s (e.g. %q)\n", i+1, name, example)
+	}
+	fmt.Println("Press Enter to accept a suggestion, or type a column name or number.")
+
+	in := bufio.NewReader(os.Stdin)
+	prompts := []struct {
+		label string
+		field *string
+	}{
+		{"Site code", &guess.SiteCode},
+		{"Site name", &guess.SiteName},
+		{"Site latitude (destination)", &guess.DestinationLat},
+		{"Site longitude (destination)", &guess.DestinationLng},
+		{"Terminal code", &guess.TerminalCode},
+		{"Terminal latitude (origin)", &guess.OriginLat},
+		{"Terminal longitude (origin)", &guess.OriginLng},
+	}
+	for _, p := range prompts {
+		answer, err := ask(in, header, p.label, *p.field)
+		if err != nil {
+			return err
+		}
+		*p.field = answer
+	}
+
+	if err := guess.Validate(header); err != nil {
+		return err
+	}
+
+	cfg := matrixio.DefaultConfig()
+	cfg.Input = sample
+	cfg.Columns = guess
+	cfg.CSV = dialect
+
+	if err := matrixio.WriteConfig(*configPath, cfg); err != nil {
+		return err
+	}
+
+	fmt.Printf("Config written to %s\n", *configPath)
+	return nil
+}
+
+// ask prompts for a single column until the answer refers to an existing column.
+func ask(in *bufio.Reader, header []string, label, suggestion string) (string, error) {
+	for {
+		if suggestion != "" {
+			fmt.Printf("%s [%s]: ", label, suggestion)
+		} else {
+			fmt.Printf("%s: ", label)
+		}
+
+		line, err := in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", fmt.Errorf("reading answer: %w", err)
+		}
+
+		answer := strings.TrimSpace(line)
+		if answer == "" {
+			answer = suggestion
+		}
+
+		i, err := matrixio.ColumnIndex(header, answer)
+		if err == nil && i < len(header) {
+			return header[i], nil
+		}
+		fmt.Printf("  %q is not a column of this file, try again.\n", answer)
+	}
+}
+
+func readSample(filename string, dialect matrixio.CSVConfig, limit int) ([]string, [][]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	reader, err := dialect.NewReader(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	var rows [][]string
+	for len(rows) < limit {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		rows = append(rows, record)
+	}
+
+	return header, rows, nil
+}
+
+// guessColumns picks a column for each field using header keywords first and
+// the value ranges of the sample rows second.
+func guessColumns(header []string, rows [][]string) matrixio.ColumnMapping {
+	used := make(map[int]bool)
+	pick := func(match func(i int, name string) bool) string {
+		for i, name := range header {
+			if !used[i] && match(i, strings.ToLower(name)) {
+				used[i] = true
+				return header[i]
+			}
+		}
+		return ""
+	}
+
+	has := func(name string, words ...string) bool {
+		for _, w := range words {
+			if strings.Contains(name, w) {
+				return true
+			}
+		}
+		return false
+	}
+	isLat := func(name string) bool { return has(name, "lat") }
+	isLng := func(name string) bool { return has(name, "lng", "lon") }
+	isOrigin := func(name string) bool { return has(name, "terminal", "origin", "from", "depot", "hub") }
+
+	var m matrixio.ColumnMapping
+	m.OriginLat = pick(func(_ int, n string) bool { return isLat(n) && isOrigin(n) })
+	m.OriginLng = pick(func(_ int, n string) bool { return isLng(n) && isOrigin(n) })
+	m.DestinationLat = pick(func(_ int, n string) bool { return isLat(n) })
+	m.DestinationLng = pick(func(_ int, n string) bool { return isLng(n) })
+	m.TerminalCode = pick(func(_ int, n string) bool { return has(n, "terminal", "depot", "hub", "origin") })
+	m.SiteName = pick(func(_ int, n string) bool { return has(n, "name") })
+	m.SiteCode = pick(func(_ int, n string) bool { return has(n, "site", "code", "id") })
+
+	// Fall back to value ranges for coordinates the headers did not reveal.
+	// Latitude and longitude columns are expected to come in lat, lng order.
+	numeric := func(lo, hi float64) func(int, string) bool {
+		return func(i int, _ string) bool { return columnInRange(rows, i, lo, hi) }
+	}
+	for _, f := range []*string{&m.DestinationLat, &m.OriginLat} {
+		if *f == "" {
+			*f = pick(numeric(-90, 90))
+		}
+	}
+	for _, f := range []*string{&m.DestinationLng, &m.OriginLng} {
+		if *f == "" {
+			*f = pick(numeric(-180, 180))
+		}
+	}
+
+	text := func(i int, _ string) bool { return !columnInRange(rows, i, -180, 180) }
+	for _, f := range []*string{&m.SiteCode, &m.SiteName, &m.TerminalCode} {
+		if *f == "" {
+			*f = pick(text)
+		}
+	}
+
+	return m
+}
+
+// columnInRange reports whether every non-empty sample value in column i
+// parses as a number between lo and hi.
+func columnInRange(rows [][]string, i int, lo, hi float64) bool {
+	seen := false
+	for _, row := range rows {
+		if i >= len(row) || strings.TrimSpace(row[i]) == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(row[i]), 64)
+		if err != nil || v < lo || v > hi {
+			return false
+		}
+		seen = true
+	}
+	return seen
+}
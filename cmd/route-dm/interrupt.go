@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"routes/pkg/matrix"
+)
+
+// exitInterrupted is the exit status of a run cut short by a signal, the
+// one shells report for SIGINT.
+const exitInterrupted = 130
+
+// stopOnSignal stops p at the first SIGINT or SIGTERM, so the run writes
+// the rows answered so far and reports the rest as INTERRUPTED, and exits
+// at once on a second signal.
+func stopOnSignal(p *matrix.StoppableProvider) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		slog.Warn("interrupted: finishing the requests in flight, then writing the results so far; interrupt again to quit at once")
+		p.Stop()
+		<-signals
+		os.Exit(exitInterrupted)
+	}()
+}
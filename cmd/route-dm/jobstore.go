@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	matrixio "routes/pkg/io"
+)
+
+// jobStore keeps the server's batch jobs in a directory, so a restarted
+// server still serves the finished ones and runs the interrupted ones
+// again. Each job has up to three files:
+//
+//	ID.json        its status, as GET /batch/{id} shows it, and its client
+//	ID.input.csv   the upload, to run it again after a restart
+//	ID.result.csv  the results, once it is done
+type jobStore struct {
+	dir string
+}
+
+// jobState is a job as saved to disk.
+type jobState struct {
+	batchJob
+	Client string `json:"client,omitempty"`
+}
+
+func newJobStore(dir string) (*jobStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating job state directory: %w", err)
+	}
+	return &jobStore{dir: dir}, nil
+}
+
+func (st *jobStore) path(id, suffix string) string {
+	return filepath.Join(st.dir, id+suffix)
+}
+
+func (st *jobStore) inputPath(id string) string  { return st.path(id, ".input.csv") }
+func (st *jobStore) resultPath(id string) string { return st.path(id, ".result.csv") }
+
+// save writes the status of job, replacing the saved one.
+func (st *jobStore) save(job batchJob) error {
+	return matrixio.WriteOutput(st.path(job.ID, ".json"), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(jobState{batchJob: job, Client: job.client})
+	})
+}
+
+// saveInput keeps the upload of job id.
+func (st *jobStore) saveInput(id string, data []byte) error {
+	return matrixio.WriteOutput(st.inputPath(id), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// remove deletes every file of job id.
+func (st *jobStore) remove(id string) {
+	for _, suffix := range []string{".json", ".input.csv", ".result.csv"} {
+		os.Remove(st.path(id, suffix))
+	}
+}
+
+// load returns the saved jobs.
+func (st *jobStore) load() ([]batchJob, error) {
+	entries, err := os.ReadDir(st.dir)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []batchJob
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(st.dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var state jobState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		if state.ID+".json" != e.Name() {
+			return nil, fmt.Errorf("%s holds job %q", e.Name(), state.ID)
+		}
+		job := state.batchJob
+		job.client = state.Client
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
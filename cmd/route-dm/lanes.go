@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+// laneCache keeps the matrix elements serve has answered, by WGS84 origin,
+// destination and query options. Critical and preloaded lanes are pinned:
+// they are kept until answered again. Other lanes are kept for ttl, or not
+// at all when it is zero. Failed elements are never kept.
+type laneCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu    sync.Mutex
+	lanes map[laneKey]cachedLane
+}
+
+type laneKey struct{ origin, destination, query string }
+
+type cachedLane struct {
+	cell    matrixCell
+	fetched time.Time
+	pinned  bool
+}
+
+func newLaneCache(ttl time.Duration) *laneCache {
+	return &laneCache{ttl: ttl, now: time.Now, lanes: make(map[laneKey]cachedLane)}
+}
+
+// laneQuery identifies the options a lane was queried with.
+func laneQuery(opts matrix.QueryOptions) string {
+	return opts.Mode + "|" + strings.Join(opts.Avoid, ",")
+}
+
+// get returns the lane's element, unless it is unknown or has expired.
+func (c *laneCache) get(k laneKey) (cachedLane, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lane, ok := c.lanes[k]
+	if ok && !lane.pinned && c.now().Sub(lane.fetched) >= c.ttl {
+		delete(c.lanes, k)
+		return cachedLane{}, false
+	}
+	return lane, ok
+}
+
+// put keeps an answered element. A lane once pinned stays so.
+func (c *laneCache) put(k laneKey, cell matrixCell, pinned bool) {
+	if cell.duration == "N/A" || (!pinned && c.ttl <= 0) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pinned = pinned || c.lanes[k].pinned
+	c.lanes[k] = cachedLane{cell: cell, fetched: c.now(), pinned: pinned}
+}
+
+// cachedMatrix is computeMatrix answering the lanes s.lanes knows from
+// memory: only the origins and destinations of unknown lanes are queried.
+func (s *server) cachedMatrix(p matrix.Provider, opts matrix.QueryOptions, origins, destinations []matrixPoint) [][]matrixCell {
+	if s.lanes == nil {
+		return computeMatrix(p, opts, origins, destinations, s.cfg.Concurrency)
+	}
+	query := laneQuery(opts)
+	cells := make([][]matrixCell, len(origins))
+	var missingOrigins, missingDestinations []int
+	missing := make(map[int]bool)
+	for i, origin := range origins {
+		cells[i] = make([]matrixCell, len(destinations))
+		for j, destination := range destinations {
+			if lane, ok := s.lanes.get(laneKey{origin.coordinate, destination.coordinate, query}); ok {
+				cells[i][j] = lane.cell
+				continue
+			}
+			if len(missingOrigins) == 0 || missingOrigins[len(missingOrigins)-1] != i {
+				missingOrigins = append(missingOrigins, i)
+			}
+			if !missing[j] {
+				missing[j] = true
+				missingDestinations = append(missingDestinations, j)
+			}
+		}
+	}
+	if len(missingOrigins) == 0 {
+		return cells
+	}
+
+	liveOrigins := make([]matrixPoint, len(missingOrigins))
+	for a, i := range missingOrigins {
+		liveOrigins[a] = origins[i]
+	}
+	liveDestinations := make([]matrixPoint, len(missingDestinations))
+	for b, j := range missingDestinations {
+		liveDestinations[b] = destinations[j]
+	}
+	live := computeMatrix(p, opts, liveOrigins, liveDestinations, s.cfg.Concurrency)
+	for a, i := range missingOrigins {
+		for b, j := range missingDestinations {
+			if cells[i][j].duration != "" {
+				continue // answered from memory
+			}
+			cells[i][j] = live[a][b]
+			s.lanes.put(laneKey{origins[i].coordinate, destinations[j].coordinate, query}, live[a][b], false)
+		}
+	}
+	return cells
+}
+
+// preloadLanes pins the lanes of a published matrix file in the cache,
+// as answers for the default query options, and returns how many it read.
+// The file is a CSV with ORIGIN and DESTINATION columns, as runs with
+// -previous write, or ORIGIN_ID and DESTINATION_ID, as the long layout of
+// route-dm matrix writes when the IDs are coordinates; either holds
+// "lat,lng" in the server's CRS. A distance column and DURATION give the
+// answer. Failed rows, rows with waypoints and ends that are not
+// coordinates are skipped.
+func (s *server) preloadLanes(path string) (int, error) {
+	file, err := matrixio.OpenInput(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	reader, err := s.cfg.CSV.NewReader(file)
+	if err != nil {
+		return 0, err
+	}
+	records, err := reader.ReadAll()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(records) == 0 {
+		return 0, fmt.Errorf("%s is empty", path)
+	}
+
+	header := records[0]
+	origin, destination := slices.Index(header, "ORIGIN"), slices.Index(header, "DESTINATION")
+	if origin < 0 || destination < 0 {
+		origin, destination = slices.Index(header, "ORIGIN_ID"), slices.Index(header, "DESTINATION_ID")
+	}
+	duration, waypoints := slices.Index(header, "DURATION"), slices.Index(header, "WAYPOINTS")
+	seconds := slices.Index(header, "DURATION_SECONDS")
+	units, _ := matrixio.ParseDistanceUnits([]string{"km", "mi", "m", "nmi"})
+	distance := -1
+	var unit matrixio.DistanceUnit
+	for _, u := range units {
+		if i := slices.Index(header, u.Column()); i >= 0 {
+			distance, unit = i, u
+			break
+		}
+	}
+	if origin < 0 || destination < 0 || distance < 0 || duration < 0 {
+		return 0, fmt.Errorf("%s has no ORIGIN and DESTINATION (or ORIGIN_ID and DESTINATION_ID), distance and DURATION columns", path)
+	}
+
+	query := laneQuery(matrix.QueryOptions{})
+	loaded := 0
+	for _, record := range records[1:] {
+		if len(record) != len(header) || record[duration] == "N/A" || (waypoints >= 0 && record[waypoints] != "") {
+			continue
+		}
+		// Failed rows may also carry an -on-failure placeholder such as -1.
+		d, err := strconv.ParseFloat(record[distance], 64)
+		if err != nil || d < 0 {
+			continue
+		}
+		points, err := s.matrixPoints([]string{record[origin], record[destination]})
+		if err != nil {
+			continue
+		}
+		cell := matrixCell{distanceKm: d / unit.FromKm(1), duration: record[duration]}
+		if seconds >= 0 {
+			cell.seconds, _ = strconv.Atoi(record[seconds])
+		}
+		s.lanes.put(laneKey{points[0].coordinate, points[1].coordinate, query}, cell, true)
+		loaded++
+	}
+	return loaded, nil
+}
+
+// criticalLane is a lane of the config's critical_lanes.
+type criticalLane struct {
+	name                string
+	origin, destination matrixPoint
+}
+
+func (s *server) criticalLanes(lanes []matrixio.LaneConfig) ([]criticalLane, error) {
+	critical := make([]criticalLane, len(lanes))
+	for i, lane := range lanes {
+		points, err := s.matrixPoints([]string{lane.Origin, lane.Destination})
+		if err != nil {
+			return nil, fmt.Errorf("critical lane %d: %w", i+1, err)
+		}
+		name := lane.Name
+		if name == "" {
+			name = lane.Origin + " to " + lane.Destination
+		}
+		critical[i] = criticalLane{name: name, origin: points[0], destination: points[1]}
+	}
+	return critical, nil
+}
+
+// refreshCriticalLanes queries every critical lane again, however recently
+// it was fetched. A lane that fails keeps its previous answer.
+func (s *server) refreshCriticalLanes() {
+	opts := matrix.QueryOptions{}
+	p, err := s.newProvider(opts)
+	if err != nil {
+		slog.Error("refreshing critical lanes", "err", err)
+		return
+	}
+	start := time.Now()
+	next := make(chan criticalLane)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failed := 0
+	for range max(s.cfg.Concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for lane := range next {
+				cell := computeMatrix(p, opts, []matrixPoint{lane.origin}, []matrixPoint{lane.destination}, 1)[0][0]
+				if cell.duration == "N/A" {
+					slog.Warn("critical lane not refreshed", "lane", lane.name)
+					mu.Lock()
+					failed++
+					mu.Unlock()
+					continue
+				}
+				s.lanes.put(laneKey{lane.origin.coordinate, lane.destination.coordinate, laneQuery(opts)}, cell, true)
+			}
+		}()
+	}
+	for _, lane := range s.critical {
+		next <- lane
+	}
+	close(next)
+	wg.Wait()
+	slog.Info("critical lanes refreshed", "lanes", len(s.critical), "failed", failed, "elapsed", time.Since(start).Round(time.Millisecond))
+}
+
+// refreshCriticalLanesOn refreshes the critical lanes each time schedule
+// matches, for as long as the server runs.
+func (s *server) refreshCriticalLanesOn(schedule *cronSchedule) {
+	for {
+		at := schedule.next(time.Now())
+		if at.IsZero() {
+			slog.Error("the critical lane schedule never matches")
+			return
+		}
+		time.Sleep(time.Until(at))
+		s.refreshCriticalLanes()
+	}
+}
+
+// laneStatus is a critical lane as GET /lanes reports it. Its ends are as
+// the config gives them.
+type laneStatus struct {
+	Name        string `json:"name"`
+	Origin      string `json:"origin"`
+	Destination string `json:"destination"`
+	// Status is OK, or N/A while the lane has never been answered.
+	Status     string     `json:"status"`
+	DistanceKm float64    `json:"distance_km,omitempty"`
+	Duration   string     `json:"duration,omitempty"`
+	Refreshed  *time.Time `json:"refreshed,omitempty"`
+}
+
+func (s *server) handleLanes(w http.ResponseWriter, r *http.Request) {
+	statuses := make([]laneStatus, len(s.critical))
+	for i, lane := range s.critical {
+		statuses[i] = laneStatus{Name: lane.name, Origin: lane.origin.id, Destination: lane.destination.id, Status: "N/A"}
+		if cached, ok := s.lanes.get(laneKey{lane.origin.coordinate, lane.destination.coordinate, laneQuery(matrix.QueryOptions{})}); ok {
+			statuses[i].Status, statuses[i].DistanceKm, statuses[i].Duration = "OK", cached.cell.distanceKm, cached.cell.duration
+			statuses[i].Refreshed = &cached.fetched
+		}
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
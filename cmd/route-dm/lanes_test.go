@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	matrixio "routes/pkg/io"
+)
+
+func TestLaneCache(t *testing.T) {
+	c := newLaneCache(time.Hour)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	lane := laneKey{"1,1", "2,2", "|"}
+	critical := laneKey{"1,1", "3,3", "|"}
+
+	c.put(lane, matrixCell{distanceKm: 5, duration: "10 mins"}, false)
+	c.put(critical, matrixCell{distanceKm: 7, duration: "12 mins"}, true)
+	c.put(laneKey{"1,1", "4,4", "|"}, matrixCell{duration: "N/A"}, false)
+	if got, ok := c.get(lane); !ok || got.cell.distanceKm != 5 {
+		t.Errorf("get = %+v, %v", got, ok)
+	}
+	if _, ok := c.get(laneKey{"1,1", "2,2", "walking|"}); ok {
+		t.Error("a lane was answered for other query options")
+	}
+	if _, ok := c.get(laneKey{"1,1", "4,4", "|"}); ok {
+		t.Error("a failed element was kept")
+	}
+
+	// An hour on, only the pinned lane is left, even if later answered as
+	// an ordinary one.
+	now = now.Add(time.Hour)
+	if _, ok := c.get(lane); ok {
+		t.Error("an expired lane was answered")
+	}
+	c.put(critical, matrixCell{distanceKm: 8, duration: "13 mins"}, false)
+	now = now.Add(24 * time.Hour)
+	if got, ok := c.get(critical); !ok || got.cell.distanceKm != 8 {
+		t.Errorf("critical lane = %+v, %v", got, ok)
+	}
+
+	// Without a TTL only pinned lanes are kept.
+	c = newLaneCache(0)
+	c.put(lane, matrixCell{distanceKm: 5, duration: "10 mins"}, false)
+	if _, ok := c.get(lane); ok {
+		t.Error("a lane was kept without a TTL")
+	}
+}
+
+func TestCriticalLanes(t *testing.T) {
+	s := newMockServer()
+	s.lanes = newLaneCache(0)
+	var err error
+	s.critical, err = s.criticalLanes([]matrixio.LaneConfig{
+		{Name: "JKT-BDG", Origin: "-6.2,106.8", Destination: "-6.9,107.6"},
+		{Origin: "-6.2,106.8", Destination: "-7.0,107.7"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.criticalLanes([]matrixio.LaneConfig{{Origin: "-6.2 106.8", Destination: "-6.9,107.6"}}); err == nil || !strings.HasPrefix(err.Error(), "critical lane 1: ") {
+		t.Errorf("criticalLanes with a bad origin = %v", err)
+	}
+
+	lanes := func() []laneStatus {
+		rec := httptest.NewRecorder()
+		s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/lanes", nil))
+		var statuses []laneStatus
+		if err := json.Unmarshal(rec.Body.Bytes(), &statuses); err != nil {
+			t.Fatalf("GET /lanes: %v: %s", err, rec.Body)
+		}
+		return statuses
+	}
+	if got := lanes(); len(got) != 2 || got[0].Status != "N/A" || got[0].Refreshed != nil {
+		t.Fatalf("lanes before the refresh = %+v", got)
+	}
+
+	s.refreshCriticalLanes()
+	got := lanes()
+	if got[0].Name != "JKT-BDG" || got[1].Name != "-6.2,106.8 to -7.0,107.7" {
+		t.Errorf("lane names = %q and %q", got[0].Name, got[1].Name)
+	}
+	for _, lane := range got {
+		if lane.Status != "OK" || lane.DistanceKm <= 0 || lane.Refreshed == nil {
+			t.Errorf("refreshed lane = %+v", lane)
+		}
+	}
+
+	// POST /matrix answers the critical lanes from memory and queries the
+	// others.
+	first := s.critical[0]
+	s.lanes.put(laneKey{first.origin.coordinate, first.destination.coordinate, "|"}, matrixCell{distanceKm: 999, duration: "1 min"}, true)
+	body := `{"origins": ["-6.2,106.8"], "destinations": ["-6.9,107.6", "-6.25,106.85"]}`
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/matrix", strings.NewReader(body)))
+	var resp matrixResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("POST /matrix: %v: %s", err, rec.Body)
+	}
+	elements := resp.Rows[0].Elements
+	if elements[0].DistanceKm != 999 || elements[1].Status != "OK" || elements[1].DistanceKm == 999 {
+		t.Errorf("elements = %+v, want the first from memory", elements)
+	}
+}
+
+func TestPreloadLanes(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	published := write("results.csv", `SITE_CODE,TERMINAL_CODE,DISTANCE_MI,DURATION,ORIGIN,DESTINATION,WAYPOINTS
+S1,T1,10,20 mins,"-6.2,106.8","-6.9,107.6",
+S2,T1,N/A,N/A,"-6.2,106.8","-7.0,107.7",
+S3,T1,5,9 mins,"-6.2,106.8","-7.0,107.7","-6.5,107.0"
+S4,T1,7,11 mins,Jakarta,"-7.0,107.7",
+`)
+	long := write("matrix.csv", `ORIGIN_ID,DESTINATION_ID,DISTANCE_KM,DURATION
+"-6.2,106.8","-6.25,106.85",3.5,8 mins
+`)
+
+	s := newMockServer()
+	s.lanes = newLaneCache(0)
+	for path, want := range map[string]int{published: 1, long: 1} {
+		if n, err := s.preloadLanes(path); err != nil || n != want {
+			t.Errorf("preloadLanes(%s) = %d, %v, want %d", filepath.Base(path), n, err, want)
+		}
+	}
+	noLanes := write("sites.csv", "ID,LAT,LNG\nS1,-6.2,106.8\n")
+	if _, err := s.preloadLanes(noLanes); err == nil {
+		t.Error("preloadLanes read a file without lane columns")
+	}
+
+	// Known lanes come from the files, the unknown one is computed.
+	body := `{"origins": ["-6.2,106.8"], "destinations": ["-6.9,107.6", "-6.25,106.85", "-7.0,107.7"]}`
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/matrix", strings.NewReader(body)))
+	var resp matrixResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("POST /matrix: %v: %s", err, rec.Body)
+	}
+	elements := resp.Rows[0].Elements
+	if e := elements[0]; math.Abs(e.DistanceKm-16.09344) > 1e-9 || e.Duration != "20 mins" {
+		t.Errorf("preloaded element in miles = %+v", e)
+	}
+	if e := elements[1]; e.DistanceKm != 3.5 || e.Duration != "8 mins" {
+		t.Errorf("preloaded element from the long layout = %+v", e)
+	}
+	if e := elements[2]; e.Status != "OK" || math.Abs(e.DistanceKm-8.04672) < 1e-9 {
+		t.Errorf("unknown element = %+v, want it computed", e)
+	}
+}
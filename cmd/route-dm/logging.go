@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// messageWriter writes to whatever messages currently is, so log output
+// follows it to stderr when results go to stdout.
+type messageWriter struct{}
+
+func (messageWriter) Write(p []byte) (int, error) { return messages.Write(p) }
+
+// setupLogging installs the default logger. level is debug, info, warn or
+// error; format is text or json.
+func setupLogging(level, format string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unknown log level %q (want debug, info, warn or error)", level)
+	}
+	opts := &slog.HandlerOptions{Level: l}
+
+	var h slog.Handler
+	switch format {
+	case "text":
+		h = slog.NewTextHandler(messageWriter{}, opts)
+	case "json":
+		h = slog.NewJSONHandler(messageWriter{}, opts)
+	default:
+		return fmt.Errorf("unknown log format %q (want text or json)", format)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// fatal logs err, reports the failure to the run's notifiers and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	notifyRun(webhookEvent{Status: "failed", Error: fmt.Sprintf("%s: %v", msg, err)})
+	os.Exit(1)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+// Distance Matrix API limits per request.
+const (
+	maxMatrixSide     = 25
+	maxMatrixElements = 100
+)
+
+// matrixPoint is a named location from an origins or destinations list.
+type matrixPoint struct {
+	id         string
+	coordinate string
+}
+
+// matrixCell holds the result for one origin/destination combination.
+type matrixCell struct {
+	distanceKm float64
+	duration   string
+	seconds    int // duration in seconds, for ranking by time
+}
+
+// runMatrix implements `route-dm matrix`: it computes every origin against
+// every destination and writes the result in long or pivoted layout.
+func runMatrix(args []string) error {
+	fs := flag.NewFlagSet("matrix", flag.ExitOnError)
+	originsPath := fs.String("origins", "origins.csv", "CSV file listing the origins")
+	destinationsPath := fs.String("destinations", "destinations.csv", "CSV file listing the destinations")
+	output := fs.String("output", "matrix.csv", "output CSV file, or - for stdout")
+	compress := fs.String("compress", "", "compress the output file: gzip or zip, adding .gz or .zip to its name")
+	layout := fs.String("layout", "long", "output layout: long (one row per pair) or pivot (origins as rows, destinations as columns)")
+	value := fs.String("value", "distance", "pivot cell value: distance or duration")
+	api := addMatrixFlags(fs)
+	avoid := fs.String("avoid", "", "comma-separated route features to avoid: tolls, highways, ferries, indoor")
+	unitName := fs.String("distance-unit", "km", "distance unit: km, mi, m or nmi")
+	idColumn := fs.String("id-column", "1", "column holding the point ID (header name or 1-based position)")
+	latColumn := fs.String("lat-column", "2", "column holding the latitude")
+	lngColumn := fs.String("lng-column", "3", "column holding the longitude")
+	crs := fs.String("crs", "", "EPSG code of the input coordinates (default WGS84)")
+	applyCSVFlags := matrixio.CSVFlags(fs)
+	fs.Parse(args)
+
+	var dialect matrixio.CSVConfig
+	applyCSVFlags(&dialect)
+
+	if *layout != "long" && *layout != "pivot" {
+		return fmt.Errorf("unknown layout %q", *layout)
+	}
+	if *value != "distance" && *value != "duration" {
+		return fmt.Errorf("unknown pivot value %q", *value)
+	}
+	units, err := matrixio.ParseDistanceUnits([]string{*unitName})
+	if err != nil {
+		return err
+	}
+	unit := units[0]
+	if *output, err = matrixio.CompressedOutput(*output, *compress); err != nil {
+		return err
+	}
+	var opts matrix.QueryOptions
+	if err := matrix.ParseAvoid(*avoid, &opts); err != nil {
+		return err
+	}
+	if *output == "-" {
+		messages = os.Stderr
+	}
+
+	epsg, err := matrixio.ParseEPSG(*crs)
+	if err != nil {
+		return err
+	}
+
+	origins, err := readMatrixPoints(*originsPath, dialect, *idColumn, *latColumn, *lngColumn, epsg)
+	if err != nil {
+		return fmt.Errorf("reading origins: %w", err)
+	}
+	destinations, err := readMatrixPoints(*destinationsPath, dialect, *idColumn, *latColumn, *lngColumn, epsg)
+	if err != nil {
+		return fmt.Errorf("reading destinations: %w", err)
+	}
+
+	cells, err := api.query(opts, origins, destinations)
+	if err != nil {
+		return err
+	}
+
+	var records [][]string
+	if *layout == "pivot" {
+		records = pivotMatrixRecords(origins, destinations, cells, *value, unit)
+	} else {
+		records = longMatrixRecords(origins, destinations, cells, unit)
+	}
+
+	err = matrixio.WriteOutput(*output, func(w io.Writer) error {
+		return dialect.WriteAll(w, records)
+	})
+	if err != nil {
+		return err
+	}
+
+	if *output != "-" {
+		slog.Info("matrix written", "origins", len(origins), "destinations", len(destinations), "output", *output)
+	}
+	return nil
+}
+
+func readMatrixPoints(filename string, dialect matrixio.CSVConfig, idColumn, latColumn, lngColumn string, epsg int) ([]matrixPoint, error) {
+	file, err := matrixio.OpenInput(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := dialect.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("%s must contain a header and at least one data row", filename)
+	}
+
+	var idx [3]int
+	for i, ref := range []string{idColumn, latColumn, lngColumn} {
+		if idx[i], err = matrixio.ColumnIndex(records[0], ref); err != nil {
+			return nil, err
+		}
+	}
+
+	var points []matrixPoint
+	for i, record := range records[1:] {
+		if len(record) <= max(idx[0], idx[1], idx[2]) {
+			return nil, fmt.Errorf("row %d has insufficient columns", i+2)
+		}
+		coordinate, err := matrixio.RowCoordinate(epsg, record[idx[1]], record[idx[2]])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i+2, err)
+		}
+		points = append(points, matrixPoint{id: record[idx[0]], coordinate: coordinate})
+	}
+
+	return points, nil
+}
+
+// matrixBlocks returns the block size that splits a matrix with the given
+// number of origins into requests within the per-request limits.
+func matrixBlocks(origins int) (originBlock, destinationBlock int) {
+	originBlock = max(min(maxMatrixSide, origins), 1)
+	return originBlock, min(maxMatrixSide, maxMatrixElements/originBlock)
+}
+
+// computeMatrix queries the full cross product in blocks that respect the
+// per-request limits, up to concurrency blocks at a time. Elements of
+// failed blocks are recorded as 0/"N/A".
+func computeMatrix(p matrix.Provider, opts matrix.QueryOptions, origins, destinations []matrixPoint, concurrency int) [][]matrixCell {
+	return computeMatrixBlocks(p, opts, origins, destinations, concurrency, nil)
+}
+
+// matrixBlock is the origins [o, oEnd) and destinations [d, dEnd) queried
+// in one request.
+type matrixBlock struct{ o, oEnd, d, dEnd int }
+
+// computeMatrixBlocks is computeMatrix, also calling done, unless it is
+// nil, with each block as soon as its cells are filled. Calls to done do
+// not overlap.
+func computeMatrixBlocks(p matrix.Provider, opts matrix.QueryOptions, origins, destinations []matrixPoint, concurrency int, done func(b matrixBlock, cells [][]matrixCell)) [][]matrixCell {
+	cells := make([][]matrixCell, len(origins))
+	for i := range cells {
+		cells[i] = make([]matrixCell, len(destinations))
+	}
+
+	var blocks []matrixBlock
+	originBlock, destinationBlock := matrixBlocks(len(origins))
+	for o := 0; o < len(origins); o += originBlock {
+		for d := 0; d < len(destinations); d += destinationBlock {
+			blocks = append(blocks, matrixBlock{o, min(o+originBlock, len(origins)), d, min(d+destinationBlock, len(destinations))})
+		}
+	}
+
+	// Blocks fill disjoint cells, so they need no locking.
+	next := make(chan matrixBlock)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for range max(concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range next {
+				fillMatrixBlock(p, opts, origins, destinations, cells, b.o, b.oEnd, b.d, b.dEnd)
+				if done != nil {
+					mu.Lock()
+					done(b, cells)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, b := range blocks {
+		next <- b
+	}
+	close(next)
+	wg.Wait()
+
+	return cells
+}
+
+// fillMatrixBlock queries origins [o, oEnd) against destinations [d, dEnd)
+// and stores the answers in cells.
+func fillMatrixBlock(p matrix.Provider, opts matrix.QueryOptions, origins, destinations []matrixPoint, cells [][]matrixCell, o, oEnd, d, dEnd int) {
+	distanceMatrix, err := p.GetDistanceMatrix(joinCoordinates(origins[o:oEnd]), joinCoordinates(destinations[d:dEnd]), opts)
+	if err != nil {
+		slog.Error("fetching distance matrix", "origins", fmt.Sprintf("%d-%d", o+1, oEnd), "destinations", fmt.Sprintf("%d-%d", d+1, dEnd), "err", err)
+	}
+
+	for i := o; i < oEnd; i++ {
+		for j := d; j < dEnd; j++ {
+			cells[i][j] = matrixCell{distanceKm: 0, duration: "N/A"}
+			if err != nil || i-o >= len(distanceMatrix.Rows) || j-d >= len(distanceMatrix.Rows[i-o].Elements) {
+				continue
+			}
+			element := distanceMatrix.Rows[i-o].Elements[j-d]
+			if element.Status != "OK" {
+				continue
+			}
+			cells[i][j] = matrixCell{
+				distanceKm: float64(element.Distance.Value) / 1000, // Convert meters to kilometers
+				duration:   element.Duration.Text,
+				seconds:    element.Duration.Value,
+			}
+		}
+	}
+}
+
+func joinCoordinates(points []matrixPoint) string {
+	coordinates := make([]string, len(points))
+	for i, p := range points {
+		coordinates[i] = p.coordinate
+	}
+	return strings.Join(coordinates, "|")
+}
+
+func longMatrixRecords(origins, destinations []matrixPoint, cells [][]matrixCell, unit matrixio.DistanceUnit) [][]string {
+	records := [][]string{{"ORIGIN_ID", "DESTINATION_ID", unit.Column(), "DURATION"}}
+	for i, origin := range origins {
+		for j, destination := range destinations {
+			cell := cells[i][j]
+			records = append(records, []string{origin.id, destination.id, unit.Format(cell.distanceKm), cell.duration})
+		}
+	}
+	return records
+}
+
+func pivotMatrixRecords(origins, destinations []matrixPoint, cells [][]matrixCell, value string, unit matrixio.DistanceUnit) [][]string {
+	header := []string{"ORIGIN_ID"}
+	for _, destination := range destinations {
+		header = append(header, destination.id)
+	}
+
+	records := [][]string{header}
+	for i, origin := range origins {
+		record := []string{origin.id}
+		for _, cell := range cells[i] {
+			if value == "duration" {
+				record = append(record, cell.duration)
+			} else {
+				record = append(record, unit.Format(cell.distanceKm))
+			}
+		}
+		records = append(records, record)
+	}
+	return records
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+
+	"routes/pkg/matrix"
+)
+
+const (
+	monitorRedraw  = 250 * time.Millisecond
+	monitorLogTail = 8
+)
+
+// sparkBlocks draw the throughput graph, lowest to highest.
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// monitor is the full-screen view -tui shows in place of the progress bar,
+// for runs someone watches for hours: the requests in flight, the latest
+// log lines, requests answered per second and keys to pause, resume or
+// stop the run. It reads keys from stdin and draws on stderr, which must
+// both be the terminal.
+type monitor struct {
+	stop     *matrix.StoppableProvider
+	restore  *term.State
+	messages io.Writer // what messages was before the monitor took it
+	start    time.Time
+	done     chan struct{}
+
+	mu       sync.Mutex
+	resumed  *sync.Cond
+	paused   bool
+	label    string
+	total    int
+	began    time.Time // when the stage began
+	steps    int
+	failed   int
+	perSec   []int // items finished in each second since start
+	nextID   int
+	inFlight map[int]flight
+	logs     []string // the last monitorLogTail log lines
+	logged   int
+	partial  []byte // a log line not yet ended
+}
+
+// flight is a request the monitor is waiting on.
+type flight struct {
+	origins, destinations string
+	start                 time.Time
+}
+
+// newMonitor takes over the terminal. close gives it back.
+func newMonitor(stop *matrix.StoppableProvider) (*monitor, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stderr.Fd())) {
+		return nil, errors.New("-tui needs a terminal on stdin and stderr")
+	}
+	state, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return nil, fmt.Errorf("setting up the terminal: %w", err)
+	}
+	m := &monitor{
+		stop:     stop,
+		restore:  state,
+		messages: messages,
+		start:    time.Now(),
+		done:     make(chan struct{}),
+		inFlight: make(map[int]flight),
+	}
+	m.resumed = sync.NewCond(&m.mu)
+	// The alternate screen keeps the shell's scrollback as it was.
+	fmt.Fprint(os.Stderr, "\x1b[?1049h\x1b[?25l")
+	messages = m
+	go m.readKeys()
+	go m.redraw()
+	return m, nil
+}
+
+// close gives the terminal back and prints the log lines last shown.
+func (m *monitor) close() {
+	close(m.done)
+	m.mu.Lock()
+	m.paused = false
+	m.resumed.Broadcast()
+	logs, logged := m.logs, m.logged
+	m.mu.Unlock()
+
+	fmt.Fprint(os.Stderr, "\x1b[?25h\x1b[?1049l")
+	term.Restore(int(os.Stdin.Fd()), m.restore)
+	messages = m.messages
+	if earlier := logged - len(logs); earlier > 0 {
+		fmt.Fprintf(messages, "(%d earlier log lines were only shown by -tui)\n", earlier)
+	}
+	for _, line := range logs {
+		fmt.Fprintln(messages, line)
+	}
+}
+
+// provider returns p reporting its requests to the monitor and holding
+// new ones back while the run is paused.
+func (m *monitor) provider(p matrix.Provider) matrix.Provider {
+	return monitoredProvider{m, p}
+}
+
+type monitoredProvider struct {
+	m *monitor
+	p matrix.Provider
+}
+
+func (mp monitoredProvider) GetDistanceMatrix(origins, destinations string, opts matrix.QueryOptions) (*matrix.DistanceMatrixResponse, error) {
+	m := mp.m
+	m.mu.Lock()
+	for m.paused {
+		m.resumed.Wait()
+	}
+	id := m.nextID
+	m.nextID++
+	m.inFlight[id] = flight{origins, destinations, time.Now()}
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.inFlight, id)
+		m.mu.Unlock()
+	}()
+	return mp.p.GetDistanceMatrix(origins, destinations, opts)
+}
+
+// CallAPI passes the annotators' own requests on, held back too while the
+// run is paused.
+func (mp monitoredProvider) CallAPI(units int64, do func(auth matrix.APIAuth) error) error {
+	m := mp.m
+	m.mu.Lock()
+	for m.paused {
+		m.resumed.Wait()
+	}
+	m.mu.Unlock()
+	return matrix.CallAPI(mp.p, units, do)
+}
+
+// stage returns the matrix.Progress of a batch stage of total items.
+func (m *monitor) stage(label string, total int) matrix.Progress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.label, m.total, m.steps, m.failed, m.began = label, total, 0, 0, time.Now()
+	return monitorProgress{m}
+}
+
+type monitorProgress struct{ m *monitor }
+
+func (p monitorProgress) Step(failed bool) {
+	m := p.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.steps++
+	if failed {
+		m.failed++
+	}
+	m.tick()
+	m.perSec[len(m.perSec)-1]++
+}
+
+// tick adds the seconds passed since the last one counted. m.mu must be
+// held.
+func (m *monitor) tick() {
+	for sec := int(time.Since(m.start) / time.Second); len(m.perSec) <= sec; {
+		m.perSec = append(m.perSec, 0)
+	}
+}
+
+func (p monitorProgress) Finish() {}
+
+// Write receives the log while the monitor is shown, keeping the last lines.
+func (m *monitor) Write(b []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.partial = append(m.partial, b...)
+	for {
+		i := bytes.IndexByte(m.partial, '\n')
+		if i < 0 {
+			break
+		}
+		m.logs = append(m.logs, string(m.partial[:i]))
+		if len(m.logs) > monitorLogTail {
+			m.logs = m.logs[1:]
+		}
+		m.logged++
+		m.partial = m.partial[i+1:]
+	}
+	return len(b), nil
+}
+
+// readKeys handles p to pause or resume, r to resume and q or Ctrl-C to
+// stop like SIGINT: the first finishes the requests in flight and writes
+// the results so far, the second quits at once.
+func (m *monitor) readKeys() {
+	b := make([]byte, 1)
+	stopping := false
+	for {
+		if _, err := os.Stdin.Read(b); err != nil {
+			return
+		}
+		select {
+		case <-m.done:
+			return
+		default:
+		}
+		switch b[0] {
+		case 'p', 'r', ' ':
+			m.mu.Lock()
+			m.paused = b[0] != 'r' && !m.paused && !stopping
+			m.resumed.Broadcast()
+			m.mu.Unlock()
+		case 'q', 3:
+			if stopping {
+				m.close()
+				os.Exit(exitInterrupted)
+			}
+			stopping = true
+			slog.Warn("stopping: finishing the requests in flight, then writing the results so far; press q again to quit at once")
+			m.stop.Stop()
+			m.mu.Lock()
+			m.paused = false
+			m.resumed.Broadcast()
+			m.mu.Unlock()
+		}
+	}
+}
+
+func (m *monitor) redraw() {
+	t := time.NewTicker(monitorRedraw)
+	defer t.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-t.C:
+			m.mu.Lock()
+			screen := m.render()
+			m.mu.Unlock()
+			os.Stderr.WriteString(screen)
+		}
+	}
+}
+
+// render draws the whole screen. m.mu must be held.
+func (m *monitor) render() string {
+	width, height, err := term.GetSize(int(os.Stderr.Fd()))
+	if err != nil || width < 20 || height < 20 {
+		width, height = 80, 24
+	}
+	var lines []string
+	line := func(format string, args ...any) {
+		s := []rune(fmt.Sprintf(format, args...))
+		if len(s) > width {
+			s = s[:width]
+		}
+		lines = append(lines, string(s))
+	}
+
+	elapsed := time.Since(m.began)
+	state := "running"
+	switch {
+	case m.stop.Stopped():
+		state = "stopping"
+	case m.paused:
+		state = "PAUSED"
+	}
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(m.steps) / elapsed.Seconds()
+	}
+	eta := "--"
+	if rate > 0 && !m.paused {
+		eta = time.Duration(float64(m.total-m.steps) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+	line("route-dm  %s  %s", state, time.Since(m.start).Round(time.Second))
+	line("%d/%d %s  %d errors  %.1f/s  ETA %s", m.steps, m.total, m.label, m.failed, rate, eta)
+	line("")
+
+	// The graph leaves out the second still being counted.
+	m.tick()
+	n := min(width-2, len(m.perSec)-1)
+	var recent []int
+	if n > 0 {
+		recent = m.perSec[len(m.perSec)-1-n : len(m.perSec)-1]
+	}
+	top := slices.Max(append([]int{1}, recent...))
+	var graph strings.Builder
+	for _, v := range recent {
+		graph.WriteRune(sparkBlocks[v*(len(sparkBlocks)-1)/top])
+	}
+	line("Per second, last %ds (peak %d)", len(recent), top)
+	line("%s", graph.String())
+	line("")
+
+	flights := make([]flight, 0, len(m.inFlight))
+	for _, f := range m.inFlight {
+		flights = append(flights, f)
+	}
+	slices.SortFunc(flights, func(a, b flight) int { return a.start.Compare(b.start) })
+	line("In flight (%d)", len(flights))
+	room := max(1, height-len(lines)-monitorLogTail-4)
+	for i, f := range flights {
+		if i == room-1 && len(flights) > room {
+			line("  … %d more", len(flights)-i)
+			break
+		}
+		line("  %6s  %s → %s", time.Since(f.start).Round(100*time.Millisecond), f.origins, f.destinations)
+	}
+	line("")
+	line("Log")
+	for _, l := range m.logs {
+		line("  %s", l)
+	}
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines[:height-1], "p pause/resume  r resume  q stop (twice to quit)")
+	return "\x1b[H" + strings.Join(lines, "\x1b[K\r\n") + "\x1b[K"
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+// runNearest implements `route-dm nearest`: it computes the matrix from
+// every terminal to every site and writes each site's closest terminals by
+// road distance.
+//
+// With -capacity-column each terminal takes at most its capacity of sites,
+// or of volume with -volume-column, and each site is assigned one terminal
+// within them by duration: greedily, quickest pairs first, or by min-cost
+// matching for the least total duration.
+func runNearest(args []string) error {
+	fs := flag.NewFlagSet("nearest", flag.ExitOnError)
+	sitesPath := fs.String("sites", "sites.csv", "CSV file listing the sites")
+	terminalsPath := fs.String("terminals", "terminals.csv", "CSV file listing the terminals")
+	output := fs.String("output", "nearest.csv", "output CSV file, or - for stdout")
+	k := fs.Int("k", 1, "number of closest terminals to write per site, nearest first; above 1 adds a RANK column")
+	api := addMatrixFlags(fs)
+	avoid := fs.String("avoid", "", "comma-separated route features to avoid: tolls, highways, ferries, indoor")
+	unitName := fs.String("distance-unit", "km", "distance unit: km, mi, m or nmi")
+	idColumn := fs.String("id-column", "1", "column holding the site or terminal ID (header name or 1-based position)")
+	latColumn := fs.String("lat-column", "2", "column holding the latitude")
+	lngColumn := fs.String("lng-column", "3", "column holding the longitude")
+	crs := fs.String("crs", "", "EPSG code of the input coordinates (default WGS84)")
+	capacityColumn := fs.String("capacity-column", "", "terminals column holding the most sites, or volume with -volume-column, each terminal takes (default: no capacities, nearest terminals)")
+	volumeColumn := fs.String("volume-column", "", "sites column holding each site's volume, counted against -capacity-column (default: each site counts 1)")
+	assign := fs.String("assign", "greedy", "how sites are assigned within capacities: greedy (quickest pairs first) or min-cost (least total duration; counts sites, so not with -volume-column)")
+	applyCSVFlags := matrixio.CSVFlags(fs)
+	fs.Parse(args)
+
+	var dialect matrixio.CSVConfig
+	applyCSVFlags(&dialect)
+
+	if *k < 1 {
+		return errors.New("-k must be at least 1")
+	}
+	if *capacityColumn == "" && *volumeColumn != "" {
+		return errors.New("-volume-column needs -capacity-column")
+	}
+	if *capacityColumn != "" && *k > 1 {
+		return errors.New("-capacity-column assigns one terminal per site; it cannot be combined with -k")
+	}
+	if *assign != "greedy" && *assign != "min-cost" {
+		return fmt.Errorf("unknown assignment %q (want greedy or min-cost)", *assign)
+	}
+	if *assign == "min-cost" && *volumeColumn != "" {
+		return errors.New("-assign min-cost counts sites; use greedy with -volume-column")
+	}
+	units, err := matrixio.ParseDistanceUnits([]string{*unitName})
+	if err != nil {
+		return err
+	}
+	var opts matrix.QueryOptions
+	if err := matrix.ParseAvoid(*avoid, &opts); err != nil {
+		return err
+	}
+	if *output == "-" {
+		messages = os.Stderr
+	}
+	epsg, err := matrixio.ParseEPSG(*crs)
+	if err != nil {
+		return err
+	}
+
+	sites, err := readMatrixPoints(*sitesPath, dialect, *idColumn, *latColumn, *lngColumn, epsg)
+	if err != nil {
+		return fmt.Errorf("reading sites: %w", err)
+	}
+	terminals, err := readMatrixPoints(*terminalsPath, dialect, *idColumn, *latColumn, *lngColumn, epsg)
+	if err != nil {
+		return fmt.Errorf("reading terminals: %w", err)
+	}
+	var capacities, volumes []float64
+	if *capacityColumn != "" {
+		if capacities, err = readNumbers(*terminalsPath, dialect, *capacityColumn, "capacity", 0); err != nil {
+			return fmt.Errorf("reading terminals: %w", err)
+		}
+		volumes = make([]float64, len(sites))
+		for i := range volumes {
+			volumes[i] = 1
+		}
+		if *volumeColumn != "" {
+			if volumes, err = readNumbers(*sitesPath, dialect, *volumeColumn, "volume", 0); err != nil {
+				return fmt.Errorf("reading sites: %w", err)
+			}
+		}
+	}
+
+	// Terminals are the origins, as in the main batch.
+	cells, err := api.query(opts, terminals, sites)
+	if err != nil {
+		return err
+	}
+	choices := make([][]int, len(sites))
+	if capacities == nil {
+		for s := range sites {
+			choices[s] = nearestTerminals(cells, s, *k)
+		}
+	} else {
+		var assigned []int
+		if *assign == "min-cost" {
+			assigned = assignMinCost(cells, capacities)
+		} else {
+			assigned = assignGreedy(cells, capacities, volumes)
+		}
+		loads := make([]float64, len(terminals))
+		counts := make([]int, len(terminals))
+		for s, t := range assigned {
+			if t != unassigned {
+				choices[s] = []int{t}
+				loads[t] += volumes[s]
+				counts[t]++
+			}
+		}
+		for t, terminal := range terminals {
+			fmt.Fprintf(messages, "terminal %s: %d sites, load %g of %g\n", terminal.id, counts[t], loads[t], capacities[t])
+		}
+	}
+	records := nearestRecords(sites, terminals, cells, choices, *k, units[0])
+
+	err = matrixio.WriteOutput(*output, func(w io.Writer) error {
+		return dialect.WriteAll(w, records)
+	})
+	if err != nil {
+		return err
+	}
+
+	if *output != "-" {
+		slog.Info("nearest terminals written", "sites", len(sites), "terminals", len(terminals), "output", *output)
+	}
+	return nil
+}
+
+// nearestTerminals returns the indexes of the up to k terminals closest to
+// site by road, nearest first. Pairs the API did not answer are left out.
+func nearestTerminals(cells [][]matrixCell, site, k int) []int {
+	var candidates []int
+	for t := range cells {
+		if cells[t][site].duration != "N/A" {
+			candidates = append(candidates, t)
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b int) int {
+		return cmp.Compare(cells[a][site].distanceKm, cells[b][site].distanceKm)
+	})
+	return candidates[:min(k, len(candidates))]
+}
+
+// unassigned is the terminal of a site that could not be given one.
+const unassigned = -1
+
+// assignGreedy gives each site a terminal with room left for its volume,
+// taking the answered pairs of the whole matrix quickest first. Sites no
+// terminal has room for are left unassigned.
+func assignGreedy(cells [][]matrixCell, capacities, volumes []float64) []int {
+	type pair struct{ t, s int }
+	var pairs []pair
+	for t := range cells {
+		for s := range cells[t] {
+			if cells[t][s].duration != "N/A" {
+				pairs = append(pairs, pair{t, s})
+			}
+		}
+	}
+	slices.SortStableFunc(pairs, func(a, b pair) int {
+		return cmp.Compare(cells[a.t][a.s].seconds, cells[b.t][b.s].seconds)
+	})
+
+	left := slices.Clone(capacities)
+	assigned := make([]int, len(volumes))
+	for s := range assigned {
+		assigned[s] = unassigned
+	}
+	for _, p := range pairs {
+		if assigned[p.s] == unassigned && volumes[p.s] <= left[p.t] {
+			assigned[p.s] = p.t
+			left[p.t] -= volumes[p.s]
+		}
+	}
+	return assigned
+}
+
+// assignMinCost assigns as many sites as the capacities, counted in whole
+// sites, allow, with the least total duration. It finds the min-cost flow
+// from sites to terminals by successive shortest paths, each moving one
+// more site in and possibly others between terminals.
+func assignMinCost(cells [][]matrixCell, capacities []float64) []int {
+	terminals := len(cells)
+	sites := 0
+	if terminals > 0 {
+		sites = len(cells[0])
+	}
+	// Nodes: the source, sites, terminals, then the sink.
+	source, sink := 0, sites+terminals+1
+	type edge struct{ to, rev, capacity, cost int }
+	graph := make([][]edge, sink+1)
+	addEdge := func(from, to, capacity, cost int) {
+		graph[from] = append(graph[from], edge{to, len(graph[to]), capacity, cost})
+		graph[to] = append(graph[to], edge{from, len(graph[from]) - 1, 0, -cost})
+	}
+	for s := range sites {
+		addEdge(source, 1+s, 1, 0)
+		for t := range terminals {
+			if cells[t][s].duration != "N/A" {
+				addEdge(1+s, 1+sites+t, 1, cells[t][s].seconds)
+			}
+		}
+	}
+	for t, capacity := range capacities {
+		addEdge(1+sites+t, sink, int(capacity), 0)
+	}
+
+	// Residual edges have negative costs, so paths are found with
+	// Bellman-Ford on a queue rather than Dijkstra.
+	dist := make([]int, len(graph))
+	prevNode := make([]int, len(graph))
+	prevEdge := make([]int, len(graph))
+	queued := make([]bool, len(graph))
+	for {
+		for v := range dist {
+			dist[v] = math.MaxInt
+		}
+		dist[source] = 0
+		queue := []int{source}
+		for len(queue) > 0 {
+			u := queue[0]
+			queue = queue[1:]
+			queued[u] = false
+			for i, e := range graph[u] {
+				if e.capacity > 0 && dist[u]+e.cost < dist[e.to] {
+					dist[e.to] = dist[u] + e.cost
+					prevNode[e.to], prevEdge[e.to] = u, i
+					if !queued[e.to] {
+						queued[e.to] = true
+						queue = append(queue, e.to)
+					}
+				}
+			}
+		}
+		if dist[sink] == math.MaxInt {
+			break
+		}
+		for v := sink; v != source; v = prevNode[v] {
+			e := &graph[prevNode[v]][prevEdge[v]]
+			e.capacity--
+			graph[v][e.rev].capacity++
+		}
+	}
+
+	assigned := make([]int, sites)
+	for s := range assigned {
+		assigned[s] = unassigned
+		for _, e := range graph[1+s] {
+			if e.to > sites && e.to < sink && e.capacity == 0 {
+				assigned[s] = e.to - 1 - sites
+			}
+		}
+	}
+	return assigned
+}
+
+// nearestRecords writes a row per site and each of its chosen terminals,
+// nearest first; with k of 1 each site has one row and there is no RANK
+// column. Sites with no terminal get one row with an empty terminal and
+// N/A.
+func nearestRecords(sites, terminals []matrixPoint, cells [][]matrixCell, choices [][]int, k int, unit matrixio.DistanceUnit) [][]string {
+	header := []string{"SITE_ID", "TERMINAL_ID", unit.Column(), "DURATION"}
+	if k > 1 {
+		header = slices.Insert(header, 1, "RANK")
+	}
+	records := [][]string{header}
+	for s, site := range sites {
+		nearest := choices[s]
+		if len(nearest) == 0 {
+			slog.Warn("no terminal for the site", "site", site.id)
+			record := []string{site.id, "", "N/A", "N/A"}
+			if k > 1 {
+				record = slices.Insert(record, 1, "")
+			}
+			records = append(records, record)
+			continue
+		}
+		for rank, t := range nearest {
+			cell := cells[t][s]
+			record := []string{site.id, terminals[t].id, unit.Format(cell.distanceKm), cell.duration}
+			if k > 1 {
+				record = slices.Insert(record, 1, strconv.Itoa(rank+1))
+			}
+			records = append(records, record)
+		}
+	}
+	return records
+}
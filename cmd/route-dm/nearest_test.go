@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// durationCells returns the cells of a terminals × sites matrix of
+// durations in seconds; a negative duration is a pair the API did not
+// answer.
+func durationCells(seconds [][]int) [][]matrixCell {
+	cells := make([][]matrixCell, len(seconds))
+	for t, row := range seconds {
+		cells[t] = make([]matrixCell, len(row))
+		for s, d := range row {
+			cells[t][s] = matrixCell{distanceKm: float64(d), duration: "N/A"}
+			if d >= 0 {
+				cells[t][s].duration, cells[t][s].seconds = "x", d
+			}
+		}
+	}
+	return cells
+}
+
+func TestAssignTerminals(t *testing.T) {
+	// Site 0 is a little quicker from terminal 0, site 1 much quicker:
+	// greedy gives terminal 0 to site 0 first, min-cost to site 1.
+	contested := durationCells([][]int{
+		{10, 20},
+		{12, 100},
+	})
+
+	tests := []struct {
+		name       string
+		cells      [][]matrixCell
+		capacities []float64
+		volumes    []float64
+		greedy     []int
+		minCost    []int
+	}{
+		{
+			name:       "roomy terminals are nearest",
+			cells:      contested,
+			capacities: []float64{2, 2},
+			volumes:    []float64{1, 1},
+			greedy:     []int{0, 0},
+			minCost:    []int{0, 0},
+		},
+		{
+			name:       "one site per terminal",
+			cells:      contested,
+			capacities: []float64{1, 1},
+			volumes:    []float64{1, 1},
+			greedy:     []int{0, 1},
+			minCost:    []int{1, 0},
+		},
+		{
+			name:       "sites beyond capacity are left out",
+			cells:      contested,
+			capacities: []float64{1, 0},
+			volumes:    []float64{1, 1},
+			greedy:     []int{0, unassigned},
+			minCost:    []int{0, unassigned},
+		},
+		{
+			name:       "unanswered pairs are never assigned",
+			cells:      durationCells([][]int{{-1, 5}, {30, -1}}),
+			capacities: []float64{2, 2},
+			volumes:    []float64{1, 1},
+			greedy:     []int{1, 0},
+			minCost:    []int{1, 0},
+		},
+	}
+	for _, tt := range tests {
+		if got := assignGreedy(tt.cells, tt.capacities, tt.volumes); !reflect.DeepEqual(got, tt.greedy) {
+			t.Errorf("%s: assignGreedy = %v, want %v", tt.name, got, tt.greedy)
+		}
+		if got := assignMinCost(tt.cells, tt.capacities); !reflect.DeepEqual(got, tt.minCost) {
+			t.Errorf("%s: assignMinCost = %v, want %v", tt.name, got, tt.minCost)
+		}
+	}
+
+	// Volumes count against capacity: the big site only fits terminal 1.
+	cells := durationCells([][]int{{5, 5, 5}, {50, 50, 50}})
+	got := assignGreedy(cells, []float64{4, 10}, []float64{3, 6, 1})
+	if want := []int{0, 1, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("assignGreedy with volumes = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+// maxExactStops is the largest stop count solved exactly; larger sets use
+// nearest neighbour followed by 2-opt improvement.
+const maxExactStops = 15
+
+// runOptimize implements `route-dm optimize`: it computes the matrix between
+// one vehicle's stops and writes the visiting order that minimises the total
+// road distance, starting at the first stop.
+func runOptimize(args []string) error {
+	fs := flag.NewFlagSet("optimize", flag.ExitOnError)
+	stopsPath := fs.String("stops", "stops.csv", "CSV file listing the stops; the first is where the vehicle starts")
+	output := fs.String("output", "sequence.csv", "output CSV file, or - for stdout")
+	roundTrip := fs.Bool("round-trip", false, "return to the first stop at the end")
+	api := addMatrixFlags(fs)
+	avoid := fs.String("avoid", "", "comma-separated route features to avoid: tolls, highways, ferries, indoor")
+	unitName := fs.String("distance-unit", "km", "distance unit: km, mi, m or nmi")
+	idColumn := fs.String("id-column", "1", "column holding the stop ID (header name or 1-based position)")
+	latColumn := fs.String("lat-column", "2", "column holding the latitude")
+	lngColumn := fs.String("lng-column", "3", "column holding the longitude")
+	crs := fs.String("crs", "", "EPSG code of the input coordinates (default WGS84)")
+	applyCSVFlags := matrixio.CSVFlags(fs)
+	fs.Parse(args)
+
+	var dialect matrixio.CSVConfig
+	applyCSVFlags(&dialect)
+
+	units, err := matrixio.ParseDistanceUnits([]string{*unitName})
+	if err != nil {
+		return err
+	}
+	var opts matrix.QueryOptions
+	if err := matrix.ParseAvoid(*avoid, &opts); err != nil {
+		return err
+	}
+	if *output == "-" {
+		messages = os.Stderr
+	}
+	epsg, err := matrixio.ParseEPSG(*crs)
+	if err != nil {
+		return err
+	}
+
+	stops, err := readMatrixPoints(*stopsPath, dialect, *idColumn, *latColumn, *lngColumn, epsg)
+	if err != nil {
+		return fmt.Errorf("reading stops: %w", err)
+	}
+	if len(stops) < 2 {
+		return errors.New("need at least two stops to order")
+	}
+
+	cells, err := api.query(opts, stops, stops)
+	if err != nil {
+		return err
+	}
+
+	order := orderStops(stopDistances(cells), *roundTrip)
+	if order == nil {
+		return errors.New("no order visits every stop using only the pairs the API answered; see the errors above")
+	}
+	if *roundTrip {
+		order = append(order, 0)
+	}
+	records := sequenceRecords(stops, cells, order, units[0])
+
+	err = matrixio.WriteOutput(*output, func(w io.Writer) error {
+		return dialect.WriteAll(w, records)
+	})
+	if err != nil {
+		return err
+	}
+
+	if *output != "-" {
+		slog.Info("stop sequence written", "stops", len(stops), "output", *output)
+	}
+	return nil
+}
+
+// stopDistances returns the distance matrix in km with failed pairs as
+// +Inf, so they are never chosen.
+func stopDistances(cells [][]matrixCell) [][]float64 {
+	d := make([][]float64, len(cells))
+	for i, row := range cells {
+		d[i] = make([]float64, len(row))
+		for j, cell := range row {
+			if i != j && cell.duration == "N/A" {
+				d[i][j] = math.Inf(1)
+			} else {
+				d[i][j] = cell.distanceKm
+			}
+		}
+	}
+	return d
+}
+
+// orderStops returns the visiting order of the stops, starting at stop 0,
+// that minimises the total distance d; with roundTrip the leg back to stop
+// 0 counts too. It returns nil if every order needs a missing pair.
+func orderStops(d [][]float64, roundTrip bool) []int {
+	var order []int
+	if len(d) <= maxExactStops {
+		order = exactOrder(d, roundTrip)
+	} else {
+		order = twoOpt(d, nearestNeighbourOrder(d), roundTrip)
+	}
+	if order == nil || math.IsInf(tourLength(d, order, roundTrip), 1) {
+		return nil
+	}
+	return order
+}
+
+// exactOrder solves the problem with the Held-Karp dynamic programme. It
+// returns nil if no order avoids the missing pairs.
+func exactOrder(d [][]float64, roundTrip bool) []int {
+	n := len(d)
+	full := 1<<n - 1
+	// cost[set][j] is the shortest path from 0 through set ending at j.
+	cost := make([][]float64, 1<<n)
+	prev := make([][]int, 1<<n)
+	for set := range cost {
+		cost[set] = make([]float64, n)
+		prev[set] = make([]int, n)
+		for j := range cost[set] {
+			cost[set][j] = math.Inf(1)
+		}
+	}
+	cost[1][0] = 0
+	for set := 1; set <= full; set += 2 { // every set contains stop 0
+		for j := 0; j < n; j++ {
+			if set&(1<<j) == 0 || math.IsInf(cost[set][j], 1) {
+				continue
+			}
+			for k := 1; k < n; k++ {
+				if set&(1<<k) != 0 {
+					continue
+				}
+				next := set | 1<<k
+				if c := cost[set][j] + d[j][k]; c < cost[next][k] {
+					cost[next][k] = c
+					prev[next][k] = j
+				}
+			}
+		}
+	}
+
+	last, best := 0, math.Inf(1)
+	for j := 1; j < n; j++ {
+		c := cost[full][j]
+		if roundTrip {
+			c += d[j][0]
+		}
+		if c < best {
+			last, best = j, c
+		}
+	}
+	if math.IsInf(best, 1) {
+		return nil
+	}
+	order := make([]int, n)
+	for set, j, i := full, last, n-1; i >= 0; i-- {
+		order[i] = j
+		set, j = set&^(1<<j), prev[set][j]
+	}
+	return order
+}
+
+// nearestNeighbourOrder starts at stop 0 and always drives to the closest
+// unvisited stop.
+func nearestNeighbourOrder(d [][]float64) []int {
+	n := len(d)
+	visited := make([]bool, n)
+	order := []int{0}
+	visited[0] = true
+	for len(order) < n {
+		from, next := order[len(order)-1], -1
+		for j := 0; j < n; j++ {
+			if !visited[j] && (next < 0 || d[from][j] < d[from][next]) {
+				next = j
+			}
+		}
+		visited[next] = true
+		order = append(order, next)
+	}
+	return order
+}
+
+// twoOpt improves order by reversing segments while that shortens it. The
+// whole tour is re-measured for each candidate since road distances are not
+// symmetric.
+func twoOpt(d [][]float64, order []int, roundTrip bool) []int {
+	best := tourLength(d, order, roundTrip)
+	for improved := true; improved; {
+		improved = false
+		for i := 1; i < len(order)-1; i++ {
+			for j := i + 1; j < len(order); j++ {
+				reverse(order[i : j+1])
+				if l := tourLength(d, order, roundTrip); l < best {
+					best, improved = l, true
+				} else {
+					reverse(order[i : j+1])
+				}
+			}
+		}
+	}
+	return order
+}
+
+func reverse(s []int) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
+
+func tourLength(d [][]float64, order []int, roundTrip bool) float64 {
+	var total float64
+	for i := 1; i < len(order); i++ {
+		total += d[order[i-1]][order[i]]
+	}
+	if roundTrip {
+		total += d[order[len(order)-1]][order[0]]
+	}
+	return total
+}
+
+// sequenceRecords lists the stops in visiting order with the leg driven to
+// reach each and the running total.
+func sequenceRecords(stops []matrixPoint, cells [][]matrixCell, order []int, unit matrixio.DistanceUnit) [][]string {
+	records := [][]string{
+		{"SEQUENCE", "STOP_ID", "LEG_" + unit.Column(), "LEG_DURATION", "CUMULATIVE_" + unit.Column()},
+		{"1", stops[order[0]].id, "", "", unit.Format(0)},
+	}
+	var total float64
+	for i := 1; i < len(order); i++ {
+		leg := cells[order[i-1]][order[i]]
+		total += leg.distanceKm
+		records = append(records, []string{strconv.Itoa(i + 1), stops[order[i]].id, unit.Format(leg.distanceKm), leg.duration, unit.Format(total)})
+	}
+	return records
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// planeDistances returns the straight-line distances between points in the
+// plane.
+func planeDistances(points ...[2]float64) [][]float64 {
+	d := make([][]float64, len(points))
+	for i, p := range points {
+		d[i] = make([]float64, len(points))
+		for j, q := range points {
+			d[i][j] = math.Hypot(p[0]-q[0], p[1]-q[1])
+		}
+	}
+	return d
+}
+
+// bruteForceLength is the shortest tour from stop 0 over every order.
+func bruteForceLength(d [][]float64, roundTrip bool) float64 {
+	rest := make([]int, len(d)-1)
+	for i := range rest {
+		rest[i] = i + 1
+	}
+	best := math.Inf(1)
+	var permute func(k int)
+	permute = func(k int) {
+		if k == len(rest) {
+			best = min(best, tourLength(d, append([]int{0}, rest...), roundTrip))
+			return
+		}
+		for i := k; i < len(rest); i++ {
+			rest[k], rest[i] = rest[i], rest[k]
+			permute(k + 1)
+			rest[k], rest[i] = rest[i], rest[k]
+		}
+	}
+	permute(0)
+	return best
+}
+
+func TestExactOrder(t *testing.T) {
+	inf := math.Inf(1)
+	tests := []struct {
+		name      string
+		d         [][]float64
+		roundTrip bool
+		want      []int
+	}{
+		{
+			name: "two stops",
+			d:    [][]float64{{0, 5}, {5, 0}},
+			want: []int{0, 1},
+		},
+		{
+			name: "points on a line",
+			d:    planeDistances([2]float64{0, 0}, [2]float64{3, 0}, [2]float64{1, 0}, [2]float64{2, 0}),
+			want: []int{0, 2, 3, 1},
+		},
+		{
+			name: "one-way streets",
+			d: [][]float64{
+				{0, 1, 10},
+				{10, 0, 1},
+				{1, 10, 0},
+			},
+			roundTrip: true,
+			want:      []int{0, 1, 2},
+		},
+		{
+			name: "the way back decides a round trip",
+			d: [][]float64{
+				{0, 1, 2},
+				{1, 0, 1},
+				{9, 2, 0},
+			},
+			roundTrip: true,
+			want:      []int{0, 2, 1},
+		},
+		{
+			name: "around a missing pair",
+			d: [][]float64{
+				{0, 1, 1},
+				{1, 0, inf},
+				{1, 1, 0},
+			},
+			want: []int{0, 2, 1},
+		},
+		{
+			name: "no order avoids the missing pairs",
+			d: [][]float64{
+				{0, inf, 1},
+				{1, 0, 1},
+				{1, inf, 0},
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exactOrder(tt.d, tt.roundTrip); !slices.Equal(got, tt.want) {
+				t.Errorf("exactOrder = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExactOrderMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, n := range []int{2, 4, 6, 8} {
+		for _, roundTrip := range []bool{false, true} {
+			d := make([][]float64, n)
+			for i := range d {
+				d[i] = make([]float64, n)
+				for j := range d[i] {
+					if i != j {
+						d[i][j] = 1 + rng.Float64()*100
+					}
+				}
+			}
+			order := exactOrder(d, roundTrip)
+			if got, want := tourLength(d, order, roundTrip), bruteForceLength(d, roundTrip); math.Abs(got-want) > 1e-9 {
+				t.Errorf("n=%d roundTrip=%v: exactOrder %v has length %v, want %v", n, roundTrip, order, got, want)
+			}
+		}
+	}
+}
+
+func TestTwoOpt(t *testing.T) {
+	square := planeDistances([2]float64{0, 0}, [2]float64{1, 0}, [2]float64{1, 1}, [2]float64{0, 1})
+	tests := []struct {
+		name       string
+		d          [][]float64
+		order      []int
+		roundTrip  bool
+		wantLength float64
+	}{
+		{
+			name:       "uncrosses a round trip",
+			d:          square,
+			order:      []int{0, 2, 1, 3},
+			roundTrip:  true,
+			wantLength: 4,
+		},
+		{
+			name:       "uncrosses an open path",
+			d:          square,
+			order:      []int{0, 2, 1, 3},
+			wantLength: 3,
+		},
+		{
+			name:       "keeps an optimal order",
+			d:          square,
+			order:      []int{0, 1, 2, 3},
+			roundTrip:  true,
+			wantLength: 4,
+		},
+		{
+			name:       "reorders a line",
+			d:          planeDistances([2]float64{0, 0}, [2]float64{4, 0}, [2]float64{1, 0}, [2]float64{3, 0}, [2]float64{2, 0}),
+			order:      []int{0, 1, 2, 3, 4},
+			wantLength: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := twoOpt(tt.d, slices.Clone(tt.order), tt.roundTrip)
+			if order[0] != 0 {
+				t.Errorf("twoOpt moved the start: %v", order)
+			}
+			if got := tourLength(tt.d, order, tt.roundTrip); math.Abs(got-tt.wantLength) > 1e-9 {
+				t.Errorf("twoOpt(%v) = %v with length %v, want length %v", tt.order, order, got, tt.wantLength)
+			}
+		})
+	}
+}
+
+func TestTwoOptNeverLengthens(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for trial := 0; trial < 20; trial++ {
+		points := make([][2]float64, 30)
+		for i := range points {
+			points[i] = [2]float64{rng.Float64(), rng.Float64()}
+		}
+		d := planeDistances(points...)
+		start := nearestNeighbourOrder(d)
+		before := tourLength(d, start, true)
+		order := twoOpt(d, slices.Clone(start), true)
+		if after := tourLength(d, order, true); after > before+1e-9 {
+			t.Errorf("trial %d: twoOpt lengthened the tour from %v to %v", trial, before, after)
+		}
+		sorted := slices.Clone(order)
+		slices.Sort(sorted)
+		for i, v := range sorted {
+			if v != i {
+				t.Fatalf("trial %d: twoOpt returned %v, not a permutation of the stops", trial, order)
+			}
+		}
+	}
+}
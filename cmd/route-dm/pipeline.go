@@ -0,0 +1,437 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+// Pipeline is a declarative run read from YAML:
+//
+//	source:
+//	  input: routes.xlsx
+//	  columns: {site_code: SITE_CODE, ...}
+//	transforms:
+//	  - validate
+//	  - dedupe
+//	  - filter: {field: terminal_code, in: [T1, T2]}
+//	compute:
+//	  provider: google
+//	  concurrency: 4
+//	sinks:
+//	  - output: results.csv
+//	  - output: sqlite://results.db
+//
+// The source and each sink accept the same keys as route-dm.json.
+type Pipeline struct {
+	Source     json.RawMessage   `json:"source"`
+	Transforms []json.RawMessage `json:"transforms"`
+	Compute    ComputeConfig     `json:"compute"`
+	Sinks      []json.RawMessage `json:"sinks"`
+}
+
+// ComputeConfig selects the provider and its request options.
+type ComputeConfig struct {
+	// Provider is "google" (the default), "routes", "osrm", "osrm=URL",
+	// "mock" or "simulate".
+	Provider    string `json:"provider"`
+	Concurrency int    `json:"concurrency"`
+	// Units is the API unit system, metric or imperial. Imperial makes miles
+	// the default distance unit of every sink.
+	Units string `json:"units"`
+	// Language localizes the duration text; Region biases routing toward a
+	// country (two-letter ccTLD).
+	Language string `json:"language"`
+	Region   string `json:"region"`
+	// Avoid lists route features to avoid: tolls, highways, ferries, indoor.
+	Avoid []string `json:"avoid"`
+	// Mode is driving (the default), walking, bicycling, transit or
+	// two_wheeler (routes provider only).
+	Mode string `json:"mode"`
+	// TransitMode and TransitRoutingPreference only apply to mode transit.
+	TransitMode              []string `json:"transit_mode"`
+	TransitRoutingPreference string   `json:"transit_routing_preference"`
+	// ArrivalTime (RFC3339) adds an IMPLIED_DEPARTURE column.
+	ArrivalTime string `json:"arrival_time"`
+	// DepartureTime (RFC3339 or "now") adds a DURATION_IN_TRAFFIC column.
+	DepartureTime string `json:"departure_time"`
+	// TrafficModel is best_guess, pessimistic, optimistic or all.
+	TrafficModel string `json:"traffic_model"`
+	// Legs adds per-leg columns for routes with waypoints.
+	Legs bool `json:"legs"`
+	// Status adds a STATUS column telling routes that do not exist from
+	// failed requests.
+	Status bool `json:"status"`
+	// DurationSeconds adds a numeric DURATION_SECONDS column.
+	DurationSeconds bool `json:"duration_seconds"`
+	// Alternatives adds default, shortest and fastest route columns.
+	Alternatives bool `json:"alternatives"`
+	// Tolls adds a TOLL_COST column estimated by the Routes API.
+	Tolls *TollConfig `json:"tolls"`
+	// WithGeometry adds a POLYLINE column with each route's encoded polyline.
+	WithGeometry bool `json:"with_geometry"`
+	// Peak and Offpeak are times of day (HH:MM) for extra traffic columns.
+	Peak      string           `json:"peak"`
+	Offpeak   string           `json:"offpeak"`
+	Departure *DepartureConfig `json:"departure"`
+}
+
+// TollConfig describes the vehicle whose tolls are estimated.
+type TollConfig struct {
+	// EmissionType is gasoline, electric, hybrid or diesel.
+	EmissionType string   `json:"emission_type"`
+	TollPasses   []string `json:"toll_passes"`
+}
+
+// DepartureConfig enables the latest-departure search.
+type DepartureConfig struct {
+	Deadline  string `json:"deadline"`
+	Window    string `json:"window"`
+	Precision string `json:"precision"`
+}
+
+// FilterConfig keeps only rows whose field matches. Field is site_code,
+// site_name or terminal_code.
+type FilterConfig struct {
+	Field  string   `json:"field"`
+	Equals string   `json:"equals"`
+	In     []string `json:"in"`
+	NotIn  []string `json:"not_in"`
+}
+
+// transform rewrites the routes between reading and querying.
+type transform func([]matrix.Route) ([]matrix.Route, error)
+
+// runPipeline implements `route-dm pipeline pipeline.yaml`.
+func runPipeline(args []string) error {
+	fs := flag.NewFlagSet("pipeline", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s pipeline pipeline.yaml\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one pipeline file")
+	}
+
+	pl, err := loadPipeline(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	source := matrixio.DefaultConfig()
+	if len(pl.Source) > 0 {
+		if err := json.Unmarshal(pl.Source, &source); err != nil {
+			return fmt.Errorf("source: %w", err)
+		}
+	}
+
+	transforms := make([]transform, len(pl.Transforms))
+	for i, raw := range pl.Transforms {
+		if transforms[i], err = parseTransform(raw); err != nil {
+			return fmt.Errorf("transform %d: %w", i+1, err)
+		}
+	}
+
+	if len(pl.Sinks) == 0 {
+		return fmt.Errorf("pipeline has no sinks")
+	}
+	sinks := make([]matrixio.Config, len(pl.Sinks))
+	for i, raw := range pl.Sinks {
+		sinks[i] = matrixio.DefaultConfig()
+		sinks[i].CSV = source.CSV
+		if err := json.Unmarshal(raw, &sinks[i]); err != nil {
+			return fmt.Errorf("sink %d: %w", i+1, err)
+		}
+	}
+
+	var opts matrix.QueryOptions
+	if err := matrix.ParseAvoid(strings.Join(pl.Compute.Avoid, ","), &opts); err != nil {
+		return err
+	}
+	if err := matrix.ParseUnitSystem(pl.Compute.Units, &opts); err != nil {
+		return err
+	}
+	for i := range sinks {
+		sinks[i].DefaultUnitsFor(opts)
+	}
+	if err := matrix.ParseLocale(pl.Compute.Language, pl.Compute.Region, &opts); err != nil {
+		return err
+	}
+	opts.Legs = pl.Compute.Legs
+	opts.Status = pl.Compute.Status
+	opts.DurationSeconds = pl.Compute.DurationSeconds
+	if pl.Compute.DepartureTime != "" {
+		if err := matrix.ParseDepartureTime(pl.Compute.DepartureTime, &opts); err != nil {
+			return err
+		}
+	}
+	travel := matrix.TravelOptions{
+		Mode:              pl.Compute.Mode,
+		TransitModes:      strings.Join(pl.Compute.TransitMode, ","),
+		RoutingPreference: pl.Compute.TransitRoutingPreference,
+		ArrivalTime:       pl.Compute.ArrivalTime,
+	}
+	if err := matrix.ParseTravelMode(travel, &opts); err != nil {
+		return err
+	}
+
+	var annotators []matrix.Annotator
+	if pl.Compute.TrafficModel != "" {
+		models, err := matrix.ParseTrafficModel(pl.Compute.TrafficModel, &opts)
+		if err != nil {
+			return err
+		}
+		if models != nil {
+			annotators = append(annotators, models)
+		}
+	}
+	if pl.Compute.Peak != "" || pl.Compute.Offpeak != "" {
+		times, err := matrix.NewPeakTimes(pl.Compute.Peak, pl.Compute.Offpeak, time.Now())
+		if err != nil {
+			return err
+		}
+		annotators = append(annotators, times)
+	}
+	if d := pl.Compute.Departure; d != nil {
+		precision := 5 * time.Minute
+		if d.Precision != "" {
+			if precision, err = time.ParseDuration(d.Precision); err != nil {
+				return fmt.Errorf("departure precision: %w", err)
+			}
+		}
+		search, err := matrix.NewDepartureSearch(d.Deadline, d.Window, precision)
+		if err != nil {
+			return err
+		}
+		annotators = append(annotators, search)
+	}
+
+	settings := providerSettings{name: pl.Compute.Provider, keyRotation: "round-robin"}
+	if pl.Compute.Provider == "simulate" {
+		settings.simulated = matrix.NewSyntheticProvider(150*time.Millisecond, 600*time.Millisecond, 0.01)
+	}
+	run, err := newRunProvider(settings, opts)
+	if err != nil {
+		return err
+	}
+	p, apiKey := run.p, run.apiKey
+	concurrency := max(pl.Compute.Concurrency, 1)
+
+	var g *matrix.Geocoder
+	if source.Columns.HasAddresses() || source.Geocode.Reverse {
+		if g, err = matrix.NewGeocoder(source.Geocode, apiKey); err != nil {
+			return err
+		}
+	}
+	if source.Geocode.Reverse {
+		annotators = append(annotators, matrix.NewReverseGeocoding(g))
+	}
+	if pl.Compute.WithGeometry && pl.Compute.Provider != "simulate" {
+		annotators = append(annotators, matrix.NewRouteGeometry(pl.Compute.Provider))
+	}
+	if pl.Compute.Alternatives && pl.Compute.Provider != "simulate" {
+		annotators = append(annotators, matrix.NewRouteAlternatives(pl.Compute.Provider))
+	}
+	if pl.Compute.Tolls != nil && pl.Compute.Provider != "simulate" {
+		cost, err := matrix.NewTollCost(pl.Compute.Tolls.EmissionType, pl.Compute.Tolls.TollPasses)
+		if err != nil {
+			return err
+		}
+		annotators = append(annotators, cost)
+	}
+	if source.PlusCodes {
+		annotators = append(annotators, matrix.PlusCodes{})
+	}
+	if len(source.PassThrough) > 0 {
+		annotators = append(annotators, matrix.PassThrough{})
+	}
+
+	routes, err := matrixio.ReadRoutes(source)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", source.Input, err)
+	}
+	if source.Columns.HasAddresses() {
+		matrix.GeocodeRoutes(g, routes, concurrency)
+	}
+	for _, t := range transforms {
+		if routes, err = t(routes); err != nil {
+			return err
+		}
+	}
+
+	client := &matrix.Client{Provider: p, Concurrency: concurrency, Annotators: annotators}
+	if source.Columns.HasAddresses() {
+		client.Geocoder = g
+	}
+	results := client.Compute(matrix.Request{Routes: routes, Options: opts})
+	if g != nil {
+		if err := g.Save(); err != nil {
+			return err
+		}
+	}
+
+	if sim, ok := p.(*matrix.SyntheticProvider); ok {
+		report := sinks[0]
+		report.Input = source.Input
+		report.Concurrency = concurrency
+		printSimulation(sim, report, results)
+		return nil
+	}
+
+	return writeSinks(sinks, results)
+}
+
+// loadPipeline reads a YAML pipeline. The document is converted to JSON so
+// the source and sinks reuse the config file field names.
+func loadPipeline(path string) (Pipeline, error) {
+	var pl Pipeline
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return pl, err
+	}
+
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return pl, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	data, err = json.Marshal(doc)
+	if err != nil {
+		return pl, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &pl); err != nil {
+		return pl, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return pl, nil
+}
+
+// writeSinks writes the results to every sink in parallel. All sinks are
+// attempted even when some fail.
+func writeSinks(sinks []matrixio.Config, results []matrix.Result) error {
+	errs := make([]error, len(sinks))
+	var wg sync.WaitGroup
+	for i, sink := range sinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := matrixio.WriteResults(sink, results); err != nil {
+				errs[i] = fmt.Errorf("writing results to %s: %w", sink.Output, err)
+				return
+			}
+			if sink.Output != "-" {
+				slog.Info("results written", "output", sink.Output)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// parseTransform accepts either a bare transform name or a single-key
+// mapping from the name to its options.
+func parseTransform(raw json.RawMessage) (transform, error) {
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		return newTransform(name, nil)
+	}
+
+	var spec map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &spec); err == nil && len(spec) == 1 {
+		for name, opts := range spec {
+			return newTransform(name, opts)
+		}
+	}
+	return nil, fmt.Errorf("expected a transform name or a single-key mapping")
+}
+
+func newTransform(name string, opts json.RawMessage) (transform, error) {
+	switch name {
+	case "validate":
+		return validateRoutes, nil
+	case "dedupe":
+		return dedupeRoutes, nil
+	case "filter":
+		var f FilterConfig
+		if err := json.Unmarshal(opts, &f); err != nil {
+			return nil, fmt.Errorf("filter: %w", err)
+		}
+		return f.apply, nil
+	default:
+		return nil, fmt.Errorf("unknown transform %q", name)
+	}
+}
+
+// validateRoutes drops rows that cannot produce a meaningful distance.
+func validateRoutes(routes []matrix.Route) ([]matrix.Route, error) {
+	var kept []matrix.Route
+	for _, r := range routes {
+		switch {
+		case r.SiteCode == "" || r.TerminalCode == "":
+			slog.Warn("skipping row: missing code", "site", r.SiteCode, "terminal", r.TerminalCode)
+		case r.Origin == r.Destination:
+			slog.Warn("skipping row: origin and destination are identical", "site", r.SiteCode, "terminal", r.TerminalCode)
+		default:
+			kept = append(kept, r)
+		}
+	}
+	return kept, nil
+}
+
+// dedupeRoutes keeps the first row for each site and terminal pair.
+func dedupeRoutes(routes []matrix.Route) ([]matrix.Route, error) {
+	seen := make(map[[2]string]bool)
+	var kept []matrix.Route
+	for _, r := range routes {
+		key := [2]string{r.SiteCode, r.TerminalCode}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, r)
+	}
+	return kept, nil
+}
+
+func (f FilterConfig) apply(routes []matrix.Route) ([]matrix.Route, error) {
+	var value func(matrix.Route) string
+	switch f.Field {
+	case "site_code":
+		value = func(r matrix.Route) string { return r.SiteCode }
+	case "site_name":
+		value = func(r matrix.Route) string { return r.SiteName }
+	case "terminal_code":
+		value = func(r matrix.Route) string { return r.TerminalCode }
+	default:
+		return nil, fmt.Errorf("filter: unknown field %q", f.Field)
+	}
+
+	var kept []matrix.Route
+	for _, r := range routes {
+		v := value(r)
+		if f.Equals != "" && v != f.Equals {
+			continue
+		}
+		if len(f.In) > 0 && !slices.Contains(f.In, v) {
+			continue
+		}
+		if slices.Contains(f.NotIn, v) {
+			continue
+		}
+		kept = append(kept, r)
+	}
+	return kept, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+// changedRoutes returns the routes prev has no result to copy forward for:
+// new rows, rows whose inputs changed and rows that failed last time.
+func changedRoutes(prev *matrixio.PreviousResults, routes []matrix.Route) []matrix.Route {
+	var changed []matrix.Route
+	for _, r := range routes {
+		if _, ok := prev.Reuse(r); !ok {
+			changed = append(changed, r)
+		}
+	}
+	return changed
+}
+
+// unchangedRoutes returns the routes changedRoutes leaves out.
+func unchangedRoutes(prev *matrixio.PreviousResults, routes []matrix.Route) []matrix.Route {
+	var unchanged []matrix.Route
+	for _, r := range routes {
+		if _, ok := prev.Reuse(r); ok {
+			unchanged = append(unchanged, r)
+		}
+	}
+	return unchanged
+}
+
+// previousMatches reports whether the results of this run have the columns
+// of the previous output, so its rows can be copied alongside them.
+func previousMatches(prev *matrixio.PreviousResults, cfg matrixio.Config, queried []matrix.Result) bool {
+	if len(queried) == 0 {
+		return true
+	}
+	units, err := matrixio.ParseDistanceUnits(cfg.DistanceUnits)
+	if err != nil {
+		return false
+	}
+	return prev.Matches(matrixio.ResultHeader(units, queried[0]))
+}
+
+// mergePrevious returns one result per route in input order. changed holds
+// the results of changedRoutes and unchanged those of unchangedRoutes; a nil
+// unchanged copies them from prev instead. Every result gets the input
+// columns the next run compares against.
+func mergePrevious(prev *matrixio.PreviousResults, routes []matrix.Route, changed, unchanged []matrix.Result) []matrix.Result {
+	results := make([]matrix.Result, 0, len(routes))
+	for _, route := range routes {
+		r, ok := prev.Reuse(route)
+		switch {
+		case !ok:
+			r, changed = changed[0], changed[1:]
+		case unchanged != nil:
+			r, unchanged = unchanged[0], unchanged[1:]
+		}
+		r.Extra = append(r.Extra, matrixio.InputFields(r.Route)...)
+		results = append(results, r)
+	}
+	return results
+}
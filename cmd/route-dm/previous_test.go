@@ -0,0 +1,84 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+func TestMergePrevious(t *testing.T) {
+	cfg := matrixio.DefaultConfig()
+	cfg.Output = filepath.Join(t.TempDir(), "previous.csv")
+	s1 := matrix.Route{SiteCode: "S1", TerminalCode: "T1", Origin: "-6.3,106.9", Destination: "-6.2,106.8"}
+	s2 := matrix.Route{SiteCode: "S2", TerminalCode: "T1", Origin: "-6.3,106.9", Destination: "-6.25,106.85"}
+	s3 := matrix.Route{SiteCode: "S3", TerminalCode: "T1", Origin: "-6.3,106.9", Destination: "-6.5,107.2"}
+	var previous []matrix.Result
+	for _, r := range []matrix.Result{
+		{Route: s1, DistanceKm: 20.38, Duration: "24 mins", Status: "OK"},
+		{Route: s2, Duration: "N/A", Status: "ZERO_RESULTS"},
+		{Route: s3, DistanceKm: 40, Duration: "50 mins", Status: "OK"},
+	} {
+		r.Extra = matrixio.InputFields(r.Route)
+		previous = append(previous, r)
+	}
+	if err := matrixio.WriteResults(cfg, previous); err != nil {
+		t.Fatal(err)
+	}
+	prev, err := matrixio.ReadPreviousResults(cfg.Output, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// S3 moved and S4 is new; S2 failed last time.
+	moved := s3
+	moved.Destination = "-6.55,107.25"
+	s4 := matrix.Route{SiteCode: "S4", TerminalCode: "T1", Origin: "-6.3,106.9", Destination: "-6.1,106.7"}
+	routes := []matrix.Route{s4, s1, s2, moved}
+
+	todo := changedRoutes(prev, routes)
+	if len(todo) != 3 || todo[0].SiteCode != "S4" || todo[1].SiteCode != "S2" || todo[2].SiteCode != "S3" {
+		t.Fatalf("changedRoutes = %+v, want S4, S2 and S3", todo)
+	}
+	if unchanged := unchangedRoutes(prev, routes); len(unchanged) != 1 || unchanged[0].SiteCode != "S1" {
+		t.Fatalf("unchangedRoutes = %+v, want S1", unchanged)
+	}
+
+	var queried []matrix.Result
+	for i, r := range todo {
+		queried = append(queried, matrix.Result{Route: r, DistanceKm: float64(i + 1), Duration: "1 min", Status: "OK"})
+	}
+	if !previousMatches(prev, cfg, queried) {
+		t.Error("results with the previous columns do not match")
+	}
+	results := mergePrevious(prev, routes, queried, nil)
+
+	for i, want := range []struct {
+		site     string
+		distance float64
+	}{
+		{"S4", 1}, {"S1", 20.38}, {"S2", 2}, {"S3", 3},
+	} {
+		r := results[i]
+		if r.SiteCode != want.site || r.DistanceKm != want.distance {
+			t.Errorf("row %d = %s %v km, want %s %v km", i, r.SiteCode, r.DistanceKm, want.site, want.distance)
+		}
+		// The input columns come last, for the next run to compare.
+		if n := len(r.Extra); n < 3 || r.Extra[n-3].Name != "ORIGIN" || r.Extra[n-2].Value != r.Destination {
+			t.Errorf("row %d: extra columns %+v do not end with its inputs", i, r.Extra)
+		}
+	}
+
+	// Results with other columns cannot be mixed with copied rows, so the
+	// unchanged ones are queried too and take their place.
+	queried[0].Extra = []matrix.Field{{Name: "TOLL_COST", Value: "0"}}
+	if previousMatches(prev, cfg, queried) {
+		t.Error("results with an extra column matched the previous output")
+	}
+	requeried := []matrix.Result{{Route: s1, DistanceKm: 21, Duration: "25 mins", Status: "OK"}}
+	results = mergePrevious(prev, routes, queried, requeried)
+	if results[1].DistanceKm != 21 {
+		t.Errorf("S1 = %v km, want the requeried 21 km", results[1].DistanceKm)
+	}
+}
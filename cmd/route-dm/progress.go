@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const progressBarWidth = 30
+
+// progress reports how far a batch stage has got. On a terminal it redraws
+// one line in place; elsewhere, such as a log file, it prints a line every
+// few seconds. A nil *progress reports nothing.
+type progress struct {
+	w     io.Writer
+	label string
+	total int
+	tty   bool
+	start time.Time
+
+	mu       sync.Mutex
+	done     int
+	failed   int
+	lastDraw time.Time
+}
+
+func newProgress(w io.Writer, label string, total int) *progress {
+	tty := false
+	if f, ok := w.(*os.File); ok {
+		if info, err := f.Stat(); err == nil {
+			tty = info.Mode()&os.ModeCharDevice != 0
+		}
+	}
+	return &progress{w: w, label: label, total: total, tty: tty, start: time.Now()}
+}
+
+// Step records one finished item.
+func (p *progress) Step(failed bool) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	if failed {
+		p.failed++
+	}
+
+	interval := 5 * time.Second
+	if p.tty {
+		interval = 100 * time.Millisecond
+	}
+	if now := time.Now(); now.Sub(p.lastDraw) >= interval {
+		p.lastDraw = now
+		p.draw()
+	}
+}
+
+// Finish draws the final state and ends the line.
+func (p *progress) Finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.draw()
+	if p.tty {
+		fmt.Fprintln(p.w)
+	}
+}
+
+func (p *progress) draw() {
+	elapsed := time.Since(p.start)
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(p.done) / elapsed.Seconds()
+	}
+	eta := "--"
+	if rate > 0 {
+		eta = time.Duration(float64(p.total-p.done) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+
+	filled := progressBarWidth
+	if p.total > 0 {
+		filled = p.done * progressBarWidth / p.total
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+
+	line := fmt.Sprintf("[%s] %d/%d %s  %.1f/s  %d errors  ETA %s", bar, p.done, p.total, p.label, rate, p.failed, eta)
+	if p.tty {
+		fmt.Fprintf(p.w, "\r%s\x1b[K", line)
+	} else {
+		fmt.Fprintln(p.w, line)
+	}
+}
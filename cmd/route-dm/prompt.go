@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	matrixio "routes/pkg/io"
+)
+
+// promptMissing implements -prompt: it asks on the terminal for what a run
+// of cfg cannot do without and neither the flags nor the config gave, an
+// input file that exists and, when needKey, a Google API key. The key is
+// kept for this run only and never written anywhere. Without a terminal on
+// stdin there is nobody to ask, and the run fails as it would have.
+func promptMissing(cfg *matrixio.Config, needKey bool) error {
+	if cfg.Input == "-" || !stdinIsTerminal() {
+		return nil
+	}
+	in := bufio.NewReader(os.Stdin)
+	for isMissingFile(cfg.Input) {
+		answer, err := promptLine(in, fmt.Sprintf("%s does not exist. Input file", cfg.Input))
+		if err != nil {
+			return err
+		}
+		if answer == "" {
+			return errors.New("no input file given")
+		}
+		cfg.Input = answer
+	}
+
+	if !needKey || os.Getenv("GOOGLE_API_KEY_REF") != "" {
+		return nil
+	}
+	if _, err := loadAPIKeys(); err == nil || os.Getenv("GOOGLE_MAPS_CLIENT_ID") != "" {
+		return nil
+	}
+	key, err := promptSecret(in, "No GOOGLE_API_KEY is set. Google Maps API key, for this run only")
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return errors.New("no API key given")
+	}
+	return os.Setenv("GOOGLE_API_KEY", key)
+}
+
+// promptLine asks question on stderr and returns the trimmed answer.
+func promptLine(in *bufio.Reader, question string) (string, error) {
+	fmt.Fprintf(os.Stderr, "%s: ", question)
+	line, err := in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("reading answer: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// promptSecret asks question on stderr like promptLine, but does not echo
+// the answer when stdin is a terminal.
+func promptSecret(in *bufio.Reader, question string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return promptLine(in, question)
+	}
+	fmt.Fprintf(os.Stderr, "%s: ", question)
+	secret, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("reading answer: %w", err)
+	}
+	return strings.TrimSpace(string(secret)), nil
+}
+
+// isMissingFile reports whether name is a local path with nothing at it.
+func isMissingFile(name string) bool {
+	if strings.Contains(name, "://") {
+		return false
+	}
+	_, err := os.Stat(name)
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// stdinIsTerminal reports whether someone may be typing on stdin.
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"slices"
+
+	"routes/pkg/matrix"
+)
+
+// providerSettings choose the routing API a run queries and how it may
+// spend it.
+type providerSettings struct {
+	// name is a provider as accepted by matrix.NewProvider.
+	name        string
+	keyRotation string
+	// channel is reported with client ID requests.
+	channel string
+	// record and replay are cassette files, see -record and -replay.
+	record, replay string
+	maxElements    int
+	// compared are further providers queried by -compare, which need a
+	// key too.
+	compared []string
+	// offline runs, such as dry runs, load no keys and make no calls.
+	offline bool
+	// simulated, when set, answers instead of any API, unbilled and
+	// unwrapped so its statistics can be read back.
+	simulated *matrix.SyntheticProvider
+}
+
+// runProvider is the provider a run queries, with the parts of it the run
+// reports on.
+type runProvider struct {
+	p matrix.Provider
+	// apiKey is the first key of the pool, for the geocoder; empty with a
+	// client ID or a provider that needs no key. The annotators' own
+	// requests take their keys from p.
+	apiKey  string
+	signer  *matrix.URLSigner
+	counter *matrix.CountingProvider
+	stop    *matrix.StoppableProvider
+	// billed runs query Google and are held to the budget.
+	billed bool
+}
+
+// newRunProvider builds the provider s describes: signed with a client ID,
+// drawing on the key pool, or answering from a cassette, recording to one,
+// counted against s.maxElements when billed and stoppable by stopOnSignal.
+// Keys are only loaded for the Google providers.
+func newRunProvider(s providerSettings, opts matrix.QueryOptions) (*runProvider, error) {
+	if s.simulated != nil {
+		return &runProvider{
+			p:       s.simulated,
+			counter: matrix.NewCountingProvider(s.simulated),
+			stop:    matrix.NewStoppableProvider(s.simulated),
+		}, nil
+	}
+
+	r := &runProvider{
+		billed: (s.replay == "" && matrix.NeedsAPIKey(s.name)) || slices.ContainsFunc(s.compared, matrix.NeedsAPIKey),
+	}
+	// The pool has one entry even for providers that take no key, and an
+	// empty key is never sent to Google: those runs are not billed.
+	apiKeys := []string{""}
+	if r.billed && !s.offline {
+		var err error
+		if r.signer, err = loadURLSigner(s.channel); err != nil {
+			return nil, err
+		}
+		if r.signer == nil {
+			if apiKeys, err = loadAPIKeys(); err != nil {
+				return nil, err
+			}
+			r.apiKey = apiKeys[0]
+		}
+	}
+
+	var p matrix.Provider
+	var err error
+	switch {
+	case r.signer != nil:
+		p, err = matrix.NewSignedProvider(s.name, r.signer, opts)
+	case s.replay != "":
+		// A cassette holds matrix answers only; comparisons with Google
+		// still need the key.
+		if p, err = matrix.NewReplayer(s.replay); err == nil {
+			p = matrix.WithAPIKey(p, r.apiKey)
+		}
+	default:
+		p, err = matrix.NewKeyPoolProvider(s.name, apiKeys, s.keyRotation, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.record != "" && !s.offline {
+		if p, err = matrix.NewRecorder(p, s.record); err != nil {
+			return nil, err
+		}
+	}
+
+	r.counter = matrix.NewCountingProvider(p)
+	r.counter.Limit = int64(s.maxElements)
+	if r.billed {
+		p = r.counter
+	}
+	r.stop = matrix.NewStoppableProvider(p)
+	r.p = r.stop
+	return r, nil
+}
+
+// matrixFlags are the flags of the subcommands that query a full matrix:
+// the provider, how it is spent and how fast.
+type matrixFlags struct {
+	provider    *string
+	keyRotation *string
+	channel     *string
+	record      *string
+	replay      *string
+	maxElements *int
+	maxCost     *float64
+	concurrency *int
+}
+
+func addMatrixFlags(fs *flag.FlagSet) *matrixFlags {
+	return &matrixFlags{
+		provider:    fs.String("provider", "google", "routing API: google (Distance Matrix), routes (Routes API), osrm (public demo server) or osrm=URL, or mock for straight-line answers without a key or network"),
+		keyRotation: fs.String("key-rotation", "round-robin", "how requests use a pool of keys in GOOGLE_API_KEYS: round-robin, or failover to stay on one key until it hits its quota"),
+		channel:     fs.String("channel", "", "channel reported with premium plan client ID requests, for usage breakdowns"),
+		record:      fs.String("record", "", "cassette file to record every API response to, for replaying with -replay"),
+		replay:      fs.String("replay", "", "cassette file written by -record to answer from instead of the API"),
+		maxElements: fs.Int("max-elements", 0, "refuse runs estimated to bill more API elements than this, and stop making requests past it; 0 means no limit"),
+		maxCost:     fs.Float64("max-cost", 0, "refuse runs estimated to cost more than this many USD at list prices; 0 means no limit"),
+		concurrency: fs.Int("concurrency", 1, "number of API requests in flight at once"),
+	}
+}
+
+// errMatrixInterrupted ends a subcommand whose matrix was cut short by a
+// signal; its output would rest on missing pairs, so none is written.
+var errMatrixInterrupted = errors.New("interrupted before the matrix was complete; no output written")
+
+// query computes every origin against every destination like the main
+// batch would: refusing a run over budget, stopping at SIGINT and failing
+// then rather than writing a partial answer.
+func (f *matrixFlags) query(opts matrix.QueryOptions, origins, destinations []matrixPoint) ([][]matrixCell, error) {
+	if *f.record != "" && *f.replay != "" {
+		return nil, errors.New("-record and -replay cannot be combined")
+	}
+	r, err := newRunProvider(providerSettings{
+		name:        *f.provider,
+		keyRotation: *f.keyRotation,
+		channel:     *f.channel,
+		record:      *f.record,
+		replay:      *f.replay,
+		maxElements: *f.maxElements,
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if r.billed {
+		e := estimateMatrix(*f.provider, opts, len(origins), len(destinations))
+		if err := checkBudget(e, *f.maxElements, *f.maxCost); err != nil && !confirm(err.Error()+"; run anyway?") {
+			return nil, err
+		}
+	}
+
+	stopOnSignal(r.stop)
+	cells := computeMatrix(r.p, opts, origins, destinations, *f.concurrency)
+	if r.stop.Stopped() {
+		return nil, errMatrixInterrupted
+	}
+	return cells, nil
+}
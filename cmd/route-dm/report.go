@@ -0,0 +1,391 @@
+package main
+
+import (
+	"cmp"
+	_ "embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+// maxReportChanges is how many terminals the report lists as the biggest
+// changes on the week before.
+const maxReportChanges = 5
+
+// runReport implements `route-dm report`: it sums up a week of the run
+// history, with the distance computed by terminal, average durations, the
+// biggest changes on the week before and error trends, lays it out with a
+// template and writes, emails or posts it.
+//
+// The week is the seven days before -end, midnight local time; with
+// -schedule it is the seven days before each day the schedule matches.
+func runReport(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	configPath := fs.String("config", matrixio.DefaultConfigFile, "config file naming the run history, the report template and its recipients")
+	historyPath := fs.String("history", "", "run history file (default from the config)")
+	endDate := fs.String("end", "", "day the reported week ends before, as 2006-01-02 (default: today)")
+	templateText := fs.String("template", "", "text/template laying the report out, or @path to read one (default from the config, else built in)")
+	output := fs.String("output", "-", "file to write the report to, or - for stdout; empty writes none")
+	email := fs.Bool("email", false, "email the report to the config's email recipients (default from the config)")
+	chat := fs.Bool("chat", false, "post the report to the config's chat webhook (default from the config)")
+	schedule := fs.String("schedule", "", "cron expression to report each time it matches, such as \"0 8 * * 1\", until stopped")
+	fs.Parse(args)
+
+	explicit := false
+	fs.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "config" })
+	cfg, err := matrixio.LoadConfig(*configPath, explicit)
+	if err != nil {
+		return err
+	}
+	if *historyPath == "" {
+		*historyPath = cfg.History
+	}
+	if *historyPath == "" {
+		return errors.New("no run history: set history in the config or pass -history")
+	}
+	if *templateText == "" {
+		*templateText = cfg.Report.Template
+	}
+	tmpl, err := parseReportTemplate(*templateText)
+	if err != nil {
+		return err
+	}
+
+	d := reportDelivery{output: *output}
+	if *email || cfg.Report.Email {
+		if d.mailer, err = newMailer(cfg.Email, "weekly-report"); err != nil {
+			return err
+		}
+		if d.mailer == nil {
+			return errors.New("emailing the report needs email.to in the config")
+		}
+	}
+	if *chat || cfg.Report.Chat {
+		if d.chat, err = newChatNotifier(cfg.Chat, "weekly-report"); err != nil {
+			return err
+		}
+		if d.chat == nil {
+			return errors.New("posting the report needs chat.webhook in the config")
+		}
+	}
+
+	report := func(end time.Time) error {
+		r, err := buildWeeklyReport(*historyPath, end)
+		if err != nil {
+			return err
+		}
+		var text strings.Builder
+		if err := tmpl.Execute(&text, r); err != nil {
+			return fmt.Errorf("laying out the report: %w", err)
+		}
+		return d.deliver(r, text.String())
+	}
+
+	if *schedule == "" {
+		end := midnight(time.Now())
+		if *endDate != "" {
+			if end, err = time.ParseInLocation(time.DateOnly, *endDate, time.Local); err != nil {
+				return fmt.Errorf("-end: %w", err)
+			}
+		}
+		return report(end)
+	}
+	sched, err := parseSchedule(*schedule)
+	if err != nil {
+		return err
+	}
+	for {
+		at := sched.next(time.Now())
+		if at.IsZero() {
+			return fmt.Errorf("schedule %q never matches", *schedule)
+		}
+		slog.Info("next report", "at", at.Format(time.RFC3339))
+		time.Sleep(time.Until(at))
+		// A failed report is logged, and the next one still happens.
+		if err := report(midnight(at)); err != nil {
+			slog.Error("reporting", "err", err)
+		}
+	}
+}
+
+// parseReportTemplate parses a report template: the text/template itself
+// or, after an @, the name of a file holding it. Empty is the built-in
+// one.
+func parseReportTemplate(text string) (*template.Template, error) {
+	name := "report"
+	switch path, ok := strings.CutPrefix(text, "@"); {
+	case ok:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading report template: %w", err)
+		}
+		name, text = path, string(data)
+	case text == "":
+		text = defaultReportTemplate
+	}
+	tmpl, err := template.New(name).Funcs(reportFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing report template: %w", err)
+	}
+	return tmpl, nil
+}
+
+//go:embed report.tmpl
+var defaultReportTemplate string
+
+// reportFuncs are the functions a report template can call besides the
+// text/template builtins.
+var reportFuncs = template.FuncMap{
+	// duration writes a number of seconds as the API does, as 1 hour 5
+	// mins; zero, for no routes, is n/a.
+	"duration": func(seconds float64) string {
+		if seconds == 0 {
+			return "n/a"
+		}
+		return matrix.DurationText(int(seconds + 0.5))
+	},
+	// percent writes a ratio as a percentage.
+	"percent": func(ratio float64) string { return fmt.Sprintf("%.1f%%", 100*ratio) },
+	// change writes how far km moved from the week before, as +12.3 km,
+	// +4.5%.
+	"change": func(km, previous float64) string {
+		switch {
+		case km == previous:
+			return "no change"
+		case previous == 0:
+			return fmt.Sprintf("%+.1f km, new", km)
+		}
+		return fmt.Sprintf("%+.1f km, %+.1f%%", km-previous, 100*(km-previous)/previous)
+	},
+}
+
+// weeklyReport is what a report template lays out: a week of runs, each
+// figure with the week before's for comparison.
+type weeklyReport struct {
+	From, To time.Time // the week is [From, To)
+
+	Runs, PreviousRuns             int
+	FailedRuns, PreviousFailedRuns int
+	Rows, PreviousRows             int
+	FailedRows, PreviousFailedRows int
+	// ErrorRate is the share of rows that failed.
+	ErrorRate, PreviousErrorRate           float64
+	TotalKm, PreviousTotalKm               float64
+	AvgDurationSec, PreviousAvgDurationSec float64
+
+	// Terminals are the week's terminals, most km first.
+	Terminals []terminalWeek
+	// Changes are the terminals whose km moved most on the week before,
+	// either way.
+	Changes []terminalWeek
+	// Failures are the failed rows by type, most this week first.
+	Failures []failureTrend
+}
+
+// LastDay is the last day of the week.
+func (r weeklyReport) LastDay() time.Time {
+	return r.To.AddDate(0, 0, -1)
+}
+
+// terminalWeek is one terminal's routes in the week and the week before.
+type terminalWeek struct {
+	Terminal                               string
+	Routes, PreviousRoutes                 int
+	Km, PreviousKm                         float64
+	AvgDurationSec, PreviousAvgDurationSec float64
+}
+
+type failureTrend struct {
+	Type               string
+	Rows, PreviousRows int
+}
+
+// weekTotals sums up the runs of one week.
+type weekTotals struct {
+	runs, failedRuns, rows, failedRows int
+	failures                           map[string]int
+	terminals                          map[string]terminalTotals
+	total                              terminalTotals
+}
+
+func sumWeek(records []historyRecord) weekTotals {
+	w := weekTotals{failures: make(map[string]int), terminals: make(map[string]terminalTotals)}
+	for _, r := range records {
+		w.runs++
+		if r.Status != "succeeded" {
+			w.failedRuns++
+		}
+		w.rows += r.Rows
+		w.failedRows += r.FailedRows
+		for failure, n := range r.Failures {
+			w.failures[failure] += n
+		}
+		for _, t := range r.Terminals {
+			sum := w.terminals[t.Terminal]
+			sum.Terminal = t.Terminal
+			sum.Routes += t.Routes
+			sum.DistanceKm += t.DistanceKm
+			sum.Seconds += t.Seconds
+			w.terminals[t.Terminal] = sum
+			w.total.Routes += t.Routes
+			w.total.DistanceKm += t.DistanceKm
+			w.total.Seconds += t.Seconds
+		}
+	}
+	return w
+}
+
+func (w weekTotals) errorRate() float64 {
+	if w.rows == 0 {
+		return 0
+	}
+	return float64(w.failedRows) / float64(w.rows)
+}
+
+func avgSeconds(t terminalTotals) float64 {
+	if t.Routes == 0 {
+		return 0
+	}
+	return float64(t.Seconds) / float64(t.Routes)
+}
+
+// buildWeeklyReport sums up the runs of the history in the week before end
+// and in the week before that.
+func buildWeeklyReport(historyPath string, end time.Time) (weeklyReport, error) {
+	from := end.AddDate(0, 0, -7)
+	records, err := readHistory(historyPath, from.AddDate(0, 0, -7), end)
+	if err != nil {
+		return weeklyReport{}, err
+	}
+	var thisWeek, weekBefore []historyRecord
+	for _, record := range records {
+		if record.Finished.Before(from) {
+			weekBefore = append(weekBefore, record)
+		} else {
+			thisWeek = append(thisWeek, record)
+		}
+	}
+	week, previous := sumWeek(thisWeek), sumWeek(weekBefore)
+
+	r := weeklyReport{
+		From:                   from,
+		To:                     end,
+		Runs:                   week.runs,
+		PreviousRuns:           previous.runs,
+		FailedRuns:             week.failedRuns,
+		PreviousFailedRuns:     previous.failedRuns,
+		Rows:                   week.rows,
+		PreviousRows:           previous.rows,
+		FailedRows:             week.failedRows,
+		PreviousFailedRows:     previous.failedRows,
+		ErrorRate:              week.errorRate(),
+		PreviousErrorRate:      previous.errorRate(),
+		TotalKm:                week.total.DistanceKm,
+		PreviousTotalKm:        previous.total.DistanceKm,
+		AvgDurationSec:         avgSeconds(week.total),
+		PreviousAvgDurationSec: avgSeconds(previous.total),
+	}
+
+	var changes []terminalWeek
+	for _, totals := range []map[string]terminalTotals{week.terminals, previous.terminals} {
+		for name := range totals {
+			if slices.ContainsFunc(changes, func(t terminalWeek) bool { return t.Terminal == name }) {
+				continue
+			}
+			now, before := week.terminals[name], previous.terminals[name]
+			t := terminalWeek{
+				Terminal:               name,
+				Routes:                 now.Routes,
+				PreviousRoutes:         before.Routes,
+				Km:                     now.DistanceKm,
+				PreviousKm:             before.DistanceKm,
+				AvgDurationSec:         avgSeconds(now),
+				PreviousAvgDurationSec: avgSeconds(before),
+			}
+			changes = append(changes, t)
+			if now.Routes > 0 {
+				r.Terminals = append(r.Terminals, t)
+			}
+		}
+	}
+	slices.SortFunc(r.Terminals, func(a, b terminalWeek) int {
+		return cmp.Or(cmp.Compare(b.Km, a.Km), cmp.Compare(a.Terminal, b.Terminal))
+	})
+	changes = slices.DeleteFunc(changes, func(t terminalWeek) bool { return t.Km == t.PreviousKm })
+	slices.SortFunc(changes, func(a, b terminalWeek) int {
+		return cmp.Or(cmp.Compare(math.Abs(b.Km-b.PreviousKm), math.Abs(a.Km-a.PreviousKm)), cmp.Compare(a.Terminal, b.Terminal))
+	})
+	r.Changes = changes[:min(len(changes), maxReportChanges)]
+
+	for _, failures := range []map[string]int{week.failures, previous.failures} {
+		for failure := range failures {
+			if !slices.ContainsFunc(r.Failures, func(f failureTrend) bool { return f.Type == failure }) {
+				r.Failures = append(r.Failures, failureTrend{Type: failure, Rows: week.failures[failure], PreviousRows: previous.failures[failure]})
+			}
+		}
+	}
+	slices.SortFunc(r.Failures, func(a, b failureTrend) int {
+		return cmp.Or(cmp.Compare(b.Rows, a.Rows), cmp.Compare(b.PreviousRows, a.PreviousRows), cmp.Compare(a.Type, b.Type))
+	})
+	return r, nil
+}
+
+// midnight returns the start of t's day in local time.
+func midnight(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+}
+
+// reportDelivery is where a report goes: a file or stdout, the config's
+// email recipients and its chat channel, each where set.
+type reportDelivery struct {
+	output string
+	mailer *mailer
+	chat   *chatNotifier
+}
+
+// deliver sends text, the report r laid out, everywhere d names. A failed
+// email or chat post does not stop the others.
+func (d reportDelivery) deliver(r weeklyReport, text string) error {
+	title := fmt.Sprintf("route-dm weekly report: %s to %s", r.From.Format("2 Jan"), r.LastDay().Format("2 Jan 2006"))
+	if d.output != "" {
+		err := matrixio.WriteOutput(d.output, func(w io.Writer) error {
+			_, err := io.WriteString(w, text)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	var errs []error
+	if d.mailer != nil {
+		msg, err := d.mailer.compose(title, text, "")
+		if err == nil {
+			err = d.mailer.send(msg)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("emailing the report: %w", err))
+		} else {
+			slog.Info("report emailed", "to", strings.Join(d.mailer.cfg.To, ","))
+		}
+	}
+	if d.chat != nil {
+		if err := d.chat.postText(title, text); err != nil {
+			errs = append(errs, fmt.Errorf("posting the report: %w", err))
+		} else {
+			slog.Info("report posted to chat")
+		}
+	}
+	return errors.Join(errs...)
+}
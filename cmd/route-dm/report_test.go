@@ -0,0 +1,121 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"routes/pkg/matrix"
+)
+
+func TestRunHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	h := newRunHistory(path, "job-1")
+	h.add([]matrix.Result{
+		{Route: matrix.Route{TerminalCode: "T2"}, Status: "OK", DistanceKm: 4, Seconds: 300},
+		{Route: matrix.Route{TerminalCode: "T1"}, Status: "OK", DistanceKm: 10, Seconds: 600},
+		{Route: matrix.Route{TerminalCode: "T1"}, Status: "OK", DistanceKm: 5, Seconds: 400},
+		{Route: matrix.Route{TerminalCode: "T1"}, Status: "ZERO_RESULTS"},
+	})
+	h.notify(webhookEvent{Status: "succeeded", Rows: 4, FailedRows: 1})
+	newRunHistory(path, "job-2").notify(webhookEvent{Status: "failed", Error: "reading input: no such file"})
+
+	records, err := readHistory(path, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1].JobID != "job-2" || records[1].Status != "failed" {
+		t.Fatalf("history = %+v", records)
+	}
+	first := records[0]
+	want := []terminalTotals{{"T1", 2, 15, 1000}, {"T2", 1, 4, 300}}
+	if !reflect.DeepEqual(first.Terminals, want) || first.Failures["ZERO_RESULTS"] != 1 || first.Rows != 4 {
+		t.Errorf("first run = %+v", first)
+	}
+
+	if records, err := readHistory(filepath.Join(t.TempDir(), "none.jsonl"), time.Time{}, time.Now()); err != nil || records != nil {
+		t.Errorf("readHistory of a missing file = %v, %v", records, err)
+	}
+}
+
+func TestWeeklyReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	end := time.Date(2024, 6, 10, 0, 0, 0, 0, time.Local)
+	for _, r := range []historyRecord{
+		// Two weeks back: not reported.
+		{Finished: end.AddDate(0, 0, -15), Status: "succeeded", Rows: 9, Terminals: []terminalTotals{{"T1", 9, 900, 9000}}},
+		// The week before.
+		{Finished: end.AddDate(0, 0, -10), Status: "succeeded", Rows: 12, FailedRows: 2, Failures: map[string]int{"NOT_FOUND": 2},
+			Terminals: []terminalTotals{{"T1", 6, 100, 3600}, {"T2", 4, 40, 1200}}},
+		// The week reported.
+		{Finished: end.AddDate(0, 0, -6), Status: "succeeded", Rows: 10, FailedRows: 1, Failures: map[string]int{"ZERO_RESULTS": 1},
+			Terminals: []terminalTotals{{"T1", 5, 150, 3000}, {"T3", 4, 20, 600}}},
+		{Finished: end.AddDate(0, 0, -1), Status: "failed", Error: "interrupted"},
+		// After the week.
+		{Finished: end, Status: "succeeded", Rows: 7, Terminals: []terminalTotals{{"T1", 7, 700, 7000}}},
+	} {
+		if err := appendHistory(path, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := buildWeeklyReport(path, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Runs != 2 || r.FailedRuns != 1 || r.PreviousRuns != 1 || r.Rows != 10 || r.ErrorRate != 0.1 || r.PreviousErrorRate != 2.0/12 {
+		t.Errorf("runs and rows = %+v", r)
+	}
+	if r.TotalKm != 170 || r.PreviousTotalKm != 140 || r.AvgDurationSec != 400 || r.PreviousAvgDurationSec != 480 {
+		t.Errorf("totals = %v km, %v s; the week before %v km, %v s", r.TotalKm, r.AvgDurationSec, r.PreviousTotalKm, r.PreviousAvgDurationSec)
+	}
+	var terminals, changes []string
+	for _, t := range r.Terminals {
+		terminals = append(terminals, t.Terminal)
+	}
+	for _, t := range r.Changes {
+		changes = append(changes, t.Terminal)
+	}
+	// T1 gained 50 km, T2 lost 40 and T3 is new with 20.
+	if !reflect.DeepEqual(terminals, []string{"T1", "T3"}) || !reflect.DeepEqual(changes, []string{"T1", "T2", "T3"}) {
+		t.Errorf("terminals %v, changes %v", terminals, changes)
+	}
+	wantFailures := []failureTrend{{"NOT_FOUND", 0, 2}, {"ZERO_RESULTS", 1, 0}}
+	if !reflect.DeepEqual(r.Failures, []failureTrend{wantFailures[1], wantFailures[0]}) {
+		t.Errorf("failures = %+v", r.Failures)
+	}
+
+	tmpl, err := parseReportTemplate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text strings.Builder
+	if err := tmpl.Execute(&text, r); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"route-dm weekly report, 3 Jun 2024 to 9 Jun 2024\n",
+		"Rows:          10 (1 failed, 10.0%; 16.7% the week before)\n",
+		"Total km:      170.0 (+30.0 km, +21.4%)\n",
+		"Avg duration:  7 mins (8 mins the week before)\n",
+		"  T1                    150.0 km      5 routes, avg 10 mins\n",
+		"  T2               -40.0 km, -100.0%\n",
+		"  T3               +20.0 km, new\n",
+		"  ZERO_RESULTS              1 (0 the week before)\n",
+	} {
+		if !strings.Contains(text.String(), line) {
+			t.Errorf("report has no line %q:\n%s", line, text.String())
+		}
+	}
+
+	custom, err := parseReportTemplate(`{{.Runs}} runs, {{printf "%.0f" .TotalKm}} km`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text.Reset()
+	if err := custom.Execute(&text, r); err != nil || text.String() != "2 runs, 170 km" {
+		t.Errorf("custom template = %q, %v", text.String(), err)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	matrixio "routes/pkg/io"
+)
+
+// cronSchedule is a parsed five-field cron expression: minute, hour, day of
+// month, month and day of week (0 or 7 is Sunday). Each field holds the
+// allowed values as a bit set.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record a "*" day field. As in cron, when both day
+	// fields are restricted a day matching either runs.
+	domAny, dowAny bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// parseSchedule parses a cron expression such as "0 3 * * 1" (03:00 every
+// Monday). Fields accept *, numbers, ranges (1-5), lists (1,15) and steps
+// (*/15, 0-30/10).
+func parseSchedule(expr string) (*cronSchedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("schedule %q: want 5 fields (minute hour day-of-month month day-of-week), got %d", expr, len(parts))
+	}
+	var sets [5]uint64
+	for i, part := range parts {
+		set, err := parseCronField(part, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", expr, err)
+		}
+		sets[i] = set
+	}
+	s := &cronSchedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    sets[4],
+		domAny: parts[2] == "*",
+		dowAny: parts[4] == "*",
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // 7 is Sunday too
+	}
+	return s, nil
+}
+
+func parseCronField(s string, f cronField) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(s, ",") {
+		rng, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("%s: invalid step %q", f.name, stepText)
+			}
+			step = n
+		}
+
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			loText, hiText, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = cronValue(loText, f); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = cronValue(hiText, f); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = f.max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("%s: range %q runs backwards", f.name, rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func cronValue(s string, f cronField) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s: %q is not a number from %d to %d", f.name, s, f.min, f.max)
+	}
+	return v, nil
+}
+
+// next returns the first minute after t that the schedule matches.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Every schedule matches within a few years (Feb 29 at worst).
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	if s.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// runScheduled runs the batch given by the command line at every time the
+// schedule matches, until the process is stopped. Each run is a child
+// process with the same flags, so a failed run is logged and the next one
+// still happens. File and object outputs get the run's timestamp in their name.
+func runScheduled(expr, output, errorsOutput string) error {
+	schedule, err := parseSchedule(expr)
+	if err != nil {
+		return err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := withoutFlag(os.Args[1:], "schedule")
+	if _, err := stampedPath(output, ""); err != nil {
+		return err
+	}
+
+	for {
+		at := schedule.next(time.Now())
+		if at.IsZero() {
+			return fmt.Errorf("schedule %q never matches", expr)
+		}
+		slog.Info("next scheduled run", "at", at.Format(time.RFC3339))
+		time.Sleep(time.Until(at))
+
+		stamp := at.Format("20060102T1504")
+		runOutput, err := stampedPath(output, stamp)
+		if err != nil {
+			return err
+		}
+		runArgs := append(args[:len(args):len(args)], "-output", runOutput)
+		if errorsOutput != "" {
+			runErrors, err := stampedPath(errorsOutput, stamp)
+			if err != nil {
+				return err
+			}
+			runArgs = append(runArgs, "-errors-output", runErrors)
+		}
+		start := time.Now()
+		failed, err := runChild(self, runArgs)
+		switch {
+		case err != nil:
+			return fmt.Errorf("starting scheduled run: %w", err)
+		case failed:
+			slog.Error("scheduled run failed", "run", stamp)
+		default:
+			slog.Info("scheduled run finished", "run", stamp, "elapsed", time.Since(start).Round(time.Second))
+		}
+	}
+}
+
+// runChild runs the route-dm binary self with args as a separate batch,
+// sharing this process's stdout and stderr. failed reports a run that
+// exited with an error, which it has already logged; err is for a run that
+// could not be started.
+func runChild(self string, args []string) (failed bool, err error) {
+	cmd := exec.Command(self, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return true, nil
+	}
+	return false, err
+}
+
+// stampedPath returns the output a run marked stamp writes to, so each run
+// keeps its own: stamp goes before the extension of a file path or remote
+// object, so "out/matrix.csv" becomes "out/matrix-20261016T0300.csv" and
+// "s3://bucket/matrix.csv" becomes "s3://bucket/matrix-20261016T0300.csv".
+// Databases, which runs add rows to, are returned unchanged. Stdout and
+// http URLs, which cannot hold one output per run, give an error.
+func stampedPath(path, stamp string) (string, error) {
+	if path == "-" {
+		return "", errors.New("stdout cannot hold one output per run; give a file or object -output")
+	}
+	if err := matrixio.CheckWritable(path); err != nil {
+		return "", err
+	}
+	if matrixio.IsRemote(path) {
+		u, err := url.Parse(path)
+		if err != nil {
+			return "", err
+		}
+		u.Path = stampFile(u.Path, stamp)
+		u.RawPath = ""
+		return u.String(), nil
+	}
+	if strings.Contains(path, "://") {
+		return path, nil
+	}
+	return stampFile(path, stamp), nil
+}
+
+// stampFile inserts stamp before the extension of a file name, including
+// any compression extension, as in results-STAMP.csv.gz.
+func stampFile(name, stamp string) string {
+	stem, ext := matrixio.SplitExt(name)
+	return stem + "-" + stamp + ext
+}
+
+// withoutFlag removes every occurrence of the named flag and its value from
+// args.
+func withoutFlag(args []string, name string) []string {
+	var out []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		bare := strings.TrimLeft(arg, "-")
+		switch {
+		case arg == "--":
+			return append(out, args[i:]...)
+		case arg != bare && bare == name:
+			i++ // the value is the next argument
+		case arg != bare && strings.HasPrefix(bare, name+"="):
+		default:
+			out = append(out, arg)
+		}
+	}
+	return out
+}
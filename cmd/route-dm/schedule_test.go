@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{expr: "0 3 * * 1"},
+		{expr: "*/15 * * * *"},
+		{expr: "0-30/10 8-18 1,15 * 1-5"},
+		{expr: "0 0 * * 7"},
+		{expr: "  0   3 * * 1 "},
+		{expr: "0 3 * *", wantErr: true},
+		{expr: "0 3 * * 1 2026", wantErr: true},
+		{expr: "60 * * * *", wantErr: true},
+		{expr: "* 24 * * *", wantErr: true},
+		{expr: "* * 0 * *", wantErr: true},
+		{expr: "* * * 13 *", wantErr: true},
+		{expr: "* * * * 8", wantErr: true},
+		{expr: "*/0 * * * *", wantErr: true},
+		{expr: "30-10 * * * *", wantErr: true},
+		{expr: "mon * * * *", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := parseSchedule(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseSchedule(%q) error = %v, want error %v", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		name string
+		expr string
+		from string
+		want string
+	}{
+		{"every quarter hour", "*/15 * * * *", "2026-10-16 10:07", "2026-10-16 10:15"},
+		{"strictly after a match", "*/15 * * * *", "2026-10-16 10:15", "2026-10-16 10:30"},
+		{"weekly on Monday", "0 3 * * 1", "2026-10-16 12:00", "2026-10-19 03:00"},
+		{"7 is Sunday", "30 9 * * 7", "2026-10-16 12:00", "2026-10-18 09:30"},
+		{"weekdays skip the weekend", "0 22 * * 1-5", "2026-10-16 23:00", "2026-10-19 22:00"},
+		{"either day field matches", "0 12 1 * 1", "2026-10-16 13:00", "2026-10-19 12:00"},
+		{"day of month alone", "0 12 1 * *", "2026-10-16 13:00", "2026-11-01 12:00"},
+		{"next leap day", "0 0 29 2 *", "2026-03-01 00:00", "2028-02-29 00:00"},
+		{"across the year", "59 23 31 12 *", "2026-12-31 23:59", "2027-12-31 23:59"},
+		{"hour list and range step", "0-30/10 8,18 * * *", "2026-10-16 08:31", "2026-10-16 18:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseSchedule(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := s.next(at(tt.from)), at(tt.want); !got.Equal(want) {
+				t.Errorf("next(%s) for %q = %s, want %s", tt.from, tt.expr, got.Format("2006-01-02 15:04 Mon"), want.Format("2006-01-02 15:04 Mon"))
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	matrixio "routes/pkg/io"
+)
+
+// secretClient fetches secrets over the default transport as it was before
+// -debug wrapped it, so secret values never reach the debug log.
+var secretClient = &http.Client{Transport: http.DefaultTransport}
+
+// fetchSecret resolves a secret reference from GOOGLE_API_KEY_REF:
+//
+//	vault://MOUNT/PATH#FIELD      HashiCorp Vault KV v2, via VAULT_ADDR and VAULT_TOKEN
+//	gcpsm://PROJECT/SECRET[/VER]  GCP Secret Manager, via Application Default Credentials
+//	awssm://SECRET_ID[#KEY]       AWS Secrets Manager in AWS_REGION, with the standard
+//	                              AWS credentials (see matrixio.LoadAWSCredentials)
+//
+// FIELD defaults to api_key. An AWS secret is used whole unless KEY names a
+// field of its JSON value.
+func fetchSecret(ref string) (string, error) {
+	scheme, rest, ok := strings.Cut(ref, "://")
+	if !ok {
+		return "", fmt.Errorf("secret reference %q has no scheme (want vault://, gcpsm:// or awssm://)", ref)
+	}
+	var value string
+	var err error
+	switch scheme {
+	case "vault":
+		value, err = fetchVaultSecret(rest)
+	case "gcpsm":
+		value, err = fetchGCPSecret(rest)
+	case "awssm":
+		value, err = fetchAWSSecret(rest)
+	default:
+		return "", fmt.Errorf("unknown secret store %q (want vault, gcpsm or awssm)", scheme)
+	}
+	if err != nil {
+		return "", fmt.Errorf("fetching %s: %w", ref, err)
+	}
+	return strings.TrimSpace(value), nil
+}
+
+func fetchVaultSecret(ref string) (string, error) {
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return "", fmt.Errorf("VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+	path, field, _ := strings.Cut(ref, "#")
+	if field == "" {
+		field = "api_key"
+	}
+	mount, secret, ok := strings.Cut(path, "/")
+	if !ok || secret == "" {
+		return "", fmt.Errorf("want vault://MOUNT/PATH#FIELD")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+mount+"/data/"+secret, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	var resp struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := doSecretRequest(secretClient, req, &resp); err != nil {
+		return "", err
+	}
+	value, ok := resp.Data.Data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("secret has no string field %q", field)
+	}
+	return value, nil
+}
+
+func fetchGCPSecret(ref string) (string, error) {
+	parts := strings.Split(ref, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return "", fmt.Errorf("want gcpsm://PROJECT/SECRET[/VERSION]")
+	}
+	version := "latest"
+	if len(parts) == 3 {
+		version = parts[2]
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, secretClient)
+	creds, err := google.FindDefaultCredentials(ctx, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return "", fmt.Errorf("finding Google credentials: %w", err)
+	}
+	u := fmt.Sprintf("https://secretmanager.googleapis.com/v1/projects/%s/secrets/%s/versions/%s:access",
+		url.PathEscape(parts[0]), url.PathEscape(parts[1]), url.PathEscape(version))
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := doSecretRequest(oauth2.NewClient(ctx, creds.TokenSource), req, &resp); err != nil {
+		return "", err
+	}
+	value, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("decoding payload: %w", err)
+	}
+	return string(value), nil
+}
+
+func fetchAWSSecret(ref string) (string, error) {
+	id, key, _ := strings.Cut(ref, "#")
+	creds, err := matrixio.LoadAWSCredentials()
+	if err != nil {
+		return "", err
+	}
+	region := matrixio.AWSRegion("")
+	if region == "" {
+		return "", fmt.Errorf("AWS_REGION must be set")
+	}
+
+	body, _ := json.Marshal(map[string]string{"SecretId": id})
+	req, err := http.NewRequest(http.MethodPost, "https://secretsmanager."+region+".amazonaws.com/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	matrixio.SignAWSRequest(req, matrixio.SHA256Hex(body), creds, region, "secretsmanager", time.Now())
+
+	var resp struct {
+		SecretString string
+	}
+	if err := doSecretRequest(secretClient, req, &resp); err != nil {
+		return "", err
+	}
+	if key == "" {
+		return resp.SecretString, nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(resp.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object: %w", err)
+	}
+	value, ok := fields[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret has no string field %q", key)
+	}
+	return value, nil
+}
+
+// doSecretRequest sends req and decodes a JSON response into v.
+func doSecretRequest(client *http.Client, req *http.Request, v any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
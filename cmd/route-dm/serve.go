@@ -0,0 +1,794 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+// runServe implements `route-dm serve`: an HTTP API that answers matrix
+// requests and runs CSV batches with the server's API key, so callers need
+// no key of their own.
+//
+//	POST /matrix             {"origins": ["lat,lng", ...], "destinations": [...], "avoid": "tolls", "mode": "driving"}
+//	POST /batch              multipart form with a CSV "file"; returns {"id": ...}
+//	GET  /batch/{id}         job status and progress; also GET /jobs/{id}
+//	GET  /batch/{id}/result  results as CSV once the job is done; also GET /jobs/{id}/result
+//	                         ?offset=N&limit=M for a page of rows; Range for part of the file
+//	GET  /batch              recent jobs, newest first; also GET /jobs
+//	GET  /                   dashboard for uploading CSVs and following jobs
+//	GET  /openapi.json       OpenAPI 3 description of the endpoints above
+//	GET  /usage              the calling client's usage today
+//	GET  /slo                availability, burn rates and error budget left
+//	GET  /metrics            the same in the Prometheus text format
+//	GET  /lanes              the critical lanes with their latest distance and duration
+//
+// With clients in the config, the API endpoints need one of their keys, as
+// "Authorization: Bearer KEY" or "X-API-Key: KEY". Each client sees only
+// its own jobs, and may be capped at a number of elements per UTC day.
+//
+// Every provider request counts towards the availability objective of
+// -slo-objective: its elements are good when the provider answers them and
+// bad when the request fails. /slo and /metrics report the share of good
+// elements and the rate the error budget is burning at over rolling
+// windows, for alerting before users notice. They need no API key.
+//
+// Matrix answers are kept for -cache-ttl, so repeated lanes are answered
+// from memory. -preload loads published matrix files at startup, whose
+// lanes are then always answered from memory; only unknown lanes are
+// computed live. The config's critical_lanes are refreshed before anything
+// else at startup, and again whenever -critical-schedule matches, however
+// recently they were fetched; between refreshes they are always answered
+// from memory.
+//
+// With -grpc-addr it also serves the RouteDistanceMatrix gRPC service of
+// pkg/routedmpb/routedm.proto, with the same clients and limits. Its
+// ComputeMatrix and ComputeBatch calls answer once everything is computed;
+// StreamMatrix and StreamBatch send each element or route as it completes.
+//
+// Batch jobs are kept in memory and lost on restart, unless -state-dir
+// names a directory to keep them in, from which a restarted server serves
+// the finished jobs and runs the interrupted ones again. Only the latest
+// maxJobHistory finished jobs are kept.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	grpcAddr := fs.String("grpc-addr", "", "address to serve the gRPC API on (default: no gRPC)")
+	configPath := fs.String("config", matrixio.DefaultConfigFile, "config file giving the CSV layout of batch uploads and the provider")
+	webhookURL := fs.String("webhook", "", "URL that receives a JSON POST when a batch job finishes (default from the config)")
+	concurrency := fs.Int("concurrency", 0, "API requests in flight per batch (default from the config, else 1)")
+	maxElements := fs.Int("max-elements", 2500, "largest origins × destinations accepted by POST /matrix, and largest gRPC call in elements")
+	maxUpload := fs.Int64("max-upload", 32<<20, "largest batch upload in bytes")
+	stateDir := fs.String("state-dir", "", "directory keeping batch jobs, their uploads and results across restarts (default: memory only)")
+	sloObjective := fs.Float64("slo-objective", 0.999, "target ratio of provider elements answered, for /slo and /metrics")
+	sloPeriod := fs.Duration("slo-period", 30*24*time.Hour, "period the error budget of -slo-objective is spent over")
+	cacheTTL := fs.Duration("cache-ttl", 0, "how long matrix answers are kept to answer the same lanes again (default: only critical lanes are kept)")
+	preload := fs.String("preload", "", "comma-separated published matrix files, with lat,lng ORIGIN and DESTINATION (or ORIGIN_ID and DESTINATION_ID) columns, whose lanes are answered from memory")
+	criticalSchedule := fs.String("critical-schedule", "", "cron expression on which the config's critical lanes are refreshed, besides at startup")
+	newKey := fs.String("new-key", "", "print a new API key for the named client, with the config entry that lets it in, and exit")
+	fs.Parse(args)
+
+	if *newKey != "" {
+		key, digest, err := newAPIKey()
+		if err != nil {
+			return err
+		}
+		entry, _ := json.MarshalIndent(matrixio.ClientConfig{Name: *newKey, KeySHA256: digest}, "  ", "  ")
+		fmt.Printf("API key: %s\n\nAdd to \"clients\" in the config:\n  %s\n", key, entry)
+		return nil
+	}
+
+	explicit := false
+	fs.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "config" })
+	cfg, err := matrixio.LoadConfig(*configPath, explicit)
+	if err != nil {
+		return err
+	}
+	if *concurrency > 0 {
+		cfg.Concurrency = *concurrency
+	}
+	if *webhookURL != "" {
+		cfg.Webhook = *webhookURL
+	}
+	epsg, err := matrixio.ParseEPSG(cfg.CRS)
+	if err != nil {
+		return err
+	}
+	if _, err := matrixio.ParseDistanceUnits(cfg.DistanceUnits); err != nil {
+		return err
+	}
+
+	var apiKey string
+	if matrix.NeedsAPIKey(cfg.Provider) {
+		if apiKey, err = loadAPIKey(); err != nil {
+			return err
+		}
+	}
+	if _, err := matrix.NewProvider(cfg.Provider, apiKey, matrix.QueryOptions{}); err != nil {
+		return err
+	}
+	slo, err := newSLOTracker(*sloObjective, *sloPeriod)
+	if err != nil {
+		return err
+	}
+	var schedule *cronSchedule
+	if *criticalSchedule != "" {
+		if len(cfg.CriticalLanes) == 0 {
+			return errors.New("-critical-schedule needs critical_lanes in the config")
+		}
+		if schedule, err = parseSchedule(*criticalSchedule); err != nil {
+			return err
+		}
+	}
+	clients, err := newAPIClients(cfg.Clients)
+	if err != nil {
+		return err
+	}
+	if clients == nil {
+		slog.Warn("no clients in the config; anyone who can reach the server can use it")
+	}
+
+	s := &server{
+		cfg:         cfg,
+		apiKey:      apiKey,
+		epsg:        epsg,
+		maxElements: *maxElements,
+		maxUpload:   *maxUpload,
+		clients:     clients,
+		slo:         slo,
+		lanes:       newLaneCache(*cacheTTL),
+		jobs:        make(map[string]*batchJob),
+	}
+	for _, path := range strings.Split(*preload, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		n, err := s.preloadLanes(path)
+		if err != nil {
+			return fmt.Errorf("preloading: %w", err)
+		}
+		slog.Info("matrix preloaded", "file", path, "lanes", n)
+	}
+	if s.critical, err = s.criticalLanes(cfg.CriticalLanes); err != nil {
+		return err
+	}
+	if len(s.critical) > 0 {
+		s.refreshCriticalLanes()
+	}
+	if schedule != nil {
+		go s.refreshCriticalLanesOn(schedule)
+	}
+	if *stateDir != "" {
+		if s.store, err = newJobStore(*stateDir); err != nil {
+			return err
+		}
+		if err := s.restoreJobs(); err != nil {
+			return fmt.Errorf("restoring jobs: %w", err)
+		}
+	}
+	errs := make(chan error, 2)
+	if *grpcAddr != "" {
+		ln, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			return err
+		}
+		slog.Info("serving gRPC", "addr", *grpcAddr)
+		go func() { errs <- s.newGRPCServer().Serve(ln) }()
+	}
+	slog.Info("serving", "addr", *addr)
+	go func() { errs <- http.ListenAndServe(*addr, s.routes()) }()
+	return <-errs
+}
+
+type server struct {
+	cfg         matrixio.Config
+	apiKey      string
+	epsg        int
+	maxElements int
+	maxUpload   int64
+	// clients are keyed by the SHA-256 digest of their API key; nil means
+	// the server is open.
+	clients map[string]*apiClient
+	// store keeps jobs across restarts; nil keeps them in memory only.
+	store *jobStore
+	// slo counts the provider's good and bad elements; nil counts none.
+	slo *sloTracker
+	// lanes answers known lanes from memory; nil computes every one.
+	lanes *laneCache
+	// critical are the lanes refreshed at startup and on schedule.
+	critical []criticalLane
+
+	mu   sync.Mutex
+	jobs map[string]*batchJob
+}
+
+// batchJob is an uploaded CSV being computed in the background.
+type batchJob struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"` // running or done
+	Filename string     `json:"filename,omitempty"`
+	Rows     int        `json:"rows"`
+	Done     int        `json:"done_rows"`
+	Failed   int        `json:"failed_rows"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	client string // name of the client that submitted it
+	// results are kept in memory unless the server has a job store, which
+	// holds them in a file instead.
+	results []matrix.Result
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", handleDashboard)
+	mux.HandleFunc("GET /batch", s.authenticate(s.handleJobs))
+	mux.HandleFunc("POST /matrix", s.authenticate(s.handleMatrix))
+	mux.HandleFunc("POST /batch", s.authenticate(s.handleBatch))
+	mux.HandleFunc("GET /batch/{id}", s.authenticate(s.handleJob))
+	mux.HandleFunc("GET /batch/{id}/result", s.authenticate(s.handleJobResult))
+	mux.HandleFunc("GET /jobs", s.authenticate(s.handleJobs))
+	mux.HandleFunc("GET /jobs/{id}", s.authenticate(s.handleJob))
+	mux.HandleFunc("GET /jobs/{id}/result", s.authenticate(s.handleJobResult))
+	mux.HandleFunc("GET /usage", s.authenticate(s.handleUsage))
+	mux.HandleFunc("GET /openapi.json", handleOpenAPI)
+	if s.slo != nil {
+		mux.HandleFunc("GET /slo", s.handleSLO)
+		mux.HandleFunc("GET /metrics", s.handleMetrics)
+	}
+	if s.lanes != nil {
+		mux.HandleFunc("GET /lanes", s.authenticate(s.handleLanes))
+	}
+	return mux
+}
+
+// matrixRequest is the body of POST /matrix. Locations are "lat,lng" in the
+// server's CRS.
+type matrixRequest struct {
+	Origins      []string `json:"origins"`
+	Destinations []string `json:"destinations"`
+	Avoid        string   `json:"avoid"`
+	Mode         string   `json:"mode"`
+}
+
+type matrixResponse struct {
+	Origins      []string            `json:"origins"`
+	Destinations []string            `json:"destinations"`
+	Rows         []matrixResponseRow `json:"rows"`
+}
+
+type matrixResponseRow struct {
+	Elements []matrixResponseElement `json:"elements"`
+}
+
+type matrixResponseElement struct {
+	// Status is OK or N/A; failed elements have no distance or duration.
+	Status     string  `json:"status"`
+	DistanceKm float64 `json:"distance_km,omitempty"`
+	Duration   string  `json:"duration,omitempty"`
+}
+
+func (s *server) handleMatrix(w http.ResponseWriter, r *http.Request) {
+	var req matrixRequest
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decoding request: %w", err))
+		return
+	}
+	if len(req.Origins) == 0 || len(req.Destinations) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("origins and destinations must not be empty"))
+		return
+	}
+	n := len(req.Origins) * len(req.Destinations)
+	if n > s.maxElements {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("%d elements requested, over the limit of %d", n, s.maxElements))
+		return
+	}
+
+	opts, p, err := s.newQuery(req.Avoid, req.Mode)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	origins, err := s.matrixPoints(req.Origins)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("origins: %w", err))
+		return
+	}
+	destinations, err := s.matrixPoints(req.Destinations)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("destinations: %w", err))
+		return
+	}
+	if err := s.charge(requestClient(r), int64(n)); err != nil {
+		writeError(w, http.StatusTooManyRequests, err)
+		return
+	}
+
+	cells := s.cachedMatrix(p, opts, origins, destinations)
+	resp := matrixResponse{Origins: req.Origins, Destinations: req.Destinations}
+	for _, row := range cells {
+		var out matrixResponseRow
+		for _, cell := range row {
+			element := matrixResponseElement{Status: "N/A"}
+			if cell.duration != "N/A" {
+				element = matrixResponseElement{Status: "OK", DistanceKm: cell.distanceKm, Duration: cell.duration}
+			}
+			out.Elements = append(out.Elements, element)
+		}
+		resp.Rows = append(resp.Rows, out)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// newQuery returns the options and provider for a request's avoid and
+// mode parameters.
+func (s *server) newQuery(avoid, mode string) (matrix.QueryOptions, matrix.Provider, error) {
+	var opts matrix.QueryOptions
+	if err := matrix.ParseAvoid(avoid, &opts); err != nil {
+		return opts, nil, err
+	}
+	if err := matrix.ParseTravelMode(matrix.TravelOptions{Mode: mode}, &opts); err != nil {
+		return opts, nil, err
+	}
+	p, err := s.newProvider(opts)
+	return opts, p, err
+}
+
+// newProvider returns the configured provider for opts, counted towards the
+// SLO.
+func (s *server) newProvider(opts matrix.QueryOptions) (matrix.Provider, error) {
+	p, err := matrix.NewProvider(s.cfg.Provider, s.apiKey, opts)
+	if err != nil || s.slo == nil {
+		return p, err
+	}
+	return sloProvider{p: p, t: s.slo}, nil
+}
+
+// matrixPoints converts "lat,lng" locations to WGS84 matrix points.
+func (s *server) matrixPoints(locations []string) ([]matrixPoint, error) {
+	points := make([]matrixPoint, len(locations))
+	for i, location := range locations {
+		coordinate, err := s.coordinate(location)
+		if err != nil {
+			return nil, fmt.Errorf("location %d: %w", i+1, err)
+		}
+		points[i] = matrixPoint{id: location, coordinate: coordinate}
+	}
+	return points, nil
+}
+
+// coordinate converts a "lat,lng" location in the server's CRS to WGS84.
+func (s *server) coordinate(location string) (string, error) {
+	lat, lng, ok := strings.Cut(location, ",")
+	if !ok {
+		return "", fmt.Errorf("%q is not lat,lng", location)
+	}
+	return matrixio.RowCoordinate(s.epsg, strings.TrimSpace(lat), strings.TrimSpace(lng))
+}
+
+func (s *server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUpload)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("reading upload: %w", err))
+		return
+	}
+	defer file.Close()
+	upload, err := io.ReadAll(file)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("reading upload: %w", err))
+		return
+	}
+
+	routes, err := matrixio.ReadRoutesCSV(bytes.NewReader(upload), s.cfg)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if s.cfg.Columns.HasAddresses() {
+		writeError(w, http.StatusBadRequest, errors.New("address columns are not supported in batch uploads"))
+		return
+	}
+	p, err := s.newProvider(matrix.QueryOptions{})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	elements := int64(estimateRun(s.cfg, matrix.QueryOptions{}, routes, nil, nil).billed())
+	if err := s.charge(requestClient(r), elements); err != nil {
+		writeError(w, http.StatusTooManyRequests, err)
+		return
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		s.refund(requestClient(r), elements)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	job := &batchJob{ID: id, Status: "running", Filename: header.Filename, Rows: len(routes), Created: time.Now(), client: clientName(r)}
+	if s.store != nil {
+		err := s.store.saveInput(job.ID, upload)
+		if err == nil {
+			err = s.store.save(*job)
+		}
+		if err != nil {
+			s.store.remove(job.ID)
+			s.refund(requestClient(r), elements)
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("saving job: %w", err))
+			return
+		}
+	}
+	s.mu.Lock()
+	s.jobs[job.ID] = job
+	s.evictJobs("")
+	s.mu.Unlock()
+	s.runJob(job, p, routes)
+
+	slog.Info("batch accepted", "job", job.ID, "rows", job.Rows, "client", job.client)
+	writeJSON(w, http.StatusAccepted, s.snapshot(job))
+}
+
+// runJob computes the routes of job in the background, and saves the
+// results and the finished job to the store, if any.
+func (s *server) runJob(job *batchJob, p matrix.Provider, routes []matrix.Route) {
+	go func() {
+		client := &matrix.Client{Provider: p, Concurrency: s.cfg.Concurrency, Progress: jobProgress{s, job}}
+		results := client.Compute(matrix.Request{Routes: routes})
+		if s.store != nil {
+			if err := s.writeResult(s.store.resultPath(job.ID), results); err != nil {
+				// The results are still served from memory until the
+				// next restart.
+				slog.Error("saving batch result", "job", job.ID, "err", err)
+			} else {
+				results = nil
+			}
+		}
+
+		s.mu.Lock()
+		job.results = results
+		job.Status = "done"
+		finished := time.Now()
+		job.Finished = &finished
+		done := *job
+		s.evictJobs(job.ID)
+		// Saving under the lock keeps a later eviction from removing the
+		// job's files before they are written, which would leave a status
+		// file without its result.
+		if s.store != nil {
+			if err := s.store.save(done); err != nil {
+				slog.Error("saving batch job", "job", job.ID, "err", err)
+			}
+		}
+		s.mu.Unlock()
+
+		slog.Info("batch finished", "job", job.ID, "rows", done.Rows, "failed", done.Failed)
+		newWebhook(s.cfg.Webhook, job.ID).notify(webhookEvent{Status: "succeeded", Rows: done.Rows, FailedRows: done.Failed, Output: "/batch/" + job.ID + "/result"})
+	}()
+}
+
+// writeResult writes results to path as CSV, in the layout of
+// GET /batch/{id}/result.
+func (s *server) writeResult(path string, results []matrix.Result) error {
+	units, err := matrixio.ParseDistanceUnits(s.cfg.DistanceUnits)
+	if err != nil {
+		return err
+	}
+	return matrixio.WriteOutput(path, func(w io.Writer) error {
+		return matrixio.WriteResultsToCSV(w, s.cfg.CSV, units, results, s.cfg.OnFailure, s.cfg.OutputColumns)
+	})
+}
+
+// restoreJobs loads the jobs of the store. Finished ones are served from
+// their saved results; interrupted ones start again from their upload.
+func (s *server) restoreJobs() error {
+	jobs, err := s.store.load()
+	if err != nil {
+		return err
+	}
+	p, err := s.newProvider(matrix.QueryOptions{})
+	if err != nil {
+		return err
+	}
+	resumed := 0
+	for _, job := range jobs {
+		var routes []matrix.Route
+		if job.Status == "running" {
+			if routes, err = s.savedRoutes(job.ID); err != nil {
+				slog.Warn("dropping interrupted batch job", "job", job.ID, "err", err)
+				s.store.remove(job.ID)
+				continue
+			}
+			job.Done, job.Failed = 0, 0
+		}
+		s.mu.Lock()
+		s.jobs[job.ID] = &job
+		s.mu.Unlock()
+		if routes != nil {
+			s.runJob(&job, p, routes)
+			resumed++
+		}
+	}
+	s.mu.Lock()
+	s.evictJobs("")
+	s.mu.Unlock()
+	slog.Info("restored batch jobs", "jobs", len(jobs), "resumed", resumed, "dir", s.store.dir)
+	return nil
+}
+
+// savedRoutes reads the routes of the saved upload of job id.
+func (s *server) savedRoutes(id string) ([]matrix.Route, error) {
+	file, err := os.Open(s.store.inputPath(id))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return matrixio.ReadRoutesCSV(file, s.cfg)
+}
+
+// maxJobHistory is how many jobs GET /batch lists, and how many the server
+// keeps once they are done.
+const maxJobHistory = 50
+
+// evictJobs drops the jobs that finished first, with their results, until
+// no more than maxJobHistory are kept. Running jobs and job keep, one that
+// has just finished, are never dropped. s.mu must be held.
+func (s *server) evictJobs(keep string) {
+	if len(s.jobs) <= maxJobHistory {
+		return
+	}
+	var done []*batchJob
+	for _, job := range s.jobs {
+		if job.Status == "done" && job.ID != keep {
+			done = append(done, job)
+		}
+	}
+	slices.SortFunc(done, func(a, b *batchJob) int { return a.Finished.Compare(*b.Finished) })
+	for _, job := range done[:min(len(done), len(s.jobs)-maxJobHistory)] {
+		delete(s.jobs, job.ID)
+		if s.store != nil {
+			s.store.remove(job.ID)
+		}
+	}
+}
+
+func (s *server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	client := clientName(r)
+	s.mu.Lock()
+	jobs := make([]batchJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		if job.client == client {
+			jobs = append(jobs, *job)
+		}
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(jobs, func(a, b batchJob) int { return b.Created.Compare(a.Created) })
+	writeJSON(w, http.StatusOK, jobs[:min(len(jobs), maxJobHistory)])
+}
+
+func (s *server) handleJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(r.PathValue("id"), clientName(r))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("no such job"))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *server) handleJobResult(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(r.PathValue("id"), clientName(r))
+	switch {
+	case !ok:
+		writeError(w, http.StatusNotFound, errors.New("no such job"))
+		return
+	case job.Status != "done":
+		writeError(w, http.StatusConflict, fmt.Errorf("job is %s", job.Status))
+		return
+	}
+	offset, limit, paged, err := parsePage(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var content io.ReadSeeker
+	if job.results == nil && s.store != nil {
+		file, err := os.Open(s.store.resultPath(job.ID))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("opening result: %w", err))
+			return
+		}
+		defer file.Close()
+		content = file
+	} else {
+		units, err := matrixio.ParseDistanceUnits(s.cfg.DistanceUnits)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		var b bytes.Buffer
+		if err := matrixio.WriteResultsToCSV(&b, s.cfg.CSV, units, job.results, s.cfg.OnFailure, s.cfg.OutputColumns); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		content = bytes.NewReader(b.Bytes())
+	}
+
+	if paged {
+		page, total, err := s.resultPage(content, offset, limit)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("reading result: %w", err))
+			return
+		}
+		w.Header().Set("X-Total-Count", strconv.Itoa(total))
+		if limit < total-offset {
+			next := fmt.Sprintf("%s?offset=%d&limit=%d", r.URL.Path, offset+limit, limit)
+			w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next))
+		}
+		content = bytes.NewReader(page)
+	}
+	setResultHeaders(w, job.ID)
+	var modified time.Time
+	if job.Finished != nil {
+		modified = *job.Finished
+	}
+	// ServeContent answers Range requests, so large results can be
+	// downloaded in parts and resumed.
+	http.ServeContent(w, r, "", modified, content)
+}
+
+// parsePage reads the offset and limit parameters of a result download.
+// Without either the whole result is sent; a limit without an offset
+// starts at the first row, and an offset without a limit runs to the end.
+func parsePage(query url.Values) (offset, limit int, paged bool, err error) {
+	limit = math.MaxInt
+	for _, param := range []struct {
+		name string
+		dst  *int
+		min  int
+	}{{"offset", &offset, 0}, {"limit", &limit, 1}} {
+		v := query.Get(param.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < param.min {
+			return 0, 0, false, fmt.Errorf("%s must be an integer of at least %d", param.name, param.min)
+		}
+		*param.dst = n
+		paged = true
+	}
+	return offset, limit, paged, nil
+}
+
+// resultPage returns the header and the limit rows from offset of the CSV
+// result in content, with the number of rows the result has in all.
+func (s *server) resultPage(content io.Reader, offset, limit int) (page []byte, total int, err error) {
+	reader, err := s.cfg.CSV.NewReader(content)
+	if err != nil {
+		return nil, 0, err
+	}
+	reader.FieldsPerRecord = -1
+	var records [][]string
+	for i := -1; ; i++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		// i is -1 for the header.
+		if i < 0 || i >= offset && i-offset < limit {
+			records = append(records, record)
+		}
+		total = i + 1
+	}
+	var b bytes.Buffer
+	if err := s.cfg.CSV.WriteAll(&b, records); err != nil {
+		return nil, 0, err
+	}
+	return b.Bytes(), total, nil
+}
+
+func setResultHeaders(w http.ResponseWriter, id string) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+".csv"))
+}
+
+// job returns a copy of the job with the given ID, safe to read while it
+// runs, if the client submitted it. Other clients' jobs are reported as
+// missing rather than forbidden, so their IDs cannot be probed.
+func (s *server) job(id, client string) (batchJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok || job.client != client {
+		return batchJob{}, false
+	}
+	return *job, true
+}
+
+func (s *server) snapshot(job *batchJob) batchJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *job
+}
+
+// jobProgress counts a batch job's finished rows for its status.
+type jobProgress struct {
+	s   *server
+	job *batchJob
+}
+
+func (p jobProgress) Step(failed bool) {
+	p.s.mu.Lock()
+	defer p.s.mu.Unlock()
+	p.job.Done++
+	if failed {
+		p.job.Failed++
+	}
+}
+
+func (p jobProgress) Finish() {}
+
+//go:embed dashboard.html
+var dashboard []byte
+
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboard)
+}
+
+// openAPISpec describes the endpoints, for consumers generating typed
+// clients. TestOpenAPISchemas keeps its schemas in step with the request and
+// response types.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating job ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
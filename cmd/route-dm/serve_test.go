@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+func TestOpenAPISchemas(t *testing.T) {
+	var spec struct {
+		Components struct {
+			Schemas map[string]struct {
+				Required   []string                   `json:"required"`
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+
+	for name, v := range map[string]any{
+		"MatrixRequest":  matrixRequest{},
+		"MatrixResponse": matrixResponse{},
+		"MatrixRow":      matrixResponseRow{},
+		"MatrixElement":  matrixResponseElement{},
+		"BatchJob":       batchJob{},
+		"Usage":          clientUsage{},
+		"SLOSummary":     sloSummary{},
+		"SLOWindow":      sloWindow{},
+		"LaneStatus":     laneStatus{},
+	} {
+		schema, ok := spec.Components.Schemas[name]
+		if !ok {
+			t.Errorf("schema %s is missing", name)
+			continue
+		}
+		// Every JSON field is a property, and those the encoder always
+		// writes are required, except in requests, whose fields the spec
+		// marks required where the handler insists on them.
+		var fields, always []string
+		typ := reflect.TypeOf(v)
+		for i := range typ.NumField() {
+			tag := typ.Field(i).Tag.Get("json")
+			field, opts, _ := strings.Cut(tag, ",")
+			if field == "" || field == "-" {
+				continue
+			}
+			fields = append(fields, field)
+			if opts != "omitempty" {
+				always = append(always, field)
+			}
+		}
+		var properties []string
+		for p := range schema.Properties {
+			properties = append(properties, p)
+		}
+		slices.Sort(fields)
+		slices.Sort(properties)
+		if !slices.Equal(fields, properties) {
+			t.Errorf("schema %s has properties %v, the type has %v", name, properties, fields)
+		}
+		if strings.HasSuffix(name, "Request") {
+			continue
+		}
+		required := slices.Clone(schema.Required)
+		slices.Sort(required)
+		slices.Sort(always)
+		if !slices.Equal(always, required) {
+			t.Errorf("schema %s requires %v, the type always has %v", name, required, always)
+		}
+	}
+}
+
+func TestServerClients(t *testing.T) {
+	aliceKey, aliceDigest, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobKey, bobDigest, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := newAPIClients([]matrixio.ClientConfig{
+		{Name: "alice", KeySHA256: aliceDigest, DailyElements: 6},
+		{Name: "bob", KeySHA256: bobDigest},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{
+		cfg:         matrixio.Config{Provider: "mock"},
+		epsg:        4326,
+		maxElements: 100,
+		clients:     clients,
+		jobs:        map[string]*batchJob{"j1": {ID: "j1", Status: "done", client: "alice"}},
+	}
+	srv := httptest.NewServer(s.routes())
+	defer srv.Close()
+
+	do := func(method, path, key, body string) int {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	// 2 × 2 elements per request against alice's 6 a day.
+	const matrixBody = `{"origins": ["-6.2,106.8", "-6.3,106.9"], "destinations": ["-6.9,107.6", "-7.0,107.7"]}`
+
+	tests := []struct {
+		name, method, path, key, body string
+		want                          int
+	}{
+		{"no key", "POST", "/matrix", "", matrixBody, http.StatusUnauthorized},
+		{"unknown key", "POST", "/matrix", "rdm_nope", matrixBody, http.StatusUnauthorized},
+		{"within quota", "POST", "/matrix", aliceKey, matrixBody, http.StatusOK},
+		{"over quota", "POST", "/matrix", aliceKey, matrixBody, http.StatusTooManyRequests},
+		{"no cap", "POST", "/matrix", bobKey, matrixBody, http.StatusOK},
+		{"own job", "GET", "/batch/j1", aliceKey, "", http.StatusOK},
+		{"other client's job", "GET", "/batch/j1", bobKey, "", http.StatusNotFound},
+		{"spec is public", "GET", "/openapi.json", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := do(tt.method, tt.path, tt.key, tt.body); got != tt.want {
+			t.Errorf("%s: %s %s gave %d, want %d", tt.name, tt.method, tt.path, got, tt.want)
+		}
+	}
+
+	if got := clients[aliceDigest]; got.requests != 1 || got.elements != 4 {
+		t.Errorf("alice used %d requests and %d elements, want 1 and 4", got.requests, got.elements)
+	}
+}
+
+func TestJobStore(t *testing.T) {
+	st, err := newJobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	job := batchJob{ID: "j1", Status: "running", Filename: "sites.csv", Rows: 3, Done: 1, Created: created, client: "alice"}
+	if err := st.save(job); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.saveInput(job.ID, []byte("SITE_CODE\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := st.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || !reflect.DeepEqual(jobs[0], job) {
+		t.Fatalf("load() = %+v, want [%+v]", jobs, job)
+	}
+
+	st.remove(job.ID)
+	if jobs, err := st.load(); err != nil || len(jobs) != 0 {
+		t.Errorf("load() after remove = %v, %v; want no jobs", jobs, err)
+	}
+	if _, err := os.Stat(st.inputPath(job.ID)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("upload of removed job: %v", err)
+	}
+}
+
+func TestEvictJobs(t *testing.T) {
+	start := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	s := &server{jobs: make(map[string]*batchJob)}
+	add := func(id, status string, created, finished time.Duration) {
+		job := &batchJob{ID: id, Status: status, Created: start.Add(created)}
+		if status == "done" {
+			f := start.Add(finished)
+			job.Finished = &f
+		}
+		s.jobs[id] = job
+	}
+	// A long job created first that has just finished, a running one, and
+	// enough short ones to go over the limit by two.
+	add("long", "done", 0, 10*time.Hour)
+	add("running", "running", 0, 0)
+	for i := range maxJobHistory {
+		add(string(rune('a'+i/26))+string(rune('a'+i%26)), "done", time.Duration(i+1)*time.Minute, time.Duration(i+2)*time.Minute)
+	}
+
+	s.evictJobs("long")
+	if len(s.jobs) != maxJobHistory {
+		t.Fatalf("%d jobs kept, want %d", len(s.jobs), maxJobHistory)
+	}
+	for _, id := range []string{"long", "running", "ac", "bx"} {
+		if s.jobs[id] == nil {
+			t.Errorf("job %s was evicted", id)
+		}
+	}
+	for _, id := range []string{"aa", "ab"} {
+		if s.jobs[id] != nil {
+			t.Errorf("job %s, among the first to finish, was kept", id)
+		}
+	}
+}
+
+func TestBatchRefundsFailedSave(t *testing.T) {
+	key, digest, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients, err := newAPIClients([]matrixio.ClientConfig{{Name: "alice", KeySHA256: digest, DailyElements: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A store whose directory has been replaced by a file cannot save.
+	dir := t.TempDir() + "/jobs"
+	st, err := newJobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := matrixio.DefaultConfig()
+	cfg.Provider = "mock"
+	s := &server{cfg: cfg, epsg: 4326, maxUpload: 1 << 20, clients: clients, store: st, jobs: make(map[string]*batchJob)}
+	srv := httptest.NewServer(s.routes())
+	defer srv.Close()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "sites.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(part, "SITE_CODE,SITE_NAME,LAT,LNG,TERMINAL_CODE,OLAT,OLNG\nS1,Alpha,-6.2,106.8,T1,-6.3,106.9\n")
+	form.Close()
+	req, err := http.NewRequest("POST", srv.URL+"/batch", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("POST /batch gave %d, want %d", resp.StatusCode, http.StatusInternalServerError)
+	}
+	if got := clients[digest]; got.requests != 0 || got.elements != 0 {
+		t.Errorf("alice was charged %d requests and %d elements for a job that was never saved", got.requests, got.elements)
+	}
+}
+
+func TestJobResultPages(t *testing.T) {
+	st, err := newJobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	finished := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	var results []matrix.Result
+	for i := range 5 {
+		results = append(results, matrix.Result{Route: matrix.Route{SiteCode: fmt.Sprintf("S%d", i+1), TerminalCode: "T1"}, DistanceKm: float64(i + 1), Duration: "1 min", Status: "OK"})
+	}
+	s := &server{cfg: matrixio.DefaultConfig(), store: st, jobs: map[string]*batchJob{
+		"memory": {ID: "memory", Status: "done", Finished: &finished, results: results},
+		"stored": {ID: "stored", Status: "done", Finished: &finished},
+	}}
+	if err := s.writeResult(st.resultPath("stored"), results); err != nil {
+		t.Fatal(err)
+	}
+	const header = "SITE_CODE,SITE_NAME,TERMINAL_CODE,DISTANCE_KM,DURATION\n"
+	row := func(i int) string { return fmt.Sprintf("S%d,,T1,%d.00,1 min\n", i, i) }
+	whole := header + row(1) + row(2) + row(3) + row(4) + row(5)
+
+	tests := []struct {
+		query, rangeHeader string
+		status             int
+		body, total, link  string
+	}{
+		{"", "", http.StatusOK, whole, "", ""},
+		{"?offset=1&limit=2", "", http.StatusOK, header + row(2) + row(3), "5", `</batch/%s/result?offset=3&limit=2>; rel="next"`},
+		{"?limit=2", "", http.StatusOK, header + row(1) + row(2), "5", `</batch/%s/result?offset=2&limit=2>; rel="next"`},
+		{"?offset=3", "", http.StatusOK, header + row(4) + row(5), "5", ""},
+		{"?offset=9&limit=2", "", http.StatusOK, header, "5", ""},
+		{"?limit=0", "", http.StatusBadRequest, "", "", ""},
+		{"?offset=-1", "", http.StatusBadRequest, "", "", ""},
+		{"", "bytes=0-9", http.StatusPartialContent, whole[:10], "", ""},
+		{"", fmt.Sprintf("bytes=%d-", len(header)), http.StatusPartialContent, whole[len(header):], "", ""},
+		{"?offset=4", fmt.Sprintf("bytes=%d-", len(header)), http.StatusPartialContent, row(5), "5", ""},
+	}
+	for _, id := range []string{"memory", "stored"} {
+		for _, tt := range tests {
+			req := httptest.NewRequest(http.MethodGet, "/batch/"+id+"/result"+tt.query, nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			rec := httptest.NewRecorder()
+			s.routes().ServeHTTP(rec, req)
+			name := fmt.Sprintf("%s%s with Range %q", id, tt.query, tt.rangeHeader)
+			if rec.Code != tt.status {
+				t.Errorf("%s: status %d, want %d: %s", name, rec.Code, tt.status, rec.Body)
+				continue
+			}
+			if tt.status == http.StatusBadRequest {
+				continue
+			}
+			if rec.Body.String() != tt.body {
+				t.Errorf("%s: body\n%s\nwant\n%s", name, rec.Body, tt.body)
+			}
+			if got := rec.Header().Get("X-Total-Count"); got != tt.total {
+				t.Errorf("%s: X-Total-Count %q, want %q", name, got, tt.total)
+			}
+			link := tt.link
+			if link != "" {
+				link = fmt.Sprintf(link, id)
+			}
+			if got := rec.Header().Get("Link"); got != link {
+				t.Errorf("%s: Link %q, want %q", name, got, link)
+			}
+		}
+	}
+}
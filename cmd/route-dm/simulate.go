@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+// printSimulation prints the projection for a run against s. The synthetic
+// results are never written to the output itself; see simulateOutput.
+func printSimulation(s *matrix.SyntheticProvider, cfg matrixio.Config, results []matrix.Result) {
+	written, err := simulateOutput(cfg, results)
+	if err != nil {
+		fmt.Fprintf(messages, "Error writing results: %v\n", err)
+	}
+
+	unique := make(map[[2]string]bool)
+	for _, r := range results {
+		unique[[2]string{r.Origin, r.Destination}] = true
+	}
+
+	stats := s.Stats(cfg.Concurrency)
+	perMinute := float64(stats.Elements)
+	if stats.WallTime > time.Minute {
+		perMinute = float64(stats.Elements) / stats.WallTime.Minutes()
+	}
+
+	fmt.Fprintf(messages, "Simulation of %s\n", cfg.Input)
+	fmt.Fprintf(messages, "  rows:               %d\n", len(results))
+	fmt.Fprintf(messages, "  unique pairs:       %d\n", len(unique))
+	fmt.Fprintf(messages, "  API requests:       %d (%d retries)\n", stats.Requests, stats.Retries)
+	fmt.Fprintf(messages, "  billed elements:    %d\n", stats.Elements)
+	fmt.Fprintf(messages, "  estimated cost:     $%.2f\n", stats.Cost)
+	fmt.Fprintf(messages, "  failed rows:        %d\n", stats.Failures)
+	fmt.Fprintf(messages, "  projected wall time at concurrency %d: %s\n", max(cfg.Concurrency, 1), stats.WallTime.Round(time.Millisecond))
+	fmt.Fprintf(messages, "  quota usage:        %.0f elements/minute\n", perMinute)
+	if err == nil {
+		fmt.Fprintf(messages, "  output:             %s\n", written)
+	}
+}
+
+// simulateOutput runs the write stage of a simulated run without touching
+// the real output. A local file or SQLite output is written, in its format,
+// to a temporary copy that is then deleted. Other outputs, which live in a
+// database or a remote store, are rendered as CSV and discarded. It returns
+// what was written, for the report.
+func simulateOutput(cfg matrixio.Config, results []matrix.Result) (string, error) {
+	output, isSQLite := strings.CutPrefix(cfg.Output, "sqlite://")
+	if strings.Contains(output, "://") || strings.HasPrefix(output, "sheets:") {
+		units, err := matrixio.ParseDistanceUnits(cfg.DistanceUnits)
+		if err != nil {
+			return "", err
+		}
+		if err := matrixio.WriteResultsToCSV(io.Discard, cfg.CSV, units, results, cfg.OnFailure, cfg.OutputColumns); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("rendered and discarded; %s was not written", cfg.Output), nil
+	}
+
+	target := cfg.Output
+	dir, err := os.MkdirTemp("", "route-dm-simulate-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Base(output)
+	if output == "-" {
+		name, target = "stdout", "stdout"
+	}
+	cfg.Output = filepath.Join(dir, name)
+	if isSQLite {
+		cfg.Output = "sqlite://" + cfg.Output
+	}
+	start := time.Now()
+	if err := matrixio.WriteResults(cfg, results); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("written to a temporary copy in %s and deleted; %s was not touched", time.Since(start).Round(time.Millisecond), target), nil
+}
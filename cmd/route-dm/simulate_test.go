@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+func TestSimulateOutputLeavesOutputAlone(t *testing.T) {
+	dir := t.TempDir()
+	results := []matrix.Result{{Route: matrix.Route{SiteCode: "S1", TerminalCode: "T1"}, DistanceKm: 12.5, Duration: "15 mins", Status: "OK"}}
+	for _, output := range []string{filepath.Join(dir, "out.csv"), filepath.Join(dir, "out.json.gz"), "sqlite://" + filepath.Join(dir, "out.db")} {
+		cfg := matrixio.DefaultConfig()
+		cfg.Output = output
+		if _, err := simulateOutput(cfg, results); err != nil {
+			t.Errorf("%s: %v", output, err)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		t.Errorf("simulation wrote %s", e.Name())
+	}
+}
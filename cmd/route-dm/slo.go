@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"routes/pkg/matrix"
+)
+
+// sloWindows are the rolling windows burn rates are reported over: the
+// short and long windows of the usual fast (5m, 1h), medium (30m, 6h)
+// and slow (6h, 3d) burn alerts.
+var sloWindows = []time.Duration{5 * time.Minute, 30 * time.Minute, time.Hour, 6 * time.Hour, 72 * time.Hour}
+
+// sloTracker counts the matrix elements the provider answered (good) and
+// those whose request failed (bad) in one bucket per minute, over the SLO
+// period. Elements the provider answered without a route, such as
+// ZERO_RESULTS, are good: the service worked, there is just no road.
+type sloTracker struct {
+	objective float64 // the target ratio of good elements, such as 0.999
+	period    time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	since     time.Time
+	buckets   []sloBucket // a ring indexed by minute
+	good, bad int64       // since the server started
+}
+
+type sloBucket struct {
+	minute    int64 // Unix minute the counts are for
+	good, bad int64
+}
+
+func newSLOTracker(objective float64, period time.Duration) (*sloTracker, error) {
+	if objective <= 0 || objective >= 1 {
+		return nil, fmt.Errorf("SLO objective %v is not between 0 and 1", objective)
+	}
+	if period < sloWindows[len(sloWindows)-1] {
+		return nil, fmt.Errorf("SLO period %s is shorter than the longest burn rate window", period)
+	}
+	return &sloTracker{
+		objective: objective,
+		period:    period,
+		now:       time.Now,
+		since:     time.Now(),
+		buckets:   make([]sloBucket, int(period/time.Minute)),
+	}, nil
+}
+
+// record adds good and bad elements to the current minute.
+func (t *sloTracker) record(good, bad int64) {
+	minute := t.now().Unix() / 60
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := &t.buckets[minute%int64(len(t.buckets))]
+	if b.minute != minute {
+		*b = sloBucket{minute: minute}
+	}
+	b.good += good
+	b.bad += bad
+	t.good += good
+	t.bad += bad
+}
+
+// window returns the elements counted in the last d.
+func (t *sloTracker) window(d time.Duration) (good, bad int64) {
+	now := t.now().Unix() / 60
+	oldest := now - int64(d/time.Minute)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, b := range t.buckets {
+		if b.minute > oldest && b.minute <= now {
+			good += b.good
+			bad += b.bad
+		}
+	}
+	return good, bad
+}
+
+// sloWindow is the availability of one rolling window. Burn rate is how
+// many times faster than the objective allows the error budget is being
+// spent: 1 spends exactly the budget over the period.
+type sloWindow struct {
+	Window       string  `json:"window"`
+	Good         int64   `json:"good_elements"`
+	Bad          int64   `json:"bad_elements"`
+	Availability float64 `json:"availability"`
+	BurnRate     float64 `json:"burn_rate"`
+}
+
+// sloSummary is the body of GET /slo.
+type sloSummary struct {
+	Objective float64 `json:"objective"`
+	Period    string  `json:"period"`
+	Since     string  `json:"since"`
+	// ErrorBudgetRemaining is the share of the period's error budget left,
+	// 1 when nothing failed and negative once it is overspent.
+	ErrorBudgetRemaining float64     `json:"error_budget_remaining"`
+	Availability         float64     `json:"availability"`
+	Windows              []sloWindow `json:"windows"`
+}
+
+func (t *sloTracker) windowSummary(d time.Duration) sloWindow {
+	good, bad := t.window(d)
+	w := sloWindow{Window: formatWindow(d), Good: good, Bad: bad, Availability: 1}
+	if total := good + bad; total > 0 {
+		w.Availability = float64(good) / float64(total)
+		w.BurnRate = float64(bad) / float64(total) / (1 - t.objective)
+	}
+	return w
+}
+
+func (t *sloTracker) summary() sloSummary {
+	period := t.windowSummary(t.period)
+	s := sloSummary{
+		Objective:            t.objective,
+		Period:               period.Window,
+		Since:                t.since.UTC().Format(time.RFC3339),
+		ErrorBudgetRemaining: 1 - period.BurnRate,
+		Availability:         period.Availability,
+	}
+	for _, d := range sloWindows {
+		s.Windows = append(s.Windows, t.windowSummary(d))
+	}
+	return s
+}
+
+// formatWindow writes d in the largest whole unit, as 5m, 6h or 30d.
+func formatWindow(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	return fmt.Sprintf("%dm", d/time.Minute)
+}
+
+// sloProvider records the outcome of each of p's requests with t.
+type sloProvider struct {
+	p matrix.Provider
+	t *sloTracker
+}
+
+func (s sloProvider) GetDistanceMatrix(origins, destinations string, opts matrix.QueryOptions) (*matrix.DistanceMatrixResponse, error) {
+	n := int64((strings.Count(origins, "|") + 1) * (strings.Count(destinations, "|") + 1))
+	resp, err := s.p.GetDistanceMatrix(origins, destinations, opts)
+	if err != nil {
+		s.t.record(0, n)
+	} else {
+		s.t.record(n, 0)
+	}
+	return resp, err
+}
+
+func (s sloProvider) CallAPI(units int64, do func(auth matrix.APIAuth) error) error {
+	return matrix.CallAPI(s.p, units, do)
+}
+
+func (s *server) handleSLO(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.slo.summary())
+}
+
+// handleMetrics serves the SLO figures in the Prometheus text format.
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	t := s.slo
+	t.mu.Lock()
+	good, bad := t.good, t.bad
+	t.mu.Unlock()
+	summary := t.summary()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintf(w, "# HELP route_dm_elements_total Matrix elements requested from the provider, by whether it answered them.\n")
+	fmt.Fprintf(w, "# TYPE route_dm_elements_total counter\n")
+	fmt.Fprintf(w, "route_dm_elements_total{result=\"good\"} %d\n", good)
+	fmt.Fprintf(w, "route_dm_elements_total{result=\"bad\"} %d\n", bad)
+	fmt.Fprintf(w, "# HELP route_dm_slo_objective Target ratio of good elements.\n")
+	fmt.Fprintf(w, "# TYPE route_dm_slo_objective gauge\n")
+	fmt.Fprintf(w, "route_dm_slo_objective %g\n", summary.Objective)
+	fmt.Fprintf(w, "# HELP route_dm_slo_burn_rate Rate the error budget is spent at over a rolling window; 1 spends it exactly over the SLO period.\n")
+	fmt.Fprintf(w, "# TYPE route_dm_slo_burn_rate gauge\n")
+	for _, window := range summary.Windows {
+		fmt.Fprintf(w, "route_dm_slo_burn_rate{window=%q} %g\n", window.Window, window.BurnRate)
+	}
+	fmt.Fprintf(w, "# HELP route_dm_slo_error_budget_remaining Share of the SLO period's error budget left.\n")
+	fmt.Fprintf(w, "# TYPE route_dm_slo_error_budget_remaining gauge\n")
+	fmt.Fprintf(w, "route_dm_slo_error_budget_remaining{period=%q} %g\n", summary.Period, summary.ErrorBudgetRemaining)
+}
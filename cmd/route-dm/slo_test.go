@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"routes/pkg/matrix"
+)
+
+func TestSLOTracker(t *testing.T) {
+	tracker, err := newSLOTracker(0.99, 30*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	// Two hours ago everything failed; in the last five minutes 1 in 50.
+	now = now.Add(-2 * time.Hour)
+	tracker.record(0, 10)
+	now = now.Add(2*time.Hour - 3*time.Minute)
+	tracker.record(98, 2)
+	now = now.Add(3 * time.Minute)
+
+	s := tracker.summary()
+	want := map[string]sloWindow{
+		"5m": {Window: "5m", Good: 98, Bad: 2, Availability: 0.98, BurnRate: 2},
+		"1h": {Window: "1h", Good: 98, Bad: 2, Availability: 0.98, BurnRate: 2},
+		"6h": {Window: "6h", Good: 98, Bad: 12, Availability: 98.0 / 110, BurnRate: 12.0 / 110 / 0.01},
+	}
+	for _, w := range s.Windows {
+		if expected, ok := want[w.Window]; ok && !closeWindows(w, expected) {
+			t.Errorf("window %s = %+v, want %+v", w.Window, w, expected)
+		}
+	}
+	if s.Period != "30d" || !closeTo(s.ErrorBudgetRemaining, 1-12.0/110/0.01) {
+		t.Errorf("period %s has %v of its budget left", s.Period, s.ErrorBudgetRemaining)
+	}
+
+	// A minute's bucket is reused once the period has gone round.
+	now = now.Add(30 * 24 * time.Hour)
+	tracker.record(5, 0)
+	if good, bad := tracker.window(30 * 24 * time.Hour); good != 5 || bad != 0 {
+		t.Errorf("a period later the window holds %d good and %d bad elements, want 5 and 0", good, bad)
+	}
+
+	if _, err := newSLOTracker(1, 30*24*time.Hour); err == nil {
+		t.Error("an objective of 1 was accepted")
+	}
+	if _, err := newSLOTracker(0.999, time.Hour); err == nil {
+		t.Error("a period shorter than the burn rate windows was accepted")
+	}
+}
+
+func closeTo(a, b float64) bool {
+	return a-b < 1e-9 && b-a < 1e-9
+}
+
+func closeWindows(a, b sloWindow) bool {
+	return a.Window == b.Window && a.Good == b.Good && a.Bad == b.Bad && closeTo(a.Availability, b.Availability) && closeTo(a.BurnRate, b.BurnRate)
+}
+
+func TestSLOEndpoints(t *testing.T) {
+	s := newMockServer()
+	var err error
+	if s.slo, err = newSLOTracker(0.75, 30*24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	// Answered requests count their elements as good, failed ones as bad.
+	p := sloProvider{p: latitudeProvider{}, t: s.slo}
+	p.GetDistanceMatrix("1,1", "3,0|4,0", matrix.QueryOptions{})
+	p.GetDistanceMatrix("1,1", "north", matrix.QueryOptions{})
+
+	// So do matrices computed through the server's provider.
+	body := `{"origins": ["-6.2,106.8"], "destinations": ["-6.9,107.6", "-7.0,107.7"]}`
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/matrix", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /matrix: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slo", nil))
+	var summary sloSummary
+	if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if w := summary.Windows[0]; w.Window != "5m" || w.Good != 4 || w.Bad != 1 || !closeTo(w.BurnRate, 0.8) {
+		t.Errorf("5m window = %+v, want 4 good, 1 bad and a burn rate of 0.8", w)
+	}
+	if !closeTo(summary.ErrorBudgetRemaining, 0.2) {
+		t.Errorf("%v of the error budget left, want 0.2", summary.ErrorBudgetRemaining)
+	}
+
+	rec = httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range []string{
+		`route_dm_elements_total{result="good"} 4`,
+		`route_dm_elements_total{result="bad"} 1`,
+		`route_dm_slo_objective 0.75`,
+		`route_dm_slo_burn_rate{window="5m"} 0.8`,
+	} {
+		if !strings.Contains(rec.Body.String(), line+"\n") {
+			t.Errorf("/metrics has no line %q:\n%s", line, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+// streamWindow is how many rows per worker may be read ahead of the last
+// one written, which bounds the memory a stream holds.
+const streamWindow = 4
+
+// streamRoutes reads CSV rows from the input file, remote object or stdin and writes each
+// result as soon as it and every row before it are done, so output keeps the
+// input order while the input is still arriving. Failed results go to
+// report as they are written. It returns the number of rows written.
+func streamRoutes(p matrix.Provider, cfg matrixio.Config, opts matrix.QueryOptions, g *matrix.Geocoder, annotators []matrix.Annotator, report *matrixio.ErrorReport) (int, error) {
+	var in io.Reader = os.Stdin
+	if cfg.Input != "-" {
+		file, err := matrixio.OpenInput(cfg.Input)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		in = file
+	}
+	reader, err := cfg.CSV.NewReader(in)
+	if err != nil {
+		return 0, err
+	}
+	header, err := reader.Read()
+	if err != nil {
+		return 0, fmt.Errorf("reading header: %w", err)
+	}
+	parser, err := matrixio.NewRouteParser(header, cfg)
+	if err != nil {
+		return 0, err
+	}
+
+	var rows int
+	if cfg.Append {
+		err = matrixio.AppendOutput(cfg.Output, func(w io.Writer, header bool) error {
+			rows, err = streamResults(w, header, reader, parser, p, cfg, opts, g, annotators, report)
+			return err
+		})
+	} else {
+		err = matrixio.WriteOutput(cfg.Output, func(w io.Writer) error {
+			rows, err = streamResults(w, true, reader, parser, p, cfg, opts, g, annotators, report)
+			return err
+		})
+	}
+	return rows, err
+}
+
+// streamResults queries the rows reader has left and writes the results
+// to w in input order, after a CSV header if header is set, and the failed
+// ones to report. It returns the number of results written.
+func streamResults(w io.Writer, header bool, reader *csv.Reader, parser *matrixio.RouteParser, p matrix.Provider, cfg matrixio.Config, opts matrix.QueryOptions, g *matrix.Geocoder, annotators []matrix.Annotator, report *matrixio.ErrorReport) (int, error) {
+	out, err := matrixio.NewStreamWriter(w, cfg)
+	if err != nil {
+		return 0, err
+	}
+	if !header {
+		out.OmitHeader()
+	}
+
+	type job struct {
+		i     int
+		route matrix.Route
+	}
+	type done struct {
+		i      int
+		result matrix.Result
+	}
+	jobs := make(chan job)
+	finished := make(chan done)
+	// A slot is taken per row read and given back once it is written.
+	window := make(chan struct{}, streamWindow*max(cfg.Concurrency, 1))
+
+	var readErr error
+	go func() {
+		defer close(jobs)
+		for i, row := 0, 2; ; row++ {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				readErr = err
+				return
+			}
+			route, err := parser.Parse(record, row)
+			if err != nil {
+				if cfg.SkipInvalid {
+					slog.Warn("skipping invalid row", "err", err)
+					continue
+				}
+				readErr = err
+				return
+			}
+			window <- struct{}{}
+			jobs <- job{i, route}
+			i++
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < max(cfg.Concurrency, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if cfg.Columns.HasAddresses() {
+					g.ResolveRoute(&j.route)
+				}
+				result := matrix.QueryRoute(p, j.route, opts)
+				if cfg.Columns.HasAddresses() {
+					result.Extra = append(result.Extra, matrix.GeocodeFields(j.route)...)
+				}
+				matrix.AnnotateResult(p, opts, &result, annotators)
+				finished <- done{j.i, result}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	// Hold results that finish early until the rows before them are written.
+	pending := make(map[int]matrix.Result)
+	next := 0
+	var writeErr error
+	for d := range finished {
+		pending[d.i] = d.result
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			<-window
+			if writeErr == nil {
+				writeErr = out.WriteResult(r)
+			}
+			if writeErr == nil {
+				writeErr = report.Add(r)
+			}
+		}
+	}
+
+	if writeErr == nil {
+		writeErr = out.Finish()
+	}
+	if g != nil && writeErr == nil {
+		writeErr = g.Save()
+	}
+
+	// readErr is safe to read: jobs is closed before the workers finish.
+	return next, errors.Join(readErr, writeErr)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+// latitudeProvider answers with a distance of the destination latitude in
+// kilometres, taking longer the further north the destination is, so rows
+// finish out of input order. A destination at 0,0 has no route.
+type latitudeProvider struct{}
+
+func (latitudeProvider) GetDistanceMatrix(origins, destinations string, opts matrix.QueryOptions) (*matrix.DistanceMatrixResponse, error) {
+	lat, _, _ := strings.Cut(destinations, ",")
+	km, err := strconv.ParseFloat(lat, 64)
+	if err != nil {
+		return nil, err
+	}
+	time.Sleep(time.Duration(km) * time.Millisecond)
+	element := matrix.DistanceMatrixElement{Status: "OK", Distance: matrix.TextValue{Value: int(km * 1000)}, Duration: matrix.TextValue{Text: "1 min", Value: 60}}
+	if destinations == "0,0" {
+		element = matrix.DistanceMatrixElement{Status: "ZERO_RESULTS"}
+	}
+	return &matrix.DistanceMatrixResponse{Status: "OK", Rows: []matrix.DistanceMatrixRow{{Elements: []matrix.DistanceMatrixElement{element}}}}, nil
+}
+
+// writeRoutes writes a CSV input with one row per destination latitude,
+// all from the same terminal, and returns its name.
+func writeRoutes(t *testing.T, dir string, lats []string) string {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("SITE_CODE,SITE_NAME,LAT,LNG,TERMINAL_CODE,TLAT,TLNG\n")
+	for i, lat := range lats {
+		lng := "0"
+		if lat == "" {
+			lng = ""
+		}
+		fmt.Fprintf(&b, "S%d,,%s,%s,T1,1,1\n", i+1, lat, lng)
+	}
+	name := filepath.Join(dir, "routes.csv")
+	if err := os.WriteFile(name, []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestStreamRoutesKeepsInputOrder(t *testing.T) {
+	dir := t.TempDir()
+	var lats []string
+	for i := 40; i > 0; i-- {
+		lats = append(lats, strconv.Itoa(i))
+	}
+	lats[5] = "0"
+	cfg := matrixio.DefaultConfig()
+	cfg.Input = writeRoutes(t, dir, lats)
+	cfg.Output = filepath.Join(dir, "out.csv")
+	cfg.Concurrency = 8
+	report := matrixio.NewErrorReport(cfg.CSV)
+
+	rows, err := streamRoutes(latitudeProvider{}, cfg, matrix.QueryOptions{}, nil, nil, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != len(lats) {
+		t.Errorf("wrote %d rows, want %d", rows, len(lats))
+	}
+	data, err := os.ReadFile(cfg.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != len(lats)+1 || lines[0] != "SITE_CODE,SITE_NAME,TERMINAL_CODE,DISTANCE_KM,DURATION" {
+		t.Fatalf("output has %d lines under %q", len(lines), lines[0])
+	}
+	for i, line := range lines[1:] {
+		want := fmt.Sprintf("S%d,,T1,%s.00,1 min", i+1, lats[i])
+		if lats[i] == "0" {
+			want = fmt.Sprintf("S%d,,T1,0.00,N/A", i+1)
+		}
+		if line != want {
+			t.Errorf("line %d = %q, want %q", i+1, line, want)
+		}
+	}
+	if report.Rows() != 1 {
+		t.Errorf("reported %d failures, want 1", report.Rows())
+	}
+}
+
+func TestStreamRoutesInvalidRows(t *testing.T) {
+	dir := t.TempDir()
+	cfg := matrixio.DefaultConfig()
+	cfg.Input = writeRoutes(t, dir, []string{"3", "", "2"})
+	cfg.Output = filepath.Join(dir, "out.csv")
+
+	// The rows before the invalid one are still written.
+	rows, err := streamRoutes(latitudeProvider{}, cfg, matrix.QueryOptions{}, nil, nil, matrixio.NewErrorReport(cfg.CSV))
+	if err == nil || !strings.Contains(err.Error(), "row 3") {
+		t.Errorf("error = %v, want one naming row 3", err)
+	}
+	if rows != 1 {
+		t.Errorf("wrote %d rows before the invalid one, want 1", rows)
+	}
+
+	cfg.SkipInvalid = true
+	rows, err = streamRoutes(latitudeProvider{}, cfg, matrix.QueryOptions{}, nil, nil, matrixio.NewErrorReport(cfg.CSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 2 {
+		t.Errorf("wrote %d rows skipping the invalid one, want 2", rows)
+	}
+}
+
+func TestStreamRoutesAppends(t *testing.T) {
+	dir := t.TempDir()
+	cfg := matrixio.DefaultConfig()
+	cfg.Input = writeRoutes(t, dir, []string{"3"})
+	cfg.Output = filepath.Join(dir, "out.csv")
+	cfg.Append = true
+	for range 2 {
+		if _, err := streamRoutes(latitudeProvider{}, cfg, matrix.QueryOptions{}, nil, nil, matrixio.NewErrorReport(cfg.CSV)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(cfg.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "SITE_CODE,SITE_NAME,TERMINAL_CODE,DISTANCE_KM,DURATION\nS1,,T1,3.00,1 min\nS1,,T1,3.00,1 min\n"
+	if string(data) != want {
+		t.Errorf("appended output =\n%s\nwant\n%s", data, want)
+	}
+}
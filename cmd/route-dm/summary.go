@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+// runSummary describes a finished batch run.
+type runSummary struct {
+	Rows int `json:"rows"`
+	// Reused counts rows copied from the previous output, which the other
+	// statistics leave out.
+	Reused    int            `json:"reused_rows,omitempty"`
+	Succeeded int            `json:"succeeded"`
+	Failed    int            `json:"failed"`
+	Failures  map[string]int `json:"failures_by_type"`
+
+	// The distance statistics are in Unit, the first of the output's
+	// distance units, and named after it in JSON, as in total_distance_mi.
+	Unit           matrixio.DistanceUnit `json:"-"`
+	TotalDistance  float64               `json:"-"`
+	MinDistance    float64               `json:"-"`
+	MaxDistance    float64               `json:"-"`
+	AvgDistance    float64               `json:"-"`
+	MinDurationSec int                   `json:"min_duration_seconds"`
+	MaxDurationSec int                   `json:"max_duration_seconds"`
+	AvgDurationSec float64               `json:"avg_duration_seconds"`
+
+	ElapsedSec float64 `json:"elapsed_seconds"`
+	// Elements counts the matrix elements the API answered, including those
+	// of annotators that query the distance matrix again.
+	Elements int64 `json:"api_elements"`
+}
+
+// summarize tallies results. Distance and duration statistics cover the
+// successful rows only.
+func summarize(results []matrix.Result, unit matrixio.DistanceUnit, elapsed time.Duration, elements int64) runSummary {
+	s := runSummary{
+		Rows:       len(results),
+		Unit:       unit,
+		Failures:   make(map[string]int),
+		ElapsedSec: elapsed.Seconds(),
+		Elements:   elements,
+	}
+
+	var totalSeconds int
+	for _, r := range results {
+		if r.Status != "OK" {
+			s.Failed++
+			s.Failures[r.Status]++
+			continue
+		}
+		distance := unit.FromKm(r.DistanceKm)
+		if s.Succeeded == 0 || distance < s.MinDistance {
+			s.MinDistance = distance
+		}
+		if s.Succeeded == 0 || r.Seconds < s.MinDurationSec {
+			s.MinDurationSec = r.Seconds
+		}
+		s.MaxDistance = max(s.MaxDistance, distance)
+		s.MaxDurationSec = max(s.MaxDurationSec, r.Seconds)
+		s.TotalDistance += distance
+		totalSeconds += r.Seconds
+		s.Succeeded++
+	}
+	if s.Succeeded > 0 {
+		s.AvgDistance = s.TotalDistance / float64(s.Succeeded)
+		s.AvgDurationSec = float64(totalSeconds) / float64(s.Succeeded)
+	}
+	return s
+}
+
+// print writes the summary for people to read.
+func (s runSummary) print() {
+	fmt.Fprintf(messages, "Run summary\n")
+	fmt.Fprintf(messages, "  rows:           %d (%d succeeded, %d failed)\n", s.Rows, s.Succeeded, s.Failed)
+	if s.Reused > 0 {
+		fmt.Fprintf(messages, "  reused:         %d unchanged rows copied from the previous output\n", s.Reused)
+	}
+
+	types := make([]string, 0, len(s.Failures))
+	for t := range s.Failures {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		fmt.Fprintf(messages, "    %-20s %d\n", t+":", s.Failures[t])
+	}
+
+	if s.Succeeded > 0 {
+		unit := s.Unit.Name()
+		fmt.Fprintf(messages, "  total distance: %.2f %s\n", s.TotalDistance, unit)
+		fmt.Fprintf(messages, "  distance:       min %.2f %s, max %.2f %s, avg %.2f %s\n", s.MinDistance, unit, s.MaxDistance, unit, s.AvgDistance, unit)
+		fmt.Fprintf(messages, "  duration:       min %s, max %s, avg %s\n",
+			matrix.DurationText(s.MinDurationSec), matrix.DurationText(s.MaxDurationSec), matrix.DurationText(int(s.AvgDurationSec+0.5)))
+	}
+	fmt.Fprintf(messages, "  elapsed:        %s\n", time.Duration(s.ElapsedSec*float64(time.Second)).Round(time.Millisecond))
+	fmt.Fprintf(messages, "  API elements:   %d\n", s.Elements)
+}
+
+// writeJSON writes the summary to path as a JSON object.
+func (s runSummary) writeJSON(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for name, value := range map[string]float64{"total": s.TotalDistance, "min": s.MinDistance, "max": s.MaxDistance, "avg": s.AvgDistance} {
+		fields[name+"_distance_"+s.Unit.Name()] = value
+	}
+	data, err = json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
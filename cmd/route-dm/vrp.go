@@ -0,0 +1,257 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+)
+
+// runVRP implements `route-dm vrp`: given a depot, sites with demands and
+// vehicles of one capacity, it computes the matrix and splits the sites into
+// one round trip per vehicle with the Clarke-Wright savings heuristic, then
+// orders each trip as `optimize` does.
+func runVRP(args []string) error {
+	fs := flag.NewFlagSet("vrp", flag.ExitOnError)
+	sitesPath := fs.String("sites", "sites.csv", "CSV file listing the depot in the first row, then the sites")
+	capacity := fs.Float64("capacity", 0, "capacity of each vehicle, in the unit of the demand column")
+	vehicles := fs.Int("vehicles", 0, "number of vehicles available; 0 means as many as needed")
+	output := fs.String("output", "routes-by-vehicle.csv", "output CSV file, or - for stdout")
+	api := addMatrixFlags(fs)
+	avoid := fs.String("avoid", "", "comma-separated route features to avoid: tolls, highways, ferries, indoor")
+	unitName := fs.String("distance-unit", "km", "distance unit: km, mi, m or nmi")
+	idColumn := fs.String("id-column", "1", "column holding the site ID (header name or 1-based position)")
+	latColumn := fs.String("lat-column", "2", "column holding the latitude")
+	lngColumn := fs.String("lng-column", "3", "column holding the longitude")
+	demandColumn := fs.String("demand-column", "4", "column holding each site's demand; ignored for the depot")
+	crs := fs.String("crs", "", "EPSG code of the input coordinates (default WGS84)")
+	applyCSVFlags := matrixio.CSVFlags(fs)
+	fs.Parse(args)
+
+	var dialect matrixio.CSVConfig
+	applyCSVFlags(&dialect)
+
+	if *capacity <= 0 {
+		return errors.New("-capacity must be positive")
+	}
+	units, err := matrixio.ParseDistanceUnits([]string{*unitName})
+	if err != nil {
+		return err
+	}
+	var opts matrix.QueryOptions
+	if err := matrix.ParseAvoid(*avoid, &opts); err != nil {
+		return err
+	}
+	if *output == "-" {
+		messages = os.Stderr
+	}
+	epsg, err := matrixio.ParseEPSG(*crs)
+	if err != nil {
+		return err
+	}
+
+	sites, err := readMatrixPoints(*sitesPath, dialect, *idColumn, *latColumn, *lngColumn, epsg)
+	if err != nil {
+		return fmt.Errorf("reading sites: %w", err)
+	}
+	if len(sites) < 2 {
+		return errors.New("need a depot and at least one site")
+	}
+	demands, err := readDemands(*sitesPath, dialect, *demandColumn)
+	if err != nil {
+		return fmt.Errorf("reading sites: %w", err)
+	}
+	for i, demand := range demands[1:] {
+		if demand > *capacity {
+			return fmt.Errorf("site %s: demand %g exceeds the vehicle capacity %g", sites[i+1].id, demand, *capacity)
+		}
+	}
+
+	cells, err := api.query(opts, sites, sites)
+	if err != nil {
+		return err
+	}
+	d := stopDistances(cells)
+
+	trips := savingsRoutes(d, demands, *capacity)
+	if *vehicles > 0 && len(trips) > *vehicles {
+		return fmt.Errorf("the sites need %d vehicles of capacity %g, only %d available", len(trips), *capacity, *vehicles)
+	}
+	for v, trip := range trips {
+		if trips[v] = orderTrip(d, trip); trips[v] == nil {
+			return errors.New("some site cannot be served using only the pairs the API answered; see the errors above")
+		}
+	}
+
+	records := vrpRecords(sites, cells, demands, trips, units[0])
+
+	err = matrixio.WriteOutput(*output, func(w io.Writer) error {
+		return dialect.WriteAll(w, records)
+	})
+	if err != nil {
+		return err
+	}
+
+	for v, trip := range trips {
+		var load float64
+		for _, s := range trip[1 : len(trip)-1] {
+			load += demands[s]
+		}
+		fmt.Fprintf(messages, "vehicle %d: %d sites, load %g of %g, %s %s\n",
+			v+1, len(trip)-2, load, *capacity, units[0].Column(), units[0].Format(tourLength(d, trip, false)))
+	}
+	if *output != "-" {
+		slog.Info("vehicle routes written", "sites", len(sites)-1, "vehicles", len(trips), "output", *output)
+	}
+	return nil
+}
+
+// readDemands returns the demand column of the sites file, one per row
+// after the header; the depot's is zero.
+func readDemands(filename string, dialect matrixio.CSVConfig, column string) ([]float64, error) {
+	return readNumbers(filename, dialect, column, "demand", 1)
+}
+
+// readNumbers returns column of a points file as non-negative numbers, one
+// per row after the header, of which the first skip are left zero; name is
+// what the column holds, for errors.
+func readNumbers(filename string, dialect matrixio.CSVConfig, column, name string, skip int) ([]float64, error) {
+	file, err := matrixio.OpenInput(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader, err := dialect.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	idx, err := matrixio.ColumnIndex(records[0], column)
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make([]float64, len(records)-1)
+	for i, record := range records[1:] {
+		if i < skip {
+			continue
+		}
+		if numbers[i], err = strconv.ParseFloat(record[idx], 64); err != nil || numbers[i] < 0 {
+			return nil, fmt.Errorf("row %d: %s %q is not a non-negative number", i+2, name, record[idx])
+		}
+	}
+	return numbers, nil
+}
+
+// savingsRoutes splits the sites 1..n-1 into trips from and back to the
+// depot 0 whose demands fit capacity, using the Clarke-Wright savings
+// heuristic: starting from one trip per site, it joins the end of one trip
+// to the start of another in order of the distance that saves. Trips are
+// returned as site lists without the depot.
+func savingsRoutes(d [][]float64, demands []float64, capacity float64) [][]int {
+	n := len(d)
+	type saving struct {
+		i, j  int
+		value float64
+	}
+	var savings []saving
+	for i := 1; i < n; i++ {
+		for j := 1; j < n; j++ {
+			if i != j && !math.IsInf(d[i][j], 1) {
+				savings = append(savings, saving{i, j, d[i][0] + d[0][j] - d[i][j]})
+			}
+		}
+	}
+	slices.SortStableFunc(savings, func(a, b saving) int { return cmp.Compare(b.value, a.value) })
+
+	trips := make(map[int][]int) // keyed by the trip's first site
+	tripOf := make([]int, n)     // first site of each site's trip
+	load := make(map[int]float64)
+	for s := 1; s < n; s++ {
+		trips[s] = []int{s}
+		tripOf[s] = s
+		load[s] = demands[s]
+	}
+	for _, sv := range savings {
+		if sv.value <= 0 {
+			break
+		}
+		a, b := tripOf[sv.i], tripOf[sv.j]
+		if a == b || !(trips[a][len(trips[a])-1] == sv.i && b == sv.j) || load[a]+load[b] > capacity {
+			continue
+		}
+		trips[a] = append(trips[a], trips[b]...)
+		load[a] += load[b]
+		for _, s := range trips[b] {
+			tripOf[s] = a
+		}
+		delete(trips, b)
+		delete(load, b)
+	}
+
+	var result [][]int
+	for s := 1; s < n; s++ {
+		if trip, ok := trips[s]; ok {
+			result = append(result, trip)
+		}
+	}
+	return result
+}
+
+// orderTrip reorders the sites of one trip to shorten it and returns it as
+// stop indexes starting and ending at the depot, or nil if no order avoids
+// the missing pairs.
+func orderTrip(d [][]float64, trip []int) []int {
+	stops := append([]int{0}, trip...)
+	sub := make([][]float64, len(stops))
+	for i, from := range stops {
+		sub[i] = make([]float64, len(stops))
+		for j, to := range stops {
+			sub[i][j] = d[from][to]
+		}
+	}
+	order := orderStops(sub, true)
+	if order == nil {
+		return nil
+	}
+	ordered := make([]int, 0, len(order)+1)
+	for _, i := range order {
+		ordered = append(ordered, stops[i])
+	}
+	return append(ordered, 0)
+}
+
+// vrpRecords lists each vehicle's stops in order, from the depot and back,
+// with the leg driven to reach each stop and the running distance and load.
+func vrpRecords(sites []matrixPoint, cells [][]matrixCell, demands []float64, trips [][]int, unit matrixio.DistanceUnit) [][]string {
+	records := [][]string{{"VEHICLE", "SEQUENCE", "STOP_ID", "DEMAND", "LOAD", "LEG_" + unit.Column(), "LEG_DURATION", "CUMULATIVE_" + unit.Column()}}
+	for v, trip := range trips {
+		vehicle := strconv.Itoa(v + 1)
+		records = append(records, []string{vehicle, "1", sites[0].id, "", "0", "", "", unit.Format(0)})
+		var total, load float64
+		for i := 1; i < len(trip); i++ {
+			leg := cells[trip[i-1]][trip[i]]
+			total += leg.distanceKm
+			demand := ""
+			if trip[i] != 0 {
+				load += demands[trip[i]]
+				demand = strconv.FormatFloat(demands[trip[i]], 'g', -1, 64)
+			}
+			records = append(records, []string{vehicle, strconv.Itoa(i + 1), sites[trip[i]].id, demand,
+				strconv.FormatFloat(load, 'g', -1, 64), unit.Format(leg.distanceKm), leg.duration, unit.Format(total)})
+		}
+	}
+	return records
+}
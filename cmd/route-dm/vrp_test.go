@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestSavingsRoutes(t *testing.T) {
+	// The depot is stop 0 at the origin.
+	eastWest := planeDistances([2]float64{0, 0}, [2]float64{10, 0}, [2]float64{11, 0}, [2]float64{-10, 0})
+	noLink := planeDistances([2]float64{0, 0}, [2]float64{10, 0}, [2]float64{11, 0})
+	noLink[1][2], noLink[2][1] = math.Inf(1), math.Inf(1)
+
+	tests := []struct {
+		name     string
+		d        [][]float64
+		demands  []float64
+		capacity float64
+		want     [][]int
+	}{
+		{
+			name:     "joins neighbours, not opposite sides",
+			d:        eastWest,
+			demands:  []float64{0, 1, 1, 1},
+			capacity: 10,
+			want:     [][]int{{1, 2}, {3}},
+		},
+		{
+			name:     "capacity keeps trips apart",
+			d:        eastWest,
+			demands:  []float64{0, 5, 5, 1},
+			capacity: 8,
+			want:     [][]int{{1}, {2}, {3}},
+		},
+		{
+			name:     "chains a road in order",
+			d:        planeDistances([2]float64{0, 0}, [2]float64{10, 0}, [2]float64{20, 0}, [2]float64{30, 0}),
+			demands:  []float64{0, 1, 1, 1},
+			capacity: 3,
+			want:     [][]int{{1, 2, 3}},
+		},
+		{
+			name:     "a full load exactly fits",
+			d:        planeDistances([2]float64{0, 0}, [2]float64{10, 0}, [2]float64{20, 0}, [2]float64{30, 0}),
+			demands:  []float64{0, 1, 1, 1},
+			capacity: 2,
+			want:     [][]int{{1}, {2, 3}},
+		},
+		{
+			name:     "missing pairs are never joined",
+			d:        noLink,
+			demands:  []float64{0, 1, 1},
+			capacity: 10,
+			want:     [][]int{{1}, {2}},
+		},
+		{
+			name:     "a lone site",
+			d:        planeDistances([2]float64{0, 0}, [2]float64{3, 4}),
+			demands:  []float64{0, 1},
+			capacity: 1,
+			want:     [][]int{{1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := savingsRoutes(tt.d, tt.demands, tt.capacity); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("savingsRoutes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSavingsRoutesVisitsEverySiteWithinCapacity(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	points := make([][2]float64, 40)
+	demands := make([]float64, len(points))
+	for i := range points {
+		points[i] = [2]float64{rng.Float64()*100 - 50, rng.Float64()*100 - 50}
+		if i > 0 {
+			demands[i] = float64(1 + rng.Intn(5))
+		}
+	}
+	const capacity = 15
+	trips := savingsRoutes(planeDistances(points...), demands, capacity)
+
+	seen := make([]bool, len(points))
+	for _, trip := range trips {
+		var load float64
+		for _, s := range trip {
+			if s == 0 || seen[s] {
+				t.Fatalf("site %d is the depot or visited twice: %v", s, trips)
+			}
+			seen[s] = true
+			load += demands[s]
+		}
+		if load > capacity {
+			t.Errorf("trip %v carries %v, over the capacity of %v", trip, load, capacity)
+		}
+	}
+	for s := 1; s < len(points); s++ {
+		if !seen[s] {
+			t.Errorf("site %d is not visited", s)
+		}
+	}
+	if len(trips) >= len(points)-1 {
+		t.Errorf("no trips were joined: %d trips for %d sites", len(trips), len(points)-1)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	matrixio "routes/pkg/io"
+)
+
+// watchExtensions are the input files a watched folder picks up.
+var watchExtensions = []string{".csv", ".json", ".jsonl", ".xlsx", ".csv.gz", ".json.gz", ".jsonl.gz"}
+
+// watchedFile is an input seen in the watched folder, processed once its
+// size and modification time stop changing between polls so half-uploaded
+// files are left alone.
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// runWatch polls dir for new input files and runs the batch given by the
+// command line on each as a child process, until the process is stopped.
+// A file that succeeds is moved to dir/processed with its results written
+// next to it as NAME-result and NAME-errors; one that fails is moved to
+// dir/failed. A remote object output gets NAME in its name instead, and
+// database outputs are left as configured.
+func runWatch(dir string, interval time.Duration, output string) error {
+	processed := filepath.Join(dir, "processed")
+	failed := filepath.Join(dir, "failed")
+	for _, d := range []string{processed, failed} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return err
+		}
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := withoutFlag(withoutFlag(os.Args[1:], "watch"), "watch-interval")
+
+	slog.Info("watching for input files", "dir", dir, "interval", interval)
+	seen := make(map[string]watchedFile)
+	for {
+		ready, err := pollWatchDir(dir, seen)
+		if err != nil {
+			return err
+		}
+		for _, path := range ready {
+			delete(seen, path)
+			if err := processWatched(self, args, path, output, processed, failed); err != nil {
+				return err
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// pollWatchDir updates seen with the input files in dir and returns those
+// unchanged since the previous poll, oldest first.
+func pollWatchDir(dir string, seen map[string]watchedFile) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ready []string
+	present := make(map[string]bool)
+	for _, e := range entries {
+		name := e.Name()
+		_, ext := matrixio.SplitExt(name)
+		if !e.Type().IsRegular() || strings.HasPrefix(name, ".") ||
+			!slices.Contains(watchExtensions, strings.ToLower(ext)) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue // removed since ReadDir
+		}
+		path := filepath.Join(dir, name)
+		present[path] = true
+		now := watchedFile{size: info.Size(), modTime: info.ModTime()}
+		if prev, ok := seen[path]; ok && prev == now {
+			ready = append(ready, path)
+		}
+		seen[path] = now
+	}
+	for path := range seen {
+		if !present[path] {
+			delete(seen, path)
+		}
+	}
+	slices.SortFunc(ready, func(a, b string) int {
+		return seen[a].modTime.Compare(seen[b].modTime)
+	})
+	return ready, nil
+}
+
+// processWatched runs one batch on path and files it under processed or
+// failed. Only a child that cannot be started, or a file that cannot be
+// moved, stops the watch.
+func processWatched(self string, args []string, path, output, processed, failed string) error {
+	name := filepath.Base(path)
+	stem, _ := matrixio.SplitExt(name)
+	runArgs := append(args[:len(args):len(args)], "-input", path,
+		"-errors-output", filepath.Join(processed, stem+"-errors.csv"))
+	switch {
+	case matrixio.IsRemote(output):
+		remoteOutput, err := stampedPath(output, stem)
+		if err != nil {
+			return err
+		}
+		runArgs = append(runArgs, "-output", remoteOutput)
+	case output != "-" && !strings.Contains(output, "://"):
+		_, ext := matrixio.SplitExt(output)
+		if ext == "" {
+			ext = ".csv"
+		}
+		runArgs = append(runArgs, "-output", filepath.Join(processed, stem+"-result"+ext))
+	}
+
+	slog.Info("processing input file", "file", path)
+	start := time.Now()
+	runFailed, err := runChild(self, runArgs)
+	if err != nil {
+		return fmt.Errorf("starting batch for %s: %w", path, err)
+	}
+	dest := processed
+	if runFailed {
+		dest = failed
+	}
+	moved, err := moveInto(path, dest)
+	if err != nil {
+		return fmt.Errorf("moving %s: %w", path, err)
+	}
+	if runFailed {
+		slog.Error("input file failed", "file", moved)
+	} else {
+		slog.Info("input file processed", "file", moved, "elapsed", time.Since(start).Round(time.Second))
+	}
+	return nil
+}
+
+// moveInto moves path into dir, adding a timestamp to the name if a file of
+// that name is already there, and returns the new path.
+func moveInto(path, dir string) (string, error) {
+	dest := filepath.Join(dir, filepath.Base(path))
+	if _, err := os.Stat(dest); err == nil {
+		dest = stampFile(dest, time.Now().Format("20060102T150405"))
+	}
+	return dest, os.Rename(path, dest)
+}
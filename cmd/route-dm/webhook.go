@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"routes/pkg/matrix"
+)
+
+// webhookAttempts is how many times a webhook POST is tried before giving
+// up; the run's outcome does not depend on it.
+const webhookAttempts = 3
+
+// webhookEvent is the JSON body POSTed when a batch finishes or fails.
+type webhookEvent struct {
+	JobID        string    `json:"job_id"`
+	Status       string    `json:"status"` // succeeded or failed
+	Rows         int       `json:"rows"`
+	FailedRows   int       `json:"failed_rows"`
+	Output       string    `json:"output,omitempty"`
+	ErrorsOutput string    `json:"errors_output,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	Finished     time.Time `json:"finished"`
+	// ElapsedSec, Elements and CostUSD describe runs that got as far as
+	// querying: the wall time, the matrix elements billed and their list
+	// price.
+	ElapsedSec float64 `json:"elapsed_seconds,omitempty"`
+	Elements   int64   `json:"api_elements,omitempty"`
+	CostUSD    float64 `json:"cost_usd,omitempty"`
+}
+
+// notifier is told how a batch run ended.
+type notifier interface {
+	notify(e webhookEvent)
+}
+
+// addUsage records the run's wall time and, for billed runs, the matrix
+// elements counted and their list price.
+func (e *webhookEvent) addUsage(elapsed time.Duration, counter *matrix.CountingProvider, billed bool, provider string, opts matrix.QueryOptions) {
+	e.ElapsedSec = elapsed.Seconds()
+	if billed {
+		e.Elements = counter.Elements()
+		e.CostUSD = matrixCost(provider, opts, e.Elements)
+	}
+}
+
+// runNotifiers are told when the batch run ends, including through fatal:
+// the webhook, the email and the chat message, where configured.
+var runNotifiers []notifier
+
+// notifyRun tells every run notifier about e.
+func notifyRun(e webhookEvent) {
+	for _, n := range runNotifiers {
+		n.notify(e)
+	}
+}
+
+// webhook POSTs the outcome of one batch to a URL. A nil *webhook sends
+// nothing.
+type webhook struct {
+	url   string
+	jobID string
+}
+
+// newWebhook returns a webhook for the batch jobID, or nil if url is empty.
+func newWebhook(url, jobID string) *webhook {
+	if url == "" {
+		return nil
+	}
+	return &webhook{url: url, jobID: jobID}
+}
+
+// notify sends e, retrying failed deliveries. Errors are logged rather than
+// returned so a broken webhook never fails the run it reports on.
+func (h *webhook) notify(e webhookEvent) {
+	if h == nil {
+		return
+	}
+	e.JobID = h.jobID
+	e.Finished = time.Now()
+	body, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("encoding webhook event", "err", err)
+		return
+	}
+
+	if err := postJSON(h.url, body); err != nil {
+		slog.Warn("delivering webhook", "job", h.jobID, "attempts", webhookAttempts, "err", err)
+		return
+	}
+	slog.Debug("webhook delivered", "job", h.jobID, "status", e.Status)
+}
+
+// postJSON POSTs body to url, retrying failed deliveries up to
+// webhookAttempts times.
+func postJSON(url string, body []byte) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	for attempt := 1; ; attempt++ {
+		err := postOnce(client, url, body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+func postOnce(client *http.Client, url string, body []byte) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
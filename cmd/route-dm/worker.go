@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	matrixio "routes/pkg/io"
+	"routes/pkg/matrix"
+	"routes/pkg/routedmpb"
+)
+
+// runWorker implements `route-dm worker`: it takes route-pair jobs from a
+// queue, computes them with the config's provider and publishes each job's
+// results to another queue, until it is stopped. Workers on the same queue
+// share its jobs, so a large backfill scales by starting more of them.
+//
+// A job is a JSON message with locations as "lat,lng" in the config's CRS:
+//
+//	{"id": "2024-06-01/17", "routes": [{"site_code": "S1", "terminal_code": "T1",
+//	  "origin": "-6.2,106.8", "destination": "-6.9,107.6", "waypoints": []}],
+//	  "avoid": "tolls", "mode": "driving"}
+//
+// and its results one with the job's id and a result per route, in order,
+// or an error for a job that could not be read:
+//
+//	{"id": "2024-06-01/17", "results": [{"site_code": "S1", "site_name": "",
+//	  "terminal_code": "T1", "status": "OK", "distance_km": 20.38,
+//	  "duration": "24 mins", "duration_seconds": 1440}]}
+//
+// A job is acknowledged only once its results are published, so a worker
+// that dies leaves it to another.
+func runWorker(args []string) error {
+	fs := flag.NewFlagSet("worker", flag.ExitOnError)
+	jobsURL := fs.String("jobs", "", "queue to take jobs from: sqs://HOST/ACCOUNT/QUEUE, amqp://HOST/VHOST?queue=QUEUE or kafka://BROKER,BROKER/TOPIC?group=GROUP")
+	resultsURL := fs.String("results", "", "queue to publish results to, in the same forms as -jobs")
+	configPath := fs.String("config", matrixio.DefaultConfigFile, "config file giving the provider, CRS and concurrency")
+	concurrency := fs.Int("concurrency", 0, "API requests in flight per job (default from the config, else 1)")
+	maxRoutes := fs.Int("max-routes", 10000, "largest job in routes; larger ones get an error result")
+	fs.Parse(args)
+
+	if *jobsURL == "" || *resultsURL == "" {
+		return errors.New("worker needs -jobs and -results")
+	}
+	explicit := false
+	fs.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "config" })
+	cfg, err := matrixio.LoadConfig(*configPath, explicit)
+	if err != nil {
+		return err
+	}
+	if *concurrency > 0 {
+		cfg.Concurrency = *concurrency
+	}
+	epsg, err := matrixio.ParseEPSG(cfg.CRS)
+	if err != nil {
+		return err
+	}
+	var apiKey string
+	if matrix.NeedsAPIKey(cfg.Provider) {
+		if apiKey, err = loadAPIKey(); err != nil {
+			return err
+		}
+	}
+	if _, err := matrix.NewProvider(cfg.Provider, apiKey, matrix.QueryOptions{}); err != nil {
+		return err
+	}
+
+	jobs, err := matrixio.OpenQueueConsumer(*jobsURL)
+	if err != nil {
+		return err
+	}
+	defer jobs.Close()
+	results, err := matrixio.OpenQueuePublisher(*resultsURL)
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	// The job in hand is finished and published before a signal stops the
+	// worker.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	w := &worker{s: &server{cfg: cfg, apiKey: apiKey, epsg: epsg}, maxRoutes: *maxRoutes}
+	slog.Info("waiting for jobs", "queue", *jobsURL)
+	return w.run(ctx, jobs, results)
+}
+
+// worker computes queued jobs as the server computes gRPC batches.
+type worker struct {
+	s         *server
+	maxRoutes int
+}
+
+// queueJob is the message of one job.
+type queueJob struct {
+	ID     string       `json:"id"`
+	Routes []queueRoute `json:"routes"`
+	Avoid  string       `json:"avoid"`
+	Mode   string       `json:"mode"`
+}
+
+type queueRoute struct {
+	SiteCode     string   `json:"site_code"`
+	SiteName     string   `json:"site_name"`
+	TerminalCode string   `json:"terminal_code"`
+	Origin       string   `json:"origin"`
+	Destination  string   `json:"destination"`
+	Waypoints    []string `json:"waypoints"`
+}
+
+// queueResults is the message published for one job.
+type queueResults struct {
+	ID      string        `json:"id"`
+	Error   string        `json:"error,omitempty"`
+	Results []queueResult `json:"results,omitempty"`
+}
+
+// queueResult is the outcome of one route of a job. Failed routes have no
+// distance or duration.
+type queueResult struct {
+	SiteCode        string  `json:"site_code"`
+	SiteName        string  `json:"site_name"`
+	TerminalCode    string  `json:"terminal_code"`
+	Status          string  `json:"status"`
+	Error           string  `json:"error,omitempty"`
+	DistanceKm      float64 `json:"distance_km"`
+	Duration        string  `json:"duration,omitempty"`
+	DurationSeconds int     `json:"duration_seconds"`
+}
+
+// run handles jobs until ctx is done or a queue fails.
+func (w *worker) run(ctx context.Context, jobs matrixio.QueueConsumer, results matrixio.QueuePublisher) error {
+	for {
+		msg, err := jobs.Receive(ctx)
+		if ctx.Err() != nil {
+			slog.Info("worker stopped")
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("receiving a job: %w", err)
+		}
+		out := w.compute(msg.Body)
+		body, err := json.Marshal(out)
+		if err != nil {
+			return err
+		}
+		finish := context.WithoutCancel(ctx)
+		if err := results.Publish(finish, body); err != nil {
+			msg.Nack(finish)
+			return fmt.Errorf("publishing the results of job %s: %w", out.ID, err)
+		}
+		if err := msg.Ack(finish); err != nil {
+			return fmt.Errorf("acknowledging job %s: %w", out.ID, err)
+		}
+	}
+}
+
+// compute returns the results of the job in body, or the reason it has
+// none.
+func (w *worker) compute(body []byte) queueResults {
+	var job queueJob
+	if err := json.Unmarshal(body, &job); err != nil {
+		slog.Warn("unreadable job", "err", err)
+		return queueResults{Error: fmt.Sprintf("reading the job: %v", err)}
+	}
+	routes, opts, p, err := w.query(job)
+	if err != nil {
+		slog.Warn("invalid job", "id", job.ID, "err", err)
+		return queueResults{ID: job.ID, Error: err.Error()}
+	}
+
+	client := &matrix.Client{Provider: p, Concurrency: w.s.cfg.Concurrency}
+	out := queueResults{ID: job.ID}
+	failed := 0
+	for _, r := range client.Compute(matrix.Request{Routes: routes, Options: opts}) {
+		result := queueResult{SiteCode: r.SiteCode, SiteName: r.SiteName, TerminalCode: r.TerminalCode, Status: r.Status, Error: r.Error}
+		if r.Status == "OK" {
+			result.DistanceKm, result.Duration, result.DurationSeconds = r.DistanceKm, r.Duration, r.Seconds
+		} else {
+			failed++
+		}
+		out.Results = append(out.Results, result)
+	}
+	slog.Info("job done", "id", job.ID, "routes", len(routes), "failed", failed)
+	return out
+}
+
+// query checks a job and returns its routes in WGS84 with the options and
+// provider to compute them with.
+func (w *worker) query(job queueJob) ([]matrix.Route, matrix.QueryOptions, matrix.Provider, error) {
+	if len(job.Routes) == 0 {
+		return nil, matrix.QueryOptions{}, nil, errors.New("the job has no routes")
+	}
+	if len(job.Routes) > w.maxRoutes {
+		return nil, matrix.QueryOptions{}, nil, fmt.Errorf("%d routes, over the limit of %d", len(job.Routes), w.maxRoutes)
+	}
+	opts, p, err := w.s.newQuery(job.Avoid, job.Mode)
+	if err != nil {
+		return nil, opts, nil, err
+	}
+	routes := make([]matrix.Route, len(job.Routes))
+	for i, r := range job.Routes {
+		routes[i], err = w.s.batchRoute(&routedmpb.Route{
+			SiteCode:     r.SiteCode,
+			SiteName:     r.SiteName,
+			TerminalCode: r.TerminalCode,
+			Origin:       r.Origin,
+			Destination:  r.Destination,
+			Waypoints:    r.Waypoints,
+		})
+		if err != nil {
+			return nil, opts, nil, fmt.Errorf("route %d: %w", i+1, err)
+		}
+	}
+	return routes, opts, p, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	matrixio "routes/pkg/io"
+)
+
+// memoryQueue hands out its jobs in order, then stops the worker, and keeps
+// what is published to it.
+type memoryQueue struct {
+	jobs       []string
+	stop       context.CancelFunc
+	published  []string
+	publishErr error
+	acked      []string
+	nacked     []string
+}
+
+func (q *memoryQueue) Receive(ctx context.Context) (*matrixio.QueueMessage, error) {
+	if len(q.jobs) == 0 {
+		q.stop()
+		return nil, ctx.Err()
+	}
+	body := q.jobs[0]
+	q.jobs = q.jobs[1:]
+	return matrixio.NewQueueMessage([]byte(body),
+		func(context.Context) error { q.acked = append(q.acked, body); return nil },
+		func(context.Context) error { q.nacked = append(q.nacked, body); return nil }), nil
+}
+
+func (q *memoryQueue) Publish(ctx context.Context, body []byte) error {
+	if q.publishErr != nil {
+		return q.publishErr
+	}
+	q.published = append(q.published, string(body))
+	return nil
+}
+
+func (q *memoryQueue) Close() error { return nil }
+
+func newMockWorker() *worker {
+	return &worker{s: newMockServer(), maxRoutes: 2}
+}
+
+func TestWorkerRun(t *testing.T) {
+	jobs := []string{
+		`{"id": "ok", "routes": [{"site_code": "S1", "terminal_code": "T1", "origin": "-6.3,106.9", "destination": "-6.2,106.8"},
+			{"site_code": "S2", "terminal_code": "T1", "origin": "-6.3,106.9", "destination": "-6.5,107.2", "waypoints": ["-6.4,107.0"]}]}`,
+		`{"id": "bad-location", "routes": [{"site_code": "S1", "origin": "-6.3 106.9", "destination": "-6.2,106.8"}]}`,
+		`{"id": "too-large", "routes": [{}, {}, {}]}`,
+		`{"id": "bad-mode", "routes": [{"origin": "-6.3,106.9", "destination": "-6.2,106.8"}], "mode": "flying"}`,
+		`not json`,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &memoryQueue{jobs: jobs, stop: cancel}
+	if err := newMockWorker().run(ctx, q, q); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.published) != len(jobs) || len(q.acked) != len(jobs) || len(q.nacked) != 0 {
+		t.Fatalf("published %d, acknowledged %d and returned %d of %d jobs", len(q.published), len(q.acked), len(q.nacked), len(jobs))
+	}
+
+	var ok queueResults
+	if err := json.Unmarshal([]byte(q.published[0]), &ok); err != nil {
+		t.Fatal(err)
+	}
+	if ok.ID != "ok" || ok.Error != "" || len(ok.Results) != 2 {
+		t.Fatalf("results of the valid job = %+v", ok)
+	}
+	for i, r := range ok.Results {
+		if r.SiteCode != []string{"S1", "S2"}[i] || r.Status != "OK" || r.DistanceKm <= 0 || r.DurationSeconds <= 0 {
+			t.Errorf("result %d = %+v", i, r)
+		}
+	}
+
+	for i, want := range []string{
+		`{"id":"bad-location","error":"route 1: origin: \"-6.3 106.9\" is not lat,lng"}`,
+		`{"id":"too-large","error":"3 routes, over the limit of 2"}`,
+		`{"id":"bad-mode","error":`,
+		`{"id":"","error":"reading the job: invalid character`,
+	} {
+		if got := q.published[i+1]; !strings.HasPrefix(got, want) {
+			t.Errorf("results of job %d = %s, want %s...", i+2, got, want)
+		}
+	}
+}
+
+func TestWorkerRunPublishFails(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	job := `{"id": "j1", "routes": [{"origin": "-6.3,106.9", "destination": "-6.2,106.8"}]}`
+	q := &memoryQueue{jobs: []string{job, job}, stop: cancel, publishErr: errors.New("broker down")}
+
+	// The job goes back to the queue and the worker stops.
+	err := newMockWorker().run(ctx, q, q)
+	if err == nil || !strings.Contains(err.Error(), "publishing the results of job j1: broker down") {
+		t.Errorf("run = %v", err)
+	}
+	if len(q.acked) != 0 || len(q.nacked) != 1 || len(q.jobs) != 1 {
+		t.Errorf("acknowledged %d and returned %d jobs, leaving %d", len(q.acked), len(q.nacked), len(q.jobs))
+	}
+}
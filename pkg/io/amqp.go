@@ -0,0 +1,127 @@
+package matrixio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// amqpBackend reaches amqp://HOST/VHOST?queue=QUEUE queues of RabbitMQ or
+// another AMQP 0-9-1 broker. The queue must already exist. Messages are
+// consumed one at a time and published persistent through the default
+// exchange, each confirmed by the broker.
+type amqpBackend struct{}
+
+func (amqpBackend) consumer(u *url.URL) (QueueConsumer, error) {
+	conn, ch, queue, err := dialAMQP(u)
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.Qos(1, 0, false); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s: %w", u.Redacted(), err)
+	}
+	deliveries, err := ch.Consume(queue, "", false, false, false, false, nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s: %w", u.Redacted(), err)
+	}
+	return &amqpConsumer{conn: conn, deliveries: deliveries}, nil
+}
+
+func (amqpBackend) publisher(u *url.URL) (QueuePublisher, error) {
+	conn, ch, queue, err := dialAMQP(u)
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s: %w", u.Redacted(), err)
+	}
+	return &amqpPublisher{conn: conn, ch: ch, queue: queue}, nil
+}
+
+// dialAMQP opens a channel to the broker of u and returns it with the
+// queue named by u's queue parameter, once the broker confirms the queue
+// exists.
+func dialAMQP(u *url.URL) (*amqp.Connection, *amqp.Channel, string, error) {
+	query := u.Query()
+	queue := query.Get("queue")
+	if queue == "" {
+		return nil, nil, "", fmt.Errorf("%s: want amqp://HOST/VHOST?queue=QUEUE", u.Redacted())
+	}
+	query.Del("queue")
+	broker := *u
+	broker.RawQuery = query.Encode()
+	conn, err := amqp.Dial(broker.String())
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("%s: %w", u.Redacted(), err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, nil, "", fmt.Errorf("%s: %w", u.Redacted(), err)
+	}
+	if _, err := ch.QueueDeclarePassive(queue, false, false, false, false, nil); err != nil {
+		conn.Close()
+		return nil, nil, "", fmt.Errorf("%s: %w", u.Redacted(), err)
+	}
+	return conn, ch, queue, nil
+}
+
+type amqpConsumer struct {
+	conn       *amqp.Connection
+	deliveries <-chan amqp.Delivery
+}
+
+func (c *amqpConsumer) Receive(ctx context.Context) (*QueueMessage, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case d, ok := <-c.deliveries:
+		if !ok {
+			return nil, errors.New("the AMQP broker closed the channel")
+		}
+		return &QueueMessage{
+			Body: d.Body,
+			ack:  func(context.Context) error { return d.Ack(false) },
+			nack: func(context.Context) error { return d.Nack(false, true) },
+		}, nil
+	}
+}
+
+func (c *amqpConsumer) Close() error {
+	return c.conn.Close()
+}
+
+type amqpPublisher struct {
+	conn  *amqp.Connection
+	ch    *amqp.Channel
+	queue string
+}
+
+func (p *amqpPublisher) Publish(ctx context.Context, body []byte) error {
+	confirm, err := p.ch.PublishWithDeferredConfirmWithContext(ctx, "", p.queue, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Body:         body,
+	})
+	if err != nil {
+		return err
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return fmt.Errorf("the AMQP broker refused a message for queue %s", p.queue)
+	}
+	return nil
+}
+
+func (p *amqpPublisher) Close() error {
+	return p.conn.Close()
+}
@@ -0,0 +1,98 @@
+package matrixio
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// WriteOutput calls write with stdout when path is "-", and otherwise with
+// a temporary file next to path that is renamed over it once write
+// succeeds, so a failed or interrupted run leaves any previous output
+// intact, even across a crash. The new file keeps the mode of the one it
+// replaces. A remote path is uploaded once write succeeds. A path ending in
+// .gz or .zip is written compressed, and one ending in .gpg or .age
+// encrypted (see EncryptOutputs).
+func WriteOutput(path string, write func(w io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+	write = encodedWrite(path, write)
+	if store, u, ok := remote(path); ok {
+		return writeRemote(store, u, write)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err := write(tmp); err != nil {
+		return err
+	}
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return err
+	}
+	// Flush the data before the rename, or a crash could keep the rename
+	// and lose the contents, leaving an empty file in place of the old one.
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	committed = true
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes a directory's entries, making a rename in it durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// AppendOutput is WriteOutput for adding to path: what write writes follows
+// the current contents of path, if any, and header tells write whether the
+// output is new and needs its header.
+func AppendOutput(path string, write func(w io.Writer, header bool) error) error {
+	if path == "-" {
+		return write(os.Stdout, true)
+	}
+	old, err := OpenInput(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return WriteOutput(path, func(w io.Writer) error { return write(w, true) })
+	}
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+	return WriteOutput(path, func(w io.Writer) error {
+		n, err := io.Copy(w, old)
+		if err != nil {
+			return err
+		}
+		return write(w, n == 0)
+	})
+}
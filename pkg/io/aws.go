@@ -0,0 +1,353 @@
+package matrixio
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ecsCredentialsHost serves an ECS task role's credentials at the path in
+// AWS_CONTAINER_CREDENTIALS_RELATIVE_URI.
+const ecsCredentialsHost = "http://169.254.170.2"
+
+// imdsEndpoint is the EC2 instance metadata service, which serves the
+// instance role's credentials.
+const imdsEndpoint = "http://169.254.169.254"
+
+// AWSCredentials are the keys AWS requests are signed with.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// LoadAWSCredentials finds credentials the way the AWS SDKs do, in order:
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY with an optional
+// AWS_SESSION_TOKEN; the web identity role of AWS_ROLE_ARN and
+// AWS_WEB_IDENTITY_TOKEN_FILE, as EKS sets them for a service account; the
+// AWS_PROFILE (or default) profile of the shared credentials file; the ECS
+// task role; and the EC2 instance role, unless AWS_EC2_METADATA_DISABLED
+// is true.
+func LoadAWSCredentials() (AWSCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return AWSCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	if os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "" && os.Getenv("AWS_ROLE_ARN") != "" {
+		return webIdentityAWSCredentials()
+	}
+	creds, err := sharedAWSCredentials()
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return creds, err
+	}
+	if os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" || os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "" {
+		return containerAWSCredentials()
+	}
+	const none = "no AWS credentials: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, configure a profile or run with a web identity, task or instance role"
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return AWSCredentials{}, errors.New(none)
+	}
+	creds, err = instanceAWSCredentials()
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("%s (%w)", none, err)
+	}
+	return creds, nil
+}
+
+// AWSRegion returns AWS_REGION or AWS_DEFAULT_REGION, or fallback when
+// neither is set.
+func AWSRegion(fallback string) string {
+	for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(name); region != "" {
+			return region
+		}
+	}
+	return fallback
+}
+
+// sharedAWSCredentials reads the profile named by AWS_PROFILE, or default,
+// from AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials. A missing file or
+// profile gives an error wrapping os.ErrNotExist.
+func sharedAWSCredentials() (AWSCredentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return AWSCredentials{}, fmt.Errorf("%w: %v", os.ErrNotExist, err)
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	defer file.Close()
+	var creds AWSCredentials
+	found := false
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || section != profile {
+			continue
+		}
+		found = true
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			creds.SessionToken = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return AWSCredentials{}, err
+	}
+	if !found {
+		return AWSCredentials{}, fmt.Errorf("%s: profile %s: %w", path, profile, os.ErrNotExist)
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return AWSCredentials{}, fmt.Errorf("%s: profile %s has no aws_access_key_id and aws_secret_access_key", path, profile)
+	}
+	return creds, nil
+}
+
+// containerAWSCredentials fetches the ECS task role's credentials from the
+// container credentials endpoint.
+func containerAWSCredentials() (AWSCredentials, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		endpoint = ecsCredentialsHost + uri
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("fetching container credentials: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return AWSCredentials{}, fmt.Errorf("fetching container credentials: %s", resp.Status)
+	}
+	var body struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return AWSCredentials{}, fmt.Errorf("decoding container credentials: %w", err)
+	}
+	return AWSCredentials{AccessKeyID: body.AccessKeyID, SecretAccessKey: body.SecretAccessKey, SessionToken: body.Token}, nil
+}
+
+// webIdentityAWSCredentials exchanges the token in
+// AWS_WEB_IDENTITY_TOKEN_FILE for credentials of the role AWS_ROLE_ARN
+// through STS AssumeRoleWithWebIdentity, which needs no signature. The
+// session is named AWS_ROLE_SESSION_NAME or after the time. STS is
+// reached in AWS_REGION, or at AWS_ENDPOINT_URL_STS or AWS_ENDPOINT_URL.
+func webIdentityAWSCredentials() (AWSCredentials, error) {
+	tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("reading web identity token: %w", err)
+	}
+	session := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if session == "" {
+		session = fmt.Sprintf("route-dm-%d", time.Now().UnixNano())
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_STS")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = "https://sts." + AWSRegion("us-east-1") + ".amazonaws.com"
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {os.Getenv("AWS_ROLE_ARN")},
+		"RoleSessionName":  {session},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.PostForm(strings.TrimSuffix(endpoint, "/")+"/", form)
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("assuming web identity role: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("assuming web identity role: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var stsErr struct {
+			Message string `xml:"Error>Message"`
+		}
+		if xml.Unmarshal(data, &stsErr) == nil && stsErr.Message != "" {
+			return AWSCredentials{}, fmt.Errorf("assuming web identity role: %s: %s", resp.Status, stsErr.Message)
+		}
+		return AWSCredentials{}, fmt.Errorf("assuming web identity role: %s", resp.Status)
+	}
+	var body struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string
+			SessionToken    string
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(data, &body); err != nil {
+		return AWSCredentials{}, fmt.Errorf("decoding web identity credentials: %w", err)
+	}
+	c := body.Credentials
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return AWSCredentials{}, errors.New("assuming web identity role: STS returned no credentials")
+	}
+	return AWSCredentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.SessionToken}, nil
+}
+
+// instanceAWSCredentials fetches the EC2 instance role's credentials from
+// the instance metadata service at AWS_EC2_METADATA_SERVICE_ENDPOINT or its
+// usual address, with a session token as IMDSv2 requires. The timeout is
+// short, since off EC2 nothing answers.
+func instanceAWSCredentials() (AWSCredentials, error) {
+	endpoint := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+	if endpoint == "" {
+		endpoint = imdsEndpoint
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	client := &http.Client{Timeout: 2 * time.Second}
+	do := func(method, path string, header http.Header) ([]byte, error) {
+		req, err := http.NewRequest(method, endpoint+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header = header
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	}
+
+	token, err := do(http.MethodPut, "/latest/api/token", http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"21600"}})
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("fetching instance metadata token: %w", err)
+	}
+	header := http.Header{"X-Aws-Ec2-Metadata-Token": {string(token)}}
+	const rolePath = "/latest/meta-data/iam/security-credentials/"
+	roles, err := do(http.MethodGet, rolePath, header)
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("fetching instance role: %w", err)
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(string(roles)), "\n")
+	if role == "" {
+		return AWSCredentials{}, errors.New("the instance has no role")
+	}
+	data, err := do(http.MethodGet, rolePath+url.PathEscape(role), header)
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("fetching instance role credentials: %w", err)
+	}
+	var body struct {
+		Code            string
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return AWSCredentials{}, fmt.Errorf("decoding instance role credentials: %w", err)
+	}
+	if body.Code != "Success" {
+		return AWSCredentials{}, fmt.Errorf("instance role credentials: %s", body.Code)
+	}
+	return AWSCredentials{AccessKeyID: body.AccessKeyID, SecretAccessKey: body.SecretAccessKey, SessionToken: body.Token}, nil
+}
+
+// SignAWSRequest adds an AWS Signature Version 4 Authorization header to
+// req, signing its Content-Type, Host and X-Amz-* headers. payloadHash is
+// the hex SHA-256 of the body, or UNSIGNED-PAYLOAD where S3 allows it.
+func SignAWSRequest(req *http.Request, payloadHash string, creds AWSCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := now.UTC().Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, SHA256Hex([]byte(canonical))}, "\n")
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// SHA256Hex returns the hex SHA-256 of data, as AWS signatures use it.
+func SHA256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
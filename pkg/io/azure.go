@@ -0,0 +1,173 @@
+package matrixio
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	azureAPIVersion = "2021-08-06"
+	azureResource   = "https://storage.azure.com/"
+	// azureIMDS is the instance metadata token endpoint of Azure VMs,
+	// including Batch pool nodes running Data Factory custom activities.
+	azureIMDS = "http://169.254.169.254/metadata/identity/oauth2/token"
+)
+
+// azureStore reads and writes blobs named az://CONTAINER/BLOB, in the
+// account AZURE_STORAGE_ACCOUNT, or abfss://CONTAINER@ACCOUNT.dfs.core.windows.net/BLOB.
+// Requests carry the SAS token in AZURE_STORAGE_SAS_TOKEN when set and a
+// managed identity token otherwise; AZURE_CLIENT_ID picks a user-assigned
+// identity. AZURE_STORAGE_ENDPOINT replaces the account's blob endpoint,
+// e.g. for Azurite.
+type azureStore struct{}
+
+func (azureStore) open(u *url.URL) (io.ReadCloser, error) {
+	req, err := azureRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := azureDo(u, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (azureStore) put(u *url.URL, r io.ReadSeeker, size int64) error {
+	req, err := azureRequest(http.MethodPut, u, io.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+	if contentType := mime.TypeByExtension(path.Ext(u.Path)); contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := azureDo(u, req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// azureRequest builds an authorized request for the blob u names.
+func azureRequest(method string, u *url.URL, body io.Reader) (*http.Request, error) {
+	account, container := os.Getenv("AZURE_STORAGE_ACCOUNT"), u.Host
+	if u.Scheme == "abfss" {
+		account, _, _ = strings.Cut(u.Host, ".")
+		container = u.User.Username()
+	}
+	blob := strings.TrimPrefix(u.Path, "/")
+	if container == "" || blob == "" {
+		return nil, fmt.Errorf("%s: want az://CONTAINER/BLOB or abfss://CONTAINER@ACCOUNT.dfs.core.windows.net/BLOB", u)
+	}
+
+	endpoint := os.Getenv("AZURE_STORAGE_ENDPOINT")
+	if endpoint == "" {
+		if account == "" {
+			return nil, fmt.Errorf("%s: AZURE_STORAGE_ACCOUNT must be set", u)
+		}
+		endpoint = "https://" + account + ".blob.core.windows.net"
+	}
+	blobURL := strings.TrimSuffix(endpoint, "/") + "/" + url.PathEscape(container) + escapeObjectPath("/"+blob)
+	if sas := os.Getenv("AZURE_STORAGE_SAS_TOKEN"); sas != "" {
+		blobURL += "?" + strings.TrimPrefix(sas, "?")
+	}
+
+	req, err := http.NewRequest(method, blobURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Ms-Version", azureAPIVersion)
+	req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	if os.Getenv("AZURE_STORAGE_SAS_TOKEN") == "" {
+		token, err := azureToken()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// azureToken fetches a managed identity token for Azure Storage once per
+// run, from the App Service identity endpoint when IDENTITY_ENDPOINT is set
+// and from the instance metadata service otherwise.
+var azureToken = sync.OnceValues(func() (string, error) {
+	endpoint, version := azureIMDS, "2018-02-01"
+	if e := os.Getenv("IDENTITY_ENDPOINT"); e != "" {
+		endpoint, version = e, "2019-08-01"
+	}
+	q := url.Values{"api-version": {version}, "resource": {azureResource}}
+	if id := os.Getenv("AZURE_CLIENT_ID"); id != "" {
+		q.Set("client_id", id)
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if header := os.Getenv("IDENTITY_HEADER"); header != "" {
+		req.Header.Set("X-Identity-Header", header)
+	} else {
+		req.Header.Set("Metadata", "true")
+	}
+	client := &http.Client{Transport: storageClient.Transport, Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching managed identity token (set AZURE_STORAGE_SAS_TOKEN to use a SAS instead): %w", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		AccessToken string `json:"access_token"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching managed identity token: %s", resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding managed identity token: %w", err)
+	}
+	if body.AccessToken == "" {
+		return "", errors.New("managed identity endpoint returned no token")
+	}
+	return body.AccessToken, nil
+})
+
+// azureDo sends req and returns the successful response. A missing blob
+// gives an error wrapping fs.ErrNotExist.
+func azureDo(u *url.URL, req *http.Request) (*http.Response, error) {
+	resp, err := storageClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &fs.PathError{Op: strings.ToLower(req.Method), Path: u.String(), Err: fs.ErrNotExist}
+	}
+	var azErr struct {
+		Code    string
+		Message string
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if xml.Unmarshal(data, &azErr) == nil && azErr.Code != "" {
+		return nil, fmt.Errorf("%s: %s: %s", u, azErr.Code, strings.TrimSpace(azErr.Message))
+	}
+	return nil, fmt.Errorf("%s: %s", u, resp.Status)
+}
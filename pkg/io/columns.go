@@ -0,0 +1,85 @@
+package matrixio
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// CheckOutputColumns fails if cfg selects output columns (see
+// Config.OutputColumns) for an output whose columns are fixed: a database,
+// GeoJSON or an output template, which picks its own.
+func CheckOutputColumns(cfg Config) error {
+	if len(cfg.OutputColumns) == 0 {
+		return nil
+	}
+	for _, c := range cfg.OutputColumns {
+		if c == "" {
+			return fmt.Errorf("empty output column name in %q", strings.Join(cfg.OutputColumns, ","))
+		}
+	}
+	switch {
+	case strings.HasPrefix(cfg.Output, "sqlite://") || IsPostgresDSN(cfg.Output):
+		return fmt.Errorf("database outputs have fixed columns; output columns cannot be selected for %s", cfg.Output)
+	case cfg.outputFormat() == "geojson" || cfg.outputFormat() == "template":
+		return fmt.Errorf("output columns can only be selected for CSV, JSON and Google Sheets outputs, not %s", cfg.outputFormat())
+	}
+	return nil
+}
+
+// pickHeader returns the header of rows laid out by pickColumns, logging
+// the selected columns that header, as this run writes it, lacks.
+func pickHeader(columns, header []string) []string {
+	if len(columns) == 0 {
+		return header
+	}
+	var missing []string
+	for _, c := range columns {
+		if !slices.Contains(header, c) {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) > 0 {
+		slog.Warn("selected output columns that this run does not produce are left empty",
+			"columns", strings.Join(missing, ","), "available", strings.Join(header, ","))
+	}
+	return columns
+}
+
+// pickColumns returns record, whose columns are named by header, as the
+// selected columns in their order, empty for those it lacks. Without a
+// selection it is record as it is.
+func pickColumns(columns, header, record []string) []string {
+	if len(columns) == 0 {
+		return record
+	}
+	picked := make([]string, len(columns))
+	for i, c := range columns {
+		if j := slices.Index(header, c); j >= 0 {
+			picked[i] = record[j]
+		}
+	}
+	return picked
+}
+
+// pickJSONColumns keeps the fields of a JSON output record whose keys are
+// the selected columns in lower case, adding null for those it lacks.
+func pickJSONColumns(columns []string, record map[string]any) {
+	if len(columns) == 0 {
+		return
+	}
+	keep := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		key := strings.ToLower(c)
+		keep[key] = true
+		if _, ok := record[key]; !ok {
+			record[key] = nil
+		}
+	}
+	for key := range record {
+		if !keep[key] {
+			delete(record, key)
+		}
+	}
+}
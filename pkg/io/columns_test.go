@@ -0,0 +1,46 @@
+package matrixio
+
+import (
+	"reflect"
+	"testing"
+
+	"routes/pkg/matrix"
+)
+
+func TestResultRecordsColumns(t *testing.T) {
+	units, err := ParseDistanceUnits(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []matrix.Result{{
+		Route:      matrix.Route{SiteCode: "S1", SiteName: "Alpha", TerminalCode: "T1"},
+		DistanceKm: 20.38,
+		Duration:   "24 mins",
+		Status:     "OK",
+		Extra:      []matrix.Field{{Name: "DURATION_SECONDS", Value: "1467"}},
+	}}
+
+	tests := []struct {
+		name    string
+		columns []string
+		want    [][]string
+	}{
+		{"all", nil, [][]string{
+			{"SITE_CODE", "SITE_NAME", "TERMINAL_CODE", "DISTANCE_KM", "DURATION", "DURATION_SECONDS"},
+			{"S1", "Alpha", "T1", "20.38", "24 mins", "1467"},
+		}},
+		{"reordered", []string{"DURATION_SECONDS", "SITE_CODE", "DISTANCE_KM"}, [][]string{
+			{"DURATION_SECONDS", "SITE_CODE", "DISTANCE_KM"},
+			{"1467", "S1", "20.38"},
+		}},
+		{"missing", []string{"SITE_CODE", "TOLL_COST"}, [][]string{
+			{"SITE_CODE", "TOLL_COST"},
+			{"S1", ""},
+		}},
+	}
+	for _, tt := range tests {
+		if got := resultRecords(units, results, "", tt.columns); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: resultRecords = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package matrixio
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// compressionExts maps the -compress methods to the file extension that
+// marks a compressed file. Files are compressed on write and decompressed on
+// read by their extension, so a .csv.gz output can be resumed or appended to
+// like a plain one.
+var compressionExts = map[string]string{
+	"gzip": ".gz",
+	"zip":  ".zip",
+}
+
+// compressionExt returns the compression extension of name, lower-cased, or
+// "" for an uncompressed file.
+func compressionExt(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	for _, e := range compressionExts {
+		if ext == e {
+			return ext
+		}
+	}
+	return ""
+}
+
+// formatName returns name without its encryption and compression
+// extensions, if any, which leaves the extension that tells the format.
+func formatName(name string) string {
+	name, _ = cutEncryptedExt(name)
+	return name[:len(name)-len(compressionExt(name))]
+}
+
+// SplitExt splits a file name into its stem and extension, taking
+// compression and encryption extensions together with the one before them,
+// so "routes.csv.gz" gives "routes" and ".csv.gz".
+func SplitExt(name string) (stem, ext string) {
+	base := formatName(name)
+	ext = path.Ext(base) + name[len(base):]
+	return name[:len(name)-len(ext)], ext
+}
+
+// CompressedOutput returns output named for the compression method, gzip or
+// zip, adding the extension unless it is already there. Only file outputs,
+// local or remote, can be compressed.
+func CompressedOutput(output, method string) (string, error) {
+	if method == "" {
+		return output, nil
+	}
+	ext, ok := compressionExts[method]
+	if !ok {
+		return "", fmt.Errorf("unknown compression %q (want gzip or zip)", method)
+	}
+	if !isFileOutput(output) {
+		return "", fmt.Errorf("only file outputs can be compressed, not %s", output)
+	}
+	if compressionExt(inputName(output)) == ext {
+		return output, nil
+	}
+	return withExt(output, ext), nil
+}
+
+// isFileOutput reports whether output is a local file or remote object,
+// rather than stdout or a database.
+func isFileOutput(output string) bool {
+	return output != "-" && !strings.HasPrefix(output, "sqlite://") &&
+		!strings.HasPrefix(output, "sheets://") && !IsPostgresDSN(output)
+}
+
+// withExt adds ext to the name of a local file or remote object.
+func withExt(output, ext string) string {
+	if _, u, ok := remote(output); ok {
+		u.Path += ext
+		u.RawPath = ""
+		return u.String()
+	}
+	return output + ext
+}
+
+// encodedWrite wraps write so that what it writes is compressed and then
+// encrypted as the extensions of output ask.
+func encodedWrite(output string, write func(w io.Writer) error) func(w io.Writer) error {
+	plain, ext := cutEncryptedExt(inputName(output))
+	if ext == "" {
+		return compressedWrite(output, write)
+	}
+	return encryptedWrite(ext, compressedWrite(plain, write))
+}
+
+// compressedWrite wraps write so that what it writes is compressed as the
+// extension of output asks, if it does.
+func compressedWrite(output string, write func(w io.Writer) error) func(w io.Writer) error {
+	switch compressionExt(inputName(output)) {
+	case ".gz":
+		return func(w io.Writer) error {
+			zw := gzip.NewWriter(w)
+			if err := write(zw); err != nil {
+				return err
+			}
+			return zw.Close()
+		}
+	case ".zip":
+		// The archive holds one file, named as the output without .zip.
+		name := path.Base(formatName(inputName(output)))
+		return func(w io.Writer) error {
+			zw := zip.NewWriter(w)
+			entry, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+			if err != nil {
+				return err
+			}
+			if err := write(entry); err != nil {
+				return err
+			}
+			return zw.Close()
+		}
+	}
+	return write
+}
+
+// decompress returns the uncompressed contents of r, the file or object at
+// name, when its extension marks it as compressed, and r otherwise.
+func decompress(name string, r io.ReadCloser) (io.ReadCloser, error) {
+	if _, ext := cutEncryptedExt(inputName(name)); ext != "" {
+		r.Close()
+		return nil, fmt.Errorf("%s is encrypted; decrypt it before reading it", name)
+	}
+	switch compressionExt(inputName(name)) {
+	case ".gz":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return readCloser{zr, func() error { zr.Close(); return r.Close() }}, nil
+	case ".zip":
+		entry, err := openZipEntry(r)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return readCloser{entry, func() error { entry.Close(); return r.Close() }}, nil
+	}
+	return r, nil
+}
+
+// openZipEntry opens the only file in the zip archive r. Remote archives
+// are read into memory, as zip needs random access.
+func openZipEntry(r io.Reader) (io.ReadCloser, error) {
+	var zr *zip.Reader
+	var err error
+	if f, ok := r.(*os.File); ok {
+		var info os.FileInfo
+		if info, err = f.Stat(); err != nil {
+			return nil, err
+		}
+		zr, err = zip.NewReader(f, info.Size())
+	} else {
+		var data []byte
+		if data, err = io.ReadAll(r); err != nil {
+			return nil, err
+		}
+		zr, err = zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file *zip.File
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if file != nil {
+			return nil, errors.New("zip archive holds more than one file")
+		}
+		file = f
+	}
+	if file == nil {
+		return nil, errors.New("zip archive is empty")
+	}
+	return file.Open()
+}
+
+// readCloser is a Reader with its own Close.
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error { return r.close() }
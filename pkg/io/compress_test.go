@@ -0,0 +1,84 @@
+package matrixio
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitExt(t *testing.T) {
+	tests := []struct {
+		name, stem, ext string
+	}{
+		{"routes.csv", "routes", ".csv"},
+		{"routes.csv.gz", "routes", ".csv.gz"},
+		{"out/routes.JSON.ZIP", "out/routes", ".JSON.ZIP"},
+		{"routes.gz", "routes", ".gz"},
+		{"routes", "routes", ""},
+	}
+	for _, tt := range tests {
+		stem, ext := SplitExt(tt.name)
+		if stem != tt.stem || ext != tt.ext {
+			t.Errorf("SplitExt(%q) = %q, %q; want %q, %q", tt.name, stem, ext, tt.stem, tt.ext)
+		}
+	}
+}
+
+func TestCompressedOutput(t *testing.T) {
+	tests := []struct {
+		output, method, want string
+		wantErr              bool
+	}{
+		{"out.csv", "", "out.csv", false},
+		{"out.csv", "gzip", "out.csv.gz", false},
+		{"out.csv.gz", "gzip", "out.csv.gz", false},
+		{"out.csv", "zip", "out.csv.zip", false},
+		{"s3://bucket/out.csv?versionId=1", "gzip", "s3://bucket/out.csv.gz?versionId=1", false},
+		{"out.csv", "bzip2", "", true},
+		{"-", "gzip", "", true},
+		{"sqlite://results.db", "gzip", "", true},
+	}
+	for _, tt := range tests {
+		got, err := CompressedOutput(tt.output, tt.method)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("CompressedOutput(%q, %q) = %q, %v; want %q, error %v", tt.output, tt.method, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCompressedRoundTrip(t *testing.T) {
+	const contents = "SITE_CODE,DISTANCE_KM\nS1,20.38\n"
+	for _, name := range []string{"out.csv", "out.csv.gz", "out.csv.zip"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			err := WriteOutput(path, func(w io.Writer) error {
+				_, err := io.WriteString(w, contents)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if compressed := string(raw) != contents; compressed != (name != "out.csv") {
+				t.Errorf("file compressed = %v", compressed)
+			}
+
+			r, err := OpenInput(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != contents {
+				t.Errorf("read back %q, want %q", got, contents)
+			}
+		})
+	}
+}
@@ -0,0 +1,393 @@
+package matrixio
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"routes/pkg/matrix"
+)
+
+const DefaultConfigFile = "route-dm.json"
+
+// Config holds the settings that can be stored in a route-dm.json file.
+// Command-line flags take precedence over values read from the file.
+type Config struct {
+	Input  string `json:"input"`
+	Output string `json:"output"`
+	Format string `json:"format,omitempty"`
+	// CRS is the EPSG code of the input coordinates, e.g. "EPSG:32748".
+	// Empty means WGS84 latitude/longitude.
+	CRS     string        `json:"crs,omitempty"`
+	Columns ColumnMapping `json:"columns"`
+	// DistanceUnits lists the units distances are written in, one column
+	// each: km, mi, m or nmi. Empty means km only.
+	DistanceUnits []string `json:"distance_units,omitempty"`
+	// Provider is the routing API: google (Distance Matrix, the default) or
+	// routes (Routes API).
+	Provider string `json:"provider,omitempty"`
+	// Units is the API unit system, metric or imperial. Imperial also makes
+	// miles the default distance unit.
+	Units string `json:"units,omitempty"`
+	// Bounds is the area input points should fall in, such as a country, as
+	// "minLat,minLng,maxLat,maxLng". Points outside it whose mirror image is
+	// inside are taken as swapped.
+	Bounds string `json:"bounds,omitempty"`
+	// CoordPrecision rounds input coordinates to that many decimal places
+	// before they are queried, so near-identical fixes of one place share
+	// their answer. Zero leaves them as given.
+	CoordPrecision int `json:"coord_precision,omitempty"`
+	// PlusCodes accepts full Plus Codes (Open Location Codes) in place of
+	// coordinates, in either coordinate column, and adds ORIGIN_DECODED and
+	// DESTINATION_DECODED columns with the location each decodes to.
+	PlusCodes bool `json:"plus_codes,omitempty"`
+	// FixSwappedCoords swaps latitude and longitude on rows where they look
+	// reversed; otherwise such rows only log a warning.
+	FixSwappedCoords bool `json:"fix_swapped_coords,omitempty"`
+	// SkipInvalid leaves out input rows that fail validation, logging
+	// each, instead of stopping before any API call.
+	SkipInvalid bool `json:"skip_invalid,omitempty"`
+	// Webhook is a URL that receives a JSON POST with the job ID, status,
+	// row counts and output location when a batch finishes or fails.
+	Webhook string `json:"webhook,omitempty"`
+	// Email sends the outcome of each run to a distribution list.
+	Email EmailConfig `json:"email"`
+	// Chat posts a summary of each run to a Slack or Microsoft Teams
+	// channel.
+	Chat ChatConfig `json:"chat"`
+	// History is a JSON Lines file each batch run appends its outcome to,
+	// with its distances and durations by terminal, for route-dm report.
+	// Empty keeps no history.
+	History string `json:"history,omitempty"`
+	// Report lays out and delivers the weekly route-dm report.
+	Report ReportConfig `json:"report"`
+	// Clients are the callers route-dm serve accepts, by API key. With
+	// none, the server is open to anyone who can reach it.
+	Clients []ClientConfig `json:"clients,omitempty"`
+	// CriticalLanes are the origin-destination pairs route-dm serve
+	// refreshes first, at startup and on its -critical-schedule, however
+	// recently they were fetched, so dashboards relying on them always
+	// show recent values.
+	CriticalLanes []LaneConfig `json:"critical_lanes,omitempty"`
+	// MaxElements and MaxCost cap a run's estimated billed units and cost
+	// in USD at list prices; zero means no cap. MaxElements also stops the
+	// run making matrix requests past it.
+	MaxElements int     `json:"max_elements,omitempty"`
+	MaxCost     float64 `json:"max_cost,omitempty"`
+	// Stream reads a CSV input and writes the results one row at a time,
+	// in memory that does not grow with the input. Stdin always streams.
+	Stream bool `json:"stream,omitempty"`
+	// Append adds the results to an existing CSV or JSON output file,
+	// which must have the same columns, instead of replacing it.
+	Append bool `json:"append,omitempty"`
+	// Compress writes a file output compressed, gzip or zip, adding .gz or
+	// .zip to its name. Outputs already named so are compressed anyway.
+	Compress string `json:"compress,omitempty"`
+	// EncryptRecipients lists age recipients or OpenPGP public key files
+	// whose owners can decrypt the file output and error report, which are
+	// written encrypted with .age or .gpg added to their names (see
+	// EncryptOutputs).
+	EncryptRecipients []string `json:"encrypt_recipients,omitempty"`
+	// OutputColumns, when set, are the columns written to CSV, JSON and
+	// Google Sheets outputs, in this order, by the names of the full
+	// output's header. Columns the run does not produce are written empty.
+	OutputColumns []string `json:"output_columns,omitempty"`
+	// OutputTemplate, when set, writes each result row of a file output
+	// or stdout as this text/template lays it out, in place of the
+	// format; "@path" reads the template from a file (see
+	// ParseOutputTemplate).
+	OutputTemplate string `json:"output_template,omitempty"`
+	// PassThrough lists input columns, by header name or 1-based position,
+	// copied unchanged to the end of each output row. "*" copies every
+	// column that is not mapped in Columns.
+	PassThrough []string `json:"pass_through,omitempty"`
+	// OnFailure is how failed rows are written (see FailurePolicy).
+	OnFailure FailurePolicy `json:"on_failure,omitempty"`
+	// Proxy is the http, https or socks5 proxy URL, with optional
+	// user:password, that API requests go through. Empty leaves the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment in charge.
+	Proxy string `json:"proxy,omitempty"`
+	// Concurrency is the number of API requests kept in flight.
+	Concurrency int                  `json:"concurrency,omitempty"`
+	CSV         CSVConfig            `json:"csv"`
+	Excel       ExcelConfig          `json:"excel"`
+	Postgres    PostgresConfig       `json:"postgres"`
+	Geocode     matrix.GeocodeConfig `json:"geocode"`
+}
+
+// EmailConfig sends an email when a run finishes or fails. The SMTP login,
+// if the server needs one, comes from SMTP_USERNAME and SMTP_PASSWORD.
+type EmailConfig struct {
+	// SMTP is the server as host:port. Port 465 is spoken over TLS; on
+	// other ports the connection is upgraded with STARTTLS where offered.
+	SMTP string   `json:"smtp,omitempty"`
+	From string   `json:"from,omitempty"`
+	To   []string `json:"to,omitempty"`
+	// Attach attaches a local output file of up to AttachLimit bytes
+	// (default 10 MB) to the message. Larger and remote outputs are named
+	// by their location, or by Link.
+	Attach      bool  `json:"attach,omitempty"`
+	AttachLimit int64 `json:"attach_limit,omitempty"`
+	// Link is where recipients download the output, such as a shared
+	// folder URL, given in place of the output's path.
+	Link string `json:"link,omitempty"`
+}
+
+// ClientConfig is a caller that route-dm serve accepts.
+type ClientConfig struct {
+	Name string `json:"name"`
+	// KeySHA256 is the hex SHA-256 digest of the client's API key, so the
+	// config does not hold the key itself. route-dm serve -new-key prints
+	// a key with its digest.
+	KeySHA256 string `json:"key_sha256"`
+	// DailyElements caps the origin-destination elements the client may
+	// request per UTC day. Zero means no cap.
+	DailyElements int64 `json:"daily_elements,omitempty"`
+}
+
+// LaneConfig is one origin-destination pair, as "lat,lng" in the config's
+// CRS.
+type LaneConfig struct {
+	// Name labels the lane on dashboards; empty names it by its ends.
+	Name        string `json:"name,omitempty"`
+	Origin      string `json:"origin"`
+	Destination string `json:"destination"`
+}
+
+// ReportConfig customises route-dm report.
+type ReportConfig struct {
+	// Template is a text/template laying the report out, or "@path" to
+	// read one from a file. Empty uses the built-in layout.
+	Template string `json:"template,omitempty"`
+	// Email sends the report to the config's email recipients, and Chat
+	// posts it to its chat webhook.
+	Email bool `json:"email,omitempty"`
+	Chat  bool `json:"chat,omitempty"`
+}
+
+// ChatConfig posts run summaries to a chat channel's incoming webhook.
+type ChatConfig struct {
+	// Webhook is a Slack or Microsoft Teams incoming webhook URL.
+	Webhook string `json:"webhook,omitempty"`
+	// Kind is slack or teams. Empty means teams for webhooks on Microsoft
+	// hosts and slack otherwise.
+	Kind string `json:"kind,omitempty"`
+	// AlertFailureRate is the fraction of failed rows, 0.05 by default,
+	// from which the summary is posted as an alert. Failed runs always
+	// are.
+	AlertFailureRate float64 `json:"alert_failure_rate,omitempty"`
+}
+
+// ColumnMapping tells the CSV reader which input column holds each field.
+// A column is referenced by its header name or by its 1-based position.
+type ColumnMapping struct {
+	SiteCode       string `json:"site_code"`
+	SiteName       string `json:"site_name"`
+	DestinationLat string `json:"destination_lat"`
+	DestinationLng string `json:"destination_lng"`
+	TerminalCode   string `json:"terminal_code"`
+	OriginLat      string `json:"origin_lat"`
+	OriginLng      string `json:"origin_lng"`
+	// CRS optionally names a column holding a per-row EPSG code that
+	// overrides Config.CRS. For projected systems the latitude columns hold
+	// the northing and the longitude columns the easting.
+	CRS string `json:"crs,omitempty"`
+	// OriginAddress and DestinationAddress optionally name street address
+	// columns. Rows whose coordinate cells are empty, or whose coordinate
+	// columns are left unmapped, are geocoded from the address instead.
+	OriginAddress      string `json:"origin_address,omitempty"`
+	DestinationAddress string `json:"destination_address,omitempty"`
+	// Waypoints optionally names a column of via points, "lat,lng" pairs
+	// in the row's CRS separated by pipes, visited between origin and
+	// destination. Distances and durations are summed over the legs.
+	Waypoints string `json:"waypoints,omitempty"`
+	// The hemisphere columns optionally hold N/S or E/W indicators. When
+	// mapped, the sign of the coordinate comes from the indicator and the
+	// sign of the value itself is ignored.
+	OriginLatHemisphere      string `json:"origin_lat_hemisphere,omitempty"`
+	OriginLngHemisphere      string `json:"origin_lng_hemisphere,omitempty"`
+	DestinationLatHemisphere string `json:"destination_lat_hemisphere,omitempty"`
+	DestinationLngHemisphere string `json:"destination_lng_hemisphere,omitempty"`
+	// LatSign ("north" or "south") and LngSign ("east" or "west") place
+	// every coordinate without an indicator in that hemisphere, for files
+	// that drop the minus sign.
+	LatSign string `json:"lat_sign,omitempty"`
+	LngSign string `json:"lng_sign,omitempty"`
+}
+
+// UnmarshalJSON lets a "columns" object replace the default mapping as a
+// whole, so columns it leaves out are unmapped rather than positional.
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type plain Config
+	var raw struct {
+		Columns json.RawMessage `json:"columns"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.Columns != nil {
+		c.Columns = ColumnMapping{}
+	}
+	return json.Unmarshal(data, (*plain)(c))
+}
+
+// HasAddresses reports whether any address column is mapped.
+func (m ColumnMapping) HasAddresses() bool {
+	return m.OriginAddress != "" || m.DestinationAddress != ""
+}
+
+// columnIndexes holds the resolved 0-based positions of each mapped column.
+// Optional columns that are not mapped are -1.
+type columnIndexes struct {
+	siteCode, siteName, terminalCode, crs, waypoints int
+	origin, destination                              locationColumns
+}
+
+// locationColumns are the positions describing one end of a route.
+type locationColumns struct {
+	lat, lng, latHemisphere, lngHemisphere, address int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Input:       "routes.csv",
+		Output:      "output.csv",
+		Concurrency: 1,
+		Columns:     DefaultColumnMapping(),
+		Postgres:    defaultPostgresConfig(),
+		Geocode:     matrix.DefaultGeocodeConfig(),
+	}
+}
+
+// DefaultColumnMapping matches the original fixed layout:
+// SITE_CODE, SITE_NAME, site lat, site lng, TERMINAL_CODE, terminal lat, terminal lng.
+func DefaultColumnMapping() ColumnMapping {
+	return ColumnMapping{
+		SiteCode:       "1",
+		SiteName:       "2",
+		DestinationLat: "3",
+		DestinationLng: "4",
+		TerminalCode:   "5",
+		OriginLat:      "6",
+		OriginLng:      "7",
+	}
+}
+
+// LoadConfig reads the config file at path on top of the defaults. A missing
+// file is only an error when required is set.
+func LoadConfig(path string, required bool) (Config, error) {
+	cfg := DefaultConfig()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return cfg, nil
+		}
+		return cfg, err
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+func WriteConfig(path string, cfg Config) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Validate checks that every column m refers to exists in header.
+func (m ColumnMapping) Validate(header []string) error {
+	_, err := m.resolve(header)
+	return err
+}
+
+// resolve maps every column reference onto a position in header.
+func (m ColumnMapping) resolve(header []string) (columnIndexes, error) {
+	var idx columnIndexes
+	// Coordinates may be left unmapped when an address column replaces them.
+	fields := []struct {
+		name     string
+		ref      string
+		dst      *int
+		optional bool
+	}{
+		{"site_code", m.SiteCode, &idx.siteCode, false},
+		{"site_name", m.SiteName, &idx.siteName, false},
+		{"destination_lat", m.DestinationLat, &idx.destination.lat, m.DestinationAddress != ""},
+		{"destination_lng", m.DestinationLng, &idx.destination.lng, m.DestinationAddress != ""},
+		{"terminal_code", m.TerminalCode, &idx.terminalCode, false},
+		{"origin_lat", m.OriginLat, &idx.origin.lat, m.OriginAddress != ""},
+		{"origin_lng", m.OriginLng, &idx.origin.lng, m.OriginAddress != ""},
+		{"crs", m.CRS, &idx.crs, true},
+		{"waypoints", m.Waypoints, &idx.waypoints, true},
+		{"origin_address", m.OriginAddress, &idx.origin.address, true},
+		{"destination_address", m.DestinationAddress, &idx.destination.address, true},
+		{"origin_lat_hemisphere", m.OriginLatHemisphere, &idx.origin.latHemisphere, true},
+		{"origin_lng_hemisphere", m.OriginLngHemisphere, &idx.origin.lngHemisphere, true},
+		{"destination_lat_hemisphere", m.DestinationLatHemisphere, &idx.destination.latHemisphere, true},
+		{"destination_lng_hemisphere", m.DestinationLngHemisphere, &idx.destination.lngHemisphere, true},
+	}
+
+	for _, f := range fields {
+		if f.optional && strings.TrimSpace(f.ref) == "" {
+			*f.dst = -1
+			continue
+		}
+		i, err := ColumnIndex(header, f.ref)
+		if err != nil {
+			return idx, fmt.Errorf("column %s: %w", f.name, err)
+		}
+		*f.dst = i
+	}
+
+	return idx, nil
+}
+
+// maxIndex returns the highest resolved position, used to check row widths.
+func (idx columnIndexes) maxIndex() int {
+	return max(idx.siteCode, idx.siteName, idx.terminalCode, idx.crs, idx.waypoints, idx.origin.maxIndex(), idx.destination.maxIndex())
+}
+
+// mapped reports whether position i is one of the mapped columns.
+func (idx columnIndexes) mapped(i int) bool {
+	for _, l := range []locationColumns{idx.origin, idx.destination} {
+		if i == l.lat || i == l.lng || i == l.latHemisphere || i == l.lngHemisphere || i == l.address {
+			return true
+		}
+	}
+	return i == idx.siteCode || i == idx.siteName || i == idx.terminalCode || i == idx.crs || i == idx.waypoints
+}
+
+func (l locationColumns) maxIndex() int {
+	return max(l.lat, l.lng, l.latHemisphere, l.lngHemisphere, l.address)
+}
+
+func ColumnIndex(header []string, ref string) (int, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return 0, fmt.Errorf("not mapped")
+	}
+
+	for i, name := range header {
+		if strings.EqualFold(strings.TrimSpace(name), ref) {
+			return i, nil
+		}
+	}
+
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 1 {
+			return 0, fmt.Errorf("position %d is out of range", n)
+		}
+		return n - 1, nil
+	}
+
+	return 0, fmt.Errorf("header %q not found", ref)
+}
@@ -0,0 +1,137 @@
+package matrixio
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const epsgWGS84 = 4326
+
+// WGS84 ellipsoid parameters shared by the projections below.
+const (
+	wgs84A = 6378137.0
+	wgs84F = 1 / 298.257223563
+)
+
+// ParseEPSG accepts "4326", "EPSG:4326" or "epsg:4326". An empty code means WGS84.
+func ParseEPSG(code string) (int, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return epsgWGS84, nil
+	}
+	if len(code) > 5 && strings.EqualFold(code[:5], "EPSG:") {
+		code = code[5:]
+	}
+	n, err := strconv.Atoi(code)
+	if err != nil {
+		return 0, fmt.Errorf("invalid EPSG code %q", code)
+	}
+	if _, err := projection(n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// projection returns the inverse transform for a supported EPSG code. Inputs
+// are (x, y) as written in the longitude/easting and latitude/northing columns.
+func projection(epsg int) (func(x, y float64) (lat, lng float64), error) {
+	switch {
+	case epsg == epsgWGS84:
+		return func(x, y float64) (float64, float64) { return y, x }, nil
+	case epsg == 3857 || epsg == 900913:
+		return webMercatorToWGS84, nil
+	case epsg > 32600 && epsg <= 32660:
+		zone := epsg - 32600
+		return func(x, y float64) (float64, float64) { return utmToWGS84(zone, true, x, y) }, nil
+	case epsg > 32700 && epsg <= 32760:
+		zone := epsg - 32700
+		return func(x, y float64) (float64, float64) { return utmToWGS84(zone, false, x, y) }, nil
+	default:
+		return nil, fmt.Errorf("unsupported CRS EPSG:%d (supported: 4326, 3857, UTM 326xx/327xx)", epsg)
+	}
+}
+
+// toWGS84 converts the raw values of the latitude/northing and
+// longitude/easting columns into a "lat,lng" string the API accepts.
+// WGS84 input is passed through untouched.
+func toWGS84(epsg int, latOrY, lngOrX string) (string, error) {
+	if epsg == epsgWGS84 {
+		return fmt.Sprintf("%s,%s", latOrY, lngOrX), nil
+	}
+
+	inverse, err := projection(epsg)
+	if err != nil {
+		return "", err
+	}
+
+	y, err := strconv.ParseFloat(strings.TrimSpace(latOrY), 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid northing %q", latOrY)
+	}
+	x, err := strconv.ParseFloat(strings.TrimSpace(lngOrX), 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid easting %q", lngOrX)
+	}
+
+	lat, lng := inverse(x, y)
+	if math.IsNaN(lat) || math.IsNaN(lng) || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return "", fmt.Errorf("coordinates %s,%s are outside EPSG:%d", latOrY, lngOrX, epsg)
+	}
+	return fmt.Sprintf("%.6f,%.6f", lat, lng), nil
+}
+
+// looksProjected reports whether a value pair cannot be WGS84 degrees, which
+// usually means the file holds projected metres and needs a CRS declared.
+func looksProjected(latOrY, lngOrX string) bool {
+	y, errY := strconv.ParseFloat(strings.TrimSpace(latOrY), 64)
+	x, errX := strconv.ParseFloat(strings.TrimSpace(lngOrX), 64)
+	return errY == nil && errX == nil && (math.Abs(y) > 90 || math.Abs(x) > 180)
+}
+
+func webMercatorToWGS84(x, y float64) (float64, float64) {
+	lng := x / wgs84A * 180 / math.Pi
+	lat := (2*math.Atan(math.Exp(y/wgs84A)) - math.Pi/2) * 180 / math.Pi
+	return lat, lng
+}
+
+// utmToWGS84 is the inverse Transverse Mercator series from Snyder,
+// "Map Projections: A Working Manual", accurate to well under a metre
+// within a zone.
+func utmToWGS84(zone int, north bool, easting, northing float64) (float64, float64) {
+	const k0 = 0.9996
+	e2 := wgs84F * (2 - wgs84F)
+	ep2 := e2 / (1 - e2)
+
+	x := easting - 500000
+	y := northing
+	if !north {
+		y -= 10000000
+	}
+
+	m := y / k0
+	mu := m / (wgs84A * (1 - e2/4 - 3*e2*e2/64 - 5*e2*e2*e2/256))
+	e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+	phi1 := mu +
+		(3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+		(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+		(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+		(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+
+	sin, cos, tan := math.Sin(phi1), math.Cos(phi1), math.Tan(phi1)
+	n1 := wgs84A / math.Sqrt(1-e2*sin*sin)
+	t1 := tan * tan
+	c1 := ep2 * cos * cos
+	r1 := wgs84A * (1 - e2) / math.Pow(1-e2*sin*sin, 1.5)
+	d := x / (n1 * k0)
+
+	lat := phi1 - (n1*tan/r1)*(d*d/2-
+		(5+3*t1+10*c1-4*c1*c1-9*ep2)*math.Pow(d, 4)/24+
+		(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*math.Pow(d, 6)/720)
+	lng := (d - (1+2*t1+c1)*math.Pow(d, 3)/6 +
+		(5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*math.Pow(d, 5)/120) / cos
+
+	centralMeridian := float64((zone-1)*6-180+3) * math.Pi / 180
+	return lat * 180 / math.Pi, (lng + centralMeridian) * 180 / math.Pi
+}
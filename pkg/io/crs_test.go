@@ -0,0 +1,54 @@
+package matrixio
+
+import (
+	"math"
+	"testing"
+)
+
+func TestWebMercatorToWGS84(t *testing.T) {
+	tests := []struct {
+		name     string
+		x, y     float64
+		lat, lng float64
+	}{
+		{"origin", 0, 0, 0, 0},
+		{"antimeridian", 20037508.342789244, 0, 0, 180},
+		{"top of the square world", 0, 20037508.342789244, 85.0511287798066, 0},
+		{"south west", -20037508.342789244 / 2, -20037508.342789244, -85.0511287798066, -90},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lat, lng := webMercatorToWGS84(tt.x, tt.y)
+			if math.Abs(lat-tt.lat) > 1e-9 || math.Abs(lng-tt.lng) > 1e-9 {
+				t.Errorf("webMercatorToWGS84(%v, %v) = %v, %v; want %v, %v", tt.x, tt.y, lat, lng, tt.lat, tt.lng)
+			}
+		})
+	}
+}
+
+func TestUTMToWGS84(t *testing.T) {
+	// Grid coordinates from an independent Krüger series forward projection.
+	tests := []struct {
+		name              string
+		zone              int
+		north             bool
+		easting, northing float64
+		lat, lng          float64
+	}{
+		{"equator on the central meridian", 32, true, 500000, 0, 0, 9},
+		{"equator from the south", 48, false, 500000, 10000000, 0, 105},
+		{"Paris", 31, true, 448252.001, 5411954.910, 48.8584, 2.2945},
+		{"Zurich", 32, true, 464130.212, 5245898.450, 47.3655625, 8.5249375},
+		{"Sydney", 56, false, 334900.570, 6252288.753, -33.8568, 151.2153},
+		{"Reykjavik", 27, true, 454138.377, 7113689.869, 64.1466, -21.9426},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lat, lng := utmToWGS84(tt.zone, tt.north, tt.easting, tt.northing)
+			// 1e-6 degrees is about ten centimetres.
+			if math.Abs(lat-tt.lat) > 1e-6 || math.Abs(lng-tt.lng) > 1e-6 {
+				t.Errorf("utmToWGS84(%d, %v, %v, %v) = %.7f, %.7f; want %v, %v", tt.zone, tt.north, tt.easting, tt.northing, lat, lng, tt.lat, tt.lng)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package matrixio
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// CSVConfig describes the dialect of the CSV files that are read and written.
+type CSVConfig struct {
+	// Delimiter is a single character; "tab" or `\t` select a tab.
+	Delimiter string `json:"delimiter,omitempty"`
+	// Quote is "minimal" (quote only when needed) or "all" (quote every field).
+	Quote string `json:"quote,omitempty"`
+	// LazyQuotes tolerates stray quotes inside unquoted fields on read.
+	LazyQuotes bool `json:"lazy_quotes,omitempty"`
+	// Encoding is a charset name such as windows-1252 or iso-8859-15.
+	// Empty means UTF-8.
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// CSVFlags registers the dialect flags on fs. The returned function copies
+// the flags that were set onto dst, leaving the rest untouched.
+func CSVFlags(fs *flag.FlagSet) func(dst *CSVConfig) {
+	var flags CSVConfig
+	fs.StringVar(&flags.Delimiter, "delimiter", ",", "CSV field delimiter, a single character or \"tab\"")
+	fs.StringVar(&flags.Quote, "quote", "minimal", "CSV output quoting: minimal or all")
+	fs.BoolVar(&flags.LazyQuotes, "lazy-quotes", false, "tolerate malformed quotes in input CSV")
+	fs.StringVar(&flags.Encoding, "encoding", "utf-8", "character encoding of CSV files, e.g. windows-1252")
+
+	return func(dst *CSVConfig) {
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "delimiter":
+				dst.Delimiter = flags.Delimiter
+			case "quote":
+				dst.Quote = flags.Quote
+			case "lazy-quotes":
+				dst.LazyQuotes = flags.LazyQuotes
+			case "encoding":
+				dst.Encoding = flags.Encoding
+			}
+		})
+	}
+}
+
+func (c CSVConfig) comma() (rune, error) {
+	switch c.Delimiter {
+	case "":
+		return ',', nil
+	case "tab", `\t`:
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(c.Delimiter)
+	if size != len(c.Delimiter) || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("invalid CSV delimiter %q", c.Delimiter)
+	}
+	return r, nil
+}
+
+func (c CSVConfig) encoding() (encoding.Encoding, error) {
+	if c.Encoding == "" {
+		return unicode.UTF8, nil
+	}
+	enc, err := htmlindex.Get(c.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported encoding %q", c.Encoding)
+	}
+	return enc, nil
+}
+
+// NewReader returns a CSV reader that decodes r from the configured charset.
+// A leading UTF-8 byte order mark is dropped.
+func (c CSVConfig) NewReader(r io.Reader) (*csv.Reader, error) {
+	comma, err := c.comma()
+	if err != nil {
+		return nil, err
+	}
+	enc, err := c.encoding()
+	if err != nil {
+		return nil, err
+	}
+	if enc == unicode.UTF8 {
+		enc = unicode.UTF8BOM
+	}
+
+	reader := csv.NewReader(enc.NewDecoder().Reader(r))
+	reader.Comma = comma
+	reader.LazyQuotes = c.LazyQuotes
+	return reader, nil
+}
+
+// WriteAll writes records to w in the configured dialect and charset.
+func (c CSVConfig) WriteAll(w io.Writer, records [][]string) error {
+	comma, err := c.comma()
+	if err != nil {
+		return err
+	}
+	enc, err := c.encoding()
+	if err != nil {
+		return err
+	}
+	out := encoding.ReplaceUnsupported(enc.NewEncoder()).Writer(w)
+
+	switch c.Quote {
+	case "", "minimal":
+		writer := csv.NewWriter(out)
+		writer.Comma = comma
+		return writer.WriteAll(records)
+	case "all":
+		return writeQuotedCSV(out, comma, records)
+	default:
+		return fmt.Errorf("unknown CSV quote mode %q", c.Quote)
+	}
+}
+
+// writeQuotedCSV writes every field quoted, which encoding/csv cannot do.
+func writeQuotedCSV(w io.Writer, comma rune, records [][]string) error {
+	bw := bufio.NewWriter(w)
+	for _, record := range records {
+		for i, field := range record {
+			if i > 0 {
+				bw.WriteRune(comma)
+			}
+			bw.WriteString(`"` + strings.ReplaceAll(field, `"`, `""`) + `"`)
+		}
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,127 @@
+package matrixio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// Encrypted outputs are named as the tools that decrypt them expect: .gpg
+// for OpenPGP, as gpg names them, and .age for age.
+const (
+	pgpExt = ".gpg"
+	ageExt = ".age"
+)
+
+// encryptTo holds the recipients outputs named .gpg or .age are encrypted
+// to. EncryptOutputs loads one kind or the other, never both.
+var encryptTo struct {
+	pgp openpgp.EntityList
+	age []age.Recipient
+}
+
+// EncryptOutputs loads the recipients encrypted outputs are written to. Each
+// is an age recipient (age1…), or a file or remote object holding either age
+// recipients, one per line as age-keygen -y prints them, or an armored or
+// binary OpenPGP public key, as gpg --export writes it. OpenPGP keys may be
+// RSA, NIST curve or Curve25519 keys, the default of recent gpg versions.
+// Any one recipient's private key decrypts the output. Recipients of the two
+// kinds cannot be mixed, since an output is either an age or an OpenPGP
+// file.
+func EncryptOutputs(recipients []string) error {
+	var keys openpgp.EntityList
+	var ageRecipients []age.Recipient
+	for _, spec := range recipients {
+		if strings.HasPrefix(spec, "age1") {
+			r, err := age.ParseX25519Recipient(spec)
+			if err != nil {
+				return fmt.Errorf("encryption recipient %s: %w", spec, err)
+			}
+			ageRecipients = append(ageRecipients, r)
+			continue
+		}
+		data, err := readInput(spec)
+		if err != nil {
+			return fmt.Errorf("reading encryption key: %w", err)
+		}
+		if rs, err := age.ParseRecipients(bytes.NewReader(data)); err == nil {
+			ageRecipients = append(ageRecipients, rs...)
+			continue
+		}
+		ring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+		if err != nil {
+			ring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+		}
+		if err != nil {
+			return fmt.Errorf("encryption key %s is neither age recipients nor an OpenPGP key: %w", spec, err)
+		}
+		keys = append(keys, ring...)
+	}
+	switch {
+	case len(keys) > 0 && len(ageRecipients) > 0:
+		return errors.New("age recipients and OpenPGP keys cannot be mixed; encrypt to one kind")
+	case len(keys) == 0 && len(ageRecipients) == 0:
+		return errors.New("no encryption keys given")
+	}
+	encryptTo.pgp, encryptTo.age = keys, ageRecipients
+	return nil
+}
+
+// EncryptedOutput returns output with .age or .gpg added, for the kind of
+// recipients EncryptOutputs loaded, unless it is already there. Only file
+// outputs, local or remote, can be encrypted.
+func EncryptedOutput(output string) (string, error) {
+	if !isFileOutput(output) {
+		return "", fmt.Errorf("only file outputs can be encrypted, not %s", output)
+	}
+	ext := pgpExt
+	if len(encryptTo.age) > 0 {
+		ext = ageExt
+	}
+	if _, had := cutEncryptedExt(inputName(output)); had == ext {
+		return output, nil
+	} else if had != "" {
+		return "", fmt.Errorf("%s is named as a %s file, but the recipients given encrypt %s files", output, had, ext)
+	}
+	return withExt(output, ext), nil
+}
+
+// cutEncryptedExt returns name without its .gpg or .age extension, and the
+// extension it had, if any.
+func cutEncryptedExt(name string) (string, string) {
+	for _, ext := range []string{pgpExt, ageExt} {
+		if len(name) >= len(ext) && strings.EqualFold(name[len(name)-len(ext):], ext) {
+			return name[:len(name)-len(ext)], ext
+		}
+	}
+	return name, ""
+}
+
+// encryptedWrite wraps write so that what it writes is encrypted, as ext
+// (.gpg or .age) asks, to the recipients loaded by EncryptOutputs.
+func encryptedWrite(ext string, write func(w io.Writer) error) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		var plaintext io.WriteCloser
+		var err error
+		switch {
+		case ext == ageExt && len(encryptTo.age) > 0:
+			plaintext, err = age.Encrypt(w, encryptTo.age...)
+		case ext == pgpExt && len(encryptTo.pgp) > 0:
+			plaintext, err = openpgp.Encrypt(w, encryptTo.pgp, nil, &openpgp.FileHints{IsBinary: true}, nil)
+		default:
+			return fmt.Errorf("no encryption recipients loaded for a %s output", ext)
+		}
+		if err != nil {
+			return err
+		}
+		if err := write(plaintext); err != nil {
+			return err
+		}
+		return plaintext.Close()
+	}
+}
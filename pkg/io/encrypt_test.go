@@ -0,0 +1,170 @@
+package matrixio
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// writeEncrypted writes contents, gzipped, to out.csv.gz in dir, encrypted to
+// the loaded recipients, and returns the name EncryptedOutput gave it.
+func writeEncrypted(t *testing.T, dir, contents string) string {
+	t.Helper()
+	output, err := EncryptedOutput(filepath.Join(dir, "out.csv.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WriteOutput(output, func(w io.Writer) error {
+		_, err := io.WriteString(w, contents)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenInput(output); err == nil {
+		t.Error("OpenInput read an encrypted output")
+	}
+	return output
+}
+
+// gunzip returns the uncompressed contents of r.
+func gunzip(t *testing.T, r io.Reader) string {
+	t.Helper()
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(got)
+}
+
+func TestEncryptedOutputOpenPGP(t *testing.T) {
+	defer func() { encryptTo.pgp, encryptTo.age = nil, nil }()
+	const contents = "SITE_CODE,DISTANCE_KM\nS1,20.38\n"
+	for _, tt := range []struct {
+		name   string
+		config *packet.Config
+	}{
+		{"RSA", &packet.Config{Algorithm: packet.PubKeyAlgoRSA, RSABits: 2048}},
+		// What gpg makes by default since 2.3.
+		{"Curve25519", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}},
+	} {
+		entity, err := openpgp.NewEntity("Ops", "", "ops@example.com", tt.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := t.TempDir()
+		keyFile := filepath.Join(dir, "ops.asc")
+		f, err := os.Create(keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := armor.Encode(f, openpgp.PublicKeyType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := entity.Serialize(w); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		f.Close()
+
+		if err := EncryptOutputs([]string{keyFile}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		output := writeEncrypted(t, dir, contents)
+		if filepath.Base(output) != "out.csv.gz.gpg" {
+			t.Fatalf("%s: EncryptedOutput named the output %s", tt.name, output)
+		}
+		ciphertext, err := os.Open(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		md, err := openpgp.ReadMessage(ciphertext, openpgp.EntityList{entity}, nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := gunzip(t, md.UnverifiedBody); got != contents {
+			t.Errorf("%s: decrypted %q, want %q", tt.name, got, contents)
+		}
+		ciphertext.Close()
+	}
+}
+
+func TestEncryptedOutputAge(t *testing.T) {
+	defer func() { encryptTo.pgp, encryptTo.age = nil, nil }()
+	const contents = "SITE_CODE,DISTANCE_KM\nS1,20.38\n"
+	alice, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	// One recipient given as is, the other in a recipients file.
+	recipientsFile := filepath.Join(dir, "recipients.txt")
+	if err := os.WriteFile(recipientsFile, []byte("# bob\n"+bob.Recipient().String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncryptOutputs([]string{alice.Recipient().String(), recipientsFile}); err != nil {
+		t.Fatal(err)
+	}
+	output := writeEncrypted(t, dir, contents)
+	if filepath.Base(output) != "out.csv.gz.age" {
+		t.Fatalf("EncryptedOutput named the output %s", output)
+	}
+	if _, err := EncryptedOutput(filepath.Join(dir, "out.csv.gpg")); err == nil {
+		t.Error("an output named .gpg was accepted for age recipients")
+	}
+
+	for _, identity := range []*age.X25519Identity{alice, bob} {
+		ciphertext, err := os.Open(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plaintext, err := age.Decrypt(ciphertext, identity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := gunzip(t, plaintext); got != contents {
+			t.Errorf("decrypted %q, want %q", got, contents)
+		}
+		ciphertext.Close()
+	}
+}
+
+func TestEncryptOutputsRejectsMixedRecipients(t *testing.T) {
+	defer func() { encryptTo.pgp, encryptTo.age = nil, nil }()
+	entity, err := openpgp.NewEntity("Ops", "", "ops@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "ops.gpg")
+	f, err := os.Create(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := EncryptOutputs([]string{keyFile, identity.Recipient().String()}); err == nil {
+		t.Error("mixed age and OpenPGP recipients were accepted")
+	}
+}
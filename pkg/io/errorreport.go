@@ -0,0 +1,141 @@
+package matrixio
+
+import (
+	"io"
+	"os"
+
+	"routes/pkg/matrix"
+)
+
+// WriteErrorReport writes the failed results to path as CSV in the input's
+// dialect, so the rows can be fixed and fed back in: the input columns as
+// read, then ERROR_STATUS and ERROR_MESSAGE. Rows read from JSON have no
+// input columns and get their route fields instead. It returns the number of
+// rows written and leaves path untouched when every row succeeded.
+func WriteErrorReport(path string, csvCfg CSVConfig, results []matrix.Result) (int, error) {
+	var failed []matrix.Result
+	for _, r := range results {
+		if r.Status != "OK" {
+			failed = append(failed, r)
+		}
+	}
+	if len(failed) == 0 {
+		return 0, nil
+	}
+
+	records := [][]string{errorReportHeader(failed[0])}
+	for _, r := range failed {
+		records = append(records, errorReportRecord(r))
+	}
+
+	err := WriteOutput(path, func(w io.Writer) error {
+		return csvCfg.WriteAll(w, records)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(failed), nil
+}
+
+// ErrorReport builds an error report one failed result at a time, for
+// streams whose failures need not fit in memory. Rows are spooled to a
+// temporary file as they are added and only reach the report on Commit, so
+// like WriteErrorReport it leaves the report untouched when every row
+// succeeded.
+type ErrorReport struct {
+	dialect CSVConfig
+	spool   *os.File
+	rows    int
+}
+
+// NewErrorReport returns an empty report written in the given dialect.
+func NewErrorReport(csvCfg CSVConfig) *ErrorReport {
+	return &ErrorReport{dialect: csvCfg}
+}
+
+// Add spools r if it failed. The header follows the first failed row.
+func (e *ErrorReport) Add(r matrix.Result) error {
+	if r.Status == "OK" {
+		return nil
+	}
+	var records [][]string
+	if e.spool == nil {
+		spool, err := os.CreateTemp("", "route-dm-errors-*.csv")
+		if err != nil {
+			return err
+		}
+		e.spool = spool
+		records = append(records, errorReportHeader(r))
+	}
+	if err := e.dialect.WriteAll(e.spool, append(records, errorReportRecord(r))); err != nil {
+		return err
+	}
+	e.rows++
+	return nil
+}
+
+// Rows is the number of failed results added.
+func (e *ErrorReport) Rows() int {
+	return e.rows
+}
+
+// Commit writes the spooled rows to path, if there are any.
+func (e *ErrorReport) Commit(path string) error {
+	if e.spool == nil {
+		return nil
+	}
+	if _, err := e.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return WriteOutput(path, func(w io.Writer) error {
+		_, err := io.Copy(w, e.spool)
+		return err
+	})
+}
+
+// Close removes the spool file.
+func (e *ErrorReport) Close() error {
+	if e.spool == nil {
+		return nil
+	}
+	e.spool.Close()
+	return os.Remove(e.spool.Name())
+}
+
+func errorReportHeader(r matrix.Result) []string {
+	var header []string
+	for _, f := range errorReportInput(r.Route) {
+		header = append(header, f.Name)
+	}
+	return append(header, "ERROR_STATUS", "ERROR_MESSAGE")
+}
+
+func errorReportRecord(r matrix.Result) []string {
+	var record []string
+	for _, f := range errorReportInput(r.Route) {
+		record = append(record, f.Value)
+	}
+	return append(record, r.Status, r.Error)
+}
+
+// errorReportInput returns the input columns of route, falling back to its
+// parsed fields.
+func errorReportInput(route matrix.Route) []matrix.Field {
+	if route.Input != nil {
+		return route.Input
+	}
+	origin, destination := route.Origin, route.Destination
+	if origin == "" {
+		origin = route.OriginAddress
+	}
+	if destination == "" {
+		destination = route.DestinationAddress
+	}
+	return []matrix.Field{
+		{Name: "SITE_CODE", Value: route.SiteCode},
+		{Name: "SITE_NAME", Value: route.SiteName},
+		{Name: "TERMINAL_CODE", Value: route.TerminalCode},
+		{Name: "ORIGIN", Value: origin},
+		{Name: "DESTINATION", Value: destination},
+	}
+}
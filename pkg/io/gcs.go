@@ -0,0 +1,133 @@
+package matrixio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	gcsBaseURL = "https://storage.googleapis.com"
+	gcsScope   = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+// gcsStore reads and writes gs://BUCKET/OBJECT objects through the Cloud
+// Storage JSON API with Application Default Credentials, such as a Cloud
+// Run job's service account. STORAGE_EMULATOR_HOST points it at an
+// emulator, without credentials.
+type gcsStore struct{}
+
+// gcsClient is shared by every request, so credentials are looked up and
+// tokens fetched once per run.
+var gcsClient = sync.OnceValues(func() (*http.Client, error) {
+	if os.Getenv("STORAGE_EMULATOR_HOST") != "" {
+		return storageClient, nil
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, storageClient)
+	creds, err := google.FindDefaultCredentials(ctx, gcsScope)
+	if err != nil {
+		return nil, fmt.Errorf("finding Google credentials: %w", err)
+	}
+	return oauth2.NewClient(ctx, creds.TokenSource), nil
+})
+
+// gcsEndpoint returns the API base URL.
+func gcsEndpoint() string {
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		return strings.TrimSuffix(host, "/")
+	}
+	return gcsBaseURL
+}
+
+func (gcsStore) open(u *url.URL) (io.ReadCloser, error) {
+	bucket, object, err := gcsObject(u)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", gcsEndpoint(), url.PathEscape(bucket), url.PathEscape(object))
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := gcsDo(u, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (gcsStore) put(u *url.URL, r io.ReadSeeker, size int64) error {
+	bucket, object, err := gcsObject(u)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s", gcsEndpoint(), url.PathEscape(bucket), url.QueryEscape(object))
+	req, err := http.NewRequest(http.MethodPost, endpoint, io.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	contentType := mime.TypeByExtension(path.Ext(object))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := gcsDo(u, req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func gcsObject(u *url.URL) (bucket, object string, err error) {
+	bucket, object = u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || object == "" {
+		return "", "", fmt.Errorf("%s: want gs://BUCKET/OBJECT", u)
+	}
+	return bucket, object, nil
+}
+
+// gcsDo sends req and returns the successful response. A missing object
+// gives an error wrapping fs.ErrNotExist.
+func gcsDo(u *url.URL, req *http.Request) (*http.Response, error) {
+	client, err := gcsClient()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &fs.PathError{Op: strings.ToLower(req.Method), Path: u.String(), Err: fs.ErrNotExist}
+	}
+	var apiErr struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+		return nil, fmt.Errorf("%s: %s: %s", u, resp.Status, apiErr.Error.Message)
+	}
+	return nil, fmt.Errorf("%s: %s", u, resp.Status)
+}
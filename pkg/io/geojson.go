@@ -0,0 +1,134 @@
+package matrixio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"routes/pkg/matrix"
+)
+
+type geoJSONFeature struct {
+	Type       string           `json:"type"`
+	Geometry   *geoJSONGeometry `json:"geometry"`
+	Properties map[string]any   `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+// writeResultsToGeoJSON writes a FeatureCollection with one LineString per
+// result, decoded from its POLYLINE column (see -with-geometry), and one
+// Point per distinct terminal and site. Results without a polyline get a null
+// geometry so their distances are still listed.
+func writeResultsToGeoJSON(w io.Writer, units []DistanceUnit, results []matrix.Result, failed FailurePolicy) error {
+	features := []geoJSONFeature{}
+	points := make(map[string]bool)
+	addPoint := func(role, code, name, location string) {
+		key := role + "\x00" + code + "\x00" + location
+		lng, lat, ok := lngLat(location)
+		if points[key] || !ok {
+			return
+		}
+		points[key] = true
+		properties := map[string]any{"role": role, "code": code}
+		if name != "" {
+			properties["name"] = name
+		}
+		features = append(features, geoJSONFeature{
+			Type:       "Feature",
+			Geometry:   &geoJSONGeometry{Type: "Point", Coordinates: []float64{lng, lat}},
+			Properties: properties,
+		})
+	}
+
+	for _, r := range failed.Filter(results) {
+		properties := map[string]any{
+			"site_code":     r.SiteCode,
+			"site_name":     r.SiteName,
+			"terminal_code": r.TerminalCode,
+		}
+		addResultValues(properties, units, r, failed)
+
+		var geometry *geoJSONGeometry
+		for _, f := range r.Extra {
+			if f.Name != "POLYLINE" {
+				properties[strings.ToLower(f.Name)] = f.Value
+				continue
+			}
+			if f.Value == "N/A" {
+				continue
+			}
+			line, err := decodePolyline(f.Value)
+			if err != nil {
+				return fmt.Errorf("site %s from terminal %s: %w", r.SiteCode, r.TerminalCode, err)
+			}
+			geometry = &geoJSONGeometry{Type: "LineString", Coordinates: line}
+		}
+		features = append(features, geoJSONFeature{Type: "Feature", Geometry: geometry, Properties: properties})
+
+		addPoint("origin", r.TerminalCode, "", r.Origin)
+		addPoint("destination", r.SiteCode, r.SiteName, r.Destination)
+	}
+
+	enc := json.NewEncoder(w)
+	return enc.Encode(map[string]any{"type": "FeatureCollection", "features": features})
+}
+
+// lngLat reads a "lat,lng" location into GeoJSON's longitude-first order.
+func lngLat(location string) (float64, float64, bool) {
+	latText, lngText, ok := strings.Cut(location, ",")
+	if !ok {
+		return 0, 0, false
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latText), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	lng, err := strconv.ParseFloat(strings.TrimSpace(lngText), 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return lng, lat, true
+}
+
+// decodePolyline decodes Google's encoded polyline format into [lng, lat]
+// pairs.
+func decodePolyline(encoded string) ([][]float64, error) {
+	var line [][]float64
+	var lat, lng int
+	for i := 0; i < len(encoded); {
+		var deltas [2]int
+		for d := range deltas {
+			var result, shift int
+			for {
+				if i >= len(encoded) {
+					return nil, fmt.Errorf("truncated polyline")
+				}
+				b := int(encoded[i]) - 63
+				i++
+				if b < 0 || b > 63 {
+					return nil, fmt.Errorf("invalid polyline character %q", encoded[i-1])
+				}
+				result |= (b & 0x1f) << shift
+				shift += 5
+				if b < 0x20 {
+					break
+				}
+			}
+			if result&1 != 0 {
+				deltas[d] = ^(result >> 1)
+			} else {
+				deltas[d] = result >> 1
+			}
+		}
+		lat += deltas[0]
+		lng += deltas[1]
+		line = append(line, []float64{float64(lng) / 1e5, float64(lat) / 1e5})
+	}
+	return line, nil
+}
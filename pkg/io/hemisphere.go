@@ -0,0 +1,96 @@
+package matrixio
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// signRules normalizes the sign of WGS84 coordinates.
+type signRules struct {
+	lat, lng axisSign
+}
+
+// axisSign describes one axis: its positive and negative hemisphere letters
+// and the hemisphere forced on values that carry no indicator (0 for none).
+type axisSign struct {
+	positive, negative byte
+	force              byte
+}
+
+func (m ColumnMapping) signRules() (signRules, error) {
+	rules := signRules{
+		lat: axisSign{positive: 'N', negative: 'S'},
+		lng: axisSign{positive: 'E', negative: 'W'},
+	}
+	for _, r := range []struct {
+		name  string
+		value string
+		axis  *axisSign
+	}{
+		{"lat_sign", m.LatSign, &rules.lat},
+		{"lng_sign", m.LngSign, &rules.lng},
+	} {
+		if r.value == "" {
+			continue
+		}
+		h, err := r.axis.hemisphere(r.value)
+		if err != nil {
+			return rules, fmt.Errorf("%s: %w", r.name, err)
+		}
+		r.axis.force = h
+	}
+	return rules, nil
+}
+
+// hemisphere reads an indicator such as "S" or "south" for this axis.
+func (a axisSign) hemisphere(indicator string) (byte, error) {
+	word := strings.ToUpper(strings.TrimSpace(indicator))
+	names := map[byte]string{'N': "NORTH", 'S': "SOUTH", 'E': "EAST", 'W': "WEST"}
+	for _, h := range []byte{a.positive, a.negative} {
+		if word == string(h) || word == names[h] {
+			return h, nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not %c or %c", indicator, a.positive, a.negative)
+}
+
+// normalize applies the hemisphere indicator to value. The indicator comes
+// from the hemisphere column, a letter attached to the value ("6.2S" or
+// "S 6.2"), or the forced hemisphere, in that order. Values with no
+// indicator at all are returned unchanged.
+func (a axisSign) normalize(value, indicator string) (string, error) {
+	number := value
+	if indicator == "" && value != "" {
+		if h, err := a.hemisphere(value[len(value)-1:]); err == nil {
+			indicator, number = string(h), value[:len(value)-1]
+		} else if h, err := a.hemisphere(value[:1]); err == nil {
+			indicator, number = string(h), value[1:]
+		}
+	}
+
+	var h byte
+	switch {
+	case indicator != "":
+		var err error
+		if h, err = a.hemisphere(indicator); err != nil {
+			return "", err
+		}
+	case a.force != 0:
+		h = a.force
+	default:
+		return value, nil
+	}
+
+	v, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid coordinate %q", value)
+	}
+	if v < 0 {
+		v = -v
+	}
+	if h == a.negative {
+		v = -v
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64), nil
+}
@@ -0,0 +1,85 @@
+package matrixio
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAxisSignNormalize(t *testing.T) {
+	lat := axisSign{positive: 'N', negative: 'S'}
+	south := axisSign{positive: 'N', negative: 'S', force: 'S'}
+	lng := axisSign{positive: 'E', negative: 'W'}
+	tests := []struct {
+		name      string
+		axis      axisSign
+		value     string
+		indicator string
+		want      string
+		wantErr   bool
+	}{
+		{"no indicator", lat, "-6.2", "", "-6.2", false},
+		{"no indicator keeps the text", lat, "6.20", "", "6.20", false},
+		{"column south", lat, "6.2", "S", "-6.2", false},
+		{"column word", lat, "6.2", " south ", "-6.2", false},
+		{"column overrides the sign", lat, "-6.2", "N", "6.2", false},
+		{"column lower case", lng, "106.8", "w", "-106.8", false},
+		{"suffix", lat, "6.2S", "", "-6.2", false},
+		{"prefix with a space", lng, "W 106.8", "", "-106.8", false},
+		{"suffix alongside a column", lat, "6.2S", "N", "", true},
+		{"forced", south, "6.2", "", "-6.2", false},
+		{"indicator wins over forced", south, "6.2", "N", "6.2", false},
+		{"wrong axis", lat, "6.2", "E", "", true},
+		{"not a number", lat, "six", "S", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.axis.normalize(tt.value, tt.indicator)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalize(%q, %q) error = %v, want error %v", tt.value, tt.indicator, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("normalize(%q, %q) = %q, want %q", tt.value, tt.indicator, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColumnMappingSignRules(t *testing.T) {
+	rules, err := ColumnMapping{LatSign: "south", LngSign: "E"}.signRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules.lat.force != 'S' || rules.lng.force != 'E' {
+		t.Errorf("forced hemispheres %c %c, want S E", rules.lat.force, rules.lng.force)
+	}
+	if _, err := (ColumnMapping{LatSign: "west"}).signRules(); err == nil || !strings.HasPrefix(err.Error(), "lat_sign: ") {
+		t.Errorf("lat_sign west: got %v", err)
+	}
+}
+
+func TestReadRoutesWithHemispheres(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Input = writeInput(t, "routes.csv", `SITE_CODE,SITE_NAME,LAT,LNG,TERMINAL_CODE,TLAT,TLNG,NS,EW
+S1,Alpha,6.2,106.8,T1,6.3S,106.9E,S,E
+S2,Beta,6.25,106.85,T1,S 6.5,107.2,south,east
+`)
+	cfg.Columns.DestinationLatHemisphere = "NS"
+	cfg.Columns.DestinationLngHemisphere = "EW"
+	routes, err := ReadRoutes(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []struct{ origin, destination string }{
+		{"-6.3,106.9", "-6.2,106.8"},
+		{"-6.5,107.2", "-6.25,106.85"},
+	} {
+		if r := routes[i]; r.Origin != want.origin || r.Destination != want.destination {
+			t.Errorf("%s: %s -> %s, want %s -> %s", r.SiteCode, r.Origin, r.Destination, want.origin, want.destination)
+		}
+	}
+
+	cfg.Columns.LatSign = "up"
+	if _, err := ReadRoutes(cfg); err == nil {
+		t.Error("lat_sign up was accepted")
+	}
+}
@@ -0,0 +1,65 @@
+package matrixio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// httpStore reads inputs from http:// and https:// URLs, such as an
+// internal API's export, fetched afresh on every run. INPUT_BEARER_TOKEN,
+// when set, is sent as an Authorization: Bearer header. It cannot be
+// written to.
+type httpStore struct{}
+
+func (httpStore) open(u *url.URL) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("INPUT_BEARER_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := storageClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp.Body, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return nil, &fs.PathError{Op: "get", Path: u.Redacted(), Err: fs.ErrNotExist}
+	}
+	return nil, fmt.Errorf("%s: %s", u.Redacted(), resp.Status)
+}
+
+func (httpStore) put(u *url.URL, r io.ReadSeeker, size int64) error {
+	return errReadOnly
+}
+
+var errReadOnly = errors.New("http URLs can only be read")
+
+// CheckWritable returns an error for an output that can only be read, an
+// http URL, so a run fails before querying rather than when it saves.
+func CheckWritable(output string) error {
+	if store, u, ok := remote(output); ok {
+		if _, ok := store.(httpStore); ok {
+			return fmt.Errorf("%s: %w", u.Redacted(), errReadOnly)
+		}
+	}
+	return nil
+}
+
+// inputName returns the path part of a remote URL, which carries the file
+// extension the input format is told by, or name itself for a local file.
+func inputName(name string) string {
+	if _, u, ok := remote(name); ok {
+		return u.Path
+	}
+	return name
+}
@@ -0,0 +1,178 @@
+package matrixio
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"routes/pkg/matrix"
+)
+
+// jsonRoute is the schema of one JSON input object:
+//
+//	{"site_code": "S1", "site_name": "Alpha", "terminal_code": "T1",
+//	 "origin": "-6.30,106.90", "destination": {"lat": -6.2, "lng": 106.8},
+//	 "waypoints": ["-6.25,106.85"]}
+//
+// site_code, terminal_code, origin and destination are required; site_name
+// and waypoints are optional. Unknown fields are rejected so typos do not go unnoticed.
+type jsonRoute struct {
+	SiteCode     *string      `json:"site_code"`
+	SiteName     string       `json:"site_name"`
+	TerminalCode *string      `json:"terminal_code"`
+	Origin       *jsonLatLng  `json:"origin"`
+	Destination  *jsonLatLng  `json:"destination"`
+	Waypoints    []jsonLatLng `json:"waypoints"`
+}
+
+// jsonLatLng accepts a location either as a "lat,lng" string or as an object
+// with numeric lat and lng members. In a projected CRS, lat holds the
+// northing and lng the easting.
+type jsonLatLng struct {
+	Lat, Lng string
+}
+
+func (l *jsonLatLng) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		lat, lng, ok := strings.Cut(s, ",")
+		if !ok {
+			return fmt.Errorf("location %q must be \"lat,lng\"", s)
+		}
+		l.Lat, l.Lng = strings.TrimSpace(lat), strings.TrimSpace(lng)
+		return nil
+	}
+
+	var obj struct {
+		Lat *float64 `json:"lat"`
+		Lng *float64 `json:"lng"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&obj); err != nil {
+		return fmt.Errorf("location must be a \"lat,lng\" string or {\"lat\": ..., \"lng\": ...}: %w", err)
+	}
+	if obj.Lat == nil || obj.Lng == nil {
+		return fmt.Errorf("location object needs both lat and lng")
+	}
+	l.Lat = strconv.FormatFloat(*obj.Lat, 'f', -1, 64)
+	l.Lng = strconv.FormatFloat(*obj.Lng, 'f', -1, 64)
+	return nil
+}
+
+func isJSONFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(formatName(inputName(filename)))) {
+	case ".json", ".jsonl":
+		return true
+	}
+	return false
+}
+
+// readRoutesFromJSON reads a JSON array of route objects, or a stream of
+// objects such as a JSON Lines file.
+func readRoutesFromJSON(filename string, cfg Config) ([]matrix.Route, error) {
+	data, err := readInput(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	epsg, err := ParseEPSG(cfg.CRS)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPrecision(cfg.CoordPrecision); err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	isArray := bytes.HasPrefix(bytes.TrimSpace(data), []byte("["))
+	if isArray {
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+	}
+
+	var routes []matrix.Route
+	invalid := 0
+	for n := 1; ; n++ {
+		if isArray && !dec.More() {
+			break
+		}
+		var rec jsonRoute
+		if err := dec.Decode(&rec); err != nil {
+			if errors.Is(err, io.EOF) && !isArray {
+				break
+			}
+			return nil, fmt.Errorf("record %d: %w", n, err)
+		}
+
+		route, err := rec.route(epsg)
+		if err != nil {
+			err = fmt.Errorf("record %d: %w", n, err)
+			if cfg.SkipInvalid {
+				slog.Warn("skipping invalid record", "err", err)
+			} else {
+				slog.Error("invalid record", "err", err)
+				invalid++
+			}
+			continue
+		}
+		roundRoute(&route, cfg.CoordPrecision)
+		routes = append(routes, route)
+	}
+
+	return validRoutes(routes, invalid)
+}
+
+func (r jsonRoute) route(epsg int) (matrix.Route, error) {
+	var missing []string
+	if r.SiteCode == nil {
+		missing = append(missing, "site_code")
+	}
+	if r.TerminalCode == nil {
+		missing = append(missing, "terminal_code")
+	}
+	if r.Origin == nil {
+		missing = append(missing, "origin")
+	}
+	if r.Destination == nil {
+		missing = append(missing, "destination")
+	}
+	if len(missing) > 0 {
+		return matrix.Route{}, fmt.Errorf("missing required field(s): %s", strings.Join(missing, ", "))
+	}
+
+	origin, err := RowCoordinate(epsg, r.Origin.Lat, r.Origin.Lng)
+	if err != nil {
+		return matrix.Route{}, fmt.Errorf("origin: %w", err)
+	}
+	destination, err := RowCoordinate(epsg, r.Destination.Lat, r.Destination.Lng)
+	if err != nil {
+		return matrix.Route{}, fmt.Errorf("destination: %w", err)
+	}
+
+	var waypoints []string
+	for i, w := range r.Waypoints {
+		coordinate, err := RowCoordinate(epsg, w.Lat, w.Lng)
+		if err != nil {
+			return matrix.Route{}, fmt.Errorf("waypoint %d: %w", i+1, err)
+		}
+		waypoints = append(waypoints, coordinate)
+	}
+
+	return matrix.Route{
+		SiteCode:     *r.SiteCode,
+		SiteName:     r.SiteName,
+		TerminalCode: *r.TerminalCode,
+		Origin:       origin,
+		Destination:  destination,
+		Waypoints:    waypoints,
+	}, nil
+}
@@ -0,0 +1,99 @@
+package matrixio
+
+import (
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"routes/pkg/matrix"
+)
+
+// writeInput writes contents to name in a temporary directory, compressed
+// as its extension asks, and returns the path.
+func writeInput(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := WriteOutput(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, contents)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadRoutesFromJSON(t *testing.T) {
+	want := []matrix.Route{
+		{SiteCode: "S1", SiteName: "Alpha", TerminalCode: "T1", Origin: "-6.30,106.90", Destination: "-6.2,106.8", Waypoints: []string{"-6.25,106.85"}},
+		{SiteCode: "S2", TerminalCode: "T1", Origin: "-6.5,107.2", Destination: "-6.25,106.85"},
+	}
+	tests := []struct {
+		name     string
+		file     string
+		contents string
+	}{
+		{"array", "routes.json", `[
+  {"site_code": "S1", "site_name": "Alpha", "terminal_code": "T1",
+   "origin": "-6.30, 106.90", "destination": {"lat": -6.2, "lng": 106.8},
+   "waypoints": ["-6.25,106.85"]},
+  {"site_code": "S2", "terminal_code": "T1", "origin": {"lat": -6.5, "lng": 107.2}, "destination": "-6.25,106.85"}
+]`},
+		{"JSON Lines", "routes.jsonl", `{"site_code": "S1", "site_name": "Alpha", "terminal_code": "T1", "origin": "-6.30,106.90", "destination": "-6.2,106.8", "waypoints": [{"lat": -6.25, "lng": 106.85}]}
+{"site_code": "S2", "terminal_code": "T1", "origin": "-6.5,107.2", "destination": "-6.25,106.85"}
+`},
+		{"compressed JSON Lines", "routes.jsonl.gz", `{"site_code": "S1", "site_name": "Alpha", "terminal_code": "T1", "origin": "-6.30,106.90", "destination": "-6.2,106.8", "waypoints": ["-6.25,106.85"]}
+{"site_code": "S2", "terminal_code": "T1", "origin": "-6.5,107.2", "destination": "-6.25,106.85"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Input = writeInput(t, tt.file, tt.contents)
+			got, err := ReadRoutes(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("routes =\n%+v\nwant\n%+v", got, want)
+			}
+		})
+	}
+}
+
+func TestReadRoutesFromJSONErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		skip     bool
+		want     string // in the error, or "" when the read succeeds
+	}{
+		{"unknown field", `{"site_code": "S1", "terminal_code": "T1", "origin": "1,2", "destination": "3,4", "sitename": "Alpha"}`, false, `record 1: json: unknown field "sitename"`},
+		{"location without a comma", `{"site_code": "S1", "terminal_code": "T1", "origin": "1 2", "destination": "3,4"}`, false, `record 1: location "1 2" must be "lat,lng"`},
+		{"location object missing lng", `{"site_code": "S1", "terminal_code": "T1", "origin": {"lat": 1}, "destination": "3,4"}`, false, "needs both lat and lng"},
+		{"missing fields", `{"site_code": "S1", "origin": "1,2"}`, false, "1 invalid row(s)"},
+		{"latitude out of range", `{"site_code": "S1", "terminal_code": "T1", "origin": "91,2", "destination": "3,4"}`, false, "1 invalid row(s)"},
+		{"invalid record skipped", `{"site_code": "S1", "origin": "1,2"}
+{"site_code": "S2", "terminal_code": "T1", "origin": "1,2", "destination": "3,4"}`, true, ""},
+		{"nothing valid", `[]`, false, "no valid rows"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Input = writeInput(t, "routes.jsonl", tt.contents)
+			cfg.SkipInvalid = tt.skip
+			routes, err := ReadRoutes(cfg)
+			switch {
+			case tt.want == "" && err != nil:
+				t.Fatal(err)
+			case tt.want == "":
+				if len(routes) != 1 || routes[0].SiteCode != "S2" {
+					t.Errorf("routes = %+v, want only S2", routes)
+				}
+			case err == nil || !strings.Contains(err.Error(), tt.want):
+				t.Errorf("error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package matrixio
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// defaultKafkaGroup is the consumer group of kafka:// URLs without a group
+// parameter, so workers share a topic's partitions by default.
+const defaultKafkaGroup = "route-dm"
+
+// kafkaBackend reaches kafka://BROKER,BROKER/TOPIC topics. Consumers read
+// as the consumer group of the group parameter and commit each message's
+// offset when it is acknowledged; publishers wait for every in-sync
+// replica to take a message.
+type kafkaBackend struct{}
+
+func (kafkaBackend) consumer(u *url.URL) (QueueConsumer, error) {
+	brokers, topic, err := kafkaTopic(u)
+	if err != nil {
+		return nil, err
+	}
+	group := u.Query().Get("group")
+	if group == "" {
+		group = defaultKafkaGroup
+	}
+	return &kafkaConsumer{r: kafka.NewReader(kafka.ReaderConfig{Brokers: brokers, GroupID: group, Topic: topic})}, nil
+}
+
+func (kafkaBackend) publisher(u *url.URL) (QueuePublisher, error) {
+	brokers, topic, err := kafkaTopic(u)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaPublisher{w: kafka.NewWriter(kafka.WriterConfig{Brokers: brokers, Topic: topic, RequiredAcks: -1})}, nil
+}
+
+// kafkaTopic returns the brokers and topic of a kafka:// URL.
+func kafkaTopic(u *url.URL) (brokers []string, topic string, err error) {
+	topic = strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || topic == "" || strings.Contains(topic, "/") {
+		return nil, "", fmt.Errorf("%s: want kafka://BROKER,BROKER/TOPIC", u)
+	}
+	return strings.Split(u.Host, ","), topic, nil
+}
+
+type kafkaConsumer struct {
+	r *kafka.Reader
+}
+
+func (c *kafkaConsumer) Receive(ctx context.Context) (*QueueMessage, error) {
+	m, err := c.r.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &QueueMessage{
+		Body: m.Value,
+		ack:  func(ctx context.Context) error { return c.r.CommitMessages(ctx, m) },
+		// The offset stays uncommitted, so the group reads the message
+		// again once the consumer is closed.
+		nack: func(context.Context) error { return nil },
+	}, nil
+}
+
+func (c *kafkaConsumer) Close() error {
+	return c.r.Close()
+}
+
+type kafkaPublisher struct {
+	w *kafka.Writer
+}
+
+func (p *kafkaPublisher) Publish(ctx context.Context, body []byte) error {
+	return p.w.WriteMessages(ctx, kafka.Message{Value: body})
+}
+
+func (p *kafkaPublisher) Close() error {
+	return p.w.Close()
+}
@@ -0,0 +1,66 @@
+package matrixio
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"routes/pkg/matrix"
+)
+
+// MergeResults writes results into the existing CSV output of cfg in place
+// of its rows with the same site and terminal codes, appending those it
+// has no row for. It is how re-queried failures are folded back into the
+// output of the run that reported them, which must have the same columns.
+func MergeResults(cfg Config, results []matrix.Result) error {
+	if cfg.outputFormat() != "csv" || cfg.Output == "-" || strings.Contains(cfg.Output, "://") {
+		return fmt.Errorf("merging needs a CSV output file, not %s", cfg.Output)
+	}
+	units, err := ParseDistanceUnits(cfg.DistanceUnits)
+	if err != nil {
+		return err
+	}
+
+	file, err := OpenInput(cfg.Output)
+	if err != nil {
+		return err
+	}
+	reader, err := cfg.CSV.NewReader(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	records, err := reader.ReadAll()
+	file.Close()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("%s is empty", cfg.Output)
+	}
+
+	results = cfg.OnFailure.Filter(results)
+	if len(results) > 0 && !slices.Equal(records[0], ResultHeader(units, results[0])) {
+		return fmt.Errorf("%s has other columns than this run; use the same options as the run that wrote it", cfg.Output)
+	}
+	rows := make(map[string][]int) // record indexes by site and terminal code
+	for i, record := range records[1:] {
+		k := record[0] + "\x00" + record[2]
+		rows[k] = append(rows[k], i+1)
+	}
+	for _, r := range results {
+		record := ResultRecord(units, r, cfg.OnFailure)
+		k := r.SiteCode + "\x00" + r.TerminalCode
+		if len(rows[k]) == 0 {
+			records = append(records, record)
+			continue
+		}
+		records[rows[k][0]] = record
+		rows[k] = rows[k][1:]
+	}
+
+	return WriteOutput(cfg.Output, func(w io.Writer) error {
+		return cfg.CSV.WriteAll(w, records)
+	})
+}
@@ -0,0 +1,100 @@
+package matrixio
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"routes/pkg/matrix"
+)
+
+func TestMergeResults(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Output = filepath.Join(t.TempDir(), "out.csv")
+	ok := func(site, terminal string, km float64) matrix.Result {
+		return matrix.Result{Route: matrix.Route{SiteCode: site, TerminalCode: terminal}, DistanceKm: km, Duration: "10 mins", Status: "OK"}
+	}
+	failed := func(site, terminal string) matrix.Result {
+		return matrix.Result{Route: matrix.Route{SiteCode: site, TerminalCode: terminal}, Duration: "N/A", Status: "ZERO_RESULTS"}
+	}
+	// S2 appears twice for T1, as sites served by two trips sometimes do.
+	err := WriteResults(cfg, []matrix.Result{ok("S1", "T1", 1), failed("S2", "T1"), failed("S2", "T1"), failed("S2", "T2"), ok("S3", "T1", 3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The retry fixes both S2/T1 rows in turn, still fails for S2/T2, and
+	// reports S4, which the output has no row for.
+	if err := MergeResults(cfg, []matrix.Result{ok("S2", "T1", 2), ok("S2", "T1", 2.5), failed("S2", "T2"), ok("S4", "T1", 4)}); err != nil {
+		t.Fatal(err)
+	}
+	want := `SITE_CODE,SITE_NAME,TERMINAL_CODE,DISTANCE_KM,DURATION
+S1,,T1,1.00,10 mins
+S2,,T1,2.00,10 mins
+S2,,T1,2.50,10 mins
+S2,,T2,0.00,N/A
+S3,,T1,3.00,10 mins
+S4,,T1,4.00,10 mins
+`
+	if got := readFile(t, cfg.Output); got != want {
+		t.Errorf("merged output =\n%s\nwant\n%s", got, want)
+	}
+
+	// Under "omit" a row that fails again keeps what the output had.
+	cfg.OnFailure = "omit"
+	if err := MergeResults(cfg, []matrix.Result{failed("S1", "T1")}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, cfg.Output); got != want {
+		t.Errorf("merging an omitted failure changed the output to\n%s", got)
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestMergeResultsErrors(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "out.csv")
+	cfg := DefaultConfig()
+	cfg.Output = existing
+	r := matrix.Result{Route: matrix.Route{SiteCode: "S1", TerminalCode: "T1"}, DistanceKm: 1, Duration: "1 min", Status: "OK"}
+	if err := WriteResults(cfg, []matrix.Result{r}); err != nil {
+		t.Fatal(err)
+	}
+	before := readFile(t, existing)
+
+	withToll := r
+	withToll.Extra = []matrix.Field{{Name: "TOLL_COST", Value: "0"}}
+	for _, tt := range []struct {
+		name, output string
+		units        []string
+		result       matrix.Result
+		want         string
+	}{
+		{"JSON output", filepath.Join(dir, "out.json"), nil, r, "merging needs a CSV output file"},
+		{"stdout", "-", nil, r, "merging needs a CSV output file"},
+		{"database", "sqlite://" + filepath.Join(dir, "out.db"), nil, r, "merging needs a CSV output file"},
+		{"missing output", filepath.Join(dir, "missing.csv"), nil, r, "no such file"},
+		{"empty output", writeInput(t, "empty.csv", ""), nil, r, "is empty"},
+		{"other columns", existing, nil, withToll, "has other columns than this run"},
+		{"other units", existing, []string{"mi"}, r, "has other columns than this run"},
+	} {
+		cfg := DefaultConfig()
+		cfg.Output = tt.output
+		cfg.DistanceUnits = tt.units
+		if err := MergeResults(cfg, []matrix.Result{tt.result}); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want one containing %q", tt.name, err, tt.want)
+		}
+	}
+	if got := readFile(t, existing); got != before {
+		t.Errorf("a refused merge changed the output to\n%s", got)
+	}
+}
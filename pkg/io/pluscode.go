@@ -0,0 +1,83 @@
+package matrixio
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// plusCodeAlphabet holds the Open Location Code digits in value order.
+	plusCodeAlphabet = "23456789CFGHJMPQRVWX"
+	// plusCodeSeparator is the position of the + in a full code.
+	plusCodeSeparator = 8
+	// plusCodePairs is the number of digits encoded as latitude and
+	// longitude pairs; any further digits refine a 5x4 grid.
+	plusCodePairs = 10
+	// plusCodeMaxDigits is the precision past which digits are ignored.
+	plusCodeMaxDigits = 15
+)
+
+// isPlusCode reports whether s looks like a Plus Code rather than a number.
+// A leading + is a sign, not a code separator.
+func isPlusCode(s string) bool {
+	if strings.IndexByte(s, '+') <= 0 {
+		return false
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	return err != nil
+}
+
+// decodePlusCode returns the centre of the area a full Plus Code (Open
+// Location Code) covers, as "lat,lng". Short codes, which need a nearby
+// reference location, are rejected.
+func decodePlusCode(code string) (string, error) {
+	upper := strings.ToUpper(strings.TrimSpace(code))
+	head, tail, _ := strings.Cut(upper, "+")
+	if len(head) < plusCodeSeparator {
+		return "", fmt.Errorf("Plus Code %q is a short code; give the full code, or map the column as an address to geocode it", code)
+	}
+	if len(head) > plusCodeSeparator || strings.Contains(tail, "+") || len(tail) == 1 {
+		return "", fmt.Errorf("%q is not a valid Plus Code", code)
+	}
+	digits := strings.TrimRight(head, "0")
+	if digits != head && (tail != "" || len(digits) == 0 || len(digits)%2 == 1) {
+		return "", fmt.Errorf("%q is not a valid Plus Code: bad padding", code)
+	}
+	digits += tail
+
+	values := make([]int, 0, len(digits))
+	for _, c := range digits {
+		v := strings.IndexRune(plusCodeAlphabet, c)
+		if v < 0 {
+			return "", fmt.Errorf("%q is not a valid Plus Code: unexpected %q", code, c)
+		}
+		values = append(values, v)
+	}
+	if values[0] >= 180/20 || values[1] >= 360/20 {
+		return "", fmt.Errorf("Plus Code %q is out of range", code)
+	}
+	values = values[:min(len(values), plusCodeMaxDigits)]
+
+	lat, lng := -90.0, -180.0
+	latSize, lngSize := 20.0*20, 20.0*20
+	for i := 0; i < min(len(values), plusCodePairs); i += 2 {
+		latSize, lngSize = latSize/20, lngSize/20
+		lat += float64(values[i]) * latSize
+		lng += float64(values[i+1]) * lngSize
+	}
+	for _, v := range values[min(len(values), plusCodePairs):] {
+		latSize, lngSize = latSize/5, lngSize/4
+		lat += float64(v/4) * latSize
+		lng += float64(v%4) * lngSize
+	}
+	lat = min(lat+latSize/2, 90)
+	lng += lngSize / 2
+
+	// Rounding to nine places drops the float noise of the sums.
+	format := func(f float64) string {
+		return strconv.FormatFloat(math.Round(f*1e9)/1e9, 'f', -1, 64)
+	}
+	return format(lat) + "," + format(lng), nil
+}
@@ -0,0 +1,44 @@
+package matrixio
+
+import "testing"
+
+func TestDecodePlusCode(t *testing.T) {
+	tests := []struct {
+		code    string
+		want    string
+		wantErr bool
+	}{
+		{code: "8FVC9G8F+6X", want: "47.3655625,8.5249375"},
+		{code: "8fvc9g8f+6x", want: "47.3655625,8.5249375"},
+		{code: " 8FVC9G8F+6X ", want: "47.3655625,8.5249375"},
+		{code: "8FVC9G8F+6XQ", want: "47.3655875,8.524984375"},
+		{code: "7FG49QCJ+2VX", want: "20.3701125,2.782234375"},
+		{code: "8FVC0000+", want: "47.5,8.5"},
+		{code: "CFX30000+", want: "89.5,1.5"},
+		{code: "22220000+", want: "-89.5,-179.5"},
+		{code: "9G8F+6X", wantErr: true},      // short code
+		{code: "8FVC9G8F6X", wantErr: true},   // no separator
+		{code: "8FVC9G8F+6", wantErr: true},   // single digit after +
+		{code: "8FVC9G8F+6X+", wantErr: true}, // two separators
+		{code: "8FV00000+", wantErr: true},    // odd padding
+		{code: "8FVC0000+6X", wantErr: true},  // digits after padding
+		{code: "8FVC9G8F+6A", wantErr: true},  // not in the alphabet
+		{code: "XFVC9G8F+6X", wantErr: true},  // latitude out of range
+		{code: "8FVC9G8F9+6X", wantErr: true}, // separator too late
+		{code: "00000000+", wantErr: true},    // padding only
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			got, err := decodePlusCode(tt.code)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("decodePlusCode(%q) = %q, want an error", tt.code, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("decodePlusCode(%q) = %q, %v; want %q", tt.code, got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package matrixio
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"routes/pkg/matrix"
+)
+
+// resultFields are the result values mapped to columns by default.
+var resultFields = []string{"site_code", "site_name", "terminal_code", "distance_km", "duration"}
+
+// PostgresConfig controls how results are loaded into Postgres.
+type PostgresConfig struct {
+	Table string `json:"table"`
+	// Columns maps result fields (site_code, site_name, terminal_code,
+	// distance_km, duration) to column names in Table. Unmapped fields are
+	// not written. Distances in other units are available as distance_mi,
+	// distance_m and distance_nmi; unless distances are mapped explicitly,
+	// each of the selected distance units goes to a column of its field's
+	// name in place of distance_km.
+	Columns map[string]string `json:"columns"`
+	// Upsert updates existing rows on conflict instead of failing.
+	Upsert bool `json:"upsert"`
+	// ConflictFields are the result fields whose columns form the unique key
+	// used by Upsert.
+	ConflictFields []string `json:"conflict_fields"`
+}
+
+func defaultPostgresConfig() PostgresConfig {
+	columns := make(map[string]string, len(resultFields))
+	for _, f := range resultFields {
+		columns[f] = f
+	}
+	return PostgresConfig{
+		Table:          "route_distances",
+		Columns:        columns,
+		ConflictFields: []string{"site_code", "terminal_code"},
+	}
+}
+
+// forUnits returns pg with the default distance_km column replaced by one
+// column per selected unit, unless the config maps distance fields itself.
+func (pg PostgresConfig) forUnits(units []DistanceUnit) PostgresConfig {
+	if pg.Columns["distance_km"] != "distance_km" {
+		return pg
+	}
+	for name := range distanceUnits {
+		if name != "km" && pg.Columns["distance_"+name] != "" {
+			return pg
+		}
+	}
+	columns := maps.Clone(pg.Columns)
+	delete(columns, "distance_km")
+	for _, u := range units {
+		columns[u.field()] = u.field()
+	}
+	pg.Columns = columns
+	return pg
+}
+
+func IsPostgresDSN(output string) bool {
+	return strings.HasPrefix(output, "postgres://") || strings.HasPrefix(output, "postgresql://")
+}
+
+// writeResultsToPostgres bulk-loads the results with COPY. In upsert mode the
+// rows are copied into a temporary table first and merged with
+// INSERT ... ON CONFLICT, since COPY itself cannot resolve conflicts. Failed
+// rows get NULL distances and duration under a placeholder policy.
+func writeResultsToPostgres(dsn string, pg PostgresConfig, results []matrix.Result, failed FailurePolicy) error {
+	ctx := context.Background()
+
+	var fields, columns []string
+	for f, col := range pg.Columns {
+		if col != "" {
+			fields = append(fields, f)
+		}
+	}
+	sort.Strings(fields)
+	for _, f := range fields {
+		columns = append(columns, pg.Columns[f])
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("postgres column mapping is empty")
+	}
+
+	var units []DistanceUnit
+	for _, u := range distanceUnits {
+		units = append(units, u)
+	}
+	rows := make([][]any, len(results))
+	for i, r := range results {
+		values := map[string]any{
+			"site_code":     r.SiteCode,
+			"site_name":     r.SiteName,
+			"terminal_code": r.TerminalCode,
+		}
+		addResultValues(values, units, r, failed)
+		row := make([]any, len(fields))
+		for j, f := range fields {
+			v, ok := values[f]
+			if !ok {
+				return fmt.Errorf("unknown result field %q in postgres column mapping", f)
+			}
+			row[j] = v
+		}
+		rows[i] = row
+	}
+
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	table := pgx.Identifier(strings.Split(pg.Table, "."))
+	target := table
+	if pg.Upsert {
+		target = pgx.Identifier{"route_dm_staging"}
+		create := fmt.Sprintf("CREATE TEMP TABLE %s (LIKE %s INCLUDING DEFAULTS) ON COMMIT DROP", target.Sanitize(), table.Sanitize())
+		if _, err := tx.Exec(ctx, create); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.CopyFrom(ctx, target, columns, pgx.CopyFromRows(rows)); err != nil {
+		return err
+	}
+
+	if pg.Upsert {
+		query, err := upsertQuery(table, target, pg, columns)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func upsertQuery(table, staging pgx.Identifier, pg PostgresConfig, columns []string) (string, error) {
+	var keys []string
+	isKey := make(map[string]bool)
+	for _, f := range pg.ConflictFields {
+		col := pg.Columns[f]
+		if col == "" {
+			return "", fmt.Errorf("conflict field %q is not mapped to a column", f)
+		}
+		keys = append(keys, pgx.Identifier{col}.Sanitize())
+		isKey[col] = true
+	}
+	if len(keys) == 0 {
+		return "", fmt.Errorf("upsert requires at least one conflict field")
+	}
+
+	quoted := make([]string, len(columns))
+	var updates []string
+	for i, col := range columns {
+		quoted[i] = pgx.Identifier{col}.Sanitize()
+		if !isKey[col] {
+			updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", quoted[i], quoted[i]))
+		}
+	}
+
+	action := "DO NOTHING"
+	if len(updates) > 0 {
+		action = "DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+
+	list := strings.Join(quoted, ", ")
+	return fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s ON CONFLICT (%s) %s",
+		table.Sanitize(), list, list, staging.Sanitize(), strings.Join(keys, ", "), action), nil
+}
@@ -0,0 +1,152 @@
+package matrixio
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"routes/pkg/matrix"
+)
+
+// inputColumns are the columns InputFields adds to an output so a later run
+// can tell which rows' inputs changed.
+var inputColumns = []string{"ORIGIN", "DESTINATION", "WAYPOINTS"}
+
+// InputFields returns the ORIGIN, DESTINATION and WAYPOINTS columns
+// identifying the input of route. Addresses are used when given, so the
+// values are the same before and after geocoding.
+func InputFields(route matrix.Route) []matrix.Field {
+	origin, destination := route.OriginAddress, route.DestinationAddress
+	if origin == "" {
+		origin = route.Origin
+	}
+	if destination == "" {
+		destination = route.Destination
+	}
+	return []matrix.Field{
+		{Name: "ORIGIN", Value: origin},
+		{Name: "DESTINATION", Value: destination},
+		{Name: "WAYPOINTS", Value: strings.Join(route.Waypoints, "|")},
+	}
+}
+
+// PreviousResults is an earlier CSV output whose successful rows can be
+// copied forward instead of queried again.
+type PreviousResults struct {
+	// header is the output header without the input columns.
+	header     []string
+	rows       map[string][]string
+	distance   int // index of the first distance column
+	unit       DistanceUnit
+	duration   int
+	extra      []int // indexes of the annotator columns
+	extraNames []string
+}
+
+// ReadPreviousResults reads an output written by an earlier run with the
+// same settings. Only outputs that carry the InputFields columns can be
+// matched; for others it returns an empty PreviousResults and an error
+// saying so, and every row is queried again.
+func ReadPreviousResults(path string, cfg Config) (*PreviousResults, error) {
+	empty := &PreviousResults{rows: map[string][]string{}}
+
+	file, err := OpenInput(path)
+	if err != nil {
+		return empty, err
+	}
+	defer file.Close()
+	reader, err := cfg.CSV.NewReader(file)
+	if err != nil {
+		return empty, err
+	}
+	records, err := reader.ReadAll()
+	if err != nil {
+		return empty, err
+	}
+	if len(records) == 0 {
+		return empty, fmt.Errorf("%s is empty", path)
+	}
+
+	header := records[0]
+	col := func(name string) int { return slices.Index(header, name) }
+	for _, name := range inputColumns {
+		if col(name) < 0 {
+			return empty, fmt.Errorf("%s has no %s column; it is written by runs with -previous, so this run queries every row", path, name)
+		}
+	}
+
+	units, err := ParseDistanceUnits(cfg.DistanceUnits)
+	if err != nil {
+		return empty, err
+	}
+	prev := &PreviousResults{
+		rows:     make(map[string][]string),
+		distance: col(units[0].Column()),
+		unit:     units[0],
+		duration: col("DURATION"),
+	}
+	if prev.distance < 0 || prev.duration < 0 {
+		return empty, fmt.Errorf("%s has no %s and DURATION columns", path, units[0].Column())
+	}
+	for i, name := range header {
+		if slices.Contains(inputColumns, name) {
+			continue
+		}
+		prev.header = append(prev.header, name)
+		if i > prev.duration {
+			prev.extra = append(prev.extra, i)
+			prev.extraNames = append(prev.extraNames, name)
+		}
+	}
+
+	keyCols := []int{col("SITE_CODE"), col("TERMINAL_CODE"), col("ORIGIN"), col("DESTINATION"), col("WAYPOINTS")}
+	for _, record := range records[1:] {
+		var key []string
+		for _, i := range keyCols {
+			key = append(key, cell(record, i))
+		}
+		k := strings.Join(key, "\x00")
+		if _, dup := prev.rows[k]; !dup {
+			prev.rows[k] = record
+		}
+	}
+	return prev, nil
+}
+
+// Reuse returns route's result from the previous output if a row with the
+// same site and terminal codes and unchanged inputs succeeded there. The
+// result has no duration in seconds, which the output does not record.
+func (p *PreviousResults) Reuse(route matrix.Route) (matrix.Result, bool) {
+	key := []string{route.SiteCode, route.TerminalCode}
+	for _, f := range InputFields(route) {
+		key = append(key, f.Value)
+	}
+	record, ok := p.rows[strings.Join(key, "\x00")]
+	if !ok || cell(record, p.duration) == "N/A" {
+		return matrix.Result{}, false
+	}
+	// Failed rows may also carry an -on-failure placeholder such as -1.
+	distance, err := strconv.ParseFloat(cell(record, p.distance), 64)
+	if err != nil || distance < 0 {
+		return matrix.Result{}, false
+	}
+
+	r := matrix.Result{
+		Route:      route,
+		DistanceKm: distance * p.unit.metres / 1000,
+		Duration:   cell(record, p.duration),
+		Status:     "OK",
+	}
+	for j, i := range p.extra {
+		r.Extra = append(r.Extra, matrix.Field{Name: p.extraNames[j], Value: cell(record, i)})
+	}
+	return r, true
+}
+
+// Matches reports whether header, as written by ResultHeader for this run
+// without the input columns, is the previous output's, so copied rows line
+// up with queried ones.
+func (p *PreviousResults) Matches(header []string) bool {
+	return slices.Equal(p.header, header)
+}
@@ -0,0 +1,121 @@
+package matrixio
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"routes/pkg/matrix"
+)
+
+// writePrevious writes results, with the input columns -previous adds, as
+// the CSV output of an earlier run and returns its name.
+func writePrevious(t *testing.T, cfg Config, results []matrix.Result) string {
+	t.Helper()
+	cfg.Output = filepath.Join(t.TempDir(), "previous.csv")
+	for i := range results {
+		results[i].Extra = append(results[i].Extra, InputFields(results[i].Route)...)
+	}
+	if err := WriteResults(cfg, results); err != nil {
+		t.Fatal(err)
+	}
+	return cfg.Output
+}
+
+func TestPreviousResultsReuse(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DistanceUnits = []string{"m", "km"}
+	cfg.OnFailure = "-1"
+	toll := func(v string) []matrix.Field { return []matrix.Field{{Name: "TOLL_COST", Value: v}} }
+	s1 := matrix.Route{SiteCode: "S1", SiteName: "Alpha", TerminalCode: "T1", Origin: "-6.3,106.9", Destination: "-6.2,106.8"}
+	s2 := matrix.Route{SiteCode: "S2", TerminalCode: "T1", Origin: "-6.3,106.9", Destination: "-6.25,106.85", Waypoints: []string{"-6.27,106.87"}}
+	s3 := matrix.Route{SiteCode: "S3", TerminalCode: "T1", Origin: "-6.3,106.9", Destination: "-6.5,107.2"}
+	geocoded := matrix.Route{SiteCode: "S4", TerminalCode: "T1", OriginAddress: "Terminal 1", Origin: "-6.3,106.9", DestinationAddress: "Monas", Destination: "-6.17,106.82"}
+	path := writePrevious(t, cfg, []matrix.Result{
+		{Route: s1, DistanceKm: 20.38, Duration: "24 mins", Status: "OK", Extra: toll("12000")},
+		{Route: s2, DistanceKm: 7.5, Duration: "10 mins", Status: "OK", Extra: toll("0")},
+		{Route: s3, Duration: "N/A", Status: "ZERO_RESULTS", Extra: toll("N/A")},
+		{Route: geocoded, DistanceKm: 3, Duration: "6 mins", Status: "OK", Extra: toll("0")},
+	})
+
+	prev, err := ReadPreviousResults(path, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := prev.Reuse(s1)
+	want := matrix.Result{Route: s1, DistanceKm: 20.38, Duration: "24 mins", Status: "OK", Extra: toll("12000")}
+	if !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("Reuse(S1) = %+v, %v; want %+v", got, ok, want)
+	}
+
+	moved := s2
+	moved.Waypoints = []string{"-6.28,106.87"}
+	renamed := s1
+	renamed.SiteName = "Alpha Depot"
+	// Geocoded rows are matched by their addresses, which the next run reads
+	// before geocoding.
+	ungeocoded := geocoded
+	ungeocoded.Origin, ungeocoded.Destination = "", ""
+	for _, tt := range []struct {
+		name  string
+		route matrix.Route
+		want  bool
+	}{
+		{"unchanged with waypoints", s2, true},
+		{"moved waypoint", moved, false},
+		{"failed last time", s3, false},
+		{"new row", matrix.Route{SiteCode: "S5", TerminalCode: "T1", Origin: "-6.3,106.9", Destination: "-6.2,106.8"}, false},
+		{"other terminal", matrix.Route{SiteCode: "S1", TerminalCode: "T2", Origin: "-6.3,106.9", Destination: "-6.2,106.8"}, false},
+		{"renamed site", renamed, true},
+		{"addresses before geocoding", ungeocoded, true},
+	} {
+		if _, ok := prev.Reuse(tt.route); ok != tt.want {
+			t.Errorf("%s: reused %v, want %v", tt.name, ok, tt.want)
+		}
+	}
+
+	if !prev.Matches(ResultHeader(mustUnits(t, cfg), want)) {
+		t.Error("the previous header does not match this run's")
+	}
+	if prev.Matches(ResultHeader(mustUnits(t, cfg), matrix.Result{})) {
+		t.Error("a run without TOLL_COST matched the previous header")
+	}
+}
+
+func mustUnits(t *testing.T, cfg Config) []DistanceUnit {
+	t.Helper()
+	units, err := ParseDistanceUnits(cfg.DistanceUnits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return units
+}
+
+func TestReadPreviousResultsErrors(t *testing.T) {
+	cfg := DefaultConfig()
+	withoutInputs := filepath.Join(t.TempDir(), "plain.csv")
+	cfg.Output = withoutInputs
+	err := WriteResults(cfg, []matrix.Result{{Route: matrix.Route{SiteCode: "S1"}, DistanceKm: 1, Duration: "1 min", Status: "OK"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name, path, want string
+	}{
+		{"missing file", filepath.Join(t.TempDir(), "missing.csv"), "no such file"},
+		{"empty file", writeInput(t, "empty.csv", ""), "is empty"},
+		{"no input columns", withoutInputs, "has no ORIGIN column"},
+		{"other distance unit", writeInput(t, "mi.csv", "SITE_CODE,TERMINAL_CODE,DISTANCE_MI,DURATION,ORIGIN,DESTINATION,WAYPOINTS\n"), "has no DISTANCE_KM and DURATION columns"},
+	} {
+		prev, err := ReadPreviousResults(tt.path, cfg)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want one containing %q", tt.name, err, tt.want)
+		}
+		// Every row is queried again.
+		if _, ok := prev.Reuse(matrix.Route{SiteCode: "S1"}); ok {
+			t.Errorf("%s: a row was reused", tt.name)
+		}
+	}
+}
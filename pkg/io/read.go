@@ -0,0 +1,425 @@
+// Package matrixio reads routes from CSV, JSON, Excel and Google Sheets
+// inputs and writes matrix results to files, SQLite, PostgreSQL and sheets,
+// as configured by a Config.
+package matrixio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"routes/pkg/matrix"
+)
+
+// readRoutesFromCSV reads a CSV file or remote object, or stdin when
+// filename is "-".
+func readRoutesFromCSV(filename string, cfg Config) ([]matrix.Route, error) {
+	var in io.Reader = os.Stdin
+	if filename != "-" {
+		file, err := OpenInput(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		in = file
+	}
+	return ReadRoutesCSV(in, cfg)
+}
+
+// ReadRoutesCSV reads routes from CSV in the dialect and column layout of cfg.
+func ReadRoutesCSV(r io.Reader, cfg Config) ([]matrix.Route, error) {
+	reader, err := cfg.CSV.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseRoutes(records, cfg)
+}
+
+// parseRoutes extracts the routes from raw input records, the first of which
+// is the header row. Coordinates in a projected CRS are converted to WGS84.
+func parseRoutes(records [][]string, cfg Config) ([]matrix.Route, error) {
+	if len(records) < 2 {
+		return nil, fmt.Errorf("input must contain a header and at least one data row")
+	}
+
+	parser, err := NewRouteParser(records[0], cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []matrix.Route
+	invalid := 0
+	for i, record := range records[1:] {
+		route, err := parser.Parse(record, i+2)
+		if err != nil {
+			if cfg.SkipInvalid {
+				slog.Warn("skipping invalid row", "err", err)
+			} else {
+				slog.Error("invalid row", "err", err)
+				invalid++
+			}
+			continue
+		}
+		routes = append(routes, route)
+	}
+
+	return validRoutes(routes, invalid)
+}
+
+// validRoutes fails when any row was invalid, having reported each, or when
+// skipping invalid rows left none.
+func validRoutes(routes []matrix.Route, invalid int) ([]matrix.Route, error) {
+	if invalid > 0 {
+		return nil, fmt.Errorf("%d invalid row(s); fix them or pass -skip-invalid to leave them out", invalid)
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("input contains no valid rows")
+	}
+	return routes, nil
+}
+
+// RouteParser turns input rows into routes once the header is known.
+type RouteParser struct {
+	header      []string
+	idx         columnIndexes
+	defaultEPSG int
+	signs       signRules
+	bounds      *boundingBox
+	fixSwapped  bool
+	precision   int
+	plusCodes   bool
+	passThrough []int
+}
+
+func NewRouteParser(header []string, cfg Config) (*RouteParser, error) {
+	idx, err := cfg.Columns.resolve(header)
+	if err != nil {
+		return nil, err
+	}
+
+	defaultEPSG, err := ParseEPSG(cfg.CRS)
+	if err != nil {
+		return nil, err
+	}
+
+	signs, err := cfg.Columns.signRules()
+	if err != nil {
+		return nil, err
+	}
+
+	bounds, err := parseBoundingBox(cfg.Bounds)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkPrecision(cfg.CoordPrecision); err != nil {
+		return nil, err
+	}
+
+	passThrough, err := passThroughColumns(header, idx, cfg.PassThrough)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RouteParser{header: header, idx: idx, defaultEPSG: defaultEPSG, signs: signs, bounds: bounds,
+		fixSwapped: cfg.FixSwappedCoords, precision: cfg.CoordPrecision, plusCodes: cfg.PlusCodes, passThrough: passThrough}, nil
+}
+
+// passThroughColumns resolves the pass_through references onto positions in
+// header. "*" stands for every unmapped column except the ERROR_STATUS and
+// ERROR_MESSAGE an error report adds.
+func passThroughColumns(header []string, idx columnIndexes, refs []string) ([]int, error) {
+	var positions []int
+	for _, ref := range refs {
+		if strings.TrimSpace(ref) == "*" {
+			for i, name := range header {
+				name = strings.TrimSpace(name)
+				if !idx.mapped(i) && name != "ERROR_STATUS" && name != "ERROR_MESSAGE" && !slices.Contains(positions, i) {
+					positions = append(positions, i)
+				}
+			}
+			continue
+		}
+		i, err := ColumnIndex(header, ref)
+		if err == nil && i >= len(header) {
+			err = fmt.Errorf("position %d is out of range", i+1)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pass-through column: %w", err)
+		}
+		if !slices.Contains(positions, i) {
+			positions = append(positions, i)
+		}
+	}
+	return positions, nil
+}
+
+// Parse converts one record; row is its 1-based line for error messages.
+func (p *RouteParser) Parse(record []string, row int) (matrix.Route, error) {
+	idx := p.idx
+	if len(record) <= idx.maxIndex() {
+		return matrix.Route{}, fmt.Errorf("row %d has insufficient columns", row)
+	}
+
+	epsg := p.defaultEPSG
+	if idx.crs >= 0 && strings.TrimSpace(record[idx.crs]) != "" {
+		var err error
+		if epsg, err = ParseEPSG(record[idx.crs]); err != nil {
+			return matrix.Route{}, fmt.Errorf("row %d: %w", row, err)
+		}
+	}
+
+	route := matrix.Route{
+		SiteCode:     record[idx.siteCode],
+		SiteName:     record[idx.siteName],
+		TerminalCode: record[idx.terminalCode],
+	}
+
+	var err error
+	route.Origin, route.OriginAddress, route.OriginPlusCode, err = p.location(epsg, record, idx.origin, fmt.Sprintf("row %d origin", row))
+	if err != nil {
+		return matrix.Route{}, fmt.Errorf("row %d origin: %w", row, err)
+	}
+	route.Destination, route.DestinationAddress, route.DestinationPlusCode, err = p.location(epsg, record, idx.destination, fmt.Sprintf("row %d destination", row))
+	if err != nil {
+		return matrix.Route{}, fmt.Errorf("row %d destination: %w", row, err)
+	}
+	if route.Waypoints, err = parseWaypoints(epsg, cell(record, idx.waypoints)); err != nil {
+		return matrix.Route{}, fmt.Errorf("row %d: %w", row, err)
+	}
+	roundRoute(&route, p.precision)
+	for i, name := range p.header {
+		value := ""
+		if i < len(record) {
+			value = record[i]
+		}
+		route.Input = append(route.Input, matrix.Field{Name: name, Value: value})
+	}
+	for _, i := range p.passThrough {
+		route.PassThrough = append(route.PassThrough, route.Input[i])
+	}
+
+	return route, nil
+}
+
+// location returns the coordinate in record, or the address to geocode
+// when the coordinate cells are unmapped or empty. A "place_id:" value in
+// the coordinate cells is returned as is, for the API to resolve. With Plus
+// Codes enabled, a code in the coordinate cells is decoded, and the code is
+// returned along with its coordinate. The where argument names the location
+// in warnings about swapped coordinates.
+func (p *RouteParser) location(epsg int, record []string, cols locationColumns, where string) (coordinate, address, plusCode string, err error) {
+	var lat, lng string
+	if cols.lat >= 0 && cols.lng >= 0 {
+		lat, lng = strings.TrimSpace(record[cols.lat]), strings.TrimSpace(record[cols.lng])
+	}
+	if lat == "" && lng == "" && cols.address >= 0 {
+		address := strings.TrimSpace(record[cols.address])
+		if address == "" {
+			return "", "", "", fmt.Errorf("neither coordinates nor an address are given")
+		}
+		return "", address, "", nil
+	}
+	if id, ok := singleCell(lat, lng, isPlaceID); ok {
+		return id, "", "", nil
+	}
+	if code, ok := singleCell(lat, lng, isPlusCode); ok && p.plusCodes {
+		coordinate, err := decodePlusCode(code)
+		return coordinate, "", code, err
+	}
+
+	if epsg == epsgWGS84 {
+		var err error
+		if lat, err = p.signs.lat.normalize(lat, cell(record, cols.latHemisphere)); err != nil {
+			return "", "", "", fmt.Errorf("latitude: %w", err)
+		}
+		if lng, err = p.signs.lng.normalize(lng, cell(record, cols.lngHemisphere)); err != nil {
+			return "", "", "", fmt.Errorf("longitude: %w", err)
+		}
+		if looksSwapped(lat, lng, p.bounds) {
+			if p.fixSwapped {
+				slog.Warn("swapping latitude and longitude", "location", where, "lat", lng, "lng", lat)
+				lat, lng = lng, lat
+			} else {
+				slog.Warn("latitude and longitude look swapped; pass -fix-swapped-coords to swap them", "location", where, "lat", lat, "lng", lng)
+			}
+		}
+	}
+
+	coordinate, err = RowCoordinate(epsg, lat, lng)
+	var coordErr *coordinateError
+	if errors.As(err, &coordErr) {
+		col := cols.lat
+		if coordErr.lng {
+			col = cols.lng
+		}
+		if col >= 0 && col < len(p.header) {
+			err = fmt.Errorf("column %s: %w", p.header[col], err)
+		}
+	}
+	return coordinate, "", "", err
+}
+
+func checkPrecision(digits int) error {
+	if digits < 0 || digits > 15 {
+		return fmt.Errorf("coordinate precision %d is out of range (want 0 to 15 decimal places)", digits)
+	}
+	return nil
+}
+
+// roundRoute rounds the coordinates of route to digits decimal places, so
+// fixes a few metres apart become the same location. Zero leaves them as
+// they are, as does a location still to be geocoded.
+func roundRoute(route *matrix.Route, digits int) {
+	if digits == 0 {
+		return
+	}
+	route.Origin = roundCoordinate(route.Origin, digits)
+	route.Destination = roundCoordinate(route.Destination, digits)
+	for i, w := range route.Waypoints {
+		route.Waypoints[i] = roundCoordinate(w, digits)
+	}
+}
+
+func roundCoordinate(coordinate string, digits int) string {
+	lat, lng, ok := strings.Cut(coordinate, ",")
+	if !ok {
+		return coordinate
+	}
+	scale := math.Pow10(digits)
+	round := func(value string) string {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return value
+		}
+		// Adding zero turns the -0 of small negatives into 0.
+		return strconv.FormatFloat(math.Round(f*scale)/scale+0, 'f', -1, 64)
+	}
+	return round(lat) + "," + round(lng)
+}
+
+// singleCell returns the location given in one cell of a coordinate pair:
+// one cell for which is reports true and the other empty or the same.
+func singleCell(lat, lng string, is func(string) bool) (string, bool) {
+	switch {
+	case is(lat) && (lng == "" || lng == lat):
+		return lat, true
+	case is(lng) && lat == "":
+		return lng, true
+	}
+	return "", false
+}
+
+// isPlaceID reports whether s is a Google Place ID reference, which the
+// Distance Matrix API accepts in place of coordinates.
+func isPlaceID(s string) bool {
+	id, ok := strings.CutPrefix(s, "place_id:")
+	return ok && id != ""
+}
+
+// cell returns the trimmed value at i, or "" for an unmapped column.
+func cell(record []string, i int) string {
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+func RowCoordinate(epsg int, latOrY, lngOrX string) (string, error) {
+	if epsg == epsgWGS84 {
+		if err := checkLatLng(latOrY, lngOrX); err != nil {
+			return "", err
+		}
+	}
+	return toWGS84(epsg, latOrY, lngOrX)
+}
+
+// coordinateError is an invalid latitude or longitude value.
+type coordinateError struct {
+	lng     bool
+	value   string
+	problem string
+}
+
+func (e *coordinateError) Error() string {
+	axis := "latitude"
+	if e.lng {
+		axis = "longitude"
+	}
+	return fmt.Sprintf("%s %q %s", axis, e.value, e.problem)
+}
+
+// checkLatLng reports the first of lat and lng that is empty, not a number
+// or out of range.
+func checkLatLng(lat, lng string) error {
+	for _, axis := range []struct {
+		lng   bool
+		value string
+		limit float64
+	}{{false, lat, 90}, {true, lng, 180}} {
+		value := strings.TrimSpace(axis.value)
+		if value == "" {
+			return &coordinateError{lng: axis.lng, value: axis.value, problem: "is empty"}
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return &coordinateError{lng: axis.lng, value: axis.value, problem: "is not a number"}
+		}
+		if math.Abs(f) > axis.limit {
+			problem := fmt.Sprintf("is outside [-%g, %g]", axis.limit, axis.limit)
+			if looksProjected(lat, lng) {
+				problem += "; if the input is projected, declare its CRS with -crs or a crs column"
+			}
+			return &coordinateError{lng: axis.lng, value: axis.value, problem: problem}
+		}
+	}
+	return nil
+}
+
+// ReadRoutes loads the input from a CSV file, a JSON file, an Excel workbook
+// or a sheets:// reference.
+func ReadRoutes(cfg Config) ([]matrix.Route, error) {
+	if ref, ok := strings.CutPrefix(cfg.Input, "sheets://"); ok {
+		return readRoutesFromSheet(ref, cfg)
+	}
+	if isExcelFile(cfg.Input) {
+		return readRoutesFromExcel(cfg.Input, cfg)
+	}
+	if isJSONFile(cfg.Input) {
+		return readRoutesFromJSON(cfg.Input, cfg)
+	}
+	return readRoutesFromCSV(cfg.Input, cfg)
+}
+
+// parseWaypoints reads a waypoints cell: "lat,lng" pairs in the row's CRS,
+// separated by pipes.
+func parseWaypoints(epsg int, value string) ([]string, error) {
+	var waypoints []string
+	for i, point := range strings.Split(value, "|") {
+		point = strings.TrimSpace(point)
+		if point == "" {
+			continue
+		}
+		lat, lng, ok := strings.Cut(point, ",")
+		if !ok {
+			return nil, fmt.Errorf("waypoint %d: %q must be \"lat,lng\"", i+1, point)
+		}
+		coordinate, err := RowCoordinate(epsg, strings.TrimSpace(lat), strings.TrimSpace(lng))
+		if err != nil {
+			return nil, fmt.Errorf("waypoint %d: %w", i+1, err)
+		}
+		waypoints = append(waypoints, coordinate)
+	}
+	return waypoints, nil
+}
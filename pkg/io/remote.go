@@ -0,0 +1,165 @@
+package matrixio
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// storageClient talks to remote stores over the default transport as it
+// was at startup, before -debug wraps it, so object contents stay out of
+// the debug log and are not cut at its response size limit.
+var storageClient = &http.Client{Transport: http.DefaultTransport}
+
+// remoteStore reads and writes the objects of one URL scheme.
+type remoteStore interface {
+	// open returns the object's contents, or an error wrapping
+	// os.ErrNotExist when there is no such object.
+	open(u *url.URL) (io.ReadCloser, error)
+	// put stores the size bytes of r as the object, rewinding r to send
+	// it again if it has to.
+	put(u *url.URL, r io.ReadSeeker, size int64) error
+}
+
+// remoteStores maps URL schemes accepted in place of file paths to their
+// store.
+var remoteStores = map[string]remoteStore{
+	"s3":    s3Store{},
+	"gs":    gcsStore{},
+	"sftp":  sftpStore{},
+	"az":    azureStore{},
+	"abfss": azureStore{},
+	"http":  httpStore{},
+	"https": httpStore{},
+}
+
+// remote returns the store and parsed URL of a remote path such as
+// s3://bucket/key, or ok false for a local path.
+func remote(path string) (store remoteStore, u *url.URL, ok bool) {
+	scheme, _, found := strings.Cut(path, "://")
+	if !found {
+		return nil, nil, false
+	}
+	store, ok = remoteStores[scheme]
+	if !ok {
+		return nil, nil, false
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, nil, false
+	}
+	return store, u, true
+}
+
+// IsRemote reports whether path names an object in a remote store rather
+// than a local file.
+func IsRemote(path string) bool {
+	_, _, ok := remote(path)
+	return ok
+}
+
+// OpenInput opens a local file or remote object for reading, decompressing
+// it when its name ends in .gz or .zip. A missing one gives an error
+// wrapping os.ErrNotExist either way.
+func OpenInput(path string) (io.ReadCloser, error) {
+	r, err := openRaw(path)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(path, r)
+}
+
+// openRaw opens a local file or remote object as stored, without
+// decompressing it.
+func openRaw(path string) (io.ReadCloser, error) {
+	if store, u, ok := remote(path); ok {
+		return store.open(u)
+	}
+	return os.Open(path)
+}
+
+// readInput returns the whole contents of a local file or remote object.
+func readInput(path string) ([]byte, error) {
+	r, err := OpenInput(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// localInput returns a local file holding path, downloading a remote object
+// to a temporary file that cleanup removes, for readers that need random
+// access.
+func localInput(path string) (local string, cleanup func(), err error) {
+	if !IsRemote(path) {
+		return path, func() {}, nil
+	}
+	r, err := OpenInput(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer r.Close()
+	tmp, err := os.CreateTemp("", "route-dm-*"+filepath.Ext(inputName(path)))
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.Remove(tmp.Name()) }
+	_, err = io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return tmp.Name(), cleanup, nil
+}
+
+// writeRemote spools what write writes to a temporary file and uploads it
+// once write succeeds, so a failed run leaves the object as it was.
+func writeRemote(store remoteStore, u *url.URL, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp("", "route-dm-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	if err := write(tmp); err != nil {
+		return err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := store.put(u, tmp, size); err != nil {
+		return fmt.Errorf("uploading %s: %w", u, err)
+	}
+	return nil
+}
+
+// escapeObjectPath percent-encodes every byte of an object path p but the
+// unreserved characters and slashes, which is also the form AWS signatures
+// expect.
+func escapeObjectPath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
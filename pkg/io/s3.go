@@ -0,0 +1,117 @@
+package matrixio
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// s3Store reads and writes s3://BUCKET/KEY objects through the S3 REST API,
+// signing requests with LoadAWSCredentials in AWS_REGION (us-east-1 by
+// default). A bucket in another region is retried there. AWS_ENDPOINT_URL_S3
+// or AWS_ENDPOINT_URL point it at an S3-compatible service instead, with
+// path-style addressing.
+type s3Store struct{}
+
+func (s3Store) open(u *url.URL) (io.ReadCloser, error) {
+	resp, err := s3Do(http.MethodGet, u, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s3Store) put(u *url.URL, r io.ReadSeeker, size int64) error {
+	resp, err := s3Do(http.MethodPut, u, r, size)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// s3Do sends one object request and returns the successful response. A
+// missing object gives an error wrapping os.ErrNotExist.
+func s3Do(method string, u *url.URL, body io.ReadSeeker, size int64) (*http.Response, error) {
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("%s: want s3://BUCKET/KEY", u)
+	}
+	creds, err := LoadAWSCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	region := AWSRegion("us-east-1")
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequest(method, s3URL(bucket, key, region), nil)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			if _, err := body.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			req.Body, req.ContentLength = io.NopCloser(body), size
+			if size == 0 {
+				req.Body = http.NoBody
+			}
+			if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+				req.Header.Set("Content-Type", contentType)
+			}
+		}
+		req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+		SignAWSRequest(req, "UNSIGNED-PAYLOAD", creds, region, "s3", time.Now())
+
+		resp, err := storageClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode/100 == 2 {
+			return resp, nil
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		resp.Body.Close()
+
+		if actual := resp.Header.Get("X-Amz-Bucket-Region"); attempt == 1 && actual != "" && actual != region {
+			region = actual
+			continue
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, &fs.PathError{Op: strings.ToLower(method), Path: u.String(), Err: fs.ErrNotExist}
+		}
+		var s3Err struct {
+			Code    string
+			Message string
+		}
+		if xml.Unmarshal(data, &s3Err) == nil && s3Err.Code != "" {
+			return nil, fmt.Errorf("%s: %s: %s", u, s3Err.Code, s3Err.Message)
+		}
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+}
+
+// s3URL addresses an object virtual-hosted style, or path-style for a
+// custom endpoint and for bucket names with dots, which do not match the
+// wildcard certificate.
+func s3URL(bucket, key, region string) string {
+	objectPath := escapeObjectPath("/" + key)
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	switch {
+	case endpoint != "":
+		return strings.TrimSuffix(endpoint, "/") + "/" + escapeObjectPath(bucket) + objectPath
+	case strings.Contains(bucket, "."):
+		return "https://s3." + region + ".amazonaws.com/" + bucket + objectPath
+	}
+	return "https://" + bucket + ".s3." + region + ".amazonaws.com" + objectPath
+}
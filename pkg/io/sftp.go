@@ -0,0 +1,267 @@
+package matrixio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpStore reads and writes files named sftp://USER@HOST[:PORT]/PATH over
+// SFTP. PATH is absolute; /~/PATH is relative to the login directory.
+//
+// The server's host key must be in SFTP_KNOWN_HOSTS or ~/.ssh/known_hosts,
+// or match the SHA256:... fingerprint in SFTP_HOST_KEY. The client
+// authenticates with the private key in SFTP_KEY or the file SFTP_KEY_FILE,
+// decrypted with SFTP_KEY_PASSPHRASE, and otherwise with the keys of a
+// running ssh-agent or ~/.ssh/id_ed25519, id_ecdsa and id_rsa. SFTP_USER is
+// the user when the URL has none.
+type sftpStore struct{}
+
+func (sftpStore) open(u *url.URL) (io.ReadCloser, error) {
+	client, err := dialSFTP(u)
+	if err != nil {
+		return nil, err
+	}
+	f, err := client.open(sftpPath(u), sftpFlagRead)
+	if err != nil {
+		client.Close()
+		return nil, sftpError(u, "open", err)
+	}
+	return &sftpReader{file: f, client: client}, nil
+}
+
+// put uploads to a temporary file next to the target and renames it into
+// place, so a dropped connection never leaves a partial file under the
+// target's name.
+func (sftpStore) put(u *url.URL, r io.ReadSeeker, size int64) error {
+	client, err := dialSFTP(u)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	target := sftpPath(u)
+	tmp := path.Join(path.Dir(target), fmt.Sprintf(".%s.%d.tmp", path.Base(target), time.Now().UnixNano()))
+	f, err := client.open(tmp, sftpFlagWrite|sftpFlagCreate|sftpFlagTruncate)
+	if err != nil {
+		return sftpError(u, "put", err)
+	}
+	err = func() error {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := io.Copy(f, io.LimitReader(r, size)); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		return client.rename(tmp, target)
+	}()
+	if err != nil {
+		client.remove(tmp)
+		return sftpError(u, "put", err)
+	}
+	return nil
+}
+
+// sftpReader closes the connection along with the file.
+type sftpReader struct {
+	file   *sftpFile
+	client *sftpClient
+}
+
+func (r *sftpReader) Read(p []byte) (int, error) { return r.file.Read(p) }
+
+func (r *sftpReader) Close() error {
+	err := r.file.Close()
+	if cerr := r.client.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// sftpPath returns the path to request from the server.
+func sftpPath(u *url.URL) string {
+	if rest, ok := strings.CutPrefix(u.Path, "/~/"); ok {
+		return rest
+	}
+	return u.Path
+}
+
+// sftpError adds the URL to a protocol error, keeping a missing file
+// recognisable as fs.ErrNotExist.
+func sftpError(u *url.URL, op string, err error) error {
+	var status *sftpStatusError
+	if errors.As(err, &status) && status.code == sftpNoSuchFile {
+		return &fs.PathError{Op: op, Path: u.String(), Err: fs.ErrNotExist}
+	}
+	return fmt.Errorf("%s: %w", u, err)
+}
+
+// dialSFTP connects and authenticates to the server u names and starts an
+// SFTP session.
+func dialSFTP(u *url.URL) (*sftpClient, error) {
+	user := u.User.Username()
+	if user == "" {
+		user = os.Getenv("SFTP_USER")
+	}
+	if u.Hostname() == "" || user == "" || sftpPath(u) == "" {
+		return nil, fmt.Errorf("%s: want sftp://USER@HOST[:PORT]/PATH", u)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	hostKeyCallback, algorithms, err := sftpHostKeyCallback(addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", u, err)
+	}
+	signers, closeAgent, err := sftpSigners()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", u, err)
+	}
+	defer closeAgent()
+
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:              user,
+		Auth:              []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: algorithms,
+		Timeout:           30 * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", u, err)
+	}
+	client, err := newSFTPClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s: starting sftp: %w", u, err)
+	}
+	return client, nil
+}
+
+// sftpHostKeyCallback verifies the server against SFTP_HOST_KEY or a
+// known_hosts file. It also returns the host key algorithms to offer, those
+// known_hosts has keys of for addr, so a server with several keys presents
+// the one that is on record.
+func sftpHostKeyCallback(addr string) (ssh.HostKeyCallback, []string, error) {
+	if fingerprint := os.Getenv("SFTP_HOST_KEY"); fingerprint != "" {
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if ssh.FingerprintSHA256(key) != fingerprint {
+				return fmt.Errorf("host key %s does not match SFTP_HOST_KEY", ssh.FingerprintSHA256(key))
+			}
+			return nil
+		}, nil, nil
+	}
+
+	path := os.Getenv("SFTP_KNOWN_HOSTS")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil, fmt.Errorf("no known_hosts file: set SFTP_KNOWN_HOSTS or SFTP_HOST_KEY: %w", err)
+		}
+		path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading known hosts (set SFTP_KNOWN_HOSTS or SFTP_HOST_KEY): %w", err)
+	}
+
+	// Checking a key no server has lists the keys on record for addr.
+	var algorithms []string
+	var keyErr *knownhosts.KeyError
+	if errors.As(callback(addr, &net.TCPAddr{IP: net.IPv4zero}, probeHostKey{}), &keyErr) {
+		for _, want := range keyErr.Want {
+			switch typ := want.Key.Type(); typ {
+			case ssh.KeyAlgoRSA:
+				algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+			default:
+				algorithms = append(algorithms, typ)
+			}
+		}
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return fmt.Errorf("host %s is not in %s; add it with ssh-keyscan or set SFTP_HOST_KEY to %s", hostname, path, ssh.FingerprintSHA256(key))
+		}
+		return err
+	}, algorithms, nil
+}
+
+// probeHostKey is a public key of a type no known_hosts line has.
+type probeHostKey struct{}
+
+func (probeHostKey) Type() string                        { return "route-dm-probe" }
+func (probeHostKey) Marshal() []byte                     { return []byte("route-dm-probe") }
+func (probeHostKey) Verify([]byte, *ssh.Signature) error { return errors.New("probe key") }
+
+// sftpSigners returns the private keys to offer, and a function that closes
+// the ssh-agent connection once authentication is done.
+func sftpSigners() ([]ssh.Signer, func(), error) {
+	passphrase := os.Getenv("SFTP_KEY_PASSPHRASE")
+	parse := func(pem []byte) (ssh.Signer, error) {
+		if passphrase != "" {
+			return ssh.ParsePrivateKeyWithPassphrase(pem, []byte(passphrase))
+		}
+		return ssh.ParsePrivateKey(pem)
+	}
+
+	key := []byte(os.Getenv("SFTP_KEY"))
+	if file := os.Getenv("SFTP_KEY_FILE"); len(key) == 0 && file != "" {
+		var err error
+		if key, err = os.ReadFile(file); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(key) > 0 {
+		signer, err := parse(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing SFTP private key: %w", err)
+		}
+		return []ssh.Signer{signer}, func() {}, nil
+	}
+
+	var signers []ssh.Signer
+	closeAgent := func() {}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			closeAgent = func() { conn.Close() }
+			if agentSigners, err := agent.NewClient(conn).Signers(); err == nil {
+				signers = append(signers, agentSigners...)
+			}
+		}
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			pem, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+			if err != nil {
+				continue
+			}
+			if signer, err := parse(pem); err == nil {
+				signers = append(signers, signer)
+			}
+		}
+	}
+	if len(signers) == 0 {
+		closeAgent()
+		return nil, nil, errors.New("no SSH key: set SFTP_KEY_FILE or SFTP_KEY, or run ssh-agent")
+	}
+	return signers, closeAgent, nil
+}
@@ -0,0 +1,324 @@
+package matrixio
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sftpTestServer is an SSH server on localhost whose sftp subsystem serves
+// the files under root, with as much of SFTP version 3 as sftpStore uses.
+type sftpTestServer struct {
+	root string
+	addr string
+	// posixRename announces the posix-rename@openssh.com extension; without
+	// it, renaming over an existing file fails as version 3 says.
+	posixRename bool
+	// emptyData answers every read with an empty DATA packet.
+	emptyData bool
+}
+
+// newSFTPTestServer starts a server that lets in the user ops with a key it
+// makes, and points SFTP_KEY and SFTP_HOST_KEY at that key and its own.
+func newSFTPTestServer(t *testing.T, posixRename bool) *sftpTestServer {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientPub, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "ops" && bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SFTP_KEY", string(pem.EncodeToMemory(block)))
+	t.Setenv("SFTP_KEY_PASSPHRASE", "")
+	t.Setenv("SFTP_HOST_KEY", ssh.FingerprintSHA256(hostSigner.PublicKey()))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	t.Cleanup(func() {
+		ln.Close()
+		wg.Wait()
+	})
+	s := &sftpTestServer{root: t.TempDir(), addr: ln.Addr().String(), posixRename: posixRename}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.serveConn(conn, config)
+			}()
+		}
+	}()
+	return s
+}
+
+// url returns the sftp:// URL of name under the root.
+func (s *sftpTestServer) url(name string) string {
+	return "sftp://ops@" + s.addr + "/~/" + name
+}
+
+func (s *sftpTestServer) serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	sc, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	defer sc.Close()
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "sessions only")
+			continue
+		}
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				name, _, _ := readSFTPString(req.Payload)
+				ok := req.Type == "subsystem" && string(name) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					go func() {
+						s.serveSFTP(ch)
+						ch.Close()
+					}()
+				}
+			}
+		}()
+	}
+}
+
+// serveSFTP answers requests on rw until the client goes away.
+func (s *sftpTestServer) serveSFTP(rw io.ReadWriter) {
+	files := map[string]*os.File{}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	send := func(typ byte, payload []byte) error {
+		packet := binary.BigEndian.AppendUint32(nil, uint32(1+len(payload)))
+		_, err := rw.Write(append(append(packet, typ), payload...))
+		return err
+	}
+	status := func(id []byte, err error) error {
+		code := uint32(sftpOK)
+		message := ""
+		switch {
+		case errors.Is(err, io.EOF):
+			code = sftpEOF
+		case errors.Is(err, fs.ErrNotExist):
+			code, message = sftpNoSuchFile, "no such file"
+		case err != nil:
+			code, message = 4, err.Error()
+		}
+		payload := binary.BigEndian.AppendUint32(append([]byte(nil), id...), code)
+		payload = appendSFTPString(payload, []byte(message))
+		return send(sftpStatus, appendSFTPString(payload, nil))
+	}
+	path := func(p []byte) string { return filepath.Join(s.root, string(p)) }
+
+	for {
+		var header [5]byte
+		if _, err := io.ReadFull(rw, header[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(header[:4])-1)
+		if _, err := io.ReadFull(rw, req); err != nil {
+			return
+		}
+		if header[4] == sftpInit {
+			version := binary.BigEndian.AppendUint32(nil, 3)
+			if s.posixRename {
+				version = appendSFTPString(appendSFTPString(version, []byte(sftpPosixRename)), []byte("1"))
+			}
+			if send(sftpVersion, version) != nil {
+				return
+			}
+			continue
+		}
+		id, args := req[:4], req[4:]
+		var err error
+		switch header[4] {
+		case sftpOpen:
+			name, rest, _ := readSFTPString(args)
+			pflags := binary.BigEndian.Uint32(rest)
+			flag := os.O_RDONLY
+			if pflags&sftpFlagWrite != 0 {
+				flag = os.O_WRONLY
+			}
+			if pflags&sftpFlagCreate != 0 {
+				flag |= os.O_CREATE
+			}
+			if pflags&sftpFlagTruncate != 0 {
+				flag |= os.O_TRUNC
+			}
+			f, openErr := os.OpenFile(path(name), flag, 0o644)
+			if openErr != nil {
+				err = status(id, openErr)
+				break
+			}
+			handle := strconv.Itoa(len(files))
+			files[handle] = f
+			err = send(sftpHandle, appendSFTPString(append([]byte(nil), id...), []byte(handle)))
+		case sftpClose:
+			handle, _, _ := readSFTPString(args)
+			f := files[string(handle)]
+			delete(files, string(handle))
+			err = status(id, f.Close())
+		case sftpRead:
+			handle, rest, _ := readSFTPString(args)
+			offset := binary.BigEndian.Uint64(rest)
+			buf := make([]byte, binary.BigEndian.Uint32(rest[8:]))
+			n, readErr := files[string(handle)].ReadAt(buf, int64(offset))
+			switch {
+			case s.emptyData:
+				err = send(sftpData, appendSFTPString(append([]byte(nil), id...), nil))
+			case n == 0:
+				err = status(id, readErr)
+			default:
+				err = send(sftpData, appendSFTPString(append([]byte(nil), id...), buf[:n]))
+			}
+		case sftpWrite:
+			handle, rest, _ := readSFTPString(args)
+			offset := binary.BigEndian.Uint64(rest)
+			data, _, _ := readSFTPString(rest[8:])
+			_, writeErr := files[string(handle)].WriteAt(data, int64(offset))
+			err = status(id, writeErr)
+		case sftpRemove:
+			name, _, _ := readSFTPString(args)
+			err = status(id, os.Remove(path(name)))
+		case sftpRename:
+			oldName, rest, _ := readSFTPString(args)
+			newName, _, _ := readSFTPString(rest)
+			if _, statErr := os.Stat(path(newName)); statErr == nil {
+				err = status(id, errors.New("file exists"))
+				break
+			}
+			err = status(id, os.Rename(path(oldName), path(newName)))
+		case sftpExtended:
+			ext, rest, _ := readSFTPString(args)
+			if string(ext) != sftpPosixRename || !s.posixRename {
+				err = status(id, errors.New("unsupported"))
+				break
+			}
+			oldName, rest, _ := readSFTPString(rest)
+			newName, _, _ := readSFTPString(rest)
+			err = status(id, os.Rename(path(oldName), path(newName)))
+		default:
+			err = status(id, errors.New("unsupported"))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestSFTPStoreRoundTrip(t *testing.T) {
+	// More than one sftpChunk, so reads and writes take several requests.
+	first := "SITE_CODE,DISTANCE_KM\n" + strings.Repeat("S1,20.38\n", 10000)
+	second := "SITE_CODE,DISTANCE_KM\nS2,7.5\n"
+	for _, posixRename := range []bool{true, false} {
+		s := newSFTPTestServer(t, posixRename)
+		output := s.url("out.csv")
+		// The second write replaces the first, which is how the rename
+		// differs between servers with and without posix-rename.
+		for _, contents := range []string{first, second} {
+			err := WriteOutput(output, func(w io.Writer) error {
+				_, err := io.WriteString(w, contents)
+				return err
+			})
+			if err != nil {
+				t.Fatalf("posix-rename %v: %v", posixRename, err)
+			}
+			got, err := readInput(output)
+			if err != nil {
+				t.Fatalf("posix-rename %v: %v", posixRename, err)
+			}
+			if string(got) != contents {
+				t.Errorf("posix-rename %v: read back %d bytes, want %d", posixRename, len(got), len(contents))
+			}
+		}
+		entries, err := os.ReadDir(s.root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Name() != "out.csv" {
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			t.Errorf("posix-rename %v: server holds %v, want only out.csv", posixRename, names)
+		}
+	}
+}
+
+func TestSFTPStoreErrors(t *testing.T) {
+	s := newSFTPTestServer(t, true)
+	if err := os.WriteFile(filepath.Join(s.root, "in.csv"), []byte("SITE_CODE\nS1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenInput(s.url("missing.csv")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("opening a missing file: got %v, want fs.ErrNotExist", err)
+	}
+
+	s.emptyData = true
+	if _, err := readInput(s.url("in.csv")); !errors.Is(err, io.ErrNoProgress) {
+		t.Errorf("reading from a server sending empty data: got %v, want io.ErrNoProgress", err)
+	}
+	s.emptyData = false
+
+	t.Setenv("SFTP_HOST_KEY", "SHA256:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA")
+	if _, err := readInput(s.url("in.csv")); err == nil || !strings.Contains(err.Error(), "does not match SFTP_HOST_KEY") {
+		t.Errorf("connecting to a server with another host key: got %v", err)
+	}
+}
@@ -0,0 +1,313 @@
+package matrixio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The parts of SFTP version 3 (draft-ietf-secsh-filexfer-02) needed to read
+// and write whole files, one request at a time, and to rename them into
+// place.
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpWrite    = 6
+	sftpRemove   = 13
+	sftpRename   = 18
+	sftpExtended = 200
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+
+	// sftpPosixRename is the OpenSSH extension that renames over an
+	// existing file in one step, which the version 3 rename refuses to do.
+	sftpPosixRename = "posix-rename@openssh.com"
+
+	sftpFlagRead     = 0x01
+	sftpFlagWrite    = 0x02
+	sftpFlagCreate   = 0x08
+	sftpFlagTruncate = 0x10
+
+	sftpOK         = 0
+	sftpEOF        = 1
+	sftpNoSuchFile = 2
+
+	// sftpChunk is the most data a request reads or writes, the size every
+	// server must accept.
+	sftpChunk = 32 * 1024
+	// sftpMaxPacket bounds the responses accepted from the server.
+	sftpMaxPacket = 256 * 1024
+)
+
+// sftpClient is an SFTP session over its own SSH connection.
+type sftpClient struct {
+	conn   *ssh.Client
+	stdin  io.WriteCloser
+	stdout io.Reader
+	nextID uint32
+	// extensions are the names of the protocol extensions the server
+	// announced.
+	extensions map[string]bool
+}
+
+func newSFTPClient(conn *ssh.Client) (*sftpClient, error) {
+	session, err := conn.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return nil, err
+	}
+	c := &sftpClient{conn: conn, stdin: stdin, stdout: stdout, extensions: map[string]bool{}}
+
+	if err := c.send(sftpInit, binary.BigEndian.AppendUint32(nil, 3)); err != nil {
+		return nil, err
+	}
+	typ, resp, err := c.recv()
+	if err != nil {
+		return nil, err
+	}
+	if typ != sftpVersion || len(resp) < 4 {
+		return nil, fmt.Errorf("unexpected packet type %d instead of version", typ)
+	}
+	for rest := resp[4:]; len(rest) > 0; {
+		name, data, ok := readSFTPString(rest)
+		if !ok {
+			break
+		}
+		if _, rest, ok = readSFTPString(data); !ok {
+			break
+		}
+		c.extensions[string(name)] = true
+	}
+	return c, nil
+}
+
+// Close ends the session and the connection.
+func (c *sftpClient) Close() error {
+	c.stdin.Close()
+	return c.conn.Close()
+}
+
+func (c *sftpClient) send(typ byte, payload []byte) error {
+	packet := binary.BigEndian.AppendUint32(make([]byte, 0, 5+len(payload)), uint32(1+len(payload)))
+	packet = append(packet, typ)
+	_, err := c.stdin.Write(append(packet, payload...))
+	return err
+}
+
+func (c *sftpClient) recv() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.stdout, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < 1 || length > sftpMaxPacket {
+		return 0, nil, fmt.Errorf("bad sftp packet length %d", length)
+	}
+	payload := make([]byte, length-1)
+	if _, err := io.ReadFull(c.stdout, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[4], payload, nil
+}
+
+// request sends a request with a fresh ID ahead of payload and returns the
+// response to it, minus the ID.
+func (c *sftpClient) request(typ byte, payload []byte) (byte, []byte, error) {
+	c.nextID++
+	id := c.nextID
+	if err := c.send(typ, append(binary.BigEndian.AppendUint32(nil, id), payload...)); err != nil {
+		return 0, nil, err
+	}
+	respType, resp, err := c.recv()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(resp) < 4 || binary.BigEndian.Uint32(resp) != id {
+		return 0, nil, errors.New("sftp response out of order")
+	}
+	return respType, resp[4:], nil
+}
+
+// open opens path with the given pflags and no attributes, so a created
+// file gets the server's default mode.
+func (c *sftpClient) open(path string, pflags uint32) (*sftpFile, error) {
+	payload := appendSFTPString(nil, []byte(path))
+	payload = binary.BigEndian.AppendUint32(payload, pflags)
+	payload = binary.BigEndian.AppendUint32(payload, 0)
+	typ, resp, err := c.request(sftpOpen, payload)
+	if err != nil {
+		return nil, err
+	}
+	if typ != sftpHandle {
+		return nil, sftpResponseError(typ, resp)
+	}
+	handle, _, ok := readSFTPString(resp)
+	if !ok {
+		return nil, errors.New("malformed sftp handle")
+	}
+	return &sftpFile{client: c, handle: handle}, nil
+}
+
+// remove deletes path.
+func (c *sftpClient) remove(path string) error {
+	typ, resp, err := c.request(sftpRemove, appendSFTPString(nil, []byte(path)))
+	if err != nil {
+		return err
+	}
+	return sftpResponseError(typ, resp)
+}
+
+// rename moves oldPath to newPath, replacing any file there: in one step
+// where the server supports posix-rename, and otherwise by removing the
+// file first.
+func (c *sftpClient) rename(oldPath, newPath string) error {
+	paths := appendSFTPString(appendSFTPString(nil, []byte(oldPath)), []byte(newPath))
+	if c.extensions[sftpPosixRename] {
+		typ, resp, err := c.request(sftpExtended, append(appendSFTPString(nil, []byte(sftpPosixRename)), paths...))
+		if err != nil {
+			return err
+		}
+		return sftpResponseError(typ, resp)
+	}
+	var status *sftpStatusError
+	if err := c.remove(newPath); err != nil && !(errors.As(err, &status) && status.code == sftpNoSuchFile) {
+		return err
+	}
+	typ, resp, err := c.request(sftpRename, paths)
+	if err != nil {
+		return err
+	}
+	return sftpResponseError(typ, resp)
+}
+
+// sftpFile is an open remote file read or written sequentially.
+type sftpFile struct {
+	client *sftpClient
+	handle []byte
+	offset uint64
+}
+
+// Read reads the next chunk of the file. A server that answers a read with
+// no data and no EOF status would have io.Copy loop forever, so that is
+// io.ErrNoProgress.
+func (f *sftpFile) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if len(p) > sftpChunk {
+		p = p[:sftpChunk]
+	}
+	payload := appendSFTPString(nil, f.handle)
+	payload = binary.BigEndian.AppendUint64(payload, f.offset)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(len(p)))
+	typ, resp, err := f.client.request(sftpRead, payload)
+	if err != nil {
+		return 0, err
+	}
+	if typ != sftpData {
+		return 0, sftpResponseError(typ, resp)
+	}
+	data, _, ok := readSFTPString(resp)
+	if !ok || len(data) > len(p) {
+		return 0, errors.New("malformed sftp data")
+	}
+	if len(data) == 0 {
+		return 0, io.ErrNoProgress
+	}
+	n := copy(p, data)
+	f.offset += uint64(n)
+	return n, nil
+}
+
+func (f *sftpFile) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), sftpChunk)]
+		payload := appendSFTPString(nil, f.handle)
+		payload = binary.BigEndian.AppendUint64(payload, f.offset)
+		payload = appendSFTPString(payload, chunk)
+		typ, resp, err := f.client.request(sftpWrite, payload)
+		if err != nil {
+			return written, err
+		}
+		if err := sftpResponseError(typ, resp); err != nil {
+			return written, err
+		}
+		f.offset += uint64(len(chunk))
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// Close closes the handle; for a written file this is where the server
+// reports a failure to store it.
+func (f *sftpFile) Close() error {
+	typ, resp, err := f.client.request(sftpClose, appendSFTPString(nil, f.handle))
+	if err != nil {
+		return err
+	}
+	return sftpResponseError(typ, resp)
+}
+
+// sftpStatusError is a status response other than OK.
+type sftpStatusError struct {
+	code    uint32
+	message string
+}
+
+func (e *sftpStatusError) Error() string {
+	if e.message != "" {
+		return "sftp: " + e.message
+	}
+	return fmt.Sprintf("sftp: status %d", e.code)
+}
+
+// sftpResponseError returns the error a response carries: nil for an OK
+// status, io.EOF for an EOF status and an *sftpStatusError otherwise.
+func sftpResponseError(typ byte, resp []byte) error {
+	if typ != sftpStatus || len(resp) < 4 {
+		return fmt.Errorf("unexpected sftp packet type %d", typ)
+	}
+	code := binary.BigEndian.Uint32(resp)
+	switch code {
+	case sftpOK:
+		return nil
+	case sftpEOF:
+		return io.EOF
+	}
+	message, _, _ := readSFTPString(resp[4:])
+	return &sftpStatusError{code: code, message: string(message)}
+}
+
+func appendSFTPString(b, s []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(b, uint32(len(s))), s...)
+}
+
+func readSFTPString(b []byte) (s, rest []byte, ok bool) {
+	if len(b) < 4 {
+		return nil, nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint64(len(b)-4) < uint64(n) {
+		return nil, nil, false
+	}
+	return b[4 : 4+n], b[4+n:], true
+}
@@ -0,0 +1,208 @@
+package matrixio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"routes/pkg/matrix"
+)
+
+const (
+	sheetsBaseURL = "https://sheets.googleapis.com/v4/spreadsheets"
+	sheetsScope   = "https://www.googleapis.com/auth/spreadsheets"
+)
+
+// sheetRef identifies a range of a spreadsheet, written as
+// sheets://SPREADSHEET_ID/RANGE, e.g. sheets://1AbC.../Sites!A1:G.
+type sheetRef struct {
+	spreadsheetID string
+	rng           string
+}
+
+func parseSheetRef(ref string) (sheetRef, error) {
+	id, rng, ok := strings.Cut(ref, "/")
+	if !ok || id == "" || rng == "" {
+		return sheetRef{}, fmt.Errorf("invalid sheet reference %q, expected sheets://SPREADSHEET_ID/RANGE", ref)
+	}
+	return sheetRef{spreadsheetID: id, rng: rng}, nil
+}
+
+// tab returns the sheet (tab) name part of the range.
+func (r sheetRef) tab() string {
+	tab, _, _ := strings.Cut(r.rng, "!")
+	return strings.Trim(tab, "'")
+}
+
+// sheetsClient returns an HTTP client authorized with Application Default
+// Credentials, normally a service account key referenced by
+// GOOGLE_APPLICATION_CREDENTIALS.
+func sheetsClient(ctx context.Context) (*http.Client, error) {
+	creds, err := google.FindDefaultCredentials(ctx, sheetsScope)
+	if err != nil {
+		return nil, fmt.Errorf("finding Google credentials: %w", err)
+	}
+	return oauth2.NewClient(ctx, creds.TokenSource), nil
+}
+
+func readRoutesFromSheet(ref string, cfg Config) ([]matrix.Route, error) {
+	sheet, err := parseSheetRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	client, err := sheetsClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Values [][]any `json:"values"`
+	}
+	endpoint := fmt.Sprintf("%s/%s/values/%s?valueRenderOption=UNFORMATTED_VALUE", sheetsBaseURL, sheet.spreadsheetID, url.PathEscape(sheet.rng))
+	if err := sheetsCall(client, http.MethodGet, endpoint, nil, &resp); err != nil {
+		return nil, err
+	}
+
+	records := make([][]string, len(resp.Values))
+	for i, row := range resp.Values {
+		records[i] = make([]string, len(row))
+		for j, v := range row {
+			records[i][j] = sheetCellString(v)
+		}
+	}
+
+	return parseRoutes(records, cfg)
+}
+
+// sheetCellString formats an unformatted cell value. Numbers are printed in
+// full precision so coordinates are not rounded by the sheet's display format.
+func sheetCellString(v any) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// writeResultsToSheet replaces the contents of the target tab with the
+// results, in the selected columns if any, creating the tab when it does
+// not exist yet.
+func writeResultsToSheet(ref string, units []DistanceUnit, results []matrix.Result, failed FailurePolicy, columns []string) error {
+	sheet, err := parseSheetRef(ref)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	client, err := sheetsClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := ensureSheetTab(client, sheet); err != nil {
+		return err
+	}
+
+	base := fmt.Sprintf("%s/%s/values/%s", sheetsBaseURL, sheet.spreadsheetID, url.PathEscape(sheet.rng))
+	if err := sheetsCall(client, http.MethodPost, base+":clear", struct{}{}, nil); err != nil {
+		return err
+	}
+
+	body := map[string]any{
+		"range":          sheet.rng,
+		"majorDimension": "ROWS",
+		"values":         resultRecords(units, results, failed, columns),
+	}
+	return sheetsCall(client, http.MethodPut, base+"?valueInputOption=RAW", body, nil)
+}
+
+func ensureSheetTab(client *http.Client, sheet sheetRef) error {
+	var meta struct {
+		Sheets []struct {
+			Properties struct {
+				Title string `json:"title"`
+			} `json:"properties"`
+		} `json:"sheets"`
+	}
+	endpoint := fmt.Sprintf("%s/%s?fields=sheets.properties.title", sheetsBaseURL, sheet.spreadsheetID)
+	if err := sheetsCall(client, http.MethodGet, endpoint, nil, &meta); err != nil {
+		return err
+	}
+
+	for _, s := range meta.Sheets {
+		if s.Properties.Title == sheet.tab() {
+			return nil
+		}
+	}
+
+	body := map[string]any{
+		"requests": []any{
+			map[string]any{"addSheet": map[string]any{"properties": map[string]any{"title": sheet.tab()}}},
+		},
+	}
+	return sheetsCall(client, http.MethodPost, fmt.Sprintf("%s/%s:batchUpdate", sheetsBaseURL, sheet.spreadsheetID), body, nil)
+}
+
+// sheetsCall sends a JSON request to the Sheets API and decodes the response into out.
+func sheetsCall(client *http.Client, method, endpoint string, in, out any) error {
+	var reqBody io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, endpoint, reqBody)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error.Message != "" {
+			return fmt.Errorf("Sheets API error: %s", apiErr.Error.Message)
+		}
+		return fmt.Errorf("Sheets API error: %s", resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
@@ -0,0 +1,140 @@
+package matrixio
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"routes/pkg/matrix"
+)
+
+// sqliteSchema creates the results table. Rows are keyed by site and terminal so
+// repeated runs update the stored distance instead of adding duplicates. The
+// distance and duration are NULL for failed rows under a placeholder policy.
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS route_distances (
+	site_code     TEXT NOT NULL,
+	site_name     TEXT NOT NULL,
+	terminal_code TEXT NOT NULL,
+	distance_km   REAL,
+	duration      TEXT,
+	updated_at    TEXT NOT NULL,
+	PRIMARY KEY (site_code, terminal_code)
+)`
+
+const sqliteUpsert = `INSERT INTO route_distances (site_code, site_name, terminal_code, distance_km, duration, updated_at%s)
+VALUES (?, ?, ?, ?, ?, ?%s)
+ON CONFLICT (site_code, terminal_code) DO UPDATE SET
+	site_name   = excluded.site_name,
+	distance_km = excluded.distance_km,
+	duration    = excluded.duration,
+	updated_at  = excluded.updated_at%s`
+
+// writeResultsToSQLite upserts the results. distance_km is always stored;
+// other configured units get their own distance_<unit> columns, which are
+// added to existing tables as needed. Under a placeholder policy, failed
+// rows are stored with NULL distances and duration.
+func writeResultsToSQLite(path string, units []DistanceUnit, results []matrix.Result, failed FailurePolicy) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return err
+	}
+
+	var extra []DistanceUnit
+	for _, u := range units {
+		if u.name != "km" {
+			extra = append(extra, u)
+		}
+	}
+	notNull, err := addSQLiteUnitColumns(db, extra)
+	if err != nil {
+		return err
+	}
+	if notNull {
+		for _, r := range results {
+			if failed.isNull(r) {
+				return fmt.Errorf("route_distances in %s was created with NOT NULL distances, which cannot hold failed rows under the %q policy; recreate the table or use -on-failure zero or omit", path, failed)
+			}
+		}
+	}
+	var columns, placeholders, updates string
+	for _, u := range extra {
+		columns += ", " + u.field()
+		placeholders += ", ?"
+		updates += fmt.Sprintf(",\n\t%s = excluded.%s", u.field(), u.field())
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(fmt.Sprintf(sqliteUpsert, columns, placeholders, updates))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	updatedAt := time.Now().UTC().Format(time.RFC3339)
+	for _, r := range results {
+		null := failed.isNull(r)
+		args := []any{r.SiteCode, r.SiteName, r.TerminalCode, r.DistanceKm, r.Duration, updatedAt}
+		if null {
+			args[3], args[4] = nil, nil
+		}
+		for _, u := range extra {
+			var distance any = u.FromKm(r.DistanceKm)
+			if null {
+				distance = nil
+			}
+			args = append(args, distance)
+		}
+		if _, err := stmt.Exec(args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// addSQLiteUnitColumns adds the columns of units that route_distances lacks.
+// It reports whether the table is one created before failed rows could be
+// stored, with NOT NULL distance_km or duration columns.
+func addSQLiteUnitColumns(db *sql.DB, units []DistanceUnit) (notNull bool, err error) {
+	rows, err := db.Query("SELECT name, \"notnull\" FROM pragma_table_info('route_distances')")
+	if err != nil {
+		return false, err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		var nn bool
+		if err := rows.Scan(&name, &nn); err != nil {
+			rows.Close()
+			return false, err
+		}
+		existing[name] = true
+		notNull = notNull || nn && (name == "distance_km" || name == "duration")
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	for _, u := range units {
+		if existing[u.field()] {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE route_distances ADD COLUMN " + u.field() + " REAL"); err != nil {
+			return false, err
+		}
+	}
+	return notNull, nil
+}
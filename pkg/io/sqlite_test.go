@@ -0,0 +1,67 @@
+package matrixio
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"routes/pkg/matrix"
+)
+
+func TestWriteResultsToSQLiteFailedRows(t *testing.T) {
+	units, err := ParseDistanceUnits([]string{"km", "mi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []matrix.Result{
+		{Route: matrix.Route{SiteCode: "S1", SiteName: "Alpha", TerminalCode: "T1"}, DistanceKm: 20.38, Duration: "24 mins", Status: "OK"},
+		{Route: matrix.Route{SiteCode: "S2", SiteName: "Beta", TerminalCode: "T1"}, Duration: "N/A", Status: "NOT_FOUND"},
+	}
+	tests := []struct {
+		failed   FailurePolicy
+		wantNull bool
+	}{
+		{"zero", false},
+		{"NULL", true},
+		{"empty", true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "results.db")
+		if err := writeResultsToSQLite(path, units, results, tt.failed); err != nil {
+			t.Fatalf("%s: %v", tt.failed, err)
+		}
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var km, mi sql.NullFloat64
+		var duration sql.NullString
+		err = db.QueryRow("SELECT distance_km, distance_mi, duration FROM route_distances WHERE site_code = 'S2'").Scan(&km, &mi, &duration)
+		db.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.failed, err)
+		}
+		if null := !km.Valid && !mi.Valid && !duration.Valid; null != tt.wantNull {
+			t.Errorf("%s: failed row stored as %v, %v, %v; want NULL %v", tt.failed, km, mi, duration, tt.wantNull)
+		}
+	}
+
+	// Tables created before failed rows could be NULL cannot take them.
+	path := filepath.Join(t.TempDir(), "old.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(strings.Replace(sqliteSchema, "distance_km   REAL,", "distance_km   REAL NOT NULL,", 1))
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeResultsToSQLite(path, units, results, "NULL"); err == nil {
+		t.Error("NULL distances were written to a NOT NULL table")
+	}
+	if err := writeResultsToSQLite(path, units, results, "zero"); err != nil {
+		t.Errorf("zero policy on an old table: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package matrixio
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// sqsWaitSeconds is how long a ReceiveMessage call waits for a message,
+// the most SQS allows.
+const sqsWaitSeconds = "20"
+
+// sqsBackend reaches sqs://HOST/ACCOUNT/QUEUE queues, whose https URL is
+// the queue URL, through the SQS query API, signing requests with
+// LoadAWSCredentials. The region is taken from the host, else AWS_REGION
+// (us-east-1 by default). AWS_ENDPOINT_URL_SQS or AWS_ENDPOINT_URL point it
+// at an SQS-compatible service instead.
+type sqsBackend struct{}
+
+func (sqsBackend) consumer(u *url.URL) (QueueConsumer, error) {
+	return newSQSQueue(u)
+}
+
+func (sqsBackend) publisher(u *url.URL) (QueuePublisher, error) {
+	return newSQSQueue(u)
+}
+
+// sqsQueue is one queue, both consumed and published to.
+type sqsQueue struct {
+	name     string
+	queueURL string
+	region   string
+}
+
+func newSQSQueue(u *url.URL) (*sqsQueue, error) {
+	account, queue, ok := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if u.Host == "" || !ok || account == "" || queue == "" || strings.Contains(queue, "/") {
+		return nil, fmt.Errorf("%s: want sqs://HOST/ACCOUNT/QUEUE", u)
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_SQS")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = "https://" + u.Host
+	}
+	region := AWSRegion("us-east-1")
+	if parts := strings.Split(u.Host, "."); len(parts) == 4 && parts[0] == "sqs" && parts[2] == "amazonaws" {
+		region = parts[1]
+	}
+	return &sqsQueue{name: u.String(), queueURL: strings.TrimSuffix(endpoint, "/") + u.Path, region: region}, nil
+}
+
+func (q *sqsQueue) Receive(ctx context.Context) (*QueueMessage, error) {
+	for {
+		var out struct {
+			Messages []struct {
+				Body          string
+				ReceiptHandle string
+			} `xml:"ReceiveMessageResult>Message"`
+		}
+		params := url.Values{"MaxNumberOfMessages": {"1"}, "WaitTimeSeconds": {sqsWaitSeconds}}
+		if err := q.call(ctx, "ReceiveMessage", params, &out); err != nil {
+			return nil, err
+		}
+		if len(out.Messages) == 0 {
+			continue // the wait ran out
+		}
+		receipt := out.Messages[0].ReceiptHandle
+		return &QueueMessage{
+			Body: []byte(out.Messages[0].Body),
+			ack: func(ctx context.Context) error {
+				return q.call(ctx, "DeleteMessage", url.Values{"ReceiptHandle": {receipt}}, nil)
+			},
+			nack: func(ctx context.Context) error {
+				return q.call(ctx, "ChangeMessageVisibility", url.Values{"ReceiptHandle": {receipt}, "VisibilityTimeout": {"0"}}, nil)
+			},
+		}, nil
+	}
+}
+
+func (q *sqsQueue) Publish(ctx context.Context, body []byte) error {
+	return q.call(ctx, "SendMessage", url.Values{"MessageBody": {string(body)}}, nil)
+}
+
+func (q *sqsQueue) Close() error {
+	return nil
+}
+
+// call sends one action to the queue and decodes its XML response into out,
+// unless out is nil.
+func (q *sqsQueue) call(ctx context.Context, action string, params url.Values, out any) error {
+	creds, err := LoadAWSCredentials()
+	if err != nil {
+		return err
+	}
+	params.Set("Action", action)
+	params.Set("Version", "2012-11-05")
+	body := params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, q.queueURL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	SignAWSRequest(req, SHA256Hex([]byte(body)), creds, q.region, "sqs", time.Now())
+
+	resp, err := storageClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%s: %s: %w", q.name, action, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var sqsErr struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		if xml.Unmarshal(data, &sqsErr) == nil && sqsErr.Code != "" {
+			return fmt.Errorf("%s: %s: %s: %s", q.name, action, sqsErr.Code, sqsErr.Message)
+		}
+		return fmt.Errorf("%s: %s: %s", q.name, action, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := xml.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%s: decoding %s response: %w", q.name, action, err)
+	}
+	return nil
+}
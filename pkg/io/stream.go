@@ -0,0 +1,98 @@
+package matrixio
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"routes/pkg/matrix"
+)
+
+// IsStreamable reports whether cfg can be processed one row at a time. Only
+// CSV input, from a file or stdin, is read row by row; database and
+// spreadsheet outputs are written in a single batch.
+func IsStreamable(cfg Config) bool {
+	if strings.HasPrefix(cfg.Input, "sheets://") || isExcelFile(cfg.Input) || isJSONFile(cfg.Input) {
+		return false
+	}
+	for _, prefix := range []string{"sqlite://", "sheets://"} {
+		if strings.HasPrefix(cfg.Output, prefix) {
+			return false
+		}
+	}
+	// A GeoJSON FeatureCollection is only complete once every row is in.
+	if cfg.outputFormat() == "geojson" {
+		return false
+	}
+	return !IsPostgresDSN(cfg.Output)
+}
+
+// StreamWriter writes results one at a time, emitting the CSV header before
+// the first row.
+type StreamWriter struct {
+	w             io.Writer
+	format        string
+	dialect       CSVConfig
+	units         []DistanceUnit
+	failed        FailurePolicy
+	tmpl          *template.Template
+	columns       []string
+	headerWritten bool
+}
+
+// NewStreamWriter writes results to w in the CSV, JSON or template output
+// format of cfg.
+func NewStreamWriter(w io.Writer, cfg Config) (*StreamWriter, error) {
+	format := cfg.outputFormat()
+	if format != "csv" && format != "json" && format != "template" {
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+	units, err := ParseDistanceUnits(cfg.DistanceUnits)
+	if err != nil {
+		return nil, err
+	}
+	s := &StreamWriter{w: w, format: format, dialect: cfg.CSV, units: units, failed: cfg.OnFailure, columns: cfg.OutputColumns}
+	if format == "template" {
+		if s.tmpl, err = ParseOutputTemplate(cfg.OutputTemplate); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// OmitHeader leaves out the CSV header, for appending to output that
+// already has one.
+func (s *StreamWriter) OmitHeader() {
+	s.headerWritten = true
+}
+
+// WriteResult writes one result, unless the failure policy omits it.
+func (s *StreamWriter) WriteResult(r matrix.Result) error {
+	if len(s.failed.Filter([]matrix.Result{r})) == 0 {
+		return nil
+	}
+	switch s.format {
+	case "json":
+		return WriteResultsToJSON(s.w, s.units, []matrix.Result{r}, s.failed, s.columns)
+	case "template":
+		return writeResultsWithTemplate(s.w, s.tmpl, s.units, []matrix.Result{r}, s.failed)
+	}
+
+	header := ResultHeader(s.units, r)
+	records := [][]string{pickColumns(s.columns, header, ResultRecord(s.units, r, s.failed))}
+	if !s.headerWritten {
+		records = append([][]string{pickHeader(s.columns, header)}, records...)
+		s.headerWritten = true
+	}
+	return s.dialect.WriteAll(s.w, records)
+}
+
+// Finish writes the CSV header if no rows were written.
+func (s *StreamWriter) Finish() error {
+	if s.format != "csv" || s.headerWritten {
+		return nil
+	}
+	s.headerWritten = true
+	return s.dialect.WriteAll(s.w, resultRecords(s.units, nil, s.failed, s.columns))
+}
@@ -0,0 +1,91 @@
+package matrixio
+
+import (
+	"strings"
+	"testing"
+
+	"routes/pkg/matrix"
+)
+
+func TestIsStreamable(t *testing.T) {
+	for _, tt := range []struct {
+		input, output string
+		want          bool
+	}{
+		{"routes.csv", "out.csv", true},
+		{"-", "-", true},
+		{"s3://bucket/routes.csv.gz", "out.json", true},
+		{"routes.xlsx", "out.csv", false},
+		{"routes.jsonl", "out.csv", false},
+		{"sheets://sheet-id/Routes", "out.csv", false},
+		{"routes.csv", "sqlite://out.db", false},
+		{"routes.csv", "postgres://localhost/routes", false},
+		{"routes.csv", "out.geojson", false},
+	} {
+		cfg := DefaultConfig()
+		cfg.Input, cfg.Output = tt.input, tt.output
+		if got := IsStreamable(cfg); got != tt.want {
+			t.Errorf("IsStreamable(%s -> %s) = %v, want %v", tt.input, tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestStreamWriter(t *testing.T) {
+	ok := matrix.Result{Route: matrix.Route{SiteCode: "S1", TerminalCode: "T1"}, DistanceKm: 20.38, Duration: "24 mins", Status: "OK"}
+	failed := matrix.Result{Route: matrix.Route{SiteCode: "S2", TerminalCode: "T1"}, Duration: "N/A", Status: "ZERO_RESULTS"}
+	tests := []struct {
+		name       string
+		output     string
+		onFailure  FailurePolicy
+		omitHeader bool
+		results    []matrix.Result
+		want       string
+	}{
+		{"header before the first row only", "out.csv", "", false, []matrix.Result{ok, failed},
+			"SITE_CODE,SITE_NAME,TERMINAL_CODE,DISTANCE_KM,DURATION\nS1,,T1,20.38,24 mins\nS2,,T1,0.00,N/A\n"},
+		{"header without rows", "out.csv", "", false, nil,
+			"SITE_CODE,SITE_NAME,TERMINAL_CODE,DISTANCE_KM,DURATION\n"},
+		{"appending", "out.csv", "", true, []matrix.Result{ok},
+			"S1,,T1,20.38,24 mins\n"},
+		{"appending nothing", "out.csv", "", true, nil, ""},
+		{"failures omitted", "out.csv", "omit", false, []matrix.Result{failed, ok},
+			"SITE_CODE,SITE_NAME,TERMINAL_CODE,DISTANCE_KM,DURATION\nS1,,T1,20.38,24 mins\n"},
+		{"JSON Lines", "out.jsonl", "NULL", false, []matrix.Result{ok, failed},
+			`{"distance_km":20.38,"duration":"24 mins","site_code":"S1","site_name":"","terminal_code":"T1"}` + "\n" +
+				`{"distance_km":null,"duration":null,"site_code":"S2","site_name":"","terminal_code":"T1"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Output = tt.output
+			cfg.OnFailure = tt.onFailure
+			var b strings.Builder
+			s, err := NewStreamWriter(&b, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.omitHeader {
+				s.OmitHeader()
+			}
+			for _, r := range tt.results {
+				if err := s.WriteResult(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.Finish(); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("wrote\n%s\nwant\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestNewStreamWriterRejectsBatchFormats(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Output = "out.geojson"
+	if _, err := NewStreamWriter(&strings.Builder{}, cfg); err == nil {
+		t.Error("a GeoJSON stream was accepted")
+	}
+}
@@ -0,0 +1,58 @@
+package matrixio
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// boundingBox is the area input points are expected in, such as the country
+// a file covers.
+type boundingBox struct {
+	minLat, minLng, maxLat, maxLng float64
+}
+
+// parseBoundingBox reads "minLat,minLng,maxLat,maxLng". An empty box means
+// none.
+func parseBoundingBox(s string) (*boundingBox, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("bounding box %q is not minLat,minLng,maxLat,maxLng", s)
+	}
+	var v [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bounding box %q: %q is not a number", s, part)
+		}
+		v[i] = f
+	}
+	b := &boundingBox{minLat: v[0], minLng: v[1], maxLat: v[2], maxLng: v[3]}
+	if b.minLat > b.maxLat || b.minLng > b.maxLng {
+		return nil, fmt.Errorf("bounding box %q has its minimum above its maximum", s)
+	}
+	return b, nil
+}
+
+func (b *boundingBox) contains(lat, lng float64) bool {
+	return lat >= b.minLat && lat <= b.maxLat && lng >= b.minLng && lng <= b.maxLng
+}
+
+// looksSwapped reports whether lat and lng appear to be in each other's
+// columns: the latitude is out of range while the longitude would make a
+// valid one, or the point lies outside bounds but its mirror image inside.
+func looksSwapped(lat, lng string, bounds *boundingBox) bool {
+	y, errY := strconv.ParseFloat(lat, 64)
+	x, errX := strconv.ParseFloat(lng, 64)
+	if errY != nil || errX != nil {
+		return false
+	}
+	if math.Abs(y) > 90 {
+		return math.Abs(y) <= 180 && math.Abs(x) <= 90
+	}
+	return bounds != nil && !bounds.contains(y, x) && bounds.contains(x, y)
+}
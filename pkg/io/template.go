@@ -0,0 +1,83 @@
+package matrixio
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"routes/pkg/matrix"
+)
+
+// templateFuncs are the functions an output template can call besides the
+// text/template builtins, which include printf for fixed-width fields such
+// as {{printf "%-10.10s" .SITE_CODE}}.
+var templateFuncs = template.FuncMap{
+	// sql quotes a value as an SQL string literal.
+	"sql": func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" },
+}
+
+// ParseOutputTemplate parses an output template: the text/template itself
+// or, after an @, the name of a file holding it. The template is applied to
+// each result row, a map from the output's column names to the row's
+// values, so {{.SITE_CODE}} is the row's site code. Each row's text ends
+// with a newline; a file's own final newline is dropped so rows are not
+// followed by blank lines. Referring to a column the output lacks is an
+// error rather than an empty value.
+func ParseOutputTemplate(text string) (*template.Template, error) {
+	name := "output-template"
+	if path, ok := strings.CutPrefix(text, "@"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading output template: %w", err)
+		}
+		name, text = path, strings.TrimSuffix(string(data), "\n")
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing output template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// CheckOutputTemplate fails unless cfg.OutputTemplate, if set, parses and
+// the output is a file or stdout that the template's text can go to.
+func CheckOutputTemplate(cfg Config) error {
+	if cfg.OutputTemplate == "" {
+		return nil
+	}
+	if cfg.Output != "-" && !isFileOutput(cfg.Output) {
+		return fmt.Errorf("an output template needs a file output or stdout, not %s", cfg.Output)
+	}
+	_, err := ParseOutputTemplate(cfg.OutputTemplate)
+	return err
+}
+
+// outputFormat is the format the results of cfg are written in: "template"
+// with an output template, else as OutputFormat infers it.
+func (c Config) outputFormat() string {
+	if c.OutputTemplate != "" {
+		return "template"
+	}
+	return OutputFormat(c.Output, c.Format)
+}
+
+// writeResultsWithTemplate writes each result as tmpl lays it out.
+func writeResultsWithTemplate(w io.Writer, tmpl *template.Template, units []DistanceUnit, results []matrix.Result, failed FailurePolicy) error {
+	for _, r := range failed.Filter(results) {
+		header := ResultHeader(units, r)
+		record := ResultRecord(units, r, failed)
+		row := make(map[string]string, len(header))
+		for i, name := range header {
+			row[name] = record[i]
+		}
+		if err := tmpl.Execute(w, row); err != nil {
+			return fmt.Errorf("row %s/%s: %w", r.SiteCode, r.TerminalCode, err)
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
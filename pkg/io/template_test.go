@@ -0,0 +1,49 @@
+package matrixio
+
+import (
+	"strings"
+	"testing"
+
+	"routes/pkg/matrix"
+)
+
+func TestWriteResultsWithTemplate(t *testing.T) {
+	results := []matrix.Result{
+		{Route: matrix.Route{SiteCode: "S1", SiteName: "O'Hare", TerminalCode: "T1"}, DistanceKm: 20.38, Duration: "24 mins", Status: "OK"},
+		{Route: matrix.Route{SiteCode: "S2", SiteName: "Beta", TerminalCode: "T1"}, Status: "NOT_FOUND"},
+	}
+	tests := []struct {
+		name, template string
+		failed         FailurePolicy
+		want           string
+	}{
+		{"fixed width", `{{printf "%-4s" .SITE_CODE}}{{printf "%8s" .DISTANCE_KM}}`, "", "S1     20.38\nS2      0.00\n"},
+		{"sql", `INSERT INTO routes VALUES ({{sql .SITE_NAME}}, {{.DISTANCE_KM}});`, "omit", "INSERT INTO routes VALUES ('O''Hare', 20.38);\n"},
+		{"placeholder", `{{.SITE_CODE}} {{.DURATION}}`, "NULL", "S1 24 mins\nS2 NULL\n"},
+	}
+	units, err := ParseDistanceUnits(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		tmpl, err := ParseOutputTemplate(tt.template)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var b strings.Builder
+		if err := writeResultsWithTemplate(&b, tmpl, units, results, tt.failed); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if b.String() != tt.want {
+			t.Errorf("%s: wrote %q, want %q", tt.name, b.String(), tt.want)
+		}
+	}
+
+	tmpl, err := ParseOutputTemplate("{{.DISTANCE_MI}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeResultsWithTemplate(&strings.Builder{}, tmpl, units, results, ""); err == nil {
+		t.Error("a column the output lacks was not an error")
+	}
+}
@@ -0,0 +1,97 @@
+package matrixio
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"routes/pkg/matrix"
+)
+
+// DistanceUnit is a unit distances can be written in.
+type DistanceUnit struct {
+	name     string
+	metres   float64
+	decimals int
+}
+
+// distanceUnits is the registry of output units, keyed by the name used in
+// config files and column names.
+var distanceUnits = map[string]DistanceUnit{
+	"km":  {name: "km", metres: 1000, decimals: 2},
+	"mi":  {name: "mi", metres: 1609.344, decimals: 2},
+	"m":   {name: "m", metres: 1, decimals: 0},
+	"nmi": {name: "nmi", metres: 1852, decimals: 2},
+}
+
+// defaultDistanceUnits keeps the original single DISTANCE_KM column.
+var defaultDistanceUnits = []DistanceUnit{distanceUnits["km"]}
+
+// ParseDistanceUnits looks up the named units in order. No names means the
+// default of kilometres only.
+func ParseDistanceUnits(names []string) ([]DistanceUnit, error) {
+	if len(names) == 0 {
+		return defaultDistanceUnits, nil
+	}
+
+	var units []DistanceUnit
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		u, ok := distanceUnits[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown distance unit %q (want km, mi, m or nmi)", name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			units = append(units, u)
+		}
+	}
+	return units, nil
+}
+
+// DefaultUnitsFor writes miles instead of kilometres for the imperial system
+// unless the output units were chosen explicitly.
+func (c *Config) DefaultUnitsFor(opts matrix.QueryOptions) {
+	if opts.Units == "imperial" && len(c.DistanceUnits) == 0 {
+		c.DistanceUnits = []string{"mi"}
+	}
+}
+
+// Column is the output column name, e.g. DISTANCE_MI.
+func (u DistanceUnit) Column() string {
+	return "DISTANCE_" + strings.ToUpper(u.name)
+}
+
+// field is the lower-case name used by JSON and database outputs.
+func (u DistanceUnit) field() string {
+	return "distance_" + u.name
+}
+
+// Name is the unit's short name, e.g. mi.
+func (u DistanceUnit) Name() string {
+	return u.name
+}
+
+// FromKm converts a distance in kilometres to the unit.
+func (u DistanceUnit) FromKm(km float64) float64 {
+	return km * 1000 / u.metres
+}
+
+func (u DistanceUnit) Format(km float64) string {
+	return strconv.FormatFloat(u.FromKm(km), 'f', u.decimals, 64)
+}
+
+// DescribeDistanceUnits names each unit with its conversion from the API's
+// metres, for the run certificate.
+func DescribeDistanceUnits(names []string) string {
+	units, err := ParseDistanceUnits(names)
+	if err != nil {
+		return err.Error()
+	}
+	var parts []string
+	for _, u := range units {
+		parts = append(parts, fmt.Sprintf("%s (API metres / %g, %d decimals)", u.name, u.metres, u.decimals))
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,288 @@
+package matrixio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"routes/pkg/matrix"
+)
+
+// FailurePolicy is how rows without a route are written: "zero" or empty
+// for a 0 distance and N/A duration, "omit" to leave them out, "empty" for
+// empty cells, or any other text, such as NULL or -1, as a placeholder for
+// the distance and duration. JSON and database outputs write placeholders
+// as null.
+type FailurePolicy string
+
+// placeholder returns the text written for the distance and duration of a
+// failed row, and false when they keep their zero values.
+func (f FailurePolicy) placeholder() (string, bool) {
+	switch f {
+	case "", "zero", "omit":
+		return "", false
+	case "empty":
+		return "", true
+	}
+	return string(f), true
+}
+
+// isNull reports whether the distance and duration of r are written as
+// null: r failed and the policy writes a placeholder for them.
+func (f FailurePolicy) isNull(r matrix.Result) bool {
+	_, placeholder := f.placeholder()
+	return placeholder && r.Status != "OK"
+}
+
+// Filter returns results without the failed rows under "omit", and all of
+// them otherwise.
+func (f FailurePolicy) Filter(results []matrix.Result) []matrix.Result {
+	if f != "omit" {
+		return results
+	}
+	var kept []matrix.Result
+	for _, r := range results {
+		if r.Status == "OK" {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// writeResultsToFile writes the results in the configured format to the
+// output file, or to stdout when the output is "-".
+func writeResultsToFile(cfg Config, results []matrix.Result) error {
+	format := cfg.outputFormat()
+	if format != "csv" && format != "json" && format != "geojson" && format != "template" {
+		return fmt.Errorf("unknown output format %q", format)
+	}
+
+	units, err := ParseDistanceUnits(cfg.DistanceUnits)
+	if err != nil {
+		return err
+	}
+	var tmpl *template.Template
+	if format == "template" {
+		if tmpl, err = ParseOutputTemplate(cfg.OutputTemplate); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Append {
+		return appendResultsToFile(cfg, format, tmpl, units, results)
+	}
+	return WriteOutput(cfg.Output, func(w io.Writer) error {
+		switch format {
+		case "json":
+			return WriteResultsToJSON(w, units, results, cfg.OnFailure, cfg.OutputColumns)
+		case "geojson":
+			return writeResultsToGeoJSON(w, units, results, cfg.OnFailure)
+		case "template":
+			return writeResultsWithTemplate(w, tmpl, units, results, cfg.OnFailure)
+		}
+		return WriteResultsToCSV(w, cfg.CSV, units, results, cfg.OnFailure, cfg.OutputColumns)
+	})
+}
+
+// appendResultsToFile adds the results to the output file, checking that a
+// CSV file's header is the one this run writes. tmpl lays out the rows of
+// the template format.
+func appendResultsToFile(cfg Config, format string, tmpl *template.Template, units []DistanceUnit, results []matrix.Result) error {
+	switch format {
+	case "geojson":
+		return fmt.Errorf("cannot append to a GeoJSON FeatureCollection")
+	case "template":
+		return AppendOutput(cfg.Output, func(w io.Writer, _ bool) error {
+			return writeResultsWithTemplate(w, tmpl, units, results, cfg.OnFailure)
+		})
+	}
+	results = cfg.OnFailure.Filter(results)
+	records := resultRecords(units, results, cfg.OnFailure, cfg.OutputColumns)
+	if format == "csv" && cfg.Output != "-" && len(results) > 0 {
+		if err := checkAppendHeader(cfg, records[0]); err != nil {
+			return err
+		}
+	}
+
+	return AppendOutput(cfg.Output, func(w io.Writer, header bool) error {
+		if format == "json" {
+			return WriteResultsToJSON(w, units, results, cfg.OnFailure, cfg.OutputColumns)
+		}
+		if !header {
+			records = records[1:]
+		}
+		return cfg.CSV.WriteAll(w, records)
+	})
+}
+
+// checkAppendHeader fails if the CSV output already has rows under another
+// header than header.
+func checkAppendHeader(cfg Config, header []string) error {
+	file, err := OpenInput(cfg.Output)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader, err := cfg.CSV.NewReader(file)
+	if err != nil {
+		return err
+	}
+	existing, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !slices.Equal(existing, header) {
+		return fmt.Errorf("cannot append: the output has columns %s, this run writes %s",
+			strings.Join(existing, ","), strings.Join(header, ","))
+	}
+	return nil
+}
+
+// OutputFormat returns the explicit format, or infers it from the file
+// extension, looking past a compression extension such as .gz.
+func OutputFormat(filename, format string) string {
+	if format != "" {
+		return format
+	}
+	switch strings.ToLower(filepath.Ext(formatName(filename))) {
+	case ".json", ".jsonl":
+		return "json"
+	case ".geojson":
+		return "geojson"
+	default:
+		return "csv"
+	}
+}
+
+// WriteResultsToCSV writes the results with a header row. columns, if any,
+// are the output columns to write, in order (see Config.OutputColumns).
+func WriteResultsToCSV(w io.Writer, dialect CSVConfig, units []DistanceUnit, results []matrix.Result, failed FailurePolicy, columns []string) error {
+	return dialect.WriteAll(w, resultRecords(units, failed.Filter(results), failed, columns))
+}
+
+// WriteResultsToJSON writes one JSON object per line, which jq and most log
+// tooling consume directly. Extra columns use their lower-cased names as keys.
+// columns, if any, are the only keys written.
+func WriteResultsToJSON(w io.Writer, units []DistanceUnit, results []matrix.Result, failed FailurePolicy, columns []string) error {
+	enc := json.NewEncoder(w)
+	for _, r := range failed.Filter(results) {
+		record := map[string]any{
+			"site_code":     r.SiteCode,
+			"site_name":     r.SiteName,
+			"terminal_code": r.TerminalCode,
+		}
+		addResultValues(record, units, r, failed)
+		for _, f := range r.Extra {
+			record[strings.ToLower(f.Name)] = f.Value
+		}
+		pickJSONColumns(columns, record)
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addResultValues sets the duration and distances of r in record, null for
+// a failed row under a placeholder policy.
+func addResultValues(record map[string]any, units []DistanceUnit, r matrix.Result, failed FailurePolicy) {
+	null := failed.isNull(r)
+	record["duration"] = r.Duration
+	if null {
+		record["duration"] = nil
+	}
+	for _, u := range units {
+		record[u.field()] = u.FromKm(r.DistanceKm)
+		if null {
+			record[u.field()] = nil
+		}
+	}
+}
+
+// resultRecords lays out the results as rows, header first, for tabular
+// outputs, in the selected columns if there are any.
+func resultRecords(units []DistanceUnit, results []matrix.Result, failed FailurePolicy, columns []string) [][]string {
+	if len(results) == 0 {
+		if len(columns) > 0 {
+			return [][]string{columns}
+		}
+		return [][]string{ResultHeader(units, matrix.Result{})}
+	}
+
+	records := [][]string{pickHeader(columns, ResultHeader(units, results[0]))}
+	for _, r := range results {
+		records = append(records, pickColumns(columns, ResultHeader(units, r), ResultRecord(units, r, failed)))
+	}
+	return records
+}
+
+// ResultHeader names the columns of r: one distance column per unit, then
+// its extra fields.
+func ResultHeader(units []DistanceUnit, r matrix.Result) []string {
+	header := []string{"SITE_CODE", "SITE_NAME", "TERMINAL_CODE"}
+	for _, u := range units {
+		header = append(header, u.Column())
+	}
+	header = append(header, "DURATION")
+	for _, f := range r.Extra {
+		header = append(header, f.Name)
+	}
+	return header
+}
+
+// ResultRecord lays out r as a row matching ResultHeader, with the failed
+// policy's placeholder for the distance and duration of a failed row.
+func ResultRecord(units []DistanceUnit, r matrix.Result, failed FailurePolicy) []string {
+	record := []string{r.SiteCode, r.SiteName, r.TerminalCode}
+	placeholder, ok := failed.placeholder()
+	ok = ok && r.Status != "OK"
+	for _, u := range units {
+		if ok {
+			record = append(record, placeholder)
+		} else {
+			record = append(record, u.Format(r.DistanceKm))
+		}
+	}
+	if ok {
+		record = append(record, placeholder)
+	} else {
+		record = append(record, r.Duration)
+	}
+	for _, f := range r.Extra {
+		record = append(record, f.Value)
+	}
+	return record
+}
+
+// WriteResults sends the results to the output selected by its prefix.
+func WriteResults(cfg Config, results []matrix.Result) error {
+	units, err := ParseDistanceUnits(cfg.DistanceUnits)
+	if err != nil {
+		return err
+	}
+
+	results = cfg.OnFailure.Filter(results)
+	output := cfg.Output
+	if dbPath, ok := strings.CutPrefix(output, "sqlite://"); ok {
+		return writeResultsToSQLite(dbPath, units, results, cfg.OnFailure)
+	}
+	if IsPostgresDSN(output) {
+		return writeResultsToPostgres(output, cfg.Postgres.forUnits(units), results, cfg.OnFailure)
+	}
+	if ref, ok := strings.CutPrefix(output, "sheets://"); ok {
+		return writeResultsToSheet(ref, units, results, cfg.OnFailure, cfg.OutputColumns)
+	}
+	return writeResultsToFile(cfg, results)
+}
@@ -0,0 +1,234 @@
+package matrixio
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"routes/pkg/matrix"
+)
+
+// ExcelConfig selects where the table sits inside an .xlsx workbook.
+type ExcelConfig struct {
+	// Sheet is the worksheet name; empty means the first sheet.
+	Sheet string `json:"sheet,omitempty"`
+	// HeaderRow is the 1-based row holding the column names. Rows above it
+	// (titles, notes) are ignored.
+	HeaderRow int `json:"header_row,omitempty"`
+}
+
+func isExcelFile(filename string) bool {
+	return strings.HasSuffix(strings.ToLower(inputName(filename)), ".xlsx")
+}
+
+func readRoutesFromExcel(filename string, cfg Config) ([]matrix.Route, error) {
+	records, err := readExcelSheet(filename, cfg.Excel.Sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	headerRow := max(cfg.Excel.HeaderRow, 1)
+	if headerRow > len(records) {
+		return nil, fmt.Errorf("header row %d is past the end of the sheet", headerRow)
+	}
+
+	return parseRoutes(records[headerRow-1:], cfg)
+}
+
+// readExcelSheet returns the cell values of a worksheet as rows of text.
+// Numbers keep the full precision stored in the file, not the displayed
+// format, and missing cells are returned as empty strings.
+func readExcelSheet(filename, sheet string) ([][]string, error) {
+	local, cleanup, err := localInput(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	zr, err := zip.OpenReader(local)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	sheetPath, err := excelSheetPath(files, sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	var shared []string
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		if shared, err = readSharedStrings(f); err != nil {
+			return nil, err
+		}
+	}
+
+	f, ok := files[sheetPath]
+	if !ok {
+		return nil, fmt.Errorf("worksheet %s is missing from the workbook", sheetPath)
+	}
+
+	var ws struct {
+		Rows []struct {
+			Cells []struct {
+				Ref    string `xml:"r,attr"`
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := decodeZipXML(f, &ws); err != nil {
+		return nil, err
+	}
+
+	var records [][]string
+	for _, row := range ws.Rows {
+		var record []string
+		for _, c := range row.Cells {
+			col := len(record)
+			if c.Ref != "" {
+				if col, err = excelColumn(c.Ref); err != nil {
+					return nil, err
+				}
+			}
+			for len(record) <= col {
+				record = append(record, "")
+			}
+
+			switch c.Type {
+			case "s":
+				i, err := strconv.Atoi(c.Value)
+				if err != nil || i < 0 || i >= len(shared) {
+					return nil, fmt.Errorf("cell %s refers to an unknown shared string", c.Ref)
+				}
+				record[col] = shared[i]
+			case "inlineStr":
+				record[col] = c.Inline
+			default:
+				record[col] = c.Value
+			}
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// excelSheetPath resolves a sheet name to its part inside the archive.
+func excelSheetPath(files map[string]*zip.File, sheet string) (string, error) {
+	var workbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	f, ok := files["xl/workbook.xml"]
+	if !ok {
+		return "", fmt.Errorf("not an Excel workbook")
+	}
+	if err := decodeZipXML(f, &workbook); err != nil {
+		return "", err
+	}
+	if len(workbook.Sheets) == 0 {
+		return "", fmt.Errorf("workbook has no sheets")
+	}
+
+	relID := workbook.Sheets[0].ID
+	if sheet != "" {
+		relID = ""
+		var names []string
+		for _, s := range workbook.Sheets {
+			names = append(names, s.Name)
+			if s.Name == sheet {
+				relID = s.ID
+			}
+		}
+		if relID == "" {
+			return "", fmt.Errorf("sheet %q not found (available: %s)", sheet, strings.Join(names, ", "))
+		}
+	}
+
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if f, ok := files["xl/_rels/workbook.xml.rels"]; ok {
+		if err := decodeZipXML(f, &rels); err != nil {
+			return "", err
+		}
+	}
+	for _, r := range rels.Relationships {
+		if r.ID == relID {
+			if strings.HasPrefix(r.Target, "/") {
+				return strings.TrimPrefix(r.Target, "/"), nil
+			}
+			return path.Join("xl", r.Target), nil
+		}
+	}
+	return "", fmt.Errorf("worksheet relationship %q not found", relID)
+}
+
+func readSharedStrings(f *zip.File) ([]string, error) {
+	var sst struct {
+		Items []struct {
+			Text string `xml:"t"`
+			Runs []struct {
+				Text string `xml:"t"`
+			} `xml:"r"`
+		} `xml:"si"`
+	}
+	if err := decodeZipXML(f, &sst); err != nil {
+		return nil, err
+	}
+
+	shared := make([]string, len(sst.Items))
+	for i, item := range sst.Items {
+		text := item.Text
+		for _, r := range item.Runs {
+			text += r.Text
+		}
+		shared[i] = text
+	}
+	return shared, nil
+}
+
+func decodeZipXML(f *zip.File, v any) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err := xml.NewDecoder(rc).Decode(v); err != nil && err != io.EOF {
+		return fmt.Errorf("parsing %s: %w", f.Name, err)
+	}
+	return nil
+}
+
+// excelColumn converts the letters of a cell reference such as "AB12" to a
+// 0-based column index.
+func excelColumn(ref string) (int, error) {
+	col := 0
+	for _, r := range ref {
+		if r >= 'A' && r <= 'Z' {
+			col = col*26 + int(r-'A') + 1
+			continue
+		}
+		break
+	}
+	if col == 0 {
+		return 0, fmt.Errorf("invalid cell reference %q", ref)
+	}
+	return col - 1, nil
+}
@@ -0,0 +1,168 @@
+package matrixio
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeWorkbook writes an .xlsx file holding parts, named by their path in
+// the archive, and returns its name.
+func writeWorkbook(t *testing.T, parts map[string]string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "routes.xlsx")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for part, contents := range parts {
+		w, err := zw.Create(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// testWorkbook has a Notes sheet first and the routes on a second sheet,
+// below a title row, the way spreadsheets from planners often look.
+var testWorkbook = map[string]string{
+	"xl/workbook.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+  <sheets>
+    <sheet name="Notes" sheetId="1" r:id="rId1"/>
+    <sheet name="Routes" sheetId="2" r:id="rId2"/>
+  </sheets>
+</workbook>`,
+	"xl/_rels/workbook.xml.rels": `<?xml version="1.0" encoding="UTF-8"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+  <Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+  <Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="/xl/worksheets/sheet2.xml"/>
+</Relationships>`,
+	// The second string is rich text, split into runs.
+	"xl/sharedStrings.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+  <si><t>SITE_CODE</t></si>
+  <si><r><t>Al</t></r><r><t>pha</t></r></si>
+  <si><t>Routes for Q3</t></si>
+</sst>`,
+	"xl/worksheets/sheet1.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+  <sheetData><row r="1"><c r="A1" t="inlineStr"><is><t>Not routes</t></is></c></row></sheetData>
+</worksheet>`,
+	// Row 3 leaves SITE_NAME (B3) out, as Excel does for empty cells, and
+	// stores a number with more digits than it displays.
+	"xl/worksheets/sheet2.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+  <sheetData>
+    <row r="1"><c r="A1" t="s"><v>2</v></c></row>
+    <row r="2">
+      <c r="A2" t="s"><v>0</v></c>
+      <c r="B2" t="inlineStr"><is><t>SITE_NAME</t></is></c>
+      <c r="C2" t="inlineStr"><is><t>LAT</t></is></c>
+      <c r="D2" t="inlineStr"><is><t>LNG</t></is></c>
+      <c r="E2" t="inlineStr"><is><t>TERMINAL_CODE</t></is></c>
+      <c r="F2" t="inlineStr"><is><t>TLAT</t></is></c>
+      <c r="G2" t="inlineStr"><is><t>TLNG</t></is></c>
+    </row>
+    <row r="3">
+      <c r="A3" t="inlineStr"><is><t>S1</t></is></c>
+      <c r="C3"><v>-6.2000000000000002</v></c>
+      <c r="D3"><v>106.8</v></c>
+      <c r="E3" t="inlineStr"><is><t>T1</t></is></c>
+      <c r="F3"><v>-6.3</v></c>
+      <c r="G3"><v>106.9</v></c>
+    </row>
+    <row r="4">
+      <c r="A4" t="inlineStr"><is><t>S2</t></is></c>
+      <c r="B4" t="s"><v>1</v></c>
+      <c r="C4"><v>-6.25</v></c>
+      <c r="D4"><v>106.85</v></c>
+      <c r="E4" t="inlineStr"><is><t>T1</t></is></c>
+      <c r="F4"><v>-6.5</v></c>
+      <c r="G4"><v>107.2</v></c>
+    </row>
+  </sheetData>
+</worksheet>`,
+}
+
+func TestReadExcelSheet(t *testing.T) {
+	name := writeWorkbook(t, testWorkbook)
+
+	first, err := readExcelSheet(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"Not routes"}}; !reflect.DeepEqual(first, want) {
+		t.Errorf("first sheet = %q, want %q", first, want)
+	}
+
+	routes, err := readExcelSheet(name, "Routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"Routes for Q3"},
+		{"SITE_CODE", "SITE_NAME", "LAT", "LNG", "TERMINAL_CODE", "TLAT", "TLNG"},
+		{"S1", "", "-6.2000000000000002", "106.8", "T1", "-6.3", "106.9"},
+		{"S2", "Alpha", "-6.25", "106.85", "T1", "-6.5", "107.2"},
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("Routes sheet =\n%q\nwant\n%q", routes, want)
+	}
+
+	if _, err := readExcelSheet(name, "Missing"); err == nil {
+		t.Error("reading a sheet the workbook does not have succeeded")
+	}
+}
+
+func TestReadRoutesFromExcel(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Input = writeWorkbook(t, testWorkbook)
+	cfg.Excel = ExcelConfig{Sheet: "Routes", HeaderRow: 2}
+	routes, err := ReadRoutes(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("read %d routes, want 2", len(routes))
+	}
+	for i, want := range []struct{ site, name, origin, destination string }{
+		{"S1", "", "-6.3,106.9", "-6.2000000000000002,106.8"},
+		{"S2", "Alpha", "-6.5,107.2", "-6.25,106.85"},
+	} {
+		r := routes[i]
+		if r.SiteCode != want.site || r.SiteName != want.name || r.Origin != want.origin || r.Destination != want.destination {
+			t.Errorf("route %d = %s %q %s -> %s, want %s %q %s -> %s", i, r.SiteCode, r.SiteName, r.Origin, r.Destination,
+				want.site, want.name, want.origin, want.destination)
+		}
+	}
+
+	cfg.Excel.HeaderRow = 9
+	if _, err := ReadRoutes(cfg); err == nil {
+		t.Error("a header row past the end of the sheet was accepted")
+	}
+}
+
+func TestExcelColumn(t *testing.T) {
+	for ref, want := range map[string]int{"A1": 0, "G12": 6, "Z3": 25, "AA1": 26, "AB12": 27} {
+		if got, err := excelColumn(ref); err != nil || got != want {
+			t.Errorf("excelColumn(%q) = %d, %v; want %d", ref, got, err, want)
+		}
+	}
+	if _, err := excelColumn("12"); err == nil {
+		t.Error("a reference without a column was accepted")
+	}
+}
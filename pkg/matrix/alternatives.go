@@ -0,0 +1,168 @@
+package matrix
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+)
+
+// routeOption is one route the Directions or Routes API offers for a pair.
+type routeOption struct {
+	metres, seconds int
+}
+
+// RouteAlternatives adds the distance and duration of the default route and
+// of the shortest and fastest among the alternatives, from one Directions
+// (or Routes API) request per pair asking for alternatives. Pairs repeated
+// across rows are queried once, through the provider annotating, as
+// RouteGeometry does.
+type RouteAlternatives struct {
+	// provider is "routes" to use the Routes API, anything else for the
+	// Directions API.
+	provider string
+
+	mu    sync.Mutex
+	cache map[[2]string][]Field
+}
+
+func NewRouteAlternatives(provider string) *RouteAlternatives {
+	return &RouteAlternatives{provider: provider, cache: make(map[[2]string][]Field)}
+}
+
+var alternativeColumns = []string{
+	"ROUTES_OFFERED",
+	"DEFAULT_DISTANCE_KM", "DEFAULT_DURATION",
+	"SHORTEST_DISTANCE_KM", "SHORTEST_DURATION",
+	"FASTEST_DISTANCE_KM", "FASTEST_DURATION",
+}
+
+func (a *RouteAlternatives) Annotate(p Provider, opts QueryOptions, r *Result) {
+	key := [2]string{r.Origin, r.Destination}
+	a.mu.Lock()
+	fields, ok := a.cache[key]
+	a.mu.Unlock()
+
+	if !ok {
+		fields = make([]Field, len(alternativeColumns))
+		for i, name := range alternativeColumns {
+			fields[i] = Field{Name: name, Value: "N/A"}
+		}
+		if r.Origin != "" && r.Destination != "" {
+			fetch := a.directions
+			if a.provider == "routes" {
+				fetch = a.routes
+			}
+			var options []routeOption
+			err := CallAPI(p, 1, func(auth APIAuth) error {
+				var err error
+				options, err = fetch(auth, r.Origin, r.Destination, opts)
+				return err
+			})
+			if err != nil {
+				slog.Error("fetching alternative routes", "site", r.SiteCode, "terminal", r.TerminalCode, "err", err)
+			} else {
+				fields = alternativeFields(options)
+			}
+		}
+		a.mu.Lock()
+		a.cache[key] = fields
+		a.mu.Unlock()
+	}
+
+	r.Extra = append(r.Extra, fields...)
+}
+
+// alternativeFields summarizes options, whose first entry is the route the
+// API recommends.
+func alternativeFields(options []routeOption) []Field {
+	shortest, fastest := options[0], options[0]
+	for _, o := range options[1:] {
+		if o.metres < shortest.metres {
+			shortest = o
+		}
+		if o.seconds < fastest.seconds {
+			fastest = o
+		}
+	}
+
+	var fields []Field
+	fields = append(fields, Field{Name: alternativeColumns[0], Value: strconv.Itoa(len(options))})
+	for i, o := range []routeOption{options[0], shortest, fastest} {
+		fields = append(fields,
+			Field{Name: alternativeColumns[1+2*i], Value: strconv.FormatFloat(float64(o.metres)/1000, 'f', 2, 64)},
+			Field{Name: alternativeColumns[2+2*i], Value: DurationText(o.seconds)},
+		)
+	}
+	return fields
+}
+
+// directions asks the Directions API for alternatives. Durations prefer the
+// traffic-aware value when a departure time is set.
+func (a *RouteAlternatives) directions(auth APIAuth, origin, destination string, opts QueryOptions) ([]routeOption, error) {
+	params := opts.values()
+	params.Add("origin", origin)
+	params.Add("destination", destination)
+	params.Add("alternatives", "true")
+
+	var resp struct {
+		Status string `json:"status"`
+		Routes []struct {
+			Legs []struct {
+				Distance          TextValue `json:"distance"`
+				Duration          TextValue `json:"duration"`
+				DurationInTraffic TextValue `json:"duration_in_traffic"`
+			} `json:"legs"`
+		} `json:"routes"`
+	}
+	if err := getDirections(auth, params, &resp); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.Status == "ZERO_RESULTS" || (resp.Status == "OK" && len(resp.Routes) == 0):
+		return nil, fmt.Errorf("no route found")
+	case resp.Status != "OK":
+		return nil, &statusError{status: resp.Status}
+	}
+
+	var options []routeOption
+	for _, route := range resp.Routes {
+		var o routeOption
+		for _, leg := range route.Legs {
+			o.metres += leg.Distance.Value
+			if leg.DurationInTraffic.Value > 0 {
+				o.seconds += leg.DurationInTraffic.Value
+			} else {
+				o.seconds += leg.Duration.Value
+			}
+		}
+		options = append(options, o)
+	}
+	return options, nil
+}
+
+// routes asks the Routes API for alternatives.
+func (a *RouteAlternatives) routes(auth APIAuth, origin, destination string, opts QueryOptions) ([]routeOption, error) {
+	req := newRoutesDirectionsRequest(origin, destination, opts)
+	req.ComputeAlternativeRoutes = true
+
+	var resp struct {
+		Routes []struct {
+			DistanceMeters int    `json:"distanceMeters"`
+			Duration       string `json:"duration"`
+		} `json:"routes"`
+	}
+	if err := postRoutes(routesDirectionsURL, "routes.distanceMeters,routes.duration", auth, req, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Routes) == 0 {
+		return nil, fmt.Errorf("no route found")
+	}
+
+	var options []routeOption
+	for _, route := range resp.Routes {
+		options = append(options, routeOption{metres: route.DistanceMeters, seconds: routesSeconds(route.Duration)})
+	}
+	return options, nil
+}
@@ -0,0 +1,90 @@
+package matrix
+
+import (
+	"slices"
+	"sync"
+)
+
+// Client computes distances and durations for batches of routes.
+//
+//	client, err := matrix.NewClient("google", apiKey)
+//	...
+//	results := client.Compute(matrix.Request{Routes: routes})
+type Client struct {
+	Provider Provider
+	// Concurrency is the number of requests kept in flight; below 1 means 1.
+	Concurrency int
+	// Geocoder, if set, resolves routes given by address and adds their
+	// ORIGIN_GEOCODED and DESTINATION_GEOCODED columns.
+	Geocoder *Geocoder
+	// Annotators add optional columns to every result, in order.
+	Annotators []Annotator
+	// Progress, if set, is stepped once per route queried.
+	Progress Progress
+}
+
+// NewClient returns a Client for the provider named by name, as accepted by
+// NewProvider.
+func NewClient(name, apiKey string) (*Client, error) {
+	p, err := NewProvider(name, apiKey, QueryOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Provider: p}, nil
+}
+
+// Request is a batch of routes queried with the same options.
+type Request struct {
+	Routes  []Route
+	Options QueryOptions
+}
+
+// Compute queries every route of req and annotates the results, which keep
+// the order of req.Routes. Routes are geocoded in place. Failed routes are
+// reported through Result.Status rather than an error.
+func (c *Client) Compute(req Request) []Result {
+	if c.Geocoder != nil {
+		GeocodeRoutes(c.Geocoder, req.Routes, c.Concurrency)
+	}
+	results := QueryRoutes(c.Provider, req.Routes, req.Options, c.Concurrency, c.Progress)
+	if c.Geocoder != nil {
+		for i := range results {
+			results[i].Extra = append(results[i].Extra, GeocodeFields(results[i].Route)...)
+		}
+	}
+	AnnotateResults(c.Provider, req.Options, results, c.Annotators, c.Concurrency, nil)
+	return results
+}
+
+// Stream queries every route of req like Compute, but calls send with each
+// annotated result and its index in req.Routes as soon as it is done, in no
+// particular order. Calls to send do not overlap.
+func (c *Client) Stream(req Request, send func(i int, r Result)) {
+	if c.Geocoder != nil {
+		GeocodeRoutes(c.Geocoder, req.Routes, c.Concurrency)
+	}
+	unique := samePairs(req.Routes)
+	var mu sync.Mutex
+	forEachConcurrently(len(unique), c.Concurrency, func(g int) {
+		first := QueryRoute(c.Provider, req.Routes[unique[g][0]], req.Options)
+		for _, i := range unique[g] {
+			r := first
+			r.Route = req.Routes[i]
+			r.Extra = slices.Clone(first.Extra)
+			if c.Geocoder != nil {
+				r.Extra = append(r.Extra, GeocodeFields(r.Route)...)
+			}
+			AnnotateResult(c.Provider, req.Options, &r, c.Annotators)
+
+			mu.Lock()
+			if c.Progress != nil {
+				c.Progress.Step(r.Status != "OK")
+			}
+			send(i, r)
+			mu.Unlock()
+		}
+	})
+	if c.Progress != nil {
+		c.Progress.Finish()
+	}
+}
@@ -0,0 +1,35 @@
+package matrix
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClientStream(t *testing.T) {
+	routes := []Route{
+		{SiteCode: "S1", Origin: "-6.3,106.9", Destination: "-6.2,106.8"},
+		{SiteCode: "S2", Origin: "-6.3,106.9", Destination: "-6.5,107.2", Waypoints: []string{"-6.4,107.0"}},
+		{SiteCode: "S3", Origin: "-6.3,106.9", Destination: "-6.2,106.8"},
+		{SiteCode: "S4", Origin: "-6.3,106.9", Destination: "-6.9,107.6"},
+	}
+	want := (&Client{Provider: mockProvider{}}).Compute(Request{Routes: routes})
+
+	// S1 and S3 share their pair, which is queried once and sent for both.
+	counter := NewCountingProvider(mockProvider{})
+	client := &Client{Provider: counter, Concurrency: 3}
+	got := make([]*Result, len(routes))
+	client.Stream(Request{Routes: routes}, func(i int, r Result) {
+		if got[i] != nil {
+			t.Errorf("route %d sent twice", i)
+		}
+		got[i] = &r
+	})
+	for i := range routes {
+		if got[i] == nil || !reflect.DeepEqual(*got[i], want[i]) {
+			t.Errorf("route %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if n := counter.Elements(); n != 4 {
+		t.Errorf("requested %d elements, want 4", n)
+	}
+}
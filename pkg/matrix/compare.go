@@ -0,0 +1,100 @@
+package matrix
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ProviderComparison queries other providers for each route too and adds
+// their distance and duration next to the main provider's, with the
+// difference from it in percent, to validate one routing engine against
+// another. Queries go through the provider annotating, so they stop with
+// the run and Google queries count against its budget and use its keys or
+// client ID.
+type ProviderComparison struct {
+	labels    []string
+	names     []string
+	providers []Provider
+}
+
+// NewProviderComparison compares the main provider with the providers
+// named by names, as accepted by NewProvider. Their columns are suffixed
+// with the upper-cased name, without any OSRM server URL.
+func NewProviderComparison(names []string, opts QueryOptions) (*ProviderComparison, error) {
+	c := &ProviderComparison{}
+	for _, name := range names {
+		p, err := NewProvider(name, "", opts)
+		if err != nil {
+			return nil, err
+		}
+		label, _, _ := strings.Cut(name, "=")
+		c.labels = append(c.labels, strings.ToUpper(label))
+		c.names = append(c.names, name)
+		c.providers = append(c.providers, p)
+	}
+	return c, nil
+}
+
+// Queries returns the number of queries per row made to the Google APIs,
+// which bill them.
+func (c *ProviderComparison) Queries() int {
+	n := 0
+	for _, name := range c.names {
+		if NeedsAPIKey(name) {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *ProviderComparison) Annotate(p Provider, opts QueryOptions, r *Result) {
+	for i, q := range c.providers {
+		distance, duration, distanceDiff, durationDiff := "N/A", "N/A", "N/A", "N/A"
+		// The Google APIs bill an element per leg.
+		var units int64
+		if NeedsAPIKey(c.names[i]) {
+			units = int64(len(r.Waypoints) + 1)
+		}
+		var legs []DistanceMatrixElement
+		err := CallAPI(p, units, func(auth APIAuth) error {
+			var err error
+			legs, err = legElements(authenticated(q, auth), r.Route, opts)
+			return err
+		})
+		if err == nil {
+			element := sumLegs(legs)
+			km := float64(element.Distance.Value) / 1000
+			distance, duration = fmt.Sprintf("%.2f", km), element.Duration.Text
+			if r.Status == "OK" && r.DistanceKm > 0 {
+				distanceDiff = percentDiff(km, r.DistanceKm)
+			}
+			if r.Status == "OK" && r.Seconds > 0 {
+				durationDiff = percentDiff(float64(element.Duration.Value), float64(r.Seconds))
+			}
+		}
+		label := c.labels[i]
+		r.Extra = append(r.Extra,
+			Field{Name: "DISTANCE_KM_" + label, Value: distance},
+			Field{Name: "DURATION_" + label, Value: duration},
+			Field{Name: "DISTANCE_DIFF_PCT_" + label, Value: distanceDiff},
+			Field{Name: "DURATION_DIFF_PCT_" + label, Value: durationDiff},
+		)
+	}
+}
+
+// authenticated returns q sending auth, when q is a Google API. The Routes
+// API takes keys only.
+func authenticated(q Provider, auth APIAuth) Provider {
+	switch q.(type) {
+	case googleProvider:
+		return googleProvider{apiKey: auth.apiKey, signer: auth.signer}
+	case routesProvider:
+		return routesProvider{apiKey: auth.apiKey}
+	}
+	return q
+}
+
+// percentDiff is how much other differs from base, in percent of base.
+func percentDiff(other, base float64) string {
+	return fmt.Sprintf("%.1f", (other-base)/base*100)
+}
@@ -0,0 +1,141 @@
+package matrix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// redactedParams are the query parameters DebugTransport blanks out.
+var redactedParams = []string{"key", "signature"}
+
+// debugEntry is one request and its raw response as written to the debug log.
+type debugEntry struct {
+	Time     time.Time       `json:"time"`
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Request  json.RawMessage `json:"request,omitempty"`
+	Status   int             `json:"status,omitempty"`
+	Elapsed  string          `json:"elapsed"`
+	Error    string          `json:"error,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+	// Body holds a response that is not JSON.
+	Body string `json:"body,omitempty"`
+}
+
+// DebugTransport is an http.RoundTripper that writes every request URL, with
+// the API key and signature redacted, and the raw response it got to a debug
+// log. Request headers, which carry the Routes API key, are not written.
+type DebugTransport struct {
+	base http.RoundTripper
+	dir  string
+
+	mu   sync.Mutex
+	file *os.File
+	n    int
+}
+
+// NewDebugTransport wraps base. When path is a directory each exchange goes
+// to a numbered JSON file in it; otherwise they are appended to path as JSON
+// lines.
+func NewDebugTransport(base http.RoundTripper, path string) (*DebugTransport, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return &DebugTransport{base: base, dir: path}, nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &DebugTransport{base: base, file: file}, nil
+}
+
+func (t *DebugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	entry := debugEntry{Time: time.Now(), Method: req.Method, URL: redactURL(req.URL)}
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(body)
+			body.Close()
+			entry.Request = rawJSON(data)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	entry.Elapsed = time.Since(entry.Time).Round(time.Millisecond).String()
+	if err != nil {
+		entry.Error = err.Error()
+		t.write(entry)
+		return resp, err
+	}
+
+	entry.Status = resp.StatusCode
+	data, readErr := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes+1))
+	resp.Body.Close()
+	// Hand the caller the body as read, read error included, so its own
+	// truncation checks still apply.
+	resp.Body = io.NopCloser(io.MultiReader(bytes.NewReader(data), errReader{readErr}))
+	if entry.Response = rawJSON(data); entry.Response == nil {
+		entry.Body = string(data)
+	}
+	if readErr != nil {
+		entry.Error = readErr.Error()
+	}
+	t.write(entry)
+	return resp, nil
+}
+
+// write records entry, giving up silently: the debug log must not fail
+// the run.
+func (t *DebugTransport) write(entry debugEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.n++
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false) // keep the & of URLs readable
+	if t.dir == "" {
+		enc.Encode(entry)
+		t.file.Write(buf.Bytes())
+		return
+	}
+	enc.SetIndent("", "  ")
+	enc.Encode(entry)
+	os.WriteFile(filepath.Join(t.dir, fmt.Sprintf("%06d.json", t.n)), buf.Bytes(), 0o600)
+}
+
+// redactURL returns u as text with the redactedParams values replaced.
+func redactURL(u *url.URL) string {
+	redacted := *u
+	q := u.Query()
+	for _, name := range redactedParams {
+		if q.Has(name) {
+			q.Set(name, "REDACTED")
+		}
+	}
+	redacted.RawQuery = q.Encode()
+	return redacted.String()
+}
+
+// rawJSON returns data if it is valid JSON, nil otherwise.
+func rawJSON(data []byte) json.RawMessage {
+	if len(data) == 0 || !json.Valid(data) {
+		return nil
+	}
+	return data
+}
+
+// errReader returns err once its reader is drained, or EOF when err is nil.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	return 0, io.EOF
+}
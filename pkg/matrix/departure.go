@@ -0,0 +1,142 @@
+package matrix
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// MaxDepartureQueries bounds the traffic-aware queries spent on one row.
+const MaxDepartureQueries = 8
+
+// DepartureSearch finds, per route, the latest departure inside a window that
+// still arrives by the deadline. Travel times in traffic grow or shrink
+// smoothly enough over a day that arrival time is treated as increasing with
+// departure time, which makes bisection valid.
+type DepartureSearch struct {
+	deadline    time.Time
+	windowStart time.Time
+	windowEnd   time.Time
+	precision   time.Duration
+}
+
+func NewDepartureSearch(deadline, window string, precision time.Duration) (*DepartureSearch, error) {
+	if deadline == "" || window == "" {
+		return nil, fmt.Errorf("-deadline and -departure-window must be used together")
+	}
+
+	s := &DepartureSearch{precision: max(precision, time.Minute)}
+	var err error
+	if s.deadline, err = time.Parse(time.RFC3339, deadline); err != nil {
+		return nil, fmt.Errorf("invalid deadline: %w", err)
+	}
+
+	start, end, ok := strings.Cut(window, "/")
+	if !ok {
+		return nil, fmt.Errorf("departure window must be START/END")
+	}
+	if s.windowStart, err = time.Parse(time.RFC3339, start); err != nil {
+		return nil, fmt.Errorf("invalid departure window start: %w", err)
+	}
+	if s.windowEnd, err = time.Parse(time.RFC3339, end); err != nil {
+		return nil, fmt.Errorf("invalid departure window end: %w", err)
+	}
+
+	if !s.windowStart.Before(s.windowEnd) {
+		return nil, fmt.Errorf("departure window start must be before its end")
+	}
+	if s.windowStart.Before(time.Now()) {
+		return nil, fmt.Errorf("departure window must lie in the future for traffic-aware durations")
+	}
+	if s.windowEnd.After(s.deadline) {
+		s.windowEnd = s.deadline
+	}
+
+	return s, nil
+}
+
+// Annotate adds LATEST_DEPARTURE and DURATION_AT_DEPARTURE columns. Rows that
+// cannot make the deadline from anywhere in the window get "N/A".
+func (s *DepartureSearch) Annotate(p Provider, opts QueryOptions, r *Result) {
+	departure, duration := "N/A", "N/A"
+
+	t, seconds, err := s.latestDeparture(p, opts, r.Origin, r.Destination)
+	if err != nil {
+		slog.Warn("no feasible departure", "site", r.SiteCode, "terminal", r.TerminalCode, "err", err)
+	} else {
+		departure = t.In(s.deadline.Location()).Format(time.RFC3339)
+		duration = DurationText(seconds)
+	}
+
+	r.Extra = append(r.Extra,
+		Field{Name: "LATEST_DEPARTURE", Value: departure},
+		Field{Name: "DURATION_AT_DEPARTURE", Value: duration},
+	)
+}
+
+func (s *DepartureSearch) latestDeparture(p Provider, opts QueryOptions, origin, destination string) (time.Time, int, error) {
+	arrivesInTime := func(t time.Time) (bool, int, error) {
+		opts.DepartureTime, opts.DepartureNow = t, false
+		seconds, err := trafficSeconds(p, origin, destination, opts)
+		if err != nil {
+			return false, 0, err
+		}
+		return !t.Add(time.Duration(seconds) * time.Second).After(s.deadline), seconds, nil
+	}
+
+	ok, seconds, err := arrivesInTime(s.windowEnd)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if ok {
+		return s.windowEnd, seconds, nil
+	}
+
+	ok, seconds, err = arrivesInTime(s.windowStart)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if !ok {
+		return time.Time{}, 0, fmt.Errorf("leaving at %s arrives after the deadline", s.windowStart.Format(time.RFC3339))
+	}
+
+	// lo always arrives in time, hi never does.
+	lo, hi := s.windowStart, s.windowEnd
+	loSeconds := seconds
+	for queries := 2; queries < MaxDepartureQueries && hi.Sub(lo) > s.precision; queries++ {
+		mid := lo.Add(hi.Sub(lo) / 2)
+		ok, seconds, err := arrivesInTime(mid)
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		if ok {
+			lo, loSeconds = mid, seconds
+		} else {
+			hi = mid
+		}
+	}
+
+	return lo, loSeconds, nil
+}
+
+// trafficSeconds returns the travel time for the departure in opts,
+// preferring the traffic-aware duration when the API provides one.
+func trafficSeconds(p Provider, origin, destination string, opts QueryOptions) (int, error) {
+	distanceMatrix, err := p.GetDistanceMatrix(origin, destination, opts)
+	if err != nil {
+		return 0, err
+	}
+	if len(distanceMatrix.Rows) == 0 || len(distanceMatrix.Rows[0].Elements) == 0 {
+		return 0, fmt.Errorf("no route returned")
+	}
+
+	element := distanceMatrix.Rows[0].Elements[0]
+	if element.Status != "OK" {
+		return 0, fmt.Errorf("element status %s", element.Status)
+	}
+	if element.DurationInTraffic.Value > 0 {
+		return element.DurationInTraffic.Value, nil
+	}
+	return element.Duration.Value, nil
+}
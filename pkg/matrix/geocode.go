@@ -0,0 +1,451 @@
+package matrix
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// nominatimInterval is the minimum spacing between Nominatim requests
+// required by its usage policy.
+const nominatimInterval = time.Second
+
+// GeocodeConfig selects how addresses are turned into coordinates and back.
+type GeocodeConfig struct {
+	// Provider is "google" (the Geocoding API, using GOOGLE_API_KEY) or
+	// "nominatim" (OpenStreetMap).
+	Provider string `json:"provider"`
+	// Cache is a JSON file mapping addresses to "lat,lng". Addresses found
+	// there are not looked up again; empty disables the cache.
+	Cache string `json:"cache"`
+	// Reverse adds city, region and country columns for both ends of each
+	// route, looked up from their coordinates.
+	Reverse bool `json:"reverse,omitempty"`
+	// ReverseCache is the cache of reverse lookups, keyed by "lat,lng".
+	ReverseCache string `json:"reverse_cache"`
+}
+
+func DefaultGeocodeConfig() GeocodeConfig {
+	return GeocodeConfig{Provider: "google", Cache: "geocode-cache.json", ReverseCache: "reverse-geocode-cache.json"}
+}
+
+// place is the result of a reverse lookup.
+type place struct {
+	City    string `json:"city"`
+	Region  string `json:"region"`
+	Country string `json:"country"`
+}
+
+// Geocoder resolves addresses through a provider, consulting the cache first.
+type Geocoder struct {
+	cfg    GeocodeConfig
+	apiKey string
+
+	mu     sync.Mutex
+	cache  map[string]string
+	places map[string]place
+	dirty  bool
+
+	throttle    sync.Mutex
+	lastRequest time.Time
+}
+
+func NewGeocoder(cfg GeocodeConfig, apiKey string) (*Geocoder, error) {
+	switch cfg.Provider {
+	case "google", "nominatim":
+	default:
+		return nil, fmt.Errorf("unknown geocoding provider %q", cfg.Provider)
+	}
+
+	g := &Geocoder{cfg: cfg, apiKey: apiKey, cache: make(map[string]string), places: make(map[string]place)}
+	if err := loadCache(cfg.Cache, &g.cache); err != nil {
+		return nil, err
+	}
+	if err := loadCache(cfg.ReverseCache, &g.places); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// loadCache reads a JSON cache file into v. An unset or missing file leaves
+// v empty.
+func loadCache(path string, v any) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// Save writes new lookups back to the cache files.
+func (g *Geocoder) Save() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.dirty {
+		return nil
+	}
+	for _, c := range []struct {
+		path string
+		v    any
+	}{
+		{g.cfg.Cache, g.cache},
+		{g.cfg.ReverseCache, g.places},
+	} {
+		if c.path == "" {
+			continue
+		}
+		data, err := json.MarshalIndent(c.v, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(c.path, append(data, '\n'), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResolveRoute fills in the coordinates of the address-only locations of r.
+
+// Cached reports whether address has been geocoded before.
+func (g *Geocoder) Cached(address string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.cache[address] != ""
+}
+
+// Locations that cannot be geocoded are left empty and reported.
+func (g *Geocoder) ResolveRoute(r *Route) {
+	for _, loc := range []struct {
+		name       string
+		address    string
+		coordinate *string
+	}{
+		{"origin", r.OriginAddress, &r.Origin},
+		{"destination", r.DestinationAddress, &r.Destination},
+	} {
+		if loc.address == "" {
+			continue
+		}
+		coordinate, err := g.geocode(loc.address)
+		if err != nil {
+			slog.Error("geocoding "+loc.name, "address", loc.address, "site", r.SiteCode, "err", err)
+			continue
+		}
+		*loc.coordinate = coordinate
+	}
+}
+
+// geocode returns the "lat,lng" of address.
+func (g *Geocoder) geocode(address string) (string, error) {
+	g.mu.Lock()
+	coordinate, ok := g.cache[address]
+	g.mu.Unlock()
+	if ok {
+		return coordinate, nil
+	}
+
+	var err error
+	if g.cfg.Provider == "nominatim" {
+		coordinate, err = g.nominatim(address)
+	} else {
+		coordinate, err = g.google(address)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	g.mu.Lock()
+	g.cache[address] = coordinate
+	g.dirty = true
+	g.mu.Unlock()
+	return coordinate, nil
+}
+
+func (g *Geocoder) google(address string) (string, error) {
+	params := url.Values{}
+	params.Add("address", address)
+	params.Add("key", g.apiKey)
+	requestURL := "https://maps.googleapis.com/maps/api/geocode/json?" + params.Encode()
+
+	var resp struct {
+		Status  string `json:"status"`
+		Results []struct {
+			Geometry struct {
+				Location struct {
+					Lat float64 `json:"lat"`
+					Lng float64 `json:"lng"`
+				} `json:"location"`
+			} `json:"geometry"`
+		} `json:"results"`
+	}
+	if err := getJSON(requestURL, nil, &resp); err != nil {
+		return "", err
+	}
+
+	switch {
+	case resp.Status == "ZERO_RESULTS" || (resp.Status == "OK" && len(resp.Results) == 0):
+		return "", fmt.Errorf("address not found")
+	case resp.Status != "OK":
+		return "", fmt.Errorf("API error: %s", resp.Status)
+	}
+	loc := resp.Results[0].Geometry.Location
+	return formatLatLng(loc.Lat, loc.Lng), nil
+}
+
+func (g *Geocoder) nominatim(address string) (string, error) {
+	g.waitForNominatim()
+
+	params := url.Values{}
+	params.Add("q", address)
+	params.Add("format", "jsonv2")
+	params.Add("limit", "1")
+	requestURL := "https://nominatim.openstreetmap.org/search?" + params.Encode()
+
+	var places []struct {
+		Lat string `json:"lat"`
+		Lon string `json:"lon"`
+	}
+	header := http.Header{"User-Agent": {"route-dm"}}
+	if err := getJSON(requestURL, header, &places); err != nil {
+		return "", err
+	}
+	if len(places) == 0 {
+		return "", fmt.Errorf("address not found")
+	}
+	return places[0].Lat + "," + places[0].Lon, nil
+}
+
+func (g *Geocoder) waitForNominatim() {
+	g.throttle.Lock()
+	defer g.throttle.Unlock()
+	if wait := nominatimInterval - time.Since(g.lastRequest); wait > 0 {
+		time.Sleep(wait)
+	}
+	g.lastRequest = time.Now()
+}
+
+// getJSON fetches requestURL with retries and decodes the response into v.
+func getJSON(requestURL string, header http.Header, v any) error {
+	return withRetry(func() error {
+		req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+		if err != nil {
+			return err
+		}
+		for k, vs := range header {
+			req.Header[k] = vs
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return &transportError{err}
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			err := fmt.Errorf("HTTP %s", resp.Status)
+			if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+				return &transportError{err}
+			}
+			return err
+		}
+
+		body, err := readResponseBody(resp)
+		if err != nil {
+			return err
+		}
+		return decodeJSON(body, v)
+	})
+}
+
+func formatLatLng(lat, lng float64) string {
+	return strconv.FormatFloat(lat, 'f', -1, 64) + "," + strconv.FormatFloat(lng, 'f', -1, 64)
+}
+
+// GeocodeRoutes resolves the address-only locations of routes.
+func GeocodeRoutes(g *Geocoder, routes []Route, concurrency int) {
+	forEachConcurrently(len(routes), concurrency, func(i int) {
+		g.ResolveRoute(&routes[i])
+	})
+}
+
+// GeocodeFields reports the coordinates found for geocoded locations. They
+// are empty for locations given as coordinates and "N/A" when geocoding
+// failed.
+func GeocodeFields(r Route) []Field {
+	value := func(address, coordinate string) string {
+		switch {
+		case address == "":
+			return ""
+		case coordinate == "":
+			return "N/A"
+		default:
+			return coordinate
+		}
+	}
+	return []Field{
+		{Name: "ORIGIN_GEOCODED", Value: value(r.OriginAddress, r.Origin)},
+		{Name: "DESTINATION_GEOCODED", Value: value(r.DestinationAddress, r.Destination)},
+	}
+}
+
+// ReverseGeocoding adds the city, region and country of both route ends.
+type ReverseGeocoding struct {
+	g *Geocoder
+}
+
+func NewReverseGeocoding(g *Geocoder) ReverseGeocoding {
+	return ReverseGeocoding{g: g}
+}
+
+func (rg ReverseGeocoding) Annotate(_ Provider, _ QueryOptions, r *Result) {
+	for _, end := range []struct {
+		prefix     string
+		coordinate string
+	}{
+		{"ORIGIN", r.Origin},
+		{"DESTINATION", r.Destination},
+	} {
+		pl := place{City: "N/A", Region: "N/A", Country: "N/A"}
+		if end.coordinate != "" {
+			found, err := rg.g.reverse(end.coordinate)
+			if err != nil {
+				slog.Error("reverse geocoding", "coordinate", end.coordinate, "site", r.SiteCode, "err", err)
+			} else {
+				pl = found
+			}
+		}
+		r.Extra = append(r.Extra,
+			Field{Name: end.prefix + "_CITY", Value: pl.City},
+			Field{Name: end.prefix + "_REGION", Value: pl.Region},
+			Field{Name: end.prefix + "_COUNTRY", Value: pl.Country},
+		)
+	}
+}
+
+// reverse returns the place at coordinate ("lat,lng").
+func (g *Geocoder) reverse(coordinate string) (place, error) {
+	g.mu.Lock()
+	pl, ok := g.places[coordinate]
+	g.mu.Unlock()
+	if ok {
+		return pl, nil
+	}
+
+	var err error
+	if g.cfg.Provider == "nominatim" {
+		pl, err = g.nominatimReverse(coordinate)
+	} else {
+		pl, err = g.googleReverse(coordinate)
+	}
+	if err != nil {
+		return place{}, err
+	}
+
+	g.mu.Lock()
+	g.places[coordinate] = pl
+	g.dirty = true
+	g.mu.Unlock()
+	return pl, nil
+}
+
+func (g *Geocoder) googleReverse(coordinate string) (place, error) {
+	params := url.Values{}
+	params.Add("latlng", coordinate)
+	params.Add("key", g.apiKey)
+	requestURL := "https://maps.googleapis.com/maps/api/geocode/json?" + params.Encode()
+
+	var resp struct {
+		Status  string `json:"status"`
+		Results []struct {
+			AddressComponents []struct {
+				LongName string   `json:"long_name"`
+				Types    []string `json:"types"`
+			} `json:"address_components"`
+		} `json:"results"`
+	}
+	if err := getJSON(requestURL, nil, &resp); err != nil {
+		return place{}, err
+	}
+
+	switch {
+	case resp.Status == "ZERO_RESULTS" || (resp.Status == "OK" && len(resp.Results) == 0):
+		return place{}, fmt.Errorf("no place found")
+	case resp.Status != "OK":
+		return place{}, fmt.Errorf("API error: %s", resp.Status)
+	}
+
+	// Results run from most to least specific; take each component from the
+	// first result that has it.
+	var pl place
+	for _, result := range resp.Results {
+		for _, c := range result.AddressComponents {
+			for _, t := range c.Types {
+				switch {
+				case t == "locality" && pl.City == "":
+					pl.City = c.LongName
+				case t == "administrative_area_level_1" && pl.Region == "":
+					pl.Region = c.LongName
+				case t == "country" && pl.Country == "":
+					pl.Country = c.LongName
+				}
+			}
+		}
+	}
+	return pl, nil
+}
+
+func (g *Geocoder) nominatimReverse(coordinate string) (place, error) {
+	lat, lng, _ := strings.Cut(coordinate, ",")
+	g.waitForNominatim()
+
+	params := url.Values{}
+	params.Add("lat", lat)
+	params.Add("lon", lng)
+	params.Add("format", "jsonv2")
+	requestURL := "https://nominatim.openstreetmap.org/reverse?" + params.Encode()
+
+	var resp struct {
+		Error   string `json:"error"`
+		Address struct {
+			City    string `json:"city"`
+			Town    string `json:"town"`
+			Village string `json:"village"`
+			State   string `json:"state"`
+			Country string `json:"country"`
+		} `json:"address"`
+	}
+	header := http.Header{"User-Agent": {"route-dm"}}
+	if err := getJSON(requestURL, header, &resp); err != nil {
+		return place{}, err
+	}
+	if resp.Error != "" {
+		return place{}, fmt.Errorf("%s", resp.Error)
+	}
+
+	a := resp.Address
+	city := a.City
+	if city == "" {
+		city = a.Town
+	}
+	if city == "" {
+		city = a.Village
+	}
+	return place{City: city, Region: a.State, Country: a.Country}, nil
+}
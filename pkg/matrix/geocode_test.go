@@ -0,0 +1,228 @@
+package matrix
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGeocoding answers Google Geocoding and Nominatim requests from bodies
+// keyed by the address or coordinate asked about, recording each request.
+type fakeGeocoding struct {
+	bodies map[string]string
+
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func (f *fakeGeocoding) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	f.mu.Unlock()
+	q := req.URL.Query()
+	var key string
+	switch {
+	case q.Has("address"):
+		key = q.Get("address")
+	case q.Has("latlng"):
+		key = q.Get("latlng")
+	case q.Has("q"):
+		key = q.Get("q")
+	default:
+		key = q.Get("lat") + "," + q.Get("lon")
+	}
+	body, ok := f.bodies[key]
+	if !ok {
+		body = `{"status": "ZERO_RESULTS", "results": []}`
+		if req.URL.Host == "nominatim.openstreetmap.org" {
+			body = `[]`
+		}
+	}
+	return &http.Response{StatusCode: http.StatusOK, ContentLength: int64(len(body)), Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+}
+
+// useFakeGeocoding sends the package's requests to a fakeGeocoding until the
+// test ends.
+func useFakeGeocoding(t *testing.T, bodies map[string]string) *fakeGeocoding {
+	f := &fakeGeocoding{bodies: bodies}
+	old := http.DefaultClient.Transport
+	http.DefaultClient.Transport = f
+	t.Cleanup(func() { http.DefaultClient.Transport = old })
+	return f
+}
+
+func TestGeocoderResolveRoute(t *testing.T) {
+	fake := useFakeGeocoding(t, map[string]string{
+		"Monas, Jakarta": `{"status": "OK", "results": [{"geometry": {"location": {"lat": -6.1754, "lng": 106.8272}}}]}`,
+		"Denied":         `{"status": "REQUEST_DENIED", "results": []}`,
+	})
+	dir := t.TempDir()
+	cfg := GeocodeConfig{Provider: "google", Cache: filepath.Join(dir, "geocode.json")}
+	if err := os.WriteFile(cfg.Cache, []byte(`{"Terminal 1": "-6.3,106.9"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewGeocoder(cfg, "geo-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := []Route{
+		{SiteCode: "S1", OriginAddress: "Terminal 1", DestinationAddress: "Monas, Jakarta"},
+		{SiteCode: "S2", OriginAddress: "Terminal 1", DestinationAddress: "Nowhere"},
+		{SiteCode: "S3", Origin: "-6.3,106.9", DestinationAddress: "Denied"},
+		{SiteCode: "S4", OriginAddress: "Monas, Jakarta", Destination: "-6.2,106.8"},
+	}
+	GeocodeRoutes(g, routes, 1)
+
+	// The cached terminal is never asked for, nor is Monas a second time.
+	if len(fake.requests) != 3 {
+		t.Errorf("made %d requests, want 3", len(fake.requests))
+	}
+	for _, req := range fake.requests {
+		if key := req.URL.Query().Get("key"); key != "geo-key" {
+			t.Errorf("request sent key %q, want geo-key", key)
+		}
+	}
+	for i, want := range [][2]string{
+		{"-6.3,106.9", "-6.1754,106.8272"},
+		{"-6.3,106.9", "N/A"},
+		{"", "N/A"},
+		{"-6.1754,106.8272", ""},
+	} {
+		fields := GeocodeFields(routes[i])
+		if got := [2]string{fields[0].Value, fields[1].Value}; got != want {
+			t.Errorf("%s: geocoded %q, want %q", routes[i].SiteCode, got, want)
+		}
+	}
+	if routes[3].Destination != "-6.2,106.8" {
+		t.Errorf("S4: destination given as a coordinate became %q", routes[3].Destination)
+	}
+
+	// Only the new, successful lookup is added to the cache.
+	if err := g.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(cfg.Cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cache map[string]string
+	if err := json.Unmarshal(data, &cache); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"Terminal 1": "-6.3,106.9", "Monas, Jakarta": "-6.1754,106.8272"}
+	if !reflect.DeepEqual(cache, want) {
+		t.Errorf("saved cache %v, want %v", cache, want)
+	}
+	reloaded, err := NewGeocoder(cfg, "geo-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.Cached("Monas, Jakarta") || reloaded.Cached("Nowhere") {
+		t.Error("the reloaded cache does not hold exactly the successful lookups")
+	}
+}
+
+func TestGeocoderSaveUnchanged(t *testing.T) {
+	cfg := GeocodeConfig{Provider: "nominatim", Cache: filepath.Join(t.TempDir(), "geocode.json")}
+	g, err := NewGeocoder(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cfg.Cache); !os.IsNotExist(err) {
+		t.Errorf("saving without new lookups wrote the cache: %v", err)
+	}
+
+	if err := os.WriteFile(cfg.Cache, []byte(`{"Terminal 1": `), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewGeocoder(cfg, ""); err == nil {
+		t.Error("a corrupt cache was accepted")
+	}
+	if _, err := NewGeocoder(GeocodeConfig{Provider: "bing"}, ""); err == nil {
+		t.Error("an unknown provider was accepted")
+	}
+}
+
+func TestReverseGeocodingGoogle(t *testing.T) {
+	fake := useFakeGeocoding(t, map[string]string{
+		// The city comes from the first result, the region and country from
+		// the second, which is the only one to have them.
+		"-6.1754,106.8272": `{"status": "OK", "results": [
+			{"address_components": [{"long_name": "Central Jakarta", "types": ["locality", "political"]}]},
+			{"address_components": [
+				{"long_name": "Jakarta", "types": ["locality"]},
+				{"long_name": "Special Capital Region of Jakarta", "types": ["administrative_area_level_1"]},
+				{"long_name": "Indonesia", "types": ["country", "political"]}
+			]}
+		]}`,
+	})
+	g, err := NewGeocoder(GeocodeConfig{Provider: "google"}, "geo-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Result{Route: Route{SiteCode: "S1", Origin: "-6.1754,106.8272", Destination: "0,0"}}
+	NewReverseGeocoding(g).Annotate(nil, QueryOptions{}, &r)
+	for name, want := range map[string]string{
+		"ORIGIN_CITY":         "Central Jakarta",
+		"ORIGIN_REGION":       "Special Capital Region of Jakarta",
+		"ORIGIN_COUNTRY":      "Indonesia",
+		"DESTINATION_CITY":    "N/A",
+		"DESTINATION_COUNTRY": "N/A",
+	} {
+		if got := field(r, name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	// A second lookup of the same coordinate comes from memory.
+	NewReverseGeocoding(g).Annotate(nil, QueryOptions{}, &Result{Route: Route{Origin: "-6.1754,106.8272"}})
+	if len(fake.requests) != 2 {
+		t.Errorf("made %d requests, want 2", len(fake.requests))
+	}
+}
+
+func TestGeocoderNominatim(t *testing.T) {
+	fake := useFakeGeocoding(t, map[string]string{
+		"Monas, Jakarta": `[{"lat": "-6.1754", "lon": "106.8272"}]`,
+		// No city, so the town stands in for it.
+		"-6.5,107.2": `{"address": {"town": "Purwakarta", "state": "West Java", "country": "Indonesia"}}`,
+	})
+	g, err := NewGeocoder(GeocodeConfig{Provider: "nominatim"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Route{SiteCode: "S1", DestinationAddress: "Monas, Jakarta"}
+	g.ResolveRoute(&r)
+	if r.Destination != "-6.1754,106.8272" {
+		t.Errorf("destination = %q, want -6.1754,106.8272", r.Destination)
+	}
+	pl, err := g.reverse("-6.5,107.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (place{City: "Purwakarta", Region: "West Java", Country: "Indonesia"}); pl != want {
+		t.Errorf("reverse = %+v, want %+v", pl, want)
+	}
+
+	for _, req := range fake.requests {
+		if req.URL.Host != "nominatim.openstreetmap.org" || req.Header.Get("User-Agent") != "route-dm" {
+			t.Errorf("request to %s with User-Agent %q", req.URL.Host, req.Header.Get("User-Agent"))
+		}
+		if req.URL.Query().Get("format") != "jsonv2" {
+			t.Errorf("request %s does not ask for jsonv2", req.URL)
+		}
+	}
+	if len(fake.requests) != 2 {
+		t.Errorf("made %d requests, want 2", len(fake.requests))
+	}
+}
@@ -0,0 +1,123 @@
+package matrix
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sync"
+)
+
+// directionsURL is the Directions API, which the annotators ask for what
+// the Distance Matrix API does not return.
+const directionsURL = "https://maps.googleapis.com/maps/api/directions/json"
+
+// getDirections fetches the Directions API with params, authenticated by
+// auth, and decodes the response into v.
+func getDirections(auth APIAuth, params url.Values, v any) error {
+	requestURL, err := auth.url(directionsURL, params)
+	if err != nil {
+		return err
+	}
+	if len(requestURL) > maxURLLength {
+		return fmt.Errorf("request URL is %d characters, over the %d limit", len(requestURL), maxURLLength)
+	}
+	return getJSON(requestURL, nil, v)
+}
+
+// RouteGeometry adds a POLYLINE column holding the encoded polyline of each
+// route, fetched with one Directions (or Routes API) request per pair. The
+// Distance Matrix API returns no geometry. Pairs repeated across rows are
+// queried once. Requests go through the provider annotating, which
+// authenticates and bills them.
+type RouteGeometry struct {
+	// provider is "routes" to use the Routes API, anything else for the
+	// Directions API.
+	provider string
+
+	mu    sync.Mutex
+	cache map[[2]string]string
+}
+
+func NewRouteGeometry(provider string) *RouteGeometry {
+	return &RouteGeometry{provider: provider, cache: make(map[[2]string]string)}
+}
+
+func (g *RouteGeometry) Annotate(p Provider, opts QueryOptions, r *Result) {
+	key := [2]string{r.Origin, r.Destination}
+	g.mu.Lock()
+	polyline, ok := g.cache[key]
+	g.mu.Unlock()
+
+	if !ok {
+		polyline = "N/A"
+		if r.Origin != "" && r.Destination != "" {
+			fetch := g.directions
+			if g.provider == "routes" {
+				fetch = g.routes
+			}
+			var points string
+			err := CallAPI(p, 1, func(auth APIAuth) error {
+				var err error
+				points, err = fetch(auth, r.Origin, r.Destination, opts)
+				return err
+			})
+			if err != nil {
+				slog.Error("fetching geometry", "site", r.SiteCode, "terminal", r.TerminalCode, "err", err)
+			} else {
+				polyline = points
+			}
+		}
+		g.mu.Lock()
+		g.cache[key] = polyline
+		g.mu.Unlock()
+	}
+
+	r.Extra = append(r.Extra, Field{Name: "POLYLINE", Value: polyline})
+}
+
+// directions returns the overview polyline from the Directions API.
+func (g *RouteGeometry) directions(auth APIAuth, origin, destination string, opts QueryOptions) (string, error) {
+	params := opts.values()
+	params.Add("origin", origin)
+	params.Add("destination", destination)
+
+	var resp struct {
+		Status string `json:"status"`
+		Routes []struct {
+			OverviewPolyline struct {
+				Points string `json:"points"`
+			} `json:"overview_polyline"`
+		} `json:"routes"`
+	}
+	if err := getDirections(auth, params, &resp); err != nil {
+		return "", err
+	}
+
+	switch {
+	case resp.Status == "ZERO_RESULTS" || (resp.Status == "OK" && len(resp.Routes) == 0):
+		return "", fmt.Errorf("no route found")
+	case resp.Status != "OK":
+		return "", &statusError{status: resp.Status}
+	}
+	return resp.Routes[0].OverviewPolyline.Points, nil
+}
+
+// routes returns the encoded polyline from the Routes API.
+func (g *RouteGeometry) routes(auth APIAuth, origin, destination string, opts QueryOptions) (string, error) {
+	req := newRoutesDirectionsRequest(origin, destination, opts)
+
+	var resp struct {
+		Routes []struct {
+			Polyline struct {
+				EncodedPolyline string `json:"encodedPolyline"`
+			} `json:"polyline"`
+		} `json:"routes"`
+	}
+	if err := postRoutes(routesDirectionsURL, "routes.polyline.encodedPolyline", auth, req, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Routes) == 0 {
+		return "", fmt.Errorf("no route found")
+	}
+	return resp.Routes[0].Polyline.EncodedPolyline, nil
+}
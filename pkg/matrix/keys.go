@@ -0,0 +1,123 @@
+package matrix
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// keyCooldown is how long a key that hit its quota is passed over.
+const keyCooldown = time.Minute
+
+// isQuotaError reports whether err says the API key ran out of quota.
+func isQuotaError(err error) bool {
+	var status *statusError
+	if !errors.As(err, &status) {
+		return false
+	}
+	switch status.status {
+	case "OVER_QUERY_LIMIT", "OVER_DAILY_LIMIT", "HTTP_429":
+		return true
+	}
+	return false
+}
+
+// keyPool spreads requests over one provider per API key. In round-robin
+// mode each request goes to the next key; otherwise requests stay on the
+// first key until it runs out of quota. Either way a request that hits a
+// key's quota is retried on the next key, and that key is skipped for
+// keyCooldown.
+type keyPool struct {
+	providers  []Provider
+	roundRobin bool
+
+	mu       sync.Mutex
+	next     int
+	cooldown []time.Time
+}
+
+// NewKeyPoolProvider returns the provider named by name, as accepted by
+// NewProvider, rotating over apiKeys. rotation is "round-robin" or
+// "failover". A single key gives a plain provider.
+func NewKeyPoolProvider(name string, apiKeys []string, rotation string, opts QueryOptions) (Provider, error) {
+	if rotation != "round-robin" && rotation != "failover" {
+		return nil, fmt.Errorf("unknown key rotation %q (want round-robin or failover)", rotation)
+	}
+	switch len(apiKeys) {
+	case 0:
+		return nil, errors.New("no API keys")
+	case 1:
+		return NewProvider(name, apiKeys[0], opts)
+	}
+	pool := &keyPool{roundRobin: rotation == "round-robin", cooldown: make([]time.Time, len(apiKeys))}
+	for _, key := range apiKeys {
+		p, err := NewProvider(name, key, opts)
+		if err != nil {
+			return nil, err
+		}
+		pool.providers = append(pool.providers, p)
+	}
+	return pool, nil
+}
+
+func (k *keyPool) GetDistanceMatrix(origins, destinations string, opts QueryOptions) (*DistanceMatrixResponse, error) {
+	var resp *DistanceMatrixResponse
+	var err error
+	for range k.providers {
+		i := k.pick()
+		resp, err = k.providers[i].GetDistanceMatrix(origins, destinations, opts)
+		if !isQuotaError(err) {
+			return resp, err
+		}
+		k.exhausted(i)
+		slog.Warn("API key over quota, rotating to the next key", "key", i+1, "err", err)
+	}
+	return resp, err
+}
+
+func (k *keyPool) CallAPI(units int64, do func(auth APIAuth) error) error {
+	var err error
+	for range k.providers {
+		i := k.pick()
+		err = CallAPI(k.providers[i], units, do)
+		if !isQuotaError(err) {
+			return err
+		}
+		k.exhausted(i)
+		slog.Warn("API key over quota, rotating to the next key", "key", i+1, "err", err)
+	}
+	return err
+}
+
+// pick returns the key to use next: the first one not cooling down, counting
+// from the round-robin position or from the first key. When every key is
+// cooling down it uses the one that recovers soonest.
+func (k *keyPool) pick() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	start := 0
+	if k.roundRobin {
+		start = k.next
+		k.next = (k.next + 1) % len(k.providers)
+	}
+	now := time.Now()
+	soonest := start
+	for n := range k.providers {
+		i := (start + n) % len(k.providers)
+		if !k.cooldown[i].After(now) {
+			return i
+		}
+		if k.cooldown[i].Before(k.cooldown[soonest]) {
+			soonest = i
+		}
+	}
+	return soonest
+}
+
+func (k *keyPool) exhausted(i int) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.cooldown[i] = time.Now().Add(keyCooldown)
+}
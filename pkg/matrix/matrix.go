@@ -0,0 +1,275 @@
+// Package matrix computes road distances and durations between route origins
+// and destinations through the Google Distance Matrix or Routes API. Client
+// runs a batch; Provider, QueryRoutes and the annotators are the pieces it is
+// built from.
+package matrix
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DistanceMatrixResponse represents the response from the Google Distance Matrix API
+type DistanceMatrixResponse struct {
+	Rows   []DistanceMatrixRow `json:"rows"`
+	Status string              `json:"status"`
+}
+
+// DistanceMatrixRow holds the elements for one origin, one per destination.
+type DistanceMatrixRow struct {
+	Elements []DistanceMatrixElement `json:"elements"`
+}
+
+// DistanceMatrixElement is the route between one origin and one destination.
+type DistanceMatrixElement struct {
+	Distance TextValue `json:"distance"`
+	Duration TextValue `json:"duration"`
+	// DurationInTraffic is only returned when a departure time is requested.
+	DurationInTraffic TextValue `json:"duration_in_traffic"`
+	Status            string    `json:"status"`
+}
+
+// TextValue is an API quantity with its human-readable text.
+type TextValue struct {
+	Text  string `json:"text"`
+	Value int    `json:"value"`
+}
+
+// QueryOptions holds the optional Distance Matrix request parameters.
+type QueryOptions struct {
+	// DepartureTime requests traffic-aware durations when set.
+	DepartureTime time.Time
+	// DepartureNow sends departure_time=now, which the API resolves itself
+	// so the request can never fall in the past.
+	DepartureNow bool
+	// TrafficModel is best_guess, pessimistic or optimistic. It only applies
+	// with a departure time.
+	TrafficModel string
+	// Avoid lists route features to avoid: tolls, highways, ferries, indoor.
+	Avoid []string
+	// Units is the unit system of distance text: metric or imperial.
+	Units string
+	// Language localizes the duration and distance text, e.g. "fr".
+	Language string
+	// Region biases routing toward a country, as a ccTLD such as "de".
+	Region string
+	// Mode is the travel mode; empty means driving. two_wheeler needs the
+	// Routes API.
+	Mode string
+	// TransitModes and TransitRoutingPreference only apply to mode transit.
+	TransitModes             []string
+	TransitRoutingPreference string
+	// Legs adds per-leg distance and duration columns, which break down
+	// routes with waypoints.
+	Legs bool
+	// Status adds a STATUS column: OK, the element status when no route
+	// exists (ZERO_RESULTS, NOT_FOUND), or why the request failed.
+	Status bool
+	// DurationSeconds adds a DURATION_SECONDS column with the duration as
+	// a number, next to the DURATION text.
+	DurationSeconds bool
+	// ArrivalTime adds an IMPLIED_DEPARTURE column. The API only takes it
+	// for mode transit.
+	ArrivalTime time.Time
+}
+
+func (o QueryOptions) HasDeparture() bool {
+	return o.DepartureNow || !o.DepartureTime.IsZero()
+}
+
+// InTraffic reports whether durations in traffic are returned, which the
+// APIs only do for motor vehicles with a departure time.
+func (o QueryOptions) InTraffic() bool {
+	return o.HasDeparture() && (o.Mode == "" || o.Mode == "two_wheeler")
+}
+
+// ParseAvoid reads a list of features to avoid, separated by commas or by
+// pipes as in the API, into opts.
+func ParseAvoid(s string, opts *QueryOptions) error {
+	opts.Avoid = nil
+	for _, feature := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '|' }) {
+		feature = strings.ToLower(strings.TrimSpace(feature))
+		switch feature {
+		case "":
+		case "tolls", "highways", "ferries", "indoor":
+			opts.Avoid = append(opts.Avoid, feature)
+		default:
+			return fmt.Errorf("cannot avoid %q (want tolls, highways, ferries or indoor)", feature)
+		}
+	}
+	return nil
+}
+
+// ParseLocale validates the language (a tag such as "fr" or "pt-BR") and
+// region (a two-letter ccTLD) into opts.
+func ParseLocale(language, region string, opts *QueryOptions) error {
+	for _, r := range language {
+		if !(r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return fmt.Errorf("invalid language %q, expected a tag such as fr or pt-BR", language)
+		}
+	}
+	if region != "" && (len(region) != 2 || strings.Trim(strings.ToLower(region), "abcdefghijklmnopqrstuvwxyz") != "") {
+		return fmt.Errorf("invalid region %q, expected a two-letter code such as de", region)
+	}
+	opts.Language, opts.Region = language, strings.ToLower(region)
+	return nil
+}
+
+// ParseDepartureTime reads an RFC3339 time or "now" into opts.
+func ParseDepartureTime(s string, opts *QueryOptions) error {
+	if s == "now" {
+		opts.DepartureNow = true
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return fmt.Errorf("invalid departure time %q: use RFC3339 or now", s)
+	}
+	if t.Before(time.Now()) {
+		return fmt.Errorf("departure time %s is in the past", s)
+	}
+	opts.DepartureTime = t
+	return nil
+}
+
+// Route is one input row: a site and the terminal its distance is measured from.
+type Route struct {
+	SiteCode     string
+	SiteName     string
+	TerminalCode string
+	Origin       string // terminal location, "lat,lng"
+	Destination  string // site location, "lat,lng"
+	// Waypoints are "lat,lng" via points visited in order between the
+	// origin and the destination.
+	Waypoints []string
+	// OriginAddress and DestinationAddress are set instead of the
+	// coordinates when a location still has to be geocoded.
+	OriginAddress      string
+	DestinationAddress string
+	// OriginPlusCode and DestinationPlusCode are the Plus Codes the
+	// coordinates were decoded from, if any.
+	OriginPlusCode      string
+	DestinationPlusCode string
+	// Input holds the input row's columns as read, for the error report.
+	Input []Field
+	// PassThrough holds the input columns the PassThrough annotator copies
+	// to the output.
+	PassThrough []Field
+}
+
+// Result is the outcome of querying a Route.
+type Result struct {
+	Route
+	DistanceKm float64
+	Duration   string
+	// Status is OK, or why no route was obtained (see failureType), with
+	// Error describing the failure.
+	Status string
+	Error  string
+	// Seconds is the duration in seconds.
+	Seconds int
+	// Extra holds the optional columns enabled by run options, in output order.
+	Extra []Field
+}
+
+// Field is a named output value beyond the fixed result columns.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// values returns the query parameters opts sets on Maps Web Service
+// requests, which the Distance Matrix and Directions APIs share.
+func (opts QueryOptions) values() url.Values {
+	mode := "driving"
+	if opts.Mode != "" {
+		mode = opts.Mode
+	}
+	params := url.Values{}
+	params.Add("mode", mode)
+	if opts.DepartureNow {
+		params.Add("departure_time", "now")
+	} else if !opts.DepartureTime.IsZero() {
+		params.Add("departure_time", strconv.FormatInt(opts.DepartureTime.Unix(), 10))
+	}
+	if opts.Mode == "transit" && !opts.ArrivalTime.IsZero() && !opts.HasDeparture() {
+		params.Add("arrival_time", strconv.FormatInt(opts.ArrivalTime.Unix(), 10))
+	}
+	if opts.Units != "" {
+		params.Add("units", opts.Units)
+	}
+	if opts.Language != "" {
+		params.Add("language", opts.Language)
+	}
+	if opts.Region != "" {
+		params.Add("region", opts.Region)
+	}
+	if len(opts.Avoid) > 0 {
+		params.Add("avoid", strings.Join(opts.Avoid, "|"))
+	}
+	if len(opts.TransitModes) > 0 {
+		params.Add("transit_mode", strings.Join(opts.TransitModes, "|"))
+	}
+	if opts.TransitRoutingPreference != "" {
+		params.Add("transit_routing_preference", opts.TransitRoutingPreference)
+	}
+	if opts.TrafficModel != "" && opts.HasDeparture() {
+		params.Add("traffic_model", opts.TrafficModel)
+	}
+	return params
+}
+
+func getDistanceMatrix(auth APIAuth, origin, destination string, opts QueryOptions) (*DistanceMatrixResponse, error) {
+	params := opts.values()
+	params.Add("origins", origin)
+	params.Add("destinations", destination)
+	requestURL, err := auth.url("https://maps.googleapis.com/maps/api/distancematrix/json", params)
+	if err != nil {
+		return nil, err
+	}
+	if len(requestURL) > maxURLLength {
+		return nil, fmt.Errorf("request URL is %d characters, over the %d limit", len(requestURL), maxURLLength)
+	}
+
+	var distanceMatrix DistanceMatrixResponse
+	err = withRetry(func() error {
+		resp, err := http.Get(requestURL)
+		if err != nil {
+			return &transportError{err}
+		}
+		defer resp.Body.Close()
+
+		body, err := readResponseBody(resp)
+		if err != nil {
+			return err
+		}
+
+		distanceMatrix = DistanceMatrixResponse{}
+		return decodeJSON(body, &distanceMatrix)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if distanceMatrix.Status != "OK" {
+		return nil, &statusError{status: distanceMatrix.Status}
+	}
+
+	return &distanceMatrix, nil
+}
+
+// ParseUnitSystem sets the unit system the API uses for distance text:
+// metric (the default) or imperial.
+func ParseUnitSystem(system string, opts *QueryOptions) error {
+	switch system {
+	case "", "metric", "imperial":
+		opts.Units = system
+		return nil
+	default:
+		return fmt.Errorf("unknown unit system %q (want metric or imperial)", system)
+	}
+}
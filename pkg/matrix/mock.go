@@ -0,0 +1,126 @@
+package matrix
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// mockProvider answers instantly from straight-line geometry, like the
+// SyntheticProvider without its latency and failures, so runs need neither
+// an API key nor the network.
+type mockProvider struct{}
+
+func (mockProvider) GetDistanceMatrix(origins, destinations string, opts QueryOptions) (*DistanceMatrixResponse, error) {
+	return syntheticResponse(strings.Split(origins, "|"), strings.Split(destinations, "|")), nil
+}
+
+// interaction is one line of a cassette file: a request, keyed by its query
+// parameters without the API key, and the response or API status it got.
+type interaction struct {
+	Request  string                  `json:"request"`
+	Status   string                  `json:"status,omitempty"`
+	Message  string                  `json:"message,omitempty"`
+	Response *DistanceMatrixResponse `json:"response,omitempty"`
+}
+
+func requestKey(origins, destinations string, opts QueryOptions) string {
+	params := opts.values()
+	params.Set("origins", origins)
+	params.Set("destinations", destinations)
+	return params.Encode()
+}
+
+// Recorder passes requests to p and appends each answer to a cassette file
+// that a Replayer can serve later. Transport errors are not recorded.
+type Recorder struct {
+	p Provider
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewRecorder records p's answers to the cassette at path, replacing it.
+func NewRecorder(p Provider, path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{p: p, file: file}, nil
+}
+
+func (r *Recorder) GetDistanceMatrix(origins, destinations string, opts QueryOptions) (*DistanceMatrixResponse, error) {
+	resp, err := r.p.GetDistanceMatrix(origins, destinations, opts)
+	entry := interaction{Request: requestKey(origins, destinations, opts), Response: resp}
+	var status *statusError
+	switch {
+	case errors.As(err, &status):
+		entry.Status, entry.Message = status.status, status.message
+	case err != nil:
+		return resp, err
+	}
+
+	line, jsonErr := json.Marshal(entry)
+	if jsonErr != nil {
+		return resp, jsonErr
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, werr := r.file.Write(append(line, '\n')); werr != nil {
+		return resp, fmt.Errorf("recording response: %w", werr)
+	}
+	return resp, err
+}
+
+// CallAPI passes the annotators' own requests on unrecorded; a Replayer
+// serves only matrix requests.
+func (r *Recorder) CallAPI(units int64, do func(auth APIAuth) error) error {
+	return CallAPI(r.p, units, do)
+}
+
+// Close closes the cassette file.
+func (r *Recorder) Close() error {
+	return r.file.Close()
+}
+
+// Replayer answers from a cassette written by a Recorder, never calling the
+// API. Requests missing from the cassette fail.
+type Replayer struct {
+	interactions map[string]interaction
+}
+
+// NewReplayer loads the cassette at path.
+func NewReplayer(path string) (*Replayer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := &Replayer{interactions: make(map[string]interaction)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxResponseBytes)
+	for n := 1; scanner.Scan(); n++ {
+		var entry interaction
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		r.interactions[entry.Request] = entry
+	}
+	return r, scanner.Err()
+}
+
+func (r *Replayer) GetDistanceMatrix(origins, destinations string, opts QueryOptions) (*DistanceMatrixResponse, error) {
+	entry, ok := r.interactions[requestKey(origins, destinations, opts)]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("request not in cassette: origins %s, destinations %s", origins, destinations)
+	case entry.Status != "":
+		return nil, &statusError{status: entry.Status, message: entry.Message}
+	}
+	return entry.Response, nil
+}
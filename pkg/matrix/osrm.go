@@ -0,0 +1,102 @@
+package matrix
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// defaultOSRMURL is the public OSRM demo server, which only routes cars
+// and is meant for light use.
+const defaultOSRMURL = "https://router.project-osrm.org"
+
+// osrmProfiles maps travel modes to the profiles of the standard OSRM
+// builds.
+var osrmProfiles = map[string]string{"": "driving", "driving": "driving", "walking": "foot", "bicycling": "bike"}
+
+// osrmProvider queries the table service of an OSRM server and converts
+// its answers to Distance Matrix responses. OSRM only takes coordinates
+// and ignores traffic, avoidances and the locale.
+type osrmProvider struct {
+	baseURL string
+}
+
+func newOSRMProvider(name string, opts QueryOptions) (Provider, error) {
+	if _, ok := osrmProfiles[opts.Mode]; !ok {
+		return nil, fmt.Errorf("mode %s is not supported by the osrm provider (want driving, walking or bicycling)", opts.Mode)
+	}
+	baseURL := defaultOSRMURL
+	if _, u, ok := strings.Cut(name, "="); ok {
+		baseURL = u
+	}
+	return osrmProvider{baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+func (p osrmProvider) GetDistanceMatrix(origins, destinations string, opts QueryOptions) (*DistanceMatrixResponse, error) {
+	originList := strings.Split(origins, "|")
+	destinationList := strings.Split(destinations, "|")
+	var coordinates, sources, targets []string
+	for i, location := range append(originList, destinationList...) {
+		lat, lng, err := parseLatLng(location)
+		if err != nil {
+			return nil, fmt.Errorf("osrm needs coordinates: %w", err)
+		}
+		coordinates = append(coordinates, strconv.FormatFloat(lng, 'f', -1, 64)+","+strconv.FormatFloat(lat, 'f', -1, 64))
+		if i < len(originList) {
+			sources = append(sources, strconv.Itoa(i))
+		} else {
+			targets = append(targets, strconv.Itoa(i))
+		}
+	}
+
+	params := url.Values{}
+	params.Set("sources", strings.Join(sources, ";"))
+	params.Set("destinations", strings.Join(targets, ";"))
+	params.Set("annotations", "duration,distance")
+	requestURL := fmt.Sprintf("%s/table/v1/%s/%s?%s", p.baseURL, osrmProfiles[opts.Mode], strings.Join(coordinates, ";"), params.Encode())
+
+	var table struct {
+		Code      string       `json:"code"`
+		Message   string       `json:"message"`
+		Durations [][]*float64 `json:"durations"`
+		Distances [][]*float64 `json:"distances"`
+	}
+	err := withRetry(func() error {
+		resp, err := http.Get(requestURL)
+		if err != nil {
+			return &transportError{err}
+		}
+		defer resp.Body.Close()
+		body, err := readResponseBody(resp)
+		if err != nil {
+			return err
+		}
+		return decodeJSON(body, &table)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if table.Code != "Ok" {
+		return nil, &statusError{status: "OSRM_" + strings.ToUpper(table.Code), message: table.Message}
+	}
+
+	resp := &DistanceMatrixResponse{Status: "OK", Rows: make([]DistanceMatrixRow, len(originList))}
+	for i := range originList {
+		for j := range destinationList {
+			element := DistanceMatrixElement{Status: "ZERO_RESULTS"}
+			if i < len(table.Durations) && j < len(table.Durations[i]) && table.Durations[i][j] != nil &&
+				i < len(table.Distances) && j < len(table.Distances[i]) && table.Distances[i][j] != nil {
+				meters, seconds := int(*table.Distances[i][j]), int(*table.Durations[i][j])
+				element = DistanceMatrixElement{
+					Distance: TextValue{Text: fmt.Sprintf("%.1f km", float64(meters)/1000), Value: meters},
+					Duration: TextValue{Text: DurationText(seconds), Value: seconds},
+					Status:   "OK",
+				}
+			}
+			resp.Rows[i].Elements = append(resp.Rows[i].Elements, element)
+		}
+	}
+	return resp, nil
+}
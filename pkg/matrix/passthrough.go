@@ -0,0 +1,23 @@
+package matrix
+
+// PassThrough is an annotator copying each route's PassThrough input
+// columns to its result unchanged, so the output keeps the business data
+// the input carried. Values copied from an earlier output are overwritten.
+type PassThrough struct{}
+
+func (PassThrough) Annotate(p Provider, opts QueryOptions, r *Result) {
+	for _, f := range r.PassThrough {
+		setField(r, f)
+	}
+}
+
+// setField replaces the extra field named f.Name, or appends f.
+func setField(r *Result, f Field) {
+	for i := range r.Extra {
+		if r.Extra[i].Name == f.Name {
+			r.Extra[i].Value = f.Value
+			return
+		}
+	}
+	r.Extra = append(r.Extra, f)
+}
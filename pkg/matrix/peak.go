@@ -0,0 +1,90 @@
+package matrix
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// PeakTimes adds traffic-aware durations at a peak and an off-peak time of
+// day. Each time is the next occurrence of the clock time, since the API only
+// predicts traffic for departures in the future. Pairs repeated across rows
+// are queried once.
+type PeakTimes struct {
+	columns []peakColumn
+
+	mu    sync.Mutex
+	cache map[[2]string][]string
+}
+
+type peakColumn struct {
+	name string
+	at   time.Time
+}
+
+func NewPeakTimes(peak, offpeak string, now time.Time) (*PeakTimes, error) {
+	t := &PeakTimes{cache: make(map[[2]string][]string)}
+	for _, c := range []struct{ name, clock string }{
+		{"DURATION_PEAK", peak},
+		{"DURATION_OFFPEAK", offpeak},
+	} {
+		if c.clock == "" {
+			continue
+		}
+		at, err := nextClockTime(c.clock, now)
+		if err != nil {
+			return nil, err
+		}
+		t.columns = append(t.columns, peakColumn{c.name, at})
+	}
+	return t, nil
+}
+
+// nextClockTime returns the first time after now, in now's location, whose
+// clock reads clock ("HH:MM").
+func nextClockTime(clock string, now time.Time) (time.Time, error) {
+	c, err := time.Parse("15:04", clock)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time of day %q, expected HH:MM", clock)
+	}
+	t := time.Date(now.Year(), now.Month(), now.Day(), c.Hour(), c.Minute(), 0, 0, now.Location())
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// Queries returns the number of matrix queries made per distinct pair.
+func (t *PeakTimes) Queries() int { return len(t.columns) }
+
+func (t *PeakTimes) Annotate(p Provider, opts QueryOptions, r *Result) {
+	key := [2]string{r.Origin, r.Destination}
+	t.mu.Lock()
+	values, ok := t.cache[key]
+	t.mu.Unlock()
+
+	if !ok {
+		values = make([]string, len(t.columns))
+		for i, c := range t.columns {
+			values[i] = "N/A"
+			if r.Origin == "" || r.Destination == "" {
+				continue
+			}
+			opts.DepartureTime, opts.DepartureNow = c.at, false
+			seconds, err := trafficSeconds(p, r.Origin, r.Destination, opts)
+			if err != nil {
+				slog.Error("fetching "+c.name, "site", r.SiteCode, "terminal", r.TerminalCode, "err", err)
+				continue
+			}
+			values[i] = DurationText(seconds)
+		}
+		t.mu.Lock()
+		t.cache[key] = values
+		t.mu.Unlock()
+	}
+
+	for i, c := range t.columns {
+		r.Extra = append(r.Extra, Field{Name: c.name, Value: values[i]})
+	}
+}
@@ -0,0 +1,17 @@
+package matrix
+
+// PlusCodes is an annotator adding ORIGIN_DECODED and DESTINATION_DECODED
+// columns with the coordinates of route ends given as Plus Codes. They are
+// empty for ends given as coordinates.
+type PlusCodes struct{}
+
+func (PlusCodes) Annotate(p Provider, opts QueryOptions, r *Result) {
+	decoded := func(code, coordinate string) string {
+		if code == "" {
+			return ""
+		}
+		return coordinate
+	}
+	setField(r, Field{Name: "ORIGIN_DECODED", Value: decoded(r.OriginPlusCode, r.Origin)})
+	setField(r, Field{Name: "DESTINATION_DECODED", Value: decoded(r.DestinationPlusCode, r.Destination)})
+}
@@ -0,0 +1,453 @@
+package matrix
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Provider computes distance matrices. Origins and destinations are
+// pipe-separated lists of locations, as accepted by the Distance Matrix API.
+type Provider interface {
+	GetDistanceMatrix(origins, destinations string, opts QueryOptions) (*DistanceMatrixResponse, error)
+}
+
+// googleProvider queries the Google Distance Matrix API.
+// It authenticates with the API key, or with signer when that is set.
+type googleProvider struct {
+	apiKey string
+	signer *URLSigner
+}
+
+func (p googleProvider) GetDistanceMatrix(origins, destinations string, opts QueryOptions) (*DistanceMatrixResponse, error) {
+	return getDistanceMatrix(p.auth(), origins, destinations, opts)
+}
+
+func (p googleProvider) CallAPI(units int64, do func(auth APIAuth) error) error {
+	return do(p.auth())
+}
+
+func (p googleProvider) auth() APIAuth {
+	return APIAuth{apiKey: p.apiKey, signer: p.signer}
+}
+
+// APIAuth authenticates a request to a Google API: with signer when it is
+// set, and with apiKey otherwise.
+type APIAuth struct {
+	apiKey string
+	signer *URLSigner
+}
+
+// url returns baseURL with params and the key or signature.
+func (a APIAuth) url(baseURL string, params url.Values) (string, error) {
+	if a.signer != nil {
+		return a.signer.signedURL(baseURL, params)
+	}
+	params.Add("key", a.apiKey)
+	return baseURL + "?" + params.Encode(), nil
+}
+
+// APICaller is implemented by the providers that pass on the requests
+// annotators make for themselves, such as for directions or tolls, so that
+// stopping, the element budget, the key pool and URL signing apply to them
+// as they do to matrix requests. CallAPI calls do with the credentials to
+// send, and bills the request as units elements once do succeeds.
+type APICaller interface {
+	CallAPI(units int64, do func(auth APIAuth) error) error
+}
+
+// CallAPI makes an annotator's own API request through p. A provider that
+// is not an APICaller, such as OSRM or a cassette, holds no Google
+// credentials, and do gets none.
+func CallAPI(p Provider, units int64, do func(auth APIAuth) error) error {
+	if c, ok := p.(APICaller); ok {
+		return c.CallAPI(units, do)
+	}
+	return do(APIAuth{})
+}
+
+// keyedProvider is a provider that is not a Google API, holding a key for
+// the Google requests annotators make alongside it, such as for a
+// comparison with Google.
+type keyedProvider struct {
+	Provider
+	apiKey string
+}
+
+// WithAPIKey returns p holding apiKey for the annotators' own requests, for
+// a provider that does not call Google itself, such as OSRM or a cassette.
+// Without a key it returns p as it is.
+func WithAPIKey(p Provider, apiKey string) Provider {
+	if apiKey == "" {
+		return p
+	}
+	return keyedProvider{Provider: p, apiKey: apiKey}
+}
+
+func (k keyedProvider) CallAPI(units int64, do func(auth APIAuth) error) error {
+	return do(APIAuth{apiKey: k.apiKey})
+}
+
+// CountingProvider counts the matrix elements requested through p that the
+// API answered, which is what the Distance Matrix API bills.
+type CountingProvider struct {
+	// Limit, if positive, is the most elements to request; requests that
+	// would go past it fail without calling the API.
+	Limit int64
+
+	p        Provider
+	elements atomic.Int64
+	reserved atomic.Int64 // elements answered or in flight
+}
+
+func NewCountingProvider(p Provider) *CountingProvider {
+	return &CountingProvider{p: p}
+}
+
+// Elements returns the number of elements counted so far.
+func (c *CountingProvider) Elements() int64 {
+	return c.elements.Load()
+}
+
+func (c *CountingProvider) GetDistanceMatrix(origins, destinations string, opts QueryOptions) (*DistanceMatrixResponse, error) {
+	n := int64((strings.Count(origins, "|") + 1) * (strings.Count(destinations, "|") + 1))
+	if c.reserved.Add(n) > c.Limit && c.Limit > 0 {
+		c.reserved.Add(-n)
+		return nil, errBudgetExceeded
+	}
+	resp, err := c.p.GetDistanceMatrix(origins, destinations, opts)
+	if err != nil {
+		c.reserved.Add(-n)
+		return resp, err
+	}
+	c.elements.Add(n)
+	return resp, nil
+}
+
+func (c *CountingProvider) CallAPI(units int64, do func(auth APIAuth) error) error {
+	if c.reserved.Add(units) > c.Limit && c.Limit > 0 {
+		c.reserved.Add(-units)
+		return errBudgetExceeded
+	}
+	if err := CallAPI(c.p, units, do); err != nil {
+		c.reserved.Add(-units)
+		return err
+	}
+	c.elements.Add(units)
+	return nil
+}
+
+// StoppableProvider passes requests to p until Stop is called, then fails
+// the rest without calling the API, so a batch winds down once the requests
+// in flight are answered.
+type StoppableProvider struct {
+	p       Provider
+	stopped atomic.Bool
+}
+
+func NewStoppableProvider(p Provider) *StoppableProvider {
+	return &StoppableProvider{p: p}
+}
+
+// Stop makes every later request fail as INTERRUPTED.
+func (s *StoppableProvider) Stop() {
+	s.stopped.Store(true)
+}
+
+// Stopped reports whether Stop was called.
+func (s *StoppableProvider) Stopped() bool {
+	return s.stopped.Load()
+}
+
+func (s *StoppableProvider) GetDistanceMatrix(origins, destinations string, opts QueryOptions) (*DistanceMatrixResponse, error) {
+	if s.stopped.Load() {
+		return nil, errInterrupted
+	}
+	return s.p.GetDistanceMatrix(origins, destinations, opts)
+}
+
+func (s *StoppableProvider) CallAPI(units int64, do func(auth APIAuth) error) error {
+	if s.stopped.Load() {
+		return errInterrupted
+	}
+	return CallAPI(s.p, units, do)
+}
+
+// ProviderNames are the names NewProvider accepts, besides "osrm=URL".
+var ProviderNames = []string{"google", "routes", "osrm", "mock"}
+
+// NewProvider returns the API named by name: "google" (or empty) for the
+// Distance Matrix API, "routes" for the Routes API, "osrm" for the public
+// OSRM demo server or "osrm=URL" for another OSRM server, or "mock" for
+// straight-line answers. Only the Google APIs need apiKey; the others keep
+// it for the requests annotators make to Google.
+func NewProvider(name, apiKey string, opts QueryOptions) (Provider, error) {
+	if name == "osrm" || strings.HasPrefix(name, "osrm=") {
+		p, err := newOSRMProvider(name, opts)
+		if err != nil {
+			return nil, err
+		}
+		return WithAPIKey(p, apiKey), nil
+	}
+	switch name {
+	case "", "google":
+		if opts.Mode == "two_wheeler" {
+			return nil, fmt.Errorf("mode two_wheeler needs the routes provider")
+		}
+		return googleProvider{apiKey: apiKey}, nil
+	case "routes":
+		return routesProvider{apiKey: apiKey}, nil
+	case "mock":
+		return WithAPIKey(mockProvider{}, apiKey), nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q (want google, routes, osrm or mock)", name)
+	}
+}
+
+// NeedsAPIKey reports whether the provider named by name, as accepted by
+// NewProvider, needs a Google API key.
+func NeedsAPIKey(name string) bool {
+	return name == "" || name == "google" || name == "routes"
+}
+
+// Progress is told as each item of a batch finishes.
+type Progress interface {
+	Step(failed bool)
+	Finish()
+}
+
+// QueryRoutes fetches every route using up to concurrency requests in
+// flight, reporting each to bar unless it is nil. Routes sharing their
+// origin, waypoints and destination are queried once and the result copied
+// to each. Results keep the order of routes.
+func QueryRoutes(p Provider, routes []Route, opts QueryOptions, concurrency int, bar Progress) []Result {
+	unique := samePairs(routes)
+	results := make([]Result, len(routes))
+	forEachConcurrently(len(unique), concurrency, func(g int) {
+		first := QueryRoute(p, routes[unique[g][0]], opts)
+		for _, i := range unique[g] {
+			results[i] = first
+			results[i].Route = routes[i]
+			results[i].Extra = slices.Clone(first.Extra)
+			if bar != nil {
+				bar.Step(first.Status != "OK")
+			}
+		}
+	})
+	if bar != nil {
+		bar.Finish()
+	}
+	return results
+}
+
+// samePairs returns the indexes into routes grouped by origin, waypoints
+// and destination, in order of first appearance.
+func samePairs(routes []Route) [][]int {
+	var unique [][]int
+	groups := make(map[string]int)
+	for i, r := range routes {
+		key := strings.Join(append([]string{r.Origin, r.Destination}, r.Waypoints...), "|")
+		g, ok := groups[key]
+		if !ok {
+			g = len(unique)
+			groups[key] = g
+			unique = append(unique, nil)
+		}
+		unique[g] = append(unique[g], i)
+	}
+	return unique
+}
+
+// QueryRoute returns the distance and duration for one route, or 0 and "N/A"
+// when no route could be obtained. Routes with waypoints are queried leg by
+// leg and summed. With a departure time it also reports the duration in
+// traffic, and with an arrival time the implied departure.
+func QueryRoute(p Provider, route Route, opts QueryOptions) Result {
+	result := Result{Route: route, DistanceKm: 0, Duration: "N/A"}
+	traffic, departure := "N/A", "N/A"
+
+	legs, err := legElements(p, route, opts)
+	result.Status = failureType(err)
+	if err != nil {
+		result.Error = failureMessage(err)
+	} else {
+		element := sumLegs(legs)
+		result.DistanceKm = float64(element.Distance.Value) / 1000 // Convert meters to kilometers
+		result.Duration = element.Duration.Text
+		result.Seconds = element.Duration.Value
+		if element.DurationInTraffic.Text != "" {
+			traffic = element.DurationInTraffic.Text
+		}
+		if !opts.ArrivalTime.IsZero() {
+			at := opts.ArrivalTime.Add(-time.Duration(element.Duration.Value) * time.Second)
+			departure = at.Format(time.RFC3339)
+		}
+	}
+
+	if opts.DurationSeconds {
+		seconds := "N/A"
+		if err == nil {
+			seconds = strconv.Itoa(result.Seconds)
+		}
+		result.Extra = append(result.Extra, Field{Name: "DURATION_SECONDS", Value: seconds})
+	}
+	if opts.Status {
+		result.Extra = append(result.Extra, Field{Name: "STATUS", Value: result.Status})
+	}
+	if opts.InTraffic() {
+		result.Extra = append(result.Extra, Field{Name: "DURATION_IN_TRAFFIC", Value: traffic})
+	}
+	if !opts.ArrivalTime.IsZero() {
+		result.Extra = append(result.Extra, Field{Name: "IMPLIED_DEPARTURE", Value: departure})
+	}
+	if opts.Legs {
+		result.Extra = append(result.Extra, legFields(legs)...)
+	}
+	return result
+}
+
+var (
+	errNotGeocoded = errors.New("location could not be geocoded")
+	errNoElement   = errors.New("no distance information in the response")
+	// errBudgetExceeded fails requests past CountingProvider.Limit.
+	errBudgetExceeded = errors.New("element budget exhausted")
+	// errInterrupted fails requests made after StoppableProvider.Stop.
+	errInterrupted = errors.New("run interrupted before this request")
+)
+
+// statusError is a non-OK status the API returned for a whole request or for
+// a single element.
+type statusError struct {
+	status  string
+	message string
+}
+
+func (e *statusError) Error() string {
+	if e.message != "" {
+		return "API error: " + e.status + ": " + e.message
+	}
+	return "API error: " + e.status
+}
+
+// failureType classifies err for the run summary: "OK" for nil, the API
+// status when the API reported one, and otherwise NOT_GEOCODED,
+// TRANSPORT_ERROR, NO_ELEMENT, BUDGET_EXCEEDED, INTERRUPTED or ERROR.
+func failureType(err error) string {
+	var status *statusError
+	switch {
+	case err == nil:
+		return "OK"
+	case errors.As(err, &status):
+		return status.status
+	case errors.Is(err, errNotGeocoded):
+		return "NOT_GEOCODED"
+	case errors.Is(err, errNoElement):
+		return "NO_ELEMENT"
+	case errors.Is(err, errBudgetExceeded):
+		return "BUDGET_EXCEEDED"
+	case errors.Is(err, errInterrupted):
+		return "INTERRUPTED"
+	case isRetryable(err):
+		return "TRANSPORT_ERROR"
+	default:
+		return "ERROR"
+	}
+}
+
+// failureMessage describes err without the query string of any request URL
+// in it, which carries the API key.
+func failureMessage(err error) string {
+	msg := err.Error()
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		if i := strings.IndexByte(urlErr.URL, '?'); i >= 0 {
+			msg = strings.ReplaceAll(msg, urlErr.URL, urlErr.URL[:i])
+		}
+	}
+	return msg
+}
+
+// routeElement fetches the single element for route. Elements the API could
+// not route fail with a statusError.
+func routeElement(p Provider, route Route, opts QueryOptions) (DistanceMatrixElement, error) {
+	if route.Origin == "" || route.Destination == "" {
+		return DistanceMatrixElement{}, errNotGeocoded
+	}
+
+	distanceMatrix, err := p.GetDistanceMatrix(route.Origin, route.Destination, opts)
+	if err != nil {
+		slog.Error("fetching distance matrix", "origin", route.Origin, "destination", route.Destination, "err", err)
+		return DistanceMatrixElement{}, err
+	}
+
+	if len(distanceMatrix.Rows) == 0 || len(distanceMatrix.Rows[0].Elements) == 0 {
+		return DistanceMatrixElement{}, errNoElement
+	}
+
+	element := distanceMatrix.Rows[0].Elements[0]
+	if element.Status != "OK" {
+		return DistanceMatrixElement{}, &statusError{status: element.Status}
+	}
+	return element, nil
+}
+
+// Annotator adds optional columns to a result after its main query. Any
+// further queries it makes start from the run's options.
+type Annotator interface {
+	// Annotate adds the annotator's columns to r, querying p with opts
+	// where it needs to.
+	Annotate(p Provider, opts QueryOptions, r *Result)
+}
+
+// AnnotateResults runs every annotator over results, in order per result,
+// reporting each finished result to bar unless it is nil.
+func AnnotateResults(p Provider, opts QueryOptions, results []Result, annotators []Annotator, concurrency int, bar Progress) {
+	if len(annotators) == 0 {
+		return
+	}
+	forEachConcurrently(len(results), concurrency, func(i int) {
+		AnnotateResult(p, opts, &results[i], annotators)
+		if bar != nil {
+			bar.Step(false)
+		}
+	})
+	if bar != nil {
+		bar.Finish()
+	}
+}
+
+// AnnotateResult runs annotators over r in order.
+func AnnotateResult(p Provider, opts QueryOptions, r *Result, annotators []Annotator) {
+	for _, a := range annotators {
+		a.Annotate(p, opts, r)
+	}
+}
+
+// forEachConcurrently calls fn for every index in [0, n) from up to
+// concurrency goroutines and waits for all calls to finish.
+func forEachConcurrently(n, concurrency int, fn func(i int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(concurrency, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
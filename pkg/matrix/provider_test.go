@@ -0,0 +1,149 @@
+package matrix
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGoogle answers Directions and Distance Matrix requests in place of
+// Google, recording the URL of each.
+type fakeGoogle struct {
+	mu   sync.Mutex
+	urls []*url.URL
+}
+
+func (f *fakeGoogle) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	f.urls = append(f.urls, req.URL)
+	f.mu.Unlock()
+	body := `{"status": "OK", "routes": [{"overview_polyline": {"points": "_p~iF~ps|U"}}]}`
+	if strings.HasSuffix(req.URL.Path, "/distancematrix/json") {
+		body = `{"status": "OK", "rows": [{"elements": [{"status": "OK", "distance": {"text": "1 km", "value": 1000}, "duration": {"text": "1 min", "value": 60}}]}]}`
+	}
+	return &http.Response{StatusCode: http.StatusOK, ContentLength: int64(len(body)), Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+}
+
+// useFakeGoogle sends the package's requests to a fakeGoogle until the test
+// ends.
+func useFakeGoogle(t *testing.T) *fakeGoogle {
+	f := &fakeGoogle{}
+	old := http.DefaultClient.Transport
+	http.DefaultClient.Transport = f
+	t.Cleanup(func() { http.DefaultClient.Transport = old })
+	return f
+}
+
+func field(r Result, name string) string {
+	for _, f := range r.Extra {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+func TestAnnotatorRequestsGoThroughProvider(t *testing.T) {
+	fake := useFakeGoogle(t)
+	signer, err := NewURLSigner("gme-acme", "vNIXE0xscrmjlyV-12Nj_BvUPaw=", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := NewCountingProvider(googleProvider{signer: signer})
+	counter.Limit = 2
+	stop := NewStoppableProvider(counter)
+
+	geometry := NewRouteGeometry("google")
+	var results []Result
+	for _, origin := range []string{"47.1,8.5", "47.2,8.5", "47.3,8.5"} {
+		r := Result{Route: Route{Origin: origin, Destination: "47.0,8.4"}}
+		geometry.Annotate(stop, QueryOptions{}, &r)
+		results = append(results, r)
+	}
+
+	// The third pair is past the budget and never requested.
+	if len(fake.urls) != 2 {
+		t.Fatalf("made %d requests, want 2", len(fake.urls))
+	}
+	for _, u := range fake.urls {
+		q := u.Query()
+		if q.Get("client") != "gme-acme" || q.Get("signature") == "" || q.Has("key") {
+			t.Errorf("request not signed with the client ID: %s", u)
+		}
+	}
+	for i, want := range []string{"_p~iF~ps|U", "_p~iF~ps|U", "N/A"} {
+		if got := field(results[i], "POLYLINE"); got != want {
+			t.Errorf("row %d: POLYLINE = %q, want %q", i, got, want)
+		}
+	}
+	if counter.Elements() != 2 {
+		t.Errorf("counted %d elements, want 2", counter.Elements())
+	}
+
+	// A stopped run makes no further requests.
+	counter.Limit = 0
+	stop.Stop()
+	r := Result{Route: Route{Origin: "47.4,8.5", Destination: "47.0,8.4"}}
+	NewRouteAlternatives("google").Annotate(stop, QueryOptions{}, &r)
+	if len(fake.urls) != 2 {
+		t.Errorf("a stopped provider made %d more requests", len(fake.urls)-2)
+	}
+	if got := field(r, "ROUTES_OFFERED"); got != "N/A" {
+		t.Errorf("ROUTES_OFFERED = %q after stopping, want N/A", got)
+	}
+}
+
+func TestAnnotatorRequestsUseKeyPool(t *testing.T) {
+	fake := useFakeGoogle(t)
+	pool, err := NewKeyPoolProvider("google", []string{"key-1", "key-2"}, "round-robin", QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	geometry := NewRouteGeometry("google")
+	for _, origin := range []string{"47.1,8.5", "47.2,8.5"} {
+		geometry.Annotate(pool, QueryOptions{}, &Result{Route: Route{Origin: origin, Destination: "47.0,8.4"}})
+	}
+	var keys []string
+	for _, u := range fake.urls {
+		keys = append(keys, u.Query().Get("key"))
+	}
+	if strings.Join(keys, ",") != "key-1,key-2" {
+		t.Errorf("requests used keys %v, want key-1 then key-2", keys)
+	}
+}
+
+func TestProviderComparisonGoesThroughProvider(t *testing.T) {
+	fake := useFakeGoogle(t)
+	comparison, err := NewProviderComparison([]string{"google"}, QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// OSRM compared with Google: the Google queries take the run's key
+	// and count against its budget.
+	osrm, err := NewProvider("osrm=http://osrm.invalid", "run-key", QueryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := NewCountingProvider(osrm)
+	r := Result{Route: Route{Origin: "47.1,8.5", Destination: "47.0,8.4", Waypoints: []string{"47.05,8.45"}}, Status: "OK", DistanceKm: 1.6, Seconds: 120}
+	comparison.Annotate(counter, QueryOptions{}, &r)
+
+	if len(fake.urls) != 2 {
+		t.Fatalf("made %d requests, want one per leg", len(fake.urls))
+	}
+	if key := fake.urls[0].Query().Get("key"); key != "run-key" {
+		t.Errorf("comparison sent key %q, want run-key", key)
+	}
+	if counter.Elements() != 2 {
+		t.Errorf("counted %d elements, want 2", counter.Elements())
+	}
+	if got := field(r, "DISTANCE_KM_GOOGLE"); got != "2.00" {
+		t.Errorf("DISTANCE_KM_GOOGLE = %q, want 2.00", got)
+	}
+	if got := field(r, "DISTANCE_DIFF_PCT_GOOGLE"); got != "25.0" {
+		t.Errorf("DISTANCE_DIFF_PCT_GOOGLE = %q, want 25.0", got)
+	}
+}
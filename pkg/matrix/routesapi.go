@@ -0,0 +1,311 @@
+package matrix
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	routesMatrixURL     = "https://routes.googleapis.com/distanceMatrix/v2:computeRouteMatrix"
+	routesDirectionsURL = "https://routes.googleapis.com/directions/v2:computeRoutes"
+	// routesFieldMask limits the response to what a DistanceMatrixElement
+	// holds; the Routes API bills by the fields requested.
+	routesFieldMask = "originIndex,destinationIndex,status,condition,distanceMeters,duration,staticDuration,localizedValues"
+)
+
+// routesProvider queries the Google Routes API (computeRouteMatrix) and
+// converts its answers to Distance Matrix responses, so the rest of the
+// program is unaware of which API was used.
+type routesProvider struct {
+	apiKey string
+}
+
+type routesWaypoint struct {
+	Location *routesLocation `json:"location,omitempty"`
+	PlaceID  string          `json:"placeId,omitempty"`
+	Address  string          `json:"address,omitempty"`
+}
+
+type routesMatrixWaypoint struct {
+	Waypoint routesWaypoint `json:"waypoint"`
+}
+
+type routesLocation struct {
+	LatLng struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	} `json:"latLng"`
+}
+
+type routesRequest struct {
+	Origins            []routesMatrixWaypoint `json:"origins,omitempty"`
+	Destinations       []routesMatrixWaypoint `json:"destinations,omitempty"`
+	TravelMode         string                 `json:"travelMode"`
+	RoutingPreference  string                 `json:"routingPreference,omitempty"`
+	DepartureTime      string                 `json:"departureTime,omitempty"`
+	ArrivalTime        string                 `json:"arrivalTime,omitempty"`
+	LanguageCode       string                 `json:"languageCode,omitempty"`
+	RegionCode         string                 `json:"regionCode,omitempty"`
+	Units              string                 `json:"units,omitempty"`
+	TrafficModel       string                 `json:"trafficModel,omitempty"`
+	RouteModifiers     *routesModifiers       `json:"routeModifiers,omitempty"`
+	TransitPreferences *transitPreferences    `json:"transitPreferences,omitempty"`
+}
+
+type routesModifiers struct {
+	AvoidTolls    bool               `json:"avoidTolls,omitempty"`
+	AvoidHighways bool               `json:"avoidHighways,omitempty"`
+	AvoidFerries  bool               `json:"avoidFerries,omitempty"`
+	AvoidIndoor   bool               `json:"avoidIndoor,omitempty"`
+	VehicleInfo   *routesVehicleInfo `json:"vehicleInfo,omitempty"`
+	TollPasses    []string           `json:"tollPasses,omitempty"`
+}
+
+type routesVehicleInfo struct {
+	EmissionType string `json:"emissionType"`
+}
+
+type transitPreferences struct {
+	AllowedTravelModes []string `json:"allowedTravelModes,omitempty"`
+	RoutingPreference  string   `json:"routingPreference,omitempty"`
+}
+
+type routesElement struct {
+	OriginIndex      int    `json:"originIndex"`
+	DestinationIndex int    `json:"destinationIndex"`
+	Condition        string `json:"condition"`
+	DistanceMeters   int    `json:"distanceMeters"`
+	Duration         string `json:"duration"`
+	StaticDuration   string `json:"staticDuration"`
+	Status           struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+	LocalizedValues struct {
+		Distance       struct{ Text string } `json:"distance"`
+		Duration       struct{ Text string } `json:"duration"`
+		StaticDuration struct{ Text string } `json:"staticDuration"`
+	} `json:"localizedValues"`
+}
+
+// routesTravelModes maps Distance Matrix travel modes to Routes API ones.
+// two_wheeler only exists in the Routes API.
+var routesTravelModes = map[string]string{
+	"":            "DRIVE",
+	"driving":     "DRIVE",
+	"walking":     "WALK",
+	"bicycling":   "BICYCLE",
+	"transit":     "TRANSIT",
+	"two_wheeler": "TWO_WHEELER",
+}
+
+func (p routesProvider) GetDistanceMatrix(origins, destinations string, opts QueryOptions) (*DistanceMatrixResponse, error) {
+	req := newRoutesRequest(origins, destinations, opts)
+	var elements []routesElement
+	if err := postRoutes(routesMatrixURL, routesFieldMask, APIAuth{apiKey: p.apiKey}, req, &elements); err != nil {
+		return nil, err
+	}
+	return routesResponse(elements, len(req.Origins), len(req.Destinations), opts), nil
+}
+
+func (p routesProvider) CallAPI(units int64, do func(auth APIAuth) error) error {
+	return do(APIAuth{apiKey: p.apiKey})
+}
+
+// postRoutes sends a Routes API request and decodes the response into v.
+// fieldMask selects the response fields, which the API requires. The API
+// takes keys only, not client IDs.
+func postRoutes(requestURL, fieldMask string, auth APIAuth, request, v any) error {
+	if auth.signer != nil {
+		return errors.New("the Routes API does not accept client IDs; use an API key")
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	return withRetry(func() error {
+		httpReq, err := http.NewRequest(http.MethodPost, requestURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("X-Goog-Api-Key", auth.apiKey)
+		httpReq.Header.Set("X-Goog-FieldMask", fieldMask)
+
+		resp, err := http.DefaultClient.Do(httpReq)
+		if err != nil {
+			return &transportError{err}
+		}
+		defer resp.Body.Close()
+
+		respBody, err := readResponseBody(resp)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			var apiErr struct {
+				Error struct{ Message string } `json:"error"`
+			}
+			json.Unmarshal(respBody, &apiErr)
+			err := &statusError{status: "HTTP_" + strconv.Itoa(resp.StatusCode), message: apiErr.Error.Message}
+			if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+				return &transportError{err}
+			}
+			return err
+		}
+
+		return decodeJSON(respBody, v)
+	})
+}
+
+func newRoutesRequest(origins, destinations string, opts QueryOptions) routesRequest {
+	req := routesRequest{
+		Origins:      routesWaypoints(origins),
+		Destinations: routesWaypoints(destinations),
+		TravelMode:   routesTravelModes[opts.Mode],
+		LanguageCode: opts.Language,
+		RegionCode:   opts.Region,
+		Units:        strings.ToUpper(opts.Units),
+	}
+
+	if opts.InTraffic() {
+		req.RoutingPreference = "TRAFFIC_AWARE"
+		if opts.TrafficModel != "" {
+			req.RoutingPreference = "TRAFFIC_AWARE_OPTIMAL"
+			req.TrafficModel = strings.ToUpper(opts.TrafficModel)
+		}
+	}
+	if !opts.DepartureTime.IsZero() {
+		req.DepartureTime = opts.DepartureTime.UTC().Format(time.RFC3339)
+	} else if opts.Mode == "transit" && !opts.ArrivalTime.IsZero() && !opts.HasDeparture() {
+		req.ArrivalTime = opts.ArrivalTime.UTC().Format(time.RFC3339)
+	}
+
+	if len(opts.Avoid) > 0 {
+		m := &routesModifiers{}
+		for _, feature := range opts.Avoid {
+			switch feature {
+			case "tolls":
+				m.AvoidTolls = true
+			case "highways":
+				m.AvoidHighways = true
+			case "ferries":
+				m.AvoidFerries = true
+			case "indoor":
+				m.AvoidIndoor = true
+			}
+		}
+		req.RouteModifiers = m
+	}
+
+	if len(opts.TransitModes) > 0 || opts.TransitRoutingPreference != "" {
+		t := &transitPreferences{RoutingPreference: strings.ToUpper(opts.TransitRoutingPreference)}
+		for _, m := range opts.TransitModes {
+			if m == "tram" {
+				m = "light_rail"
+			}
+			t.AllowedTravelModes = append(t.AllowedTravelModes, strings.ToUpper(m))
+		}
+		req.TransitPreferences = t
+	}
+	return req
+}
+
+// routesDirectionsRequest is a computeRoutes request: a single origin and
+// destination with the same options as a matrix request.
+type routesDirectionsRequest struct {
+	Origin                   routesWaypoint `json:"origin"`
+	Destination              routesWaypoint `json:"destination"`
+	ComputeAlternativeRoutes bool           `json:"computeAlternativeRoutes,omitempty"`
+	ExtraComputations        []string       `json:"extraComputations,omitempty"`
+	routesRequest
+}
+
+func newRoutesDirectionsRequest(origin, destination string, opts QueryOptions) routesDirectionsRequest {
+	matrix := newRoutesRequest(origin, destination, opts)
+	req := routesDirectionsRequest{
+		Origin:        matrix.Origins[0].Waypoint,
+		Destination:   matrix.Destinations[0].Waypoint,
+		routesRequest: matrix,
+	}
+	req.Origins, req.Destinations = nil, nil
+	return req
+}
+
+// routesWaypoints converts a pipe-separated location list into waypoints.
+// "lat,lng" pairs become coordinates and "place_id:" references place IDs;
+// anything else is sent as an address.
+func routesWaypoints(locations string) []routesMatrixWaypoint {
+	var waypoints []routesMatrixWaypoint
+	for _, location := range strings.Split(locations, "|") {
+		var w routesMatrixWaypoint
+		lat, lng, ok := strings.Cut(location, ",")
+		latValue, latErr := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+		lngValue, lngErr := strconv.ParseFloat(strings.TrimSpace(lng), 64)
+		if id, isID := strings.CutPrefix(location, "place_id:"); isID {
+			w.Waypoint.PlaceID = id
+		} else if ok && latErr == nil && lngErr == nil {
+			w.Waypoint.Location = &routesLocation{}
+			w.Waypoint.Location.LatLng.Latitude = latValue
+			w.Waypoint.Location.LatLng.Longitude = lngValue
+		} else {
+			w.Waypoint.Address = location
+		}
+		waypoints = append(waypoints, w)
+	}
+	return waypoints
+}
+
+// routesResponse arranges matrix elements, which the Routes API returns in
+// no particular order, into Distance Matrix rows. With traffic the Routes
+// duration is the traffic-aware one and staticDuration the free-flow one.
+func routesResponse(elements []routesElement, origins, destinations int, opts QueryOptions) *DistanceMatrixResponse {
+	resp := &DistanceMatrixResponse{Status: "OK", Rows: make([]DistanceMatrixRow, origins)}
+	for i := range resp.Rows {
+		resp.Rows[i].Elements = make([]DistanceMatrixElement, destinations)
+		for j := range resp.Rows[i].Elements {
+			resp.Rows[i].Elements[j].Status = "NOT_FOUND"
+		}
+	}
+
+	for _, e := range elements {
+		if e.OriginIndex < 0 || e.OriginIndex >= origins || e.DestinationIndex < 0 || e.DestinationIndex >= destinations {
+			continue
+		}
+		element := &resp.Rows[e.OriginIndex].Elements[e.DestinationIndex]
+		if e.Status.Code != 0 || e.Condition != "ROUTE_EXISTS" {
+			element.Status = "ZERO_RESULTS"
+			continue
+		}
+
+		element.Status = "OK"
+		element.Distance = TextValue{Text: e.LocalizedValues.Distance.Text, Value: e.DistanceMeters}
+		duration := routesSeconds(e.Duration)
+		element.Duration = TextValue{Text: localizedOr(e.LocalizedValues.Duration.Text, duration), Value: duration}
+		if e.StaticDuration != "" && opts.InTraffic() {
+			static := routesSeconds(e.StaticDuration)
+			element.DurationInTraffic = element.Duration
+			element.Duration = TextValue{Text: localizedOr(e.LocalizedValues.StaticDuration.Text, static), Value: static}
+		}
+	}
+	return resp
+}
+
+// routesSeconds parses a protobuf duration such as "1234s".
+func routesSeconds(d string) int {
+	seconds, _ := strconv.ParseFloat(strings.TrimSuffix(d, "s"), 64)
+	return int(seconds)
+}
+
+func localizedOr(text string, seconds int) string {
+	if text != "" {
+		return text
+	}
+	return DurationText(seconds)
+}
@@ -0,0 +1,10 @@
+package matrix
+
+// RunID is an annotator adding a RUN_ID column with its value to every
+// result, so rows appended to one output by successive runs can be told
+// apart. A RUN_ID copied from an earlier output is overwritten.
+type RunID string
+
+func (id RunID) Annotate(p Provider, opts QueryOptions, r *Result) {
+	setField(r, Field{Name: "RUN_ID", Value: string(id)})
+}
@@ -0,0 +1,70 @@
+package matrix
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// URLSigner authenticates Distance Matrix requests with a Google Maps
+// Platform premium plan client ID and its URL signing secret, in place of
+// an API key.
+type URLSigner struct {
+	clientID string
+	channel  string
+	key      []byte
+}
+
+// NewURLSigner returns a signer for clientID. secret is the URL-safe base64
+// signing secret from the Google Cloud console; channel, if set, tags the
+// requests for usage reports.
+func NewURLSigner(clientID, secret, channel string) (*URLSigner, error) {
+	if clientID == "" || secret == "" {
+		return nil, errors.New("URL signing needs both a client ID and a signing secret")
+	}
+	key, err := base64.URLEncoding.DecodeString(strings.TrimSpace(secret))
+	if err != nil {
+		return nil, fmt.Errorf("decoding signing secret: %w", err)
+	}
+	return &URLSigner{clientID: clientID, channel: channel, key: key}, nil
+}
+
+// signedURL returns baseURL with params, the client and channel, and the
+// signature: the HMAC-SHA1 of the path and query under the signing key.
+func (s *URLSigner) signedURL(baseURL string, params url.Values) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+	params.Set("client", s.clientID)
+	if s.channel != "" {
+		params.Set("channel", s.channel)
+	}
+	u.RawQuery = params.Encode()
+	mac := hmac.New(sha1.New, s.key)
+	mac.Write([]byte(u.EscapedPath() + "?" + u.RawQuery))
+	return u.String() + "&signature=" + base64.URLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// NewSignedProvider returns the provider named by name, authenticating with
+// signer. Only the Distance Matrix API takes client IDs; the Routes API
+// needs an API key.
+func NewSignedProvider(name string, signer *URLSigner, opts QueryOptions) (Provider, error) {
+	switch name {
+	case "", "google":
+		if opts.Mode == "two_wheeler" {
+			return nil, fmt.Errorf("mode two_wheeler needs the routes provider")
+		}
+		return googleProvider{signer: signer}, nil
+	case "routes":
+		return nil, errors.New("the Routes API does not accept client IDs; use an API key")
+	case "mock":
+		return mockProvider{}, nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q (want google, routes, osrm or mock)", name)
+	}
+}
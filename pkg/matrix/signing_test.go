@@ -0,0 +1,68 @@
+package matrix
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestSignedURL(t *testing.T) {
+	// The secret and first signature are Google's published example.
+	const secret = "vNIXE0xscrmjlyV-12Nj_BvUPaw="
+	tests := []struct {
+		name     string
+		clientID string
+		channel  string
+		baseURL  string
+		params   url.Values
+		want     string
+	}{
+		{
+			name:     "documented example",
+			clientID: "clientID",
+			baseURL:  "https://maps.googleapis.com/maps/api/geocode/json",
+			params:   url.Values{"address": {"New York"}},
+			want:     "https://maps.googleapis.com/maps/api/geocode/json?address=New+York&client=clientID&signature=chaRF2hTJKOScPr-RQCEhZbSzIE=",
+		},
+		{
+			name:     "with channel",
+			clientID: "gme-acme",
+			channel:  "fleet",
+			baseURL:  "https://maps.googleapis.com/maps/api/distancematrix/json",
+			params:   url.Values{"origins": {"47.3,8.6"}, "destinations": {"47.1,8.5"}},
+			want:     "https://maps.googleapis.com/maps/api/distancematrix/json?channel=fleet&client=gme-acme&destinations=47.1%2C8.5&origins=47.3%2C8.6&signature=vleLMB82uuce0nPsQnguR-32T6Q=",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewURLSigner(tt.clientID, secret, tt.channel)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := s.signedURL(tt.baseURL, tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("signedURL =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewURLSignerErrors(t *testing.T) {
+	tests := []struct {
+		name             string
+		clientID, secret string
+	}{
+		{"no client ID", "", "vNIXE0xscrmjlyV-12Nj_BvUPaw="},
+		{"no secret", "clientID", ""},
+		{"secret not base64", "clientID", "not base64!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewURLSigner(tt.clientID, tt.secret, ""); err == nil {
+				t.Error("NewURLSigner succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package matrix
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// costPerElement is the Distance Matrix list price per billed element in USD.
+	costPerElement = 0.005
+	// roadFactor and averageSpeedKmh turn straight-line distances into
+	// plausible synthetic road distances and durations.
+	roadFactor      = 1.3
+	averageSpeedKmh = 50
+)
+
+// SyntheticProvider answers from straight-line geometry instead of the API
+// and keeps a virtual clock: latencies and retries are sampled and recorded,
+// never slept, so a simulation of a large input finishes in seconds.
+type SyntheticProvider struct {
+	median    time.Duration
+	sigma     float64
+	errorRate float64
+
+	mu       sync.Mutex
+	rng      *rand.Rand
+	calls    []time.Duration
+	requests int
+	elements int
+	failures int
+}
+
+// NewSyntheticProvider models latency as log-normal with the given median and
+// 95th percentile.
+func NewSyntheticProvider(median, p95 time.Duration, errorRate float64) *SyntheticProvider {
+	sigma := 0.0
+	if p95 > median && median > 0 {
+		sigma = math.Log(float64(p95)/float64(median)) / 1.645
+	}
+	return &SyntheticProvider{
+		median:    median,
+		sigma:     sigma,
+		errorRate: errorRate,
+		rng:       rand.New(rand.NewSource(1)),
+	}
+}
+
+func (s *SyntheticProvider) GetDistanceMatrix(origins, destinations string, opts QueryOptions) (*DistanceMatrixResponse, error) {
+	originList := strings.Split(origins, "|")
+	destinationList := strings.Split(destinations, "|")
+	elements := len(originList) * len(destinationList)
+
+	s.mu.Lock()
+	var elapsed time.Duration
+	failed := true
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		elapsed += time.Duration(float64(s.median) * math.Exp(s.sigma*s.rng.NormFloat64()))
+		s.requests++
+		s.elements += elements
+		if s.rng.Float64() >= s.errorRate {
+			failed = false
+			break
+		}
+		if attempt < maxAttempts {
+			elapsed += time.Duration(attempt) * retryBackoff
+		}
+	}
+	s.calls = append(s.calls, elapsed)
+	if failed {
+		s.failures++
+	}
+	s.mu.Unlock()
+
+	if failed {
+		return nil, fmt.Errorf("simulated transport error")
+	}
+
+	return syntheticResponse(originList, destinationList), nil
+}
+
+func syntheticResponse(origins, destinations []string) *DistanceMatrixResponse {
+	resp := &DistanceMatrixResponse{Status: "OK"}
+	for _, origin := range origins {
+		var row DistanceMatrixRow
+		for _, destination := range destinations {
+			row.Elements = append(row.Elements, syntheticElement(origin, destination))
+		}
+		resp.Rows = append(resp.Rows, row)
+	}
+	return resp
+}
+
+func syntheticElement(origin, destination string) DistanceMatrixElement {
+	lat1, lng1, err1 := parseLatLng(origin)
+	lat2, lng2, err2 := parseLatLng(destination)
+	if err1 != nil || err2 != nil {
+		return DistanceMatrixElement{Status: "NOT_FOUND"}
+	}
+
+	meters := haversineKm(lat1, lng1, lat2, lng2) * roadFactor * 1000
+	seconds := meters / 1000 / averageSpeedKmh * 3600
+	return DistanceMatrixElement{
+		Distance: TextValue{Text: fmt.Sprintf("%.1f km", meters/1000), Value: int(meters)},
+		Duration: TextValue{Text: DurationText(int(seconds)), Value: int(seconds)},
+		Status:   "OK",
+	}
+}
+
+func parseLatLng(coordinate string) (float64, float64, error) {
+	latText, lngText, ok := strings.Cut(coordinate, ",")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid coordinate %q", coordinate)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latText), 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	lng, err := strconv.ParseFloat(strings.TrimSpace(lngText), 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return lat, lng, nil
+}
+
+// haversineKm returns the great-circle distance between two points.
+func haversineKm(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLng := (lng2 - lng1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// DurationText formats seconds the way the API does, e.g. "1 hour 5 mins".
+func DurationText(seconds int) string {
+	minutes := (seconds + 30) / 60
+	hours, minutes := minutes/60, minutes%60
+
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %s", n, unit)
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+
+	switch {
+	case hours == 0:
+		return plural(max(minutes, 1), "min")
+	case minutes == 0:
+		return plural(hours, "hour")
+	default:
+		return plural(hours, "hour") + " " + plural(minutes, "min")
+	}
+}
+
+// SimulationStats is what a run against a SyntheticProvider would have cost.
+type SimulationStats struct {
+	Requests, Retries int
+	Elements          int
+	// Cost is the list price of the billed elements in USD.
+	Cost     float64
+	Failures int
+	// WallTime is the projected duration of the run at the given
+	// concurrency.
+	WallTime time.Duration
+}
+
+// Stats projects the calls made so far onto concurrency workers.
+func (s *SyntheticProvider) Stats(concurrency int) SimulationStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SimulationStats{
+		Requests: s.requests,
+		Retries:  s.requests - len(s.calls),
+		Elements: s.elements,
+		Cost:     float64(s.elements) * costPerElement,
+		Failures: s.failures,
+		WallTime: projectWallTime(s.calls, concurrency),
+	}
+}
+
+// projectWallTime schedules the recorded call durations onto the given number
+// of workers, each call going to the worker that frees up first.
+func projectWallTime(calls []time.Duration, concurrency int) time.Duration {
+	workers := make([]time.Duration, max(concurrency, 1))
+	for _, d := range calls {
+		sort.Slice(workers, func(i, j int) bool { return workers[i] < workers[j] })
+		workers[0] += d
+	}
+
+	var wall time.Duration
+	for _, w := range workers {
+		wall = max(wall, w)
+	}
+	return wall
+}
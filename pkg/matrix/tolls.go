@@ -0,0 +1,116 @@
+package matrix
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TollCost adds a TOLL_COST column with the Routes API's toll estimate for
+// each pair. It always uses the Routes API, whichever provider computes the
+// distances, since the Distance Matrix and Directions APIs report no tolls.
+// Pairs repeated across rows are queried once, through the provider
+// annotating, as RouteGeometry does.
+type TollCost struct {
+	// emissionType and tollPasses describe the vehicle, which changes the
+	// price on many toll roads.
+	emissionType string
+	tollPasses   []string
+
+	mu    sync.Mutex
+	cache map[[2]string]string
+}
+
+func NewTollCost(emissionType string, tollPasses []string) (*TollCost, error) {
+	switch strings.ToLower(emissionType) {
+	case "", "gasoline", "electric", "hybrid", "diesel":
+	default:
+		return nil, fmt.Errorf("unknown vehicle emission type %q (want gasoline, electric, hybrid or diesel)", emissionType)
+	}
+	return &TollCost{emissionType: strings.ToUpper(emissionType), tollPasses: tollPasses, cache: make(map[[2]string]string)}, nil
+}
+
+func (t *TollCost) Annotate(p Provider, opts QueryOptions, r *Result) {
+	key := [2]string{r.Origin, r.Destination}
+	t.mu.Lock()
+	cost, ok := t.cache[key]
+	t.mu.Unlock()
+
+	if !ok {
+		cost = "N/A"
+		if r.Origin != "" && r.Destination != "" {
+			var c string
+			err := CallAPI(p, 1, func(auth APIAuth) error {
+				var err error
+				c, err = t.estimate(auth, r.Origin, r.Destination, opts)
+				return err
+			})
+			if err != nil {
+				slog.Error("fetching toll cost", "site", r.SiteCode, "terminal", r.TerminalCode, "err", err)
+			} else {
+				cost = c
+			}
+		}
+		t.mu.Lock()
+		t.cache[key] = cost
+		t.mu.Unlock()
+	}
+
+	r.Extra = append(r.Extra, Field{Name: "TOLL_COST", Value: cost})
+}
+
+// estimate returns the toll price as "<amount> <currency>", joined with "|"
+// when a route crosses currencies. A route without tolls costs "0"; tolls
+// the API cannot price are "unknown".
+func (t *TollCost) estimate(auth APIAuth, origin, destination string, opts QueryOptions) (string, error) {
+	req := newRoutesDirectionsRequest(origin, destination, opts)
+	req.ExtraComputations = []string{"TOLLS"}
+	if req.RouteModifiers == nil {
+		req.RouteModifiers = &routesModifiers{}
+	}
+	if t.emissionType != "" {
+		req.RouteModifiers.VehicleInfo = &routesVehicleInfo{EmissionType: t.emissionType}
+	}
+	req.RouteModifiers.TollPasses = t.tollPasses
+
+	var resp struct {
+		Routes []struct {
+			TravelAdvisory struct {
+				TollInfo *struct {
+					EstimatedPrice []struct {
+						CurrencyCode string `json:"currencyCode"`
+						Units        string `json:"units"`
+						Nanos        int    `json:"nanos"`
+					} `json:"estimatedPrice"`
+				} `json:"tollInfo"`
+			} `json:"travelAdvisory"`
+		} `json:"routes"`
+	}
+	if err := postRoutes(routesDirectionsURL, "routes.travelAdvisory.tollInfo", auth, req, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Routes) == 0 {
+		return "", fmt.Errorf("no route found")
+	}
+
+	info := resp.Routes[0].TravelAdvisory.TollInfo
+	switch {
+	case info == nil:
+		return "0", nil
+	case len(info.EstimatedPrice) == 0:
+		return "unknown", nil
+	}
+
+	var prices []string
+	for _, price := range info.EstimatedPrice {
+		units, err := strconv.ParseInt(price.Units, 10, 64)
+		if err != nil && price.Units != "" {
+			return "", fmt.Errorf("invalid toll price %q", price.Units)
+		}
+		amount := float64(units) + float64(price.Nanos)/1e9
+		prices = append(prices, strconv.FormatFloat(amount, 'f', 2, 64)+" "+price.CurrencyCode)
+	}
+	return strings.Join(prices, "|"), nil
+}
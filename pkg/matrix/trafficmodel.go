@@ -0,0 +1,46 @@
+package matrix
+
+import (
+	"fmt"
+	"strings"
+)
+
+var trafficModelNames = []string{"best_guess", "pessimistic", "optimistic"}
+
+// ParseTrafficModel sets the model for the main query. "all" keeps the
+// default best_guess there and returns an annotator that adds a column for
+// each of the other models.
+func ParseTrafficModel(model string, opts *QueryOptions) (*TrafficModels, error) {
+	if !opts.InTraffic() {
+		return nil, fmt.Errorf("a traffic model needs a departure time and mode driving")
+	}
+	switch model {
+	case "all":
+		opts.TrafficModel = "best_guess"
+		return &TrafficModels{models: trafficModelNames[1:]}, nil
+	case "best_guess", "pessimistic", "optimistic":
+		opts.TrafficModel = model
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown traffic model %q (want %s or all)", model, strings.Join(trafficModelNames, ", "))
+	}
+}
+
+// TrafficModels adds DURATION_IN_TRAFFIC_<MODEL> columns, one query per model.
+type TrafficModels struct {
+	models []string
+}
+
+// Queries returns the number of matrix queries made per row.
+func (t *TrafficModels) Queries() int { return len(t.models) }
+
+func (t *TrafficModels) Annotate(p Provider, opts QueryOptions, r *Result) {
+	for _, model := range t.models {
+		opts.TrafficModel = model
+		value := "N/A"
+		if element, err := routeElement(p, r.Route, opts); err == nil && element.DurationInTraffic.Text != "" {
+			value = element.DurationInTraffic.Text
+		}
+		r.Extra = append(r.Extra, Field{Name: "DURATION_IN_TRAFFIC_" + strings.ToUpper(model), Value: value})
+	}
+}
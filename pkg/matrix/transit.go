@@ -0,0 +1,71 @@
+package matrix
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TravelOptions holds the travel mode settings as given on the command line
+// or in a pipeline, before validation.
+type TravelOptions struct {
+	Mode              string
+	TransitModes      string
+	RoutingPreference string
+	ArrivalTime       string
+}
+
+// ParseTravelMode validates the travel mode, its transit-only settings and
+// the arrival time into opts. Transit modes and the routing preference are
+// only accepted with mode transit, which is the only mode the API honours
+// them for. An arrival time works with every mode: transit queries arrive by
+// it, others are queried without a departure and backdated from it.
+func ParseTravelMode(t TravelOptions, opts *QueryOptions) error {
+	switch t.Mode {
+	case "", "driving":
+		opts.Mode = ""
+	case "walking", "bicycling", "transit", "two_wheeler":
+		opts.Mode = t.Mode
+	default:
+		return fmt.Errorf("unknown travel mode %q (want driving, walking, bicycling, transit or two_wheeler)", t.Mode)
+	}
+
+	for _, s := range []struct{ name, value string }{
+		{"transit mode", t.TransitModes},
+		{"transit routing preference", t.RoutingPreference},
+	} {
+		if s.value != "" && opts.Mode != "transit" {
+			return fmt.Errorf("a %s needs mode transit", s.name)
+		}
+	}
+
+	opts.TransitModes = nil
+	for _, m := range strings.FieldsFunc(t.TransitModes, func(r rune) bool { return r == ',' || r == '|' }) {
+		m = strings.ToLower(strings.TrimSpace(m))
+		switch m {
+		case "bus", "subway", "train", "tram", "rail":
+			opts.TransitModes = append(opts.TransitModes, m)
+		default:
+			return fmt.Errorf("unknown transit mode %q (want bus, subway, train, tram or rail)", m)
+		}
+	}
+
+	switch t.RoutingPreference {
+	case "", "less_walking", "fewer_transfers":
+		opts.TransitRoutingPreference = t.RoutingPreference
+	default:
+		return fmt.Errorf("unknown transit routing preference %q (want less_walking or fewer_transfers)", t.RoutingPreference)
+	}
+
+	if t.ArrivalTime != "" {
+		if opts.HasDeparture() {
+			return fmt.Errorf("set either a departure time or an arrival time, not both")
+		}
+		at, err := time.Parse(time.RFC3339, t.ArrivalTime)
+		if err != nil {
+			return fmt.Errorf("invalid arrival time %q: use RFC3339", t.ArrivalTime)
+		}
+		opts.ArrivalTime = at
+	}
+	return nil
+}
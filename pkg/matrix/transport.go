@@ -0,0 +1,79 @@
+package matrix
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// maxURLLength is the Google Maps web service URL size limit.
+	maxURLLength = 16384
+	// maxResponseBytes caps how much of a response body is read. A full
+	// 25x25 Distance Matrix response is well below this.
+	maxResponseBytes = 16 << 20
+
+	maxAttempts  = 3
+	retryBackoff = time.Second
+)
+
+// transportError marks failures of the connection or of the response body
+// itself (as opposed to API or input errors); these are worth retrying.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string { return "transport error: " + e.err.Error() }
+func (e *transportError) Unwrap() error { return e.err }
+
+func isRetryable(err error) bool {
+	var te *transportError
+	return errors.As(err, &te)
+}
+
+// withRetry runs call until it succeeds, fails with a non-retryable error or
+// runs out of attempts, backing off linearly between attempts.
+func withRetry(call func() error) error {
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err = call(); err == nil || !isRetryable(err) {
+			return err
+		}
+		if attempt < maxAttempts {
+			slog.Debug("retrying request", "attempt", attempt, "err", err)
+			time.Sleep(time.Duration(attempt) * retryBackoff)
+		}
+	}
+	return err
+}
+
+// readResponseBody reads a response body while guarding against oversized
+// and truncated payloads, which proxies occasionally produce.
+func readResponseBody(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes+1))
+	if err != nil {
+		return nil, &transportError{fmt.Errorf("reading response: %w", err)}
+	}
+	if len(body) > maxResponseBytes {
+		return nil, fmt.Errorf("response exceeds %d bytes", maxResponseBytes)
+	}
+	if resp.ContentLength >= 0 && int64(len(body)) != resp.ContentLength {
+		return nil, &transportError{fmt.Errorf("response truncated: read %d of %d bytes", len(body), resp.ContentLength)}
+	}
+	return body, nil
+}
+
+// decodeJSON unmarshals body, reporting a body that ends mid-document as a
+// truncated transport error rather than a decode failure.
+func decodeJSON(body []byte, v any) error {
+	err := json.Unmarshal(body, v)
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) && syntaxErr.Offset >= int64(len(body)) {
+		return &transportError{fmt.Errorf("response truncated after %d bytes: %w", len(body), err)}
+	}
+	return err
+}
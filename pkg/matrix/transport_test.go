@@ -0,0 +1,41 @@
+package matrix
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		wantErr       bool
+		wantTruncated bool
+	}{
+		{name: "complete", body: `{"status": "OK", "rows": []}`},
+		{name: "cut mid-array", body: `{"status": "OK", "rows": [`, wantErr: true, wantTruncated: true},
+		{name: "cut mid-string", body: `{"status": "O`, wantErr: true, wantTruncated: true},
+		{name: "empty", body: ``, wantErr: true, wantTruncated: true},
+		{name: "malformed", body: `{"status": OK}`, wantErr: true},
+		{name: "html error page", body: `<html>Bad Gateway</html>`, wantErr: true},
+		{name: "wrong type", body: `{"status": 1}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v struct {
+				Status string `json:"status"`
+			}
+			err := decodeJSON([]byte(tt.body), &v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeJSON(%q) error = %v, want error %v", tt.body, err, tt.wantErr)
+			}
+			var te *transportError
+			if truncated := errors.As(err, &te); truncated != tt.wantTruncated {
+				t.Errorf("decodeJSON(%q) = %v; transport error %v, want %v", tt.body, err, truncated, tt.wantTruncated)
+			}
+			if tt.wantTruncated && !isRetryable(err) {
+				t.Errorf("truncated response %q is not retried", tt.body)
+			}
+		})
+	}
+}
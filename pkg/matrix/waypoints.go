@@ -0,0 +1,69 @@
+package matrix
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// legElements fetches one element per leg of route: origin to the first
+// waypoint, between waypoints, and on to the destination. With a fixed
+// departure time each leg departs when the previous one arrives.
+func legElements(p Provider, route Route, opts QueryOptions) ([]DistanceMatrixElement, error) {
+	stops := append(append([]string{route.Origin}, route.Waypoints...), route.Destination)
+	legs := make([]DistanceMatrixElement, 0, len(stops)-1)
+	for i := 0; i+1 < len(stops); i++ {
+		leg := route
+		leg.Origin, leg.Destination, leg.Waypoints = stops[i], stops[i+1], nil
+		element, err := routeElement(p, leg, opts)
+		if err != nil {
+			return nil, err // one failed leg fails the whole route
+		}
+		legs = append(legs, element)
+		if !opts.DepartureTime.IsZero() {
+			seconds := element.Duration.Value
+			if element.DurationInTraffic.Value > 0 {
+				seconds = element.DurationInTraffic.Value
+			}
+			opts.DepartureTime = opts.DepartureTime.Add(time.Duration(seconds) * time.Second)
+		}
+	}
+	return legs, nil
+}
+
+// sumLegs combines leg elements into one for the whole route. Durations are
+// re-rendered from the summed seconds.
+func sumLegs(legs []DistanceMatrixElement) DistanceMatrixElement {
+	if len(legs) == 1 {
+		return legs[0]
+	}
+	total := DistanceMatrixElement{Status: "OK"}
+	for _, leg := range legs {
+		total.Distance.Value += leg.Distance.Value
+		total.Duration.Value += leg.Duration.Value
+		total.DurationInTraffic.Value += leg.DurationInTraffic.Value
+	}
+	total.Duration.Text = DurationText(total.Duration.Value)
+	if total.DurationInTraffic.Value > 0 {
+		total.DurationInTraffic.Text = DurationText(total.DurationInTraffic.Value)
+	}
+	return total
+}
+
+// legFields lists each leg's distance and duration, pipe-separated in route
+// order, or N/A when the route failed.
+func legFields(legs []DistanceMatrixElement) []Field {
+	distances, durations := "N/A", "N/A"
+	if len(legs) > 0 {
+		var d, t []string
+		for _, leg := range legs {
+			d = append(d, strconv.FormatFloat(float64(leg.Distance.Value)/1000, 'f', 2, 64))
+			t = append(t, leg.Duration.Text)
+		}
+		distances, durations = strings.Join(d, "|"), strings.Join(t, "|")
+	}
+	return []Field{
+		{Name: "LEG_DISTANCES_KM", Value: distances},
+		{Name: "LEG_DURATIONS", Value: durations},
+	}
+}
@@ -0,0 +1,9 @@
+// Package routedmpb holds the protocol buffer messages and gRPC client and
+// server of the route-dm gRPC service, generated from routedm.proto.
+//
+// Java clients are generated from the same file with protoc-gen-grpc-java:
+//
+//	protoc --java_out=DIR --grpc-java_out=DIR routedm.proto
+package routedmpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative routedm.proto
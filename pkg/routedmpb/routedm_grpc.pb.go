@@ -0,0 +1,272 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: routedm.proto
+
+package routedmpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	RouteDistanceMatrix_ComputeMatrix_FullMethodName = "/routedm.v1.RouteDistanceMatrix/ComputeMatrix"
+	RouteDistanceMatrix_ComputeBatch_FullMethodName  = "/routedm.v1.RouteDistanceMatrix/ComputeBatch"
+	RouteDistanceMatrix_StreamMatrix_FullMethodName  = "/routedm.v1.RouteDistanceMatrix/StreamMatrix"
+	RouteDistanceMatrix_StreamBatch_FullMethodName   = "/routedm.v1.RouteDistanceMatrix/StreamBatch"
+)
+
+// RouteDistanceMatrixClient is the client API for RouteDistanceMatrix service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// RouteDistanceMatrix is served by `route-dm serve -grpc-addr`, for services
+// that would rather not go through the JSON API. It computes road distances
+// and durations with the server's provider and API key. With clients in
+// the server config, calls need one of their API keys in the "authorization"
+// ("Bearer KEY") or "x-api-key" metadata, and count against its daily quota.
+type RouteDistanceMatrixClient interface {
+	// ComputeMatrix computes every origin against every destination, like
+	// POST /matrix.
+	ComputeMatrix(ctx context.Context, in *MatrixRequest, opts ...grpc.CallOption) (*MatrixResponse, error)
+	// ComputeBatch computes a list of routes and returns their results in the
+	// same order.
+	ComputeBatch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error)
+	// StreamMatrix computes the same elements as ComputeMatrix, but sends
+	// each one as soon as it is computed, in no particular order, so large
+	// matrices can be consumed while they are being computed.
+	StreamMatrix(ctx context.Context, in *MatrixRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[MatrixElement], error)
+	// StreamBatch computes the same results as ComputeBatch, sending each as
+	// soon as it is computed, in no particular order.
+	StreamBatch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[RouteResult], error)
+}
+
+type routeDistanceMatrixClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRouteDistanceMatrixClient(cc grpc.ClientConnInterface) RouteDistanceMatrixClient {
+	return &routeDistanceMatrixClient{cc}
+}
+
+func (c *routeDistanceMatrixClient) ComputeMatrix(ctx context.Context, in *MatrixRequest, opts ...grpc.CallOption) (*MatrixResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MatrixResponse)
+	err := c.cc.Invoke(ctx, RouteDistanceMatrix_ComputeMatrix_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routeDistanceMatrixClient) ComputeBatch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchResponse)
+	err := c.cc.Invoke(ctx, RouteDistanceMatrix_ComputeBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routeDistanceMatrixClient) StreamMatrix(ctx context.Context, in *MatrixRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[MatrixElement], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &RouteDistanceMatrix_ServiceDesc.Streams[0], RouteDistanceMatrix_StreamMatrix_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[MatrixRequest, MatrixElement]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RouteDistanceMatrix_StreamMatrixClient = grpc.ServerStreamingClient[MatrixElement]
+
+func (c *routeDistanceMatrixClient) StreamBatch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[RouteResult], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &RouteDistanceMatrix_ServiceDesc.Streams[1], RouteDistanceMatrix_StreamBatch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[BatchRequest, RouteResult]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RouteDistanceMatrix_StreamBatchClient = grpc.ServerStreamingClient[RouteResult]
+
+// RouteDistanceMatrixServer is the server API for RouteDistanceMatrix service.
+// All implementations must embed UnimplementedRouteDistanceMatrixServer
+// for forward compatibility.
+//
+// RouteDistanceMatrix is served by `route-dm serve -grpc-addr`, for services
+// that would rather not go through the JSON API. It computes road distances
+// and durations with the server's provider and API key. With clients in
+// the server config, calls need one of their API keys in the "authorization"
+// ("Bearer KEY") or "x-api-key" metadata, and count against its daily quota.
+type RouteDistanceMatrixServer interface {
+	// ComputeMatrix computes every origin against every destination, like
+	// POST /matrix.
+	ComputeMatrix(context.Context, *MatrixRequest) (*MatrixResponse, error)
+	// ComputeBatch computes a list of routes and returns their results in the
+	// same order.
+	ComputeBatch(context.Context, *BatchRequest) (*BatchResponse, error)
+	// StreamMatrix computes the same elements as ComputeMatrix, but sends
+	// each one as soon as it is computed, in no particular order, so large
+	// matrices can be consumed while they are being computed.
+	StreamMatrix(*MatrixRequest, grpc.ServerStreamingServer[MatrixElement]) error
+	// StreamBatch computes the same results as ComputeBatch, sending each as
+	// soon as it is computed, in no particular order.
+	StreamBatch(*BatchRequest, grpc.ServerStreamingServer[RouteResult]) error
+	mustEmbedUnimplementedRouteDistanceMatrixServer()
+}
+
+// UnimplementedRouteDistanceMatrixServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedRouteDistanceMatrixServer struct{}
+
+func (UnimplementedRouteDistanceMatrixServer) ComputeMatrix(context.Context, *MatrixRequest) (*MatrixResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ComputeMatrix not implemented")
+}
+func (UnimplementedRouteDistanceMatrixServer) ComputeBatch(context.Context, *BatchRequest) (*BatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ComputeBatch not implemented")
+}
+func (UnimplementedRouteDistanceMatrixServer) StreamMatrix(*MatrixRequest, grpc.ServerStreamingServer[MatrixElement]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamMatrix not implemented")
+}
+func (UnimplementedRouteDistanceMatrixServer) StreamBatch(*BatchRequest, grpc.ServerStreamingServer[RouteResult]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamBatch not implemented")
+}
+func (UnimplementedRouteDistanceMatrixServer) mustEmbedUnimplementedRouteDistanceMatrixServer() {}
+func (UnimplementedRouteDistanceMatrixServer) testEmbeddedByValue()                             {}
+
+// UnsafeRouteDistanceMatrixServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RouteDistanceMatrixServer will
+// result in compilation errors.
+type UnsafeRouteDistanceMatrixServer interface {
+	mustEmbedUnimplementedRouteDistanceMatrixServer()
+}
+
+func RegisterRouteDistanceMatrixServer(s grpc.ServiceRegistrar, srv RouteDistanceMatrixServer) {
+	// If the following call pancis, it indicates UnimplementedRouteDistanceMatrixServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&RouteDistanceMatrix_ServiceDesc, srv)
+}
+
+func _RouteDistanceMatrix_ComputeMatrix_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MatrixRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouteDistanceMatrixServer).ComputeMatrix(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RouteDistanceMatrix_ComputeMatrix_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouteDistanceMatrixServer).ComputeMatrix(ctx, req.(*MatrixRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RouteDistanceMatrix_ComputeBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouteDistanceMatrixServer).ComputeBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RouteDistanceMatrix_ComputeBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouteDistanceMatrixServer).ComputeBatch(ctx, req.(*BatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RouteDistanceMatrix_StreamMatrix_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(MatrixRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RouteDistanceMatrixServer).StreamMatrix(m, &grpc.GenericServerStream[MatrixRequest, MatrixElement]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RouteDistanceMatrix_StreamMatrixServer = grpc.ServerStreamingServer[MatrixElement]
+
+func _RouteDistanceMatrix_StreamBatch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RouteDistanceMatrixServer).StreamBatch(m, &grpc.GenericServerStream[BatchRequest, RouteResult]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RouteDistanceMatrix_StreamBatchServer = grpc.ServerStreamingServer[RouteResult]
+
+// RouteDistanceMatrix_ServiceDesc is the grpc.ServiceDesc for RouteDistanceMatrix service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RouteDistanceMatrix_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "routedm.v1.RouteDistanceMatrix",
+	HandlerType: (*RouteDistanceMatrixServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ComputeMatrix",
+			Handler:    _RouteDistanceMatrix_ComputeMatrix_Handler,
+		},
+		{
+			MethodName: "ComputeBatch",
+			Handler:    _RouteDistanceMatrix_ComputeBatch_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamMatrix",
+			Handler:       _RouteDistanceMatrix_StreamMatrix_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamBatch",
+			Handler:       _RouteDistanceMatrix_StreamBatch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "routedm.proto",
+}